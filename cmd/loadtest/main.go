@@ -0,0 +1,278 @@
+// Command loadtest fires randomized pairing requests at a pairing system at a fixed rate for a duration and
+// reports latency percentiles, error rates and allocations against target SLOs
+//
+//	go run ./cmd/loadtest -qps 500 -duration 1m                       # In-process system, 1000 synthetic providers
+//	go run ./cmd/loadtest -target http://localhost:8080 -chain LAV1   # Remote pairing API, over its own providers
+//
+// The in-process system is configured from the LAVA_PAIRING_* environment like the service. The command exits
+// with status 1 when an SLO is missed
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/config"
+	"github.com/Yoaz/LavaPairingSystem/internal/mock"
+	"github.com/Yoaz/LavaPairingSystem/pkg/address"
+	"github.com/Yoaz/LavaPairingSystem/pkg/client"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/system"
+)
+
+// Values synthetic providers and randomized policies draw from, matching the default latency matrix and
+// feature catalog so policies can be satisfied
+var (
+	locations  = []string{"US-West", "US-East", "EU-Central"}
+	features   = []string{"featA", "featB", "featC", "featD", "featE"}
+	interfaces = []string{pairing.APIInterfaceJSONRPC, pairing.APIInterfaceREST, pairing.APIInterfaceGRPC, pairing.APIInterfaceTendermintRPC}
+	scorers    = []string{"StakeScore", "FeatureScore", "LocationScore", "FeeScore", "CommissionScore"}
+)
+
+// target sends a single pairing request
+type target func(ctx context.Context, policy *pairing.ConsumerPolicy) error
+
+func main() {
+	targetURL := flag.String("target", "", "base URL of the pairing API to load (e.g. http://localhost:8080), empty loads an in-process system")
+	apiKey := flag.String("api-key", "", "API key sent to the pairing API")
+	chainID := flag.String("chain", "", "chain the remote requests pair on")
+	qps := flag.Int("qps", 100, "requests started per second")
+	duration := flag.Duration("duration", 30*time.Second, "how long requests are started for")
+	concurrency := flag.Int("concurrency", 64, "maximum requests in flight, requests due while it's reached are dropped")
+	timeout := flag.Duration("timeout", 5*time.Second, "bound of each request")
+	providerCount := flag.Int("providers", 1000, "synthetic providers paired over in-process, or sent with every remote request (0 pairs over the mock providers in-process, the server's own remotely)")
+	seed := flag.Uint64("seed", 1, "seed of the synthetic providers and randomized policies, so runs are comparable")
+	verbose := flag.Bool("verbose", false, "log the in-process pipeline at the environment's log level instead of errors only")
+	var slo SLO
+	flag.DurationVar(&slo.P50, "slo-p50", 0, "target median latency (0 disables)")
+	flag.DurationVar(&slo.P95, "slo-p95", 0, "target 95th percentile latency (0 disables)")
+	flag.DurationVar(&slo.P99, "slo-p99", 100*time.Millisecond, "target 99th percentile latency (0 disables)")
+	flag.Float64Var(&slo.ErrorRate, "slo-error-rate", 0.01, "maximum share of failed and dropped requests, unpaired policies aren't failures")
+	flag.Uint64Var(&slo.BytesPerRequest, "slo-bytes-per-request", 0, "maximum bytes allocated per request (0 disables)")
+	flag.Parse()
+	if *qps <= 0 || *duration <= 0 || *concurrency <= 0 {
+		slog.Error("The QPS, duration and concurrency must be positive")
+		os.Exit(2)
+	}
+
+	rng := rand.New(rand.NewPCG(*seed, *seed>>1|1))
+	var providers []*pairing.Provider
+	if *providerCount > 0 {
+		providers = generateProviders(rng, *providerCount)
+	} else if *targetURL == "" {
+		providers = mock.Providers
+	}
+	var send target
+	var closeTarget func()
+	if *targetURL == "" {
+		send, closeTarget = inProcess(providers, *timeout, *verbose)
+	} else {
+		send, closeTarget = remote(*targetURL, *apiKey, *chainID, providers)
+	}
+	defer closeTarget()
+
+	slog.Info("Starting load test", "target", targetName(*targetURL), "qps", *qps, "duration", *duration,
+		"concurrency", *concurrency, "providers", len(providers))
+	report := run(send, rng, *qps, *duration, *concurrency, *timeout)
+	report.Log()
+	if failures := slo.Check(report); len(failures) > 0 {
+		for _, failure := range failures {
+			slog.Error("SLO missed", "slo", failure)
+		}
+		closeTarget()
+		os.Exit(1)
+	}
+	slog.Info("All SLOs met")
+}
+
+// inProcess returns a target pairing over providers with a system configured from the environment
+func inProcess(providers []*pairing.Provider, timeout time.Duration, verbose bool) (target, func()) {
+	env, err := config.ParseEnv(os.Environ())
+	if err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	env.RecordDir = "" // Load isn't worth replaying
+	if !verbose {
+		env.LogLevel = slog.LevelError
+	}
+	app, err := config.New(*env)
+	if err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	send := func(_ context.Context, policy *pairing.ConsumerPolicy) error {
+		_, err := app.PairingSystem.GetPairingList(providers, policy, system.PairingOptions{Timeout: timeout})
+		return err
+	}
+	return send, app.PairingSystem.Close
+}
+
+// remote returns a target sending requests to the pairing API at baseURL, with providers if set
+func remote(baseURL, apiKey, chainID string, providers []*pairing.Provider) (target, func()) {
+	opts := []client.Option{client.WithChainID(chainID)}
+	if apiKey != "" {
+		opts = append(opts, client.WithAPIKey(apiKey))
+	}
+	c := client.NewClient(baseURL, slog.Default(), opts...)
+	send := func(ctx context.Context, policy *pairing.ConsumerPolicy) error {
+		_, err := c.GetPairingListContext(ctx, providers, policy)
+		return err
+	}
+	return send, c.Close
+}
+
+// run starts qps requests per second for duration, at most concurrency at once, and waits for them to finish
+func run(send target, rng *rand.Rand, qps int, duration time.Duration, concurrency int, timeout time.Duration) *Report {
+	recorder := newRecorder(int(duration.Seconds() * float64(qps)))
+	var before runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	// Sample the heap while the load runs, its peak is lost once the requests are done
+	stopSampling := make(chan struct{})
+	sampled := make(chan uint64)
+	go func() {
+		var peak uint64
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			peak = max(peak, stats.HeapInuse)
+			select {
+			case <-ticker.C:
+			case <-stopSampling:
+				sampled <- peak
+				return
+			}
+		}
+	}()
+
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	ticker := time.NewTicker(time.Second / time.Duration(qps))
+	defer ticker.Stop()
+	start := time.Now()
+	// Tickers drop ticks when the process is saturated, so every tick starts the requests due since the last
+	// one instead of a single request: the rate holds however loaded the machine is
+	for started, total := 0, int(duration.Seconds()*float64(qps)); started < total; {
+		at := <-ticker.C
+		for due := min(int(at.Sub(start).Seconds()*float64(qps)), total); started < due; started++ {
+			policy := randomPolicy(rng)
+			select {
+			case slots <- struct{}{}:
+			default:
+				recorder.drop()
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-slots }()
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				defer cancel()
+				sent := time.Now()
+				err := send(ctx, policy)
+				recorder.record(time.Since(sent), err)
+			}()
+		}
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	close(stopSampling)
+	peak := <-sampled
+
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	return recorder.report(elapsed, Allocations{
+		Bytes:       after.TotalAlloc - before.TotalAlloc,
+		Objects:     after.Mallocs - before.Mallocs,
+		GCs:         after.NumGC - before.NumGC,
+		GCPause:     time.Duration(after.PauseTotalNs - before.PauseTotalNs),
+		PeakHeapUse: peak,
+	})
+}
+
+// generateProviders returns n synthetic providers spread over the known locations and features
+func generateProviders(rng *rand.Rand, n int) []*pairing.Provider {
+	now := time.Now()
+	providers := make([]*pairing.Provider, n)
+	for i := range providers {
+		id := "load-" + strconv.Itoa(i+1)
+		location := locations[rng.IntN(len(locations))]
+		selfStake := 100 + rng.Int64N(5000)
+		delegated := rng.Int64N(5000)
+		p := &pairing.Provider{
+			ID:             id,
+			Address:        address.Address("provider-" + id),
+			Stake:          selfStake + delegated,
+			SelfStake:      selfStake,
+			DelegatedStake: delegated,
+			Commission:     float64(rng.IntN(51)),
+			Location:       location,
+			Features:       sample(rng, features, 1+rng.IntN(len(features))),
+			Fee:            rng.Float64() * 5,
+			TLSEnabled:     rng.IntN(10) > 0,
+			LastUpdated:    now,
+		}
+		for _, apiInterface := range sample(rng, interfaces, 1+rng.IntN(2)) {
+			p.Endpoints = append(p.Endpoints, pairing.Endpoint{
+				URL:          "https://" + id + ".example.com/" + apiInterface,
+				APIInterface: apiInterface,
+				Geolocation:  location,
+			})
+		}
+		providers[i] = p
+	}
+	return providers
+}
+
+// randomPolicy returns a policy with random requirements and weights, from one of a thousand consumers
+func randomPolicy(rng *rand.Rand) *pairing.ConsumerPolicy {
+	policy := &pairing.ConsumerPolicy{
+		ConsumerID:       address.Address("load-consumer-" + strconv.Itoa(rng.IntN(1000))),
+		RequiredFeatures: sample(rng, features, rng.IntN(3)),
+		MinStake:         rng.Int64N(2000),
+		RequireTLS:       rng.IntN(2) == 0,
+		ExcludeJailed:    true,
+	}
+	if rng.IntN(4) > 0 {
+		policy.RequiredLocation = locations[rng.IntN(len(locations))]
+	}
+	if rng.IntN(2) == 0 {
+		// Random weights over a few scorers, the last one taking what's left so they sum to 1
+		names := sample(rng, scorers, 2+rng.IntN(len(scorers)-1))
+		policy.Weights = make(map[string]float64, len(names))
+		left := 1.0
+		for _, name := range names[:len(names)-1] {
+			w := left * rng.Float64()
+			policy.Weights[name] = w
+			left -= w
+		}
+		policy.Weights[names[len(names)-1]] = left
+	}
+	return policy
+}
+
+// sample returns n values drawn from values without replacement, in random order
+func sample(rng *rand.Rand, values []string, n int) []string {
+	picked := append([]string(nil), values...)
+	rng.Shuffle(len(picked), func(i, j int) { picked[i], picked[j] = picked[j], picked[i] })
+	return picked[:min(n, len(picked))]
+}
+
+// targetName names the loaded target in logs
+func targetName(url string) string {
+	if url == "" {
+		return "in-process"
+	}
+	return url
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairingerrors"
+)
+
+// SLO are the targets a load test is checked against, zero fields are unchecked
+type SLO struct {
+	P50             time.Duration
+	P95             time.Duration
+	P99             time.Duration
+	ErrorRate       float64 // Maximum share of failed and dropped requests among the scheduled ones
+	BytesPerRequest uint64  // Maximum bytes allocated per completed request
+}
+
+// Allocations are the heap allocations of the process over a load test
+// NOTE: Against a remote target they only cover the load generator and the client
+type Allocations struct {
+	Bytes       uint64
+	Objects     uint64
+	GCs         uint32
+	GCPause     time.Duration
+	PeakHeapUse uint64 // Largest heap in use sampled during the test
+}
+
+// Report summarizes a load test
+type Report struct {
+	Scheduled int // Requests due at the configured rate
+	Completed int // Requests sent and answered, successfully or not
+	Succeeded int
+	// Unpaired requests were answered that no or too few providers match their random policy, a valid answer
+	// rather than a failure
+	Unpaired    int
+	Failed      int            // Requests that failed otherwise
+	Dropped     int            // Requests due while the concurrency limit was reached, never sent
+	Errors      map[string]int // Failed requests by error code, "other" for unclassified errors
+	Elapsed     time.Duration
+	Latencies   Latencies // Of the completed requests
+	Allocations Allocations
+}
+
+// Latencies are the latency percentiles of a load test's completed requests
+type Latencies struct {
+	Mean time.Duration
+	P50  time.Duration
+	P90  time.Duration
+	P95  time.Duration
+	P99  time.Duration
+	Max  time.Duration
+}
+
+// recorder accumulates the outcome of every request of a load test, it is safe for concurrent use
+type recorder struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	succeeded int
+	unpaired  int
+	dropped   int
+	errors    map[string]int
+}
+
+// newRecorder returns a recorder expecting about n requests
+func newRecorder(n int) *recorder {
+	return &recorder{latencies: make([]time.Duration, 0, n), errors: make(map[string]int)}
+}
+
+// record accounts a completed request
+func (r *recorder) record(latency time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies = append(r.latencies, latency)
+	switch {
+	case err == nil:
+		r.succeeded++
+	case errors.Is(err, pairingerrors.ErrNoProviders), errors.Is(err, pairingerrors.ErrInsufficientProviders):
+		r.unpaired++
+	default:
+		code := string(pairingerrors.CodeOf(err))
+		if code == "" {
+			code = "other"
+		}
+		r.errors[code]++
+	}
+}
+
+// drop accounts a request that was due but not sent
+func (r *recorder) drop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dropped++
+}
+
+// report summarizes the recorded requests
+func (r *recorder) report(elapsed time.Duration, allocations Allocations) *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := &Report{
+		Completed:   len(r.latencies),
+		Succeeded:   r.succeeded,
+		Unpaired:    r.unpaired,
+		Dropped:     r.dropped,
+		Errors:      r.errors,
+		Elapsed:     elapsed,
+		Allocations: allocations,
+	}
+	report.Failed = report.Completed - report.Succeeded - report.Unpaired
+	report.Scheduled = report.Completed + report.Dropped
+	if len(r.latencies) == 0 {
+		return report
+	}
+	sorted := slices.Clone(r.latencies)
+	slices.Sort(sorted)
+	var total time.Duration
+	for _, l := range sorted {
+		total += l
+	}
+	report.Latencies = Latencies{
+		Mean: total / time.Duration(len(sorted)),
+		P50:  percentile(sorted, 0.50),
+		P90:  percentile(sorted, 0.90),
+		P95:  percentile(sorted, 0.95),
+		P99:  percentile(sorted, 0.99),
+		Max:  sorted[len(sorted)-1],
+	}
+	return report
+}
+
+// percentile returns the nearest-rank q percentile of sorted latencies
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	rank := int(q*float64(len(sorted))+0.5) - 1
+	return sorted[max(0, min(rank, len(sorted)-1))]
+}
+
+// ErrorRate returns the share of the scheduled requests that failed or were dropped
+func (r *Report) ErrorRate() float64 {
+	if r.Scheduled == 0 {
+		return 0
+	}
+	return float64(r.Failed+r.Dropped) / float64(r.Scheduled)
+}
+
+// BytesPerRequest returns the bytes allocated per completed request
+func (r *Report) BytesPerRequest() uint64 {
+	if r.Completed == 0 {
+		return 0
+	}
+	return r.Allocations.Bytes / uint64(r.Completed)
+}
+
+// Log logs the report
+func (r *Report) Log() {
+	slog.Info("Load test complete",
+		"elapsed", r.Elapsed,
+		"scheduled", r.Scheduled,
+		"completed", r.Completed,
+		"achieved_qps", fmt.Sprintf("%.1f", float64(r.Completed)/r.Elapsed.Seconds()),
+		"succeeded", r.Succeeded,
+		"unpaired", r.Unpaired,
+		"failed", r.Failed,
+		"dropped", r.Dropped,
+		"error_rate", fmt.Sprintf("%.4f", r.ErrorRate()),
+		"errors", r.Errors,
+	)
+	slog.Info("Latency",
+		"mean", r.Latencies.Mean,
+		"p50", r.Latencies.P50,
+		"p90", r.Latencies.P90,
+		"p95", r.Latencies.P95,
+		"p99", r.Latencies.P99,
+		"max", r.Latencies.Max,
+	)
+	slog.Info("Allocations",
+		"bytes", r.Allocations.Bytes,
+		"objects", r.Allocations.Objects,
+		"bytes_per_request", r.BytesPerRequest(),
+		"gcs", r.Allocations.GCs,
+		"gc_pause", r.Allocations.GCPause,
+		"peak_heap_inuse", r.Allocations.PeakHeapUse,
+	)
+}
+
+// Check returns the SLOs the report misses, described with the measured value
+func (s SLO) Check(r *Report) []string {
+	var missed []string
+	latency := func(name string, target, measured time.Duration) {
+		if target > 0 && measured > target {
+			missed = append(missed, fmt.Sprintf("%s latency %v above %v", name, measured, target))
+		}
+	}
+	latency("p50", s.P50, r.Latencies.P50)
+	latency("p95", s.P95, r.Latencies.P95)
+	latency("p99", s.P99, r.Latencies.P99)
+	if rate := r.ErrorRate(); rate > s.ErrorRate {
+		missed = append(missed, fmt.Sprintf("error rate %.4f above %.4f", rate, s.ErrorRate))
+	}
+	if s.BytesPerRequest > 0 && r.BytesPerRequest() > s.BytesPerRequest {
+		missed = append(missed, fmt.Sprintf("%d bytes allocated per request, above %d", r.BytesPerRequest(), s.BytesPerRequest))
+	}
+	if r.Completed == 0 {
+		missed = append(missed, "no request completed")
+	}
+	return missed
+}
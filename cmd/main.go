@@ -2,36 +2,137 @@ package main
 
 import (
 	// Added for Provider and ConsumerPolicy types
+	"context"
+	"errors"
+	"flag"
+	"io/fs"
 	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/Yoaz/LavaPairingSystem/config"
 	"github.com/Yoaz/LavaPairingSystem/internal/mock"
 	"github.com/Yoaz/LavaPairingSystem/internal/utils"
+	"github.com/Yoaz/LavaPairingSystem/pkg/address"
+	"github.com/Yoaz/LavaPairingSystem/pkg/auth"
+	"github.com/Yoaz/LavaPairingSystem/pkg/export"
+	"github.com/Yoaz/LavaPairingSystem/pkg/lava"
+	"github.com/Yoaz/LavaPairingSystem/pkg/metrics"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/policy"
+	"github.com/Yoaz/LavaPairingSystem/pkg/registry"
+	"github.com/Yoaz/LavaPairingSystem/pkg/scheduler"
+	"github.com/Yoaz/LavaPairingSystem/pkg/score"
+	"github.com/Yoaz/LavaPairingSystem/pkg/server"
+	"github.com/Yoaz/LavaPairingSystem/pkg/snapshot"
 )
 
 func main() {
-	// Initialize with logger `debug` level && strict mode enabled
-	app := config.Init(true, slog.LevelDebug)
+	addr := flag.String("addr", "", "serve the pairing API on this address instead of running the example (e.g. :8080)")
+	apiKeys := flag.String("api-keys", "", "comma separated API keys allowed to call the pairing API (empty disables authentication)")
+	adminKeys := flag.String("admin-keys", "", "comma separated API keys also allowed to call the admin endpoints")
+	providerKeys := flag.String("provider-keys", "", "comma separated provider_id=key pairs, each key allowed to report its provider's heartbeats, load and maintenance windows")
+	scorersFile := flag.String("scorers", "", "JSON file of additional attribute scorers (see score.ConfigurableScore)")
+	stateFile := flag.String("state", "", "file the service state (jail terms, uptime, quota usage, metric history, named policies, anomalies, maintenance windows, fairness shares) is restored from at startup and saved to on shutdown, gob if it ends in .gob and JSON otherwise")
+	exportScores := flag.String("export-scores", "", "CSV file the example's per-provider, per-component scores are appended to for offline analysis")
+	lavaExport := flag.String("lava-export", "", "Lava stake entry export (lavad query pairing providers <chain> --output json) to serve providers from instead of the mock providers")
+	templatesFile := flag.String("policy-templates", "", "JSON file of policy templates pairing requests may execute by name (see policy.Template)")
+	epoch := flag.Duration("epoch", 15*time.Minute, "epoch length, consumers subscribed to pairing updates are re-paired at every epoch boundary")
+	diagnostics := flag.Bool("diagnostics", false, "serve the pprof profiles under /debug/pprof/ and runtime variables on /debug/vars to admins, requires -admin-keys")
+	flag.Parse()
+
+	var extraScorers []score.Scorer
+	if *scorersFile != "" {
+		data, err := os.ReadFile(*scorersFile)
+		if err == nil {
+			var configurable []*score.ConfigurableScore
+			configurable, err = score.ParseConfigurableScores(data)
+			for _, s := range configurable {
+				extraScorers = append(extraScorers, s)
+			}
+		}
+		if err != nil {
+			slog.Error("Failed to load scorers", "file", *scorersFile, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Initialize from the LAVA_PAIRING_* environment, by default with logger `debug` level && strict mode enabled
+	app, err := config.FromEnv(extraScorers...)
+	if err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	defer app.PairingSystem.Close()
 	log := app.Log
 
+	if *addr != "" {
+		if *diagnostics && *adminKeys == "" {
+			// Profiles and the command line would be public otherwise
+			log.Error("Diagnostics require admin keys, set -admin-keys")
+			os.Exit(1)
+		}
+		var source server.ProviderSource = server.StaticSource(mock.Providers)
+		if *lavaExport != "" {
+			export, err := lava.LoadExport(*lavaExport)
+			if err != nil {
+				log.Error("Failed to load Lava export", "file", *lavaExport, "error", err)
+				os.Exit(1)
+			}
+			log.Info("Loaded Lava export", "file", *lavaExport, "chains", export.Chains())
+			// Serve the export through a registry, so invalid stake entries are rejected instead of paired
+			providers := registry.NewRegistry(registry.Config{Addresses: &address.Parser{Prefix: address.LavaPrefix}})
+			providers.Subscribe(func(e registry.RejectionEvent) {
+				log.Warn("Rejected provider", "chain_id", e.ChainID, "provider_id", e.ProviderID, "reason", e.Reason)
+			})
+			for _, chainID := range export.Chains() {
+				chainProviders, _ := export.Providers(chainID)
+				providers.Load(chainID, chainProviders)
+			}
+			source = providers
+		}
+		var templates []*policy.Template
+		if *templatesFile != "" {
+			var err error
+			if templates, err = policy.LoadTemplates(*templatesFile); err != nil {
+				log.Error("Failed to load policy templates", "file", *templatesFile, "error", err)
+				os.Exit(1)
+			}
+		}
+		serve(app, source, templates, *addr, *apiKeys, *adminKeys, *providerKeys, *stateFile, *epoch, *diagnostics)
+		return
+	}
+
 	// --- Example Usage  ---
 	providers := mock.Providers   // Mock data for providers
 	policy := mock.ConsumerPolicy // Mock data for consumer policy
 
 	// Making sure consumer policy assigned weights is valid
-	err := utils.ValidateWeights(policy.Weights)
-	if err != nil {
+	if err := utils.ValidateScopedWeights(policy); err != nil {
 		log.With("error", err).Error("Invalid weights in consumer policy")
 		return
 	}
+	// Catch feature typos before they silently filter every provider out
+	if app.Features != nil {
+		if err := app.Features.ValidatePolicy(policy); err != nil {
+			log.With("error", err).Error("Invalid features in consumer policy")
+			return
+		}
+	}
 
 	log.Info("Attempting to get pairing list with mock data", "policy_location", policy.RequiredLocation, "policy_min_stake", policy.MinStake, "policy_features_count", len(policy.RequiredFeatures))
 
-	topProviders, err := app.PairingSystem.GetPairingList(providers, policy)
+	result, err := app.PairingSystem.GetPairingList(providers, policy)
 	if err != nil { // If strict mode is enabled, expect an error if no providers match the policy
 		log.With("error", err).Error("Failed to get pairing list")
 	} else {
-		log.Info("Successfully retrieved pairing list", "count", len(topProviders))
+		topProviders := result.Providers
+		log.Info("Successfully retrieved pairing list", "count", len(topProviders), "partial", result.Partial, "config_hash", result.ConfigHash, "elapsed", result.Durations.Total)
 		log.Info("----------------------------- TOP PROVIDERS -----------------------------")
 		if len(topProviders) == 0 {
 			log.Info("No providers matched the policy and were selected.")
@@ -42,6 +143,116 @@ func main() {
 			}
 		}
 	}
+	if *exportScores != "" {
+		if err := exportScoreMatrix(*exportScores, app.Scorers, app.PairingSystem.RankProviders(providers, policy), policy); err != nil {
+			log.With("error", err).Error("Failed to export scores", "file", *exportScores)
+		} else {
+			log.Info("Exported score matrix", "file", *exportScores, "providers", len(providers))
+		}
+	}
 	// --- End Example Usage ---
 
 }
+
+// exportScoreMatrix appends the given scores to the CSV file at path, with a column per scorer
+func exportScoreMatrix(path string, scorers []score.Scorer, scores []*pairing.PairingScore, policy *pairing.ConsumerPolicy) error {
+	components := make([]string, 0, len(scorers))
+	for _, s := range scorers {
+		components = append(components, s.Name())
+	}
+	exporter, err := export.OpenCSV(path, components...)
+	if err != nil {
+		return err
+	}
+	return errors.Join(exporter.Export(time.Now(), policy, scores), exporter.Close())
+}
+
+// serve runs the pairing API over the given providers until the server fails or the process is interrupted
+// If stateFile is set, the service state is restored from it first and saved back to it on shutdown
+func serve(app *config.AppConfig, source server.ProviderSource, templates []*policy.Template, addr, apiKeys, adminKeys, providerKeys, stateFile string, epoch time.Duration, diagnostics bool) {
+	policies := policy.NewStore()
+	state := snapshot.Components{
+		Jailer:      app.Jailer,
+		Uptime:      app.Uptime,
+		Quota:       app.Quota,
+		Metrics:     app.Metrics,
+		Policies:    policies,
+		Anomalies:   app.Anomalies,
+		Maintenance: app.Maintenance,
+		Fairness:    app.Fairness,
+	}
+	if stateFile != "" {
+		if err := state.LoadFile(stateFile); errors.Is(err, fs.ErrNotExist) {
+			app.Log.Info("No saved state, starting cold", "file", stateFile)
+		} else if err != nil {
+			app.Log.Error("Failed to restore state, starting cold", "file", stateFile, "error", err)
+		} else {
+			app.Log.Info("Restored service state", "file", stateFile)
+		}
+	}
+
+	sched := scheduler.NewScheduler(app.PairingSystem, source, epoch, app.Log)
+	if providers, ok := source.(*registry.Registry); ok {
+		providers.Watch(sched.Changed) // Subscribers get provider churn right away, not at the next epoch
+	}
+	sched.Start()
+	defer sched.Stop()
+
+	opts := []server.Option{server.WithJailer(app.Jailer), server.WithUptimeTracker(app.Uptime), server.WithScheduler(sched), server.WithMetrics(metrics.NewRegistry()), server.WithPolicyStore(policies), server.WithPolicyTemplates(templates...), server.WithScoreHistory(app.Metrics), server.WithMaintenance(app.Maintenance), server.WithLoadReports(app.Metrics), server.WithLatencyReports(app.Metrics), server.WithFeeHistory(app.Metrics), server.WithQuota(app.Quota)}
+	// Consumers are keyed by their normalized Lava address, as the registry keys providers
+	opts = append(opts, server.WithAddressParser(&address.Parser{Prefix: address.LavaPrefix}))
+	if app.Features != nil {
+		opts = append(opts, server.WithFeatureCatalog(app.Features))
+	}
+	if adminKeys != "" {
+		// Admin endpoints are only mounted for admin keys, anyone could call them otherwise
+		opts = append(opts, server.WithAdmin(nil), server.WithAnomalyDetector(app.Anomalies))
+	}
+	if diagnostics {
+		opts = append(opts, server.WithDiagnostics())
+	}
+	if apiKeys != "" || adminKeys != "" || providerKeys != "" {
+		keys := make(map[string]*auth.Principal)
+		for i, key := range strings.Split(apiKeys, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				keys[key] = &auth.Principal{ID: "api-key-" + strconv.Itoa(i+1)}
+			}
+		}
+		for i, key := range strings.Split(adminKeys, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				keys[key] = &auth.Principal{ID: "admin-key-" + strconv.Itoa(i+1), Admin: true}
+			}
+		}
+		for _, pair := range strings.Split(providerKeys, ",") {
+			providerID, key, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || providerID == "" || key == "" {
+				continue
+			}
+			keys[key] = &auth.Principal{ID: "provider-" + providerID, ProviderID: providerID}
+		}
+		opts = append(opts, server.WithAuthenticators(&auth.APIKeyAuthenticator{Keys: keys}))
+	}
+
+	srv := server.NewServer(app.PairingSystem, source, app.Log, opts...)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			app.Log.With("error", err).Error("Failed to shut down the pairing API server")
+		}
+	}()
+	if err := srv.ListenAndServe(addr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		app.Log.With("error", err).Error("Pairing API server stopped")
+	}
+
+	if stateFile != "" {
+		if err := state.SaveFile(stateFile); err != nil {
+			app.Log.Error("Failed to save state", "file", stateFile, "error", err)
+		} else {
+			app.Log.Info("Saved service state", "file", stateFile)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Command replay re-runs pairing calls recorded with LAVA_PAIRING_RECORD_DIR and reports whether they reproduce
+//
+//	go run ./cmd/replay recordings/*.json
+//
+// Each call is replayed on a fresh system configured from the LAVA_PAIRING_* environment, restored to the
+// state the call was paired against and pinned to its clock. It exits with status 1 if any call doesn't
+// reproduce its recorded outcome
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/config"
+	"github.com/Yoaz/LavaPairingSystem/pkg/replay"
+	"github.com/Yoaz/LavaPairingSystem/pkg/snapshot"
+)
+
+func main() {
+	verbose := flag.Bool("verbose", false, "log the replayed pipelines at the environment's log level instead of warnings only")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		slog.Error("No recording files given, usage: replay [-verbose] <recording.json>...")
+		os.Exit(2)
+	}
+	env, err := config.ParseEnv(os.Environ())
+	if err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	env.RecordDir = "" // Replays aren't recorded again
+	if !*verbose {
+		env.LogLevel = slog.LevelWarn
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		report, err := replayFile(*env, path)
+		switch {
+		case err != nil:
+			slog.Error("Failed to replay recording", "file", path, "error", err)
+			failed = true
+		case !report.Match:
+			slog.Error("Replay diverged from the recorded call", "file", path, "request_id", report.RequestID,
+				"config_match", report.ConfigMatch, "recorded", report.Recorded, "replayed", report.Replayed)
+			failed = true
+		default:
+			slog.Info("Replay reproduced the recorded call", "file", path, "request_id", report.RequestID,
+				"selected", len(report.Replayed.Selected))
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// replayFile replays a recording file on a system of its own, pinned to the recorded clock and restored to the
+// recorded state
+func replayFile(env config.Env, path string) (*replay.Report, error) {
+	file, err := replay.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	at := file.Recording.Options.Now
+	env.Now = func() time.Time { return at }
+	app, err := config.New(env)
+	if err != nil {
+		return nil, err
+	}
+	defer app.PairingSystem.Close()
+	if file.State != nil {
+		state := snapshot.Components{
+			Jailer:      app.Jailer,
+			Uptime:      app.Uptime,
+			Quota:       app.Quota,
+			Metrics:     app.Metrics,
+			Anomalies:   app.Anomalies,
+			Maintenance: app.Maintenance,
+			Fairness:    app.Fairness,
+		}
+		if err := state.Restore(file.State); err != nil {
+			return nil, err
+		}
+	}
+	if app.PairingSystem.ConfigFingerprint() != file.Recording.ConfigFingerprint {
+		app.Log.Warn("Replaying on another configuration than the recorded call's",
+			"recorded", file.Recording.ConfigFingerprint, "current", app.PairingSystem.ConfigFingerprint())
+	}
+	return replay.Replay(app.PairingSystem, file.Recording, nil)
+}
@@ -1,24 +1,130 @@
 package config
 
 import (
+	_ "embed"
 	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"time"
 
-	"github.com/Yoaz/LavaPairingSystem/internal/filter"
 	"github.com/Yoaz/LavaPairingSystem/internal/logger"
-	"github.com/Yoaz/LavaPairingSystem/internal/score"
-	"github.com/Yoaz/LavaPairingSystem/internal/system"
+	"github.com/Yoaz/LavaPairingSystem/pkg/anomaly"
+	"github.com/Yoaz/LavaPairingSystem/pkg/fairness"
+	"github.com/Yoaz/LavaPairingSystem/pkg/feature"
+	"github.com/Yoaz/LavaPairingSystem/pkg/filter"
+	"github.com/Yoaz/LavaPairingSystem/pkg/jail"
+	"github.com/Yoaz/LavaPairingSystem/pkg/latency"
+	"github.com/Yoaz/LavaPairingSystem/pkg/maintenance"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/quota"
+	"github.com/Yoaz/LavaPairingSystem/pkg/replay"
+	"github.com/Yoaz/LavaPairingSystem/pkg/score"
+	"github.com/Yoaz/LavaPairingSystem/pkg/snapshot"
+	"github.com/Yoaz/LavaPairingSystem/pkg/system"
+	"github.com/Yoaz/LavaPairingSystem/pkg/timeseries"
+	"github.com/Yoaz/LavaPairingSystem/pkg/uptime"
 )
 
+// Default jailing parameters: failure reports from 5 consumers within 10 minutes jail a provider for 30 minutes,
+// and a consumer may send 20 reports within those 10 minutes
+const (
+	defaultJailMaxFailures = 5
+	defaultJailWindow      = 10 * time.Minute
+	defaultJailDuration    = 30 * time.Minute
+	defaultJailMaxReports  = 20
+)
+
+// defaultHeartbeatInterval is how often providers are expected to send a heartbeat
+const defaultHeartbeatInterval = time.Minute
+
+// Provider metric history kept for rolling aggregates: 7 days at 1 minute resolution
+const (
+	defaultMetricRetention  = 7 * 24 * time.Hour
+	defaultMetricResolution = time.Minute
+)
+
+// defaultAggregateTTL is how long the pool-wide scoring aggregates of a provider pool version are reused
+const defaultAggregateTTL = time.Minute
+
+// defaultQuotaEpoch is the period consumer quotas reset after
+const defaultQuotaEpoch = time.Hour
+
+// defaultAnomalies flags fees 1000x the median, stake swings beyond ±50% and emptied feature lists, only
+// logging them: nobody is quarantined
+var defaultAnomalies = anomaly.Config{FeeMedianFactor: 1000, MaxStakeChange: 0.5, FlagEmptyFeatures: true}
+
+// defaultMaxLatency is the probed latency (ms) at which LatencyScore reaches 0
+const defaultMaxLatency = 1000
+
+// defaultDelegationFactor weighs delegated stake equally to self stake, as Lava does
+const defaultDelegationFactor = pairing.DefaultDelegationFactor
+
+// DelegationWeights is a ready-made ConsumerPolicy.Weights preset for delegation-oriented consumers,
+// favoring well staked providers that share most of their rewards with delegators
+var DelegationWeights = map[string]float64{
+	"StakeScore":      0.4,
+	"CommissionScore": 0.4,
+	"FeeScore":        0.2,
+}
+
+// defaultLatencyMatrix is the region-to-region latency matrix used by ProximityScore
+//
+//go:embed latency_matrix.json
+var defaultLatencyMatrix []byte
+
+// defaultFeatureCatalog lists the feature identifiers policies and providers may reference
+//
+//go:embed features.json
+var defaultFeatureCatalog []byte
+
+// defaultLayers are the global and per-chain pairing settings policies inherit, empty so the system's defaults
+// and the environment apply unless a deployment sets its own
+//
+//go:embed layers.json
+var defaultLayers []byte
+
 // Init initializes the application configuration, including filters, scorers, and the pairing system
 // It takes a strictMode boolean to determine if strict mode is enabld and a logLevel for logging
-func Init(strictMode bool, logLevel slog.Level) *AppConfig {
-	log := logger.NewWithLevel(logLevel)
+// Extra scorers (e.g. score.ConfigurableScore definitions loaded by the caller) are added to the defaults
+func Init(strictMode bool, logLevel slog.Level, extraScorers ...score.Scorer) *AppConfig {
+	app, _ := initialize(Env{Strict: strictMode, LogLevel: logLevel}, extraScorers) // Only environment weights can fail
+	return app
+}
+
+// initialize builds the application configuration from the given settings, see Init and FromEnv
+func initialize(env Env, extraScorers []score.Scorer) (*AppConfig, error) {
+	log := logger.NewWithLevel(env.LogLevel)
 	log.Info("Initializing LavaPairingSystem...")
 
+	jailer := jail.NewJailer(jail.Config{
+		MaxFailures:           defaultJailMaxFailures,
+		Window:                defaultJailWindow,
+		JailDuration:          defaultJailDuration,
+		MaxReportsPerReporter: defaultJailMaxReports,
+	})
+	jailer.Subscribe(func(e jail.Event) {
+		log.Warn("Provider jail status changed", "event", e.Type, "provider_id", e.ProviderID, "until", e.Until, "reason", e.Reason)
+	})
+
+	uptimeTracker := uptime.NewTracker(defaultHeartbeatInterval)
+	if env.Now != nil {
+		jailer.SetClock(env.Now)
+		uptimeTracker.SetClock(env.Now)
+	}
+	schedule := maintenance.NewSchedule()
+
 	filters := []filter.Filter{
 		filter.LocationFilter{},
 		filter.FeatureFilter{},
-		filter.StakeFilter{},
+		filter.StakeFilter{DelegationFactor: defaultDelegationFactor},
+		filter.APIInterfaceFilter{},
+		filter.SecurityFilter{},
+		filter.JailFilter{Jailer: jailer},
+		filter.MinUptimeFilter{Tracker: uptimeTracker},
+		filter.FeeFilter{},
+		filter.StalenessFilter{Now: env.Now},
+		filter.MaintenanceFilter{Schedule: schedule, Now: env.Now},
+		filter.LockUpFilter{Now: env.Now},
 	}
 	log.Debug("Initialized filters", "count", len(filters))
 
@@ -27,16 +133,121 @@ func Init(strictMode bool, logLevel slog.Level) *AppConfig {
 		&score.FeatureScore{},
 		&score.LocationScore{},
 		&score.FeeScore{},
+		&score.CommissionScore{}, // Only contributes when weighted, see DelegationWeights
+		&score.UptimeScore{Tracker: uptimeTracker},
+		&score.MaintenanceScore{Schedule: schedule, Now: env.Now}, // Only penalizes providers with a window within the hour
+		&score.LoadScore{},                                        // Only affects providers reporting their load
+		&score.LatencyScore{MaxLatency: defaultMaxLatency},        // Only affects providers probed for latency
+		&score.LockUpScore{Now: env.Now},                          // Only affects providers reporting their lock-up
+	}
+	matrix, err := latency.ParseMatrix(defaultLatencyMatrix)
+	if err != nil {
+		log.Error("Invalid default latency matrix, ProximityScore disabled", "error", err)
+		matrix = nil // Connection hints then leave expected latencies unknown
+	} else {
+		scorers = append(scorers, &score.ProximityScore{Matrix: matrix})
 	}
+	scorers = append(scorers, extraScorers...)
 	log.Debug("Initialized scorers", "count", len(scorers))
 
-	pairingSystem := system.NewPairingSystem(filters, scorers, log, strictMode)
-	log.Info("Pairing system initialized successfully.")
+	features, err := feature.ParseCatalog(defaultFeatureCatalog)
+	if err != nil {
+		log.Error("Invalid default feature catalog, feature validation disabled", "error", err)
+	}
+
+	metrics := timeseries.NewStore(defaultMetricRetention, defaultMetricResolution)
+	if env.Now != nil {
+		metrics.SetClock(env.Now)
+	}
+	anomalies := anomaly.NewDetector(defaultAnomalies)
+	quotas := quota.NewTracker(defaultQuotaEpoch, env.Quota)
+	if env.Now != nil {
+		quotas.SetClock(env.Now)
+	}
+
+	if err := env.validateWeights(scorers); err != nil {
+		return nil, err
+	}
+
+	options := []system.Option{
+		system.WithDelegationFactor(defaultDelegationFactor),
+		system.WithTimeSeries(metrics),
+		system.WithUnknownWeights(system.UnknownWeightsError), // Surface weight key typos instead of silently zero-weighting
+		system.WithWorkers(env.Workers),
+		system.WithAggregateCache(defaultAggregateTTL),
+		system.WithConnectionHints(matrix),
+		system.WithScoreHistory(metrics),
+		system.WithAnomalyDetection(anomalies),
+		system.WithQuota(quotas),
+	}
+	if env.SnapshotCommitment {
+		options = append(options, system.WithSnapshotCommitment())
+	}
+	var shares *fairness.Tracker
+	if env.Fairness.MaxShare > 0 {
+		cfg := env.Fairness
+		if cfg.Window == 0 {
+			cfg.Window = defaultEnvFairnessWindow
+		}
+		shares = fairness.NewTracker(cfg)
+		if env.Now != nil {
+			shares.SetClock(env.Now)
+		}
+		options = append(options, system.WithFairness(shares))
+	}
+	layers, err := system.ParseLayers(defaultLayers, scorers)
+	if err != nil {
+		log.Error("Invalid default configuration layers, using the system defaults", "error", err)
+		layers = &system.Layers{}
+	}
+	// The environment sets the global layer, chains and policies can still override it
+	if env.TopN > 0 {
+		layers.Global.TopN = env.TopN
+	}
+	if env.Weights != nil {
+		layers.Global.Weights = env.Weights
+	}
+	options = append(options, system.WithLayers(layers))
+	if env.RecordDir != "" {
+		// Record the state the calls are paired against along with them, replays restore it
+		recorder := replay.NewFileRecorder(env.RecordDir, snapshot.Components{
+			Jailer:      jailer,
+			Uptime:      uptimeTracker,
+			Quota:       quotas,
+			Metrics:     metrics,
+			Anomalies:   anomalies,
+			Maintenance: schedule,
+			Fairness:    shares,
+		}, log)
+		rate := env.RecordRate
+		if rate == 0 {
+			rate = defaultEnvRecordRate
+		}
+		sample := func(policy *pairing.ConsumerPolicy) bool {
+			if len(env.RecordConsumers) > 0 && !slices.Contains(env.RecordConsumers, string(policy.ConsumerID)) {
+				return false
+			}
+			return rand.Float64() < rate
+		}
+		options = append(options, system.WithRecorder(recorder, sample))
+		log.Info("Recording pairing calls for replay", "dir", env.RecordDir, "consumers", env.RecordConsumers, "rate", rate)
+	}
+
+	pairingSystem := system.NewPairingSystem(filters, scorers, log, env.Strict, options...)
+	log.Info("Pairing system initialized successfully.", "config_fingerprint", pairingSystem.ConfigFingerprint())
 
 	return &AppConfig{
 		Log:           log,
 		Filters:       filters,
 		Scorers:       scorers,
 		PairingSystem: pairingSystem,
-	}
+		Jailer:        jailer,
+		Uptime:        uptimeTracker,
+		Metrics:       metrics,
+		Features:      features,
+		Anomalies:     anomalies,
+		Maintenance:   schedule,
+		Quota:         quotas,
+		Fairness:      shares,
+	}, nil
 }
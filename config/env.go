@@ -0,0 +1,159 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/internal/utils"
+	"github.com/Yoaz/LavaPairingSystem/pkg/score"
+)
+
+// FromEnv initializes the application configuration like Init, from the LAVA_PAIRING_* environment variables
+// (see EnvStrict and the other Env constants) instead of arguments, for container deployments
+// Every invalid variable is reported at once, wrapping ErrInvalidEnv
+func FromEnv(extraScorers ...score.Scorer) (*AppConfig, error) {
+	env, err := ParseEnv(os.Environ())
+	if err != nil {
+		return nil, err
+	}
+	return initialize(*env, extraScorers)
+}
+
+// New initializes the application configuration from the given settings, for callers building their own Env
+// (e.g. from ParseEnv) instead of reading the environment
+func New(env Env, extraScorers ...score.Scorer) (*AppConfig, error) {
+	return initialize(env, extraScorers)
+}
+
+// ParseEnv parses the LAVA_PAIRING_* variables of an environment in os.Environ's "KEY=value" form
+// Unknown LAVA_PAIRING_* variables are rejected, they are most likely misspelled
+func ParseEnv(environ []string) (*Env, error) {
+	env := &Env{Strict: defaultEnvStrict, LogLevel: defaultEnvLogLevel}
+	var errs []error
+	invalid := func(key, value, expected string) {
+		errs = append(errs, fmt.Errorf("%w: %s=%q, expected %s", ErrInvalidEnv, key, value, expected))
+	}
+	for _, entry := range environ {
+		key, value, _ := strings.Cut(entry, "=")
+		if !strings.HasPrefix(key, EnvPrefix) {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case EnvStrict, EnvSnapshotCommitment:
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				invalid(key, value, "a boolean (true or false)")
+				continue
+			}
+			if key == EnvStrict {
+				env.Strict = enabled
+			} else {
+				env.SnapshotCommitment = enabled
+			}
+		case EnvTopN, EnvWorkers:
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				invalid(key, value, "a positive integer")
+				continue
+			}
+			if key == EnvTopN {
+				env.TopN = n
+			} else {
+				env.Workers = n
+			}
+		case EnvQuotaRequests, EnvQuotaComputeUnits:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n <= 0 {
+				invalid(key, value, "a positive integer")
+				continue
+			}
+			if key == EnvQuotaRequests {
+				env.Quota.MaxRequests = n
+			} else {
+				env.Quota.MaxComputeUnits = n
+			}
+		case EnvFairnessMaxShare:
+			share, err := strconv.ParseFloat(value, 64)
+			if err != nil || !(share > 0 && share < 1) {
+				invalid(key, value, "a share of the selections within (0, 1), e.g. 0.1")
+				continue
+			}
+			env.Fairness.MaxShare = share
+		case EnvFairnessWindow:
+			window, err := time.ParseDuration(value)
+			if err != nil || window <= 0 {
+				invalid(key, value, "a positive duration, e.g. 1h")
+				continue
+			}
+			env.Fairness.Window = window
+		case EnvLogLevel:
+			if err := env.LogLevel.UnmarshalText([]byte(value)); err != nil {
+				invalid(key, value, "debug, info, warn or error")
+			}
+		case EnvWeights:
+			var weights map[string]float64
+			if err := json.Unmarshal([]byte(value), &weights); err != nil {
+				invalid(key, value, `a JSON object of scorer weights, e.g. {"StakeScore": 0.6, "FeeScore": 0.4}`)
+				continue
+			}
+			if err := utils.ValidateWeights(weights); err != nil {
+				errs = append(errs, fmt.Errorf("%w: %s: %v", ErrInvalidEnv, key, err))
+				continue
+			}
+			env.Weights = weights
+		case EnvRecordDir:
+			env.RecordDir = value
+		case EnvRecordRate:
+			rate, err := strconv.ParseFloat(value, 64)
+			if err != nil || !(rate > 0 && rate <= 1) {
+				invalid(key, value, "a share of the calls within (0, 1], e.g. 0.01")
+				continue
+			}
+			env.RecordRate = rate
+		case EnvRecordConsumers:
+			for _, consumerID := range strings.Split(value, ",") {
+				if consumerID = strings.TrimSpace(consumerID); consumerID != "" {
+					env.RecordConsumers = append(env.RecordConsumers, consumerID)
+				}
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%w: unknown variable %s, expected one of %s", ErrInvalidEnv, key,
+				strings.Join([]string{EnvStrict, EnvTopN, EnvWorkers, EnvLogLevel, EnvWeights, EnvRecordDir, EnvRecordConsumers, EnvRecordRate, EnvSnapshotCommitment, EnvQuotaRequests, EnvQuotaComputeUnits, EnvFairnessMaxShare, EnvFairnessWindow}, ", ")))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return env, nil
+}
+
+// validateWeights checks the environment weights only name known scorers, a typo would fail every request
+func (env Env) validateWeights(scorers []score.Scorer) error {
+	names := make([]string, 0, len(scorers))
+	for _, s := range scorers {
+		names = append(names, s.Name())
+	}
+	for _, name := range sortedKeys(env.Weights) {
+		if !slices.Contains(names, name) {
+			return fmt.Errorf("%w: %s: unknown scorer %q, expected one of %s", ErrInvalidEnv, EnvWeights, name, strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of a map, sorted
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
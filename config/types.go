@@ -1,11 +1,21 @@
 package config
 
 import (
+	"errors"
 	"log/slog"
+	"time"
 
-	"github.com/Yoaz/LavaPairingSystem/internal/filter"
-	"github.com/Yoaz/LavaPairingSystem/internal/score"
-	"github.com/Yoaz/LavaPairingSystem/internal/system"
+	"github.com/Yoaz/LavaPairingSystem/pkg/anomaly"
+	"github.com/Yoaz/LavaPairingSystem/pkg/fairness"
+	"github.com/Yoaz/LavaPairingSystem/pkg/feature"
+	"github.com/Yoaz/LavaPairingSystem/pkg/filter"
+	"github.com/Yoaz/LavaPairingSystem/pkg/jail"
+	"github.com/Yoaz/LavaPairingSystem/pkg/maintenance"
+	"github.com/Yoaz/LavaPairingSystem/pkg/quota"
+	"github.com/Yoaz/LavaPairingSystem/pkg/score"
+	"github.com/Yoaz/LavaPairingSystem/pkg/system"
+	"github.com/Yoaz/LavaPairingSystem/pkg/timeseries"
+	"github.com/Yoaz/LavaPairingSystem/pkg/uptime"
 )
 
 // AppConfig holds the configuration for the application, including filters, scorers, and the pairing system
@@ -14,4 +24,70 @@ type AppConfig struct {
 	Filters       []filter.Filter
 	Scorers       []score.Scorer
 	PairingSystem system.PairingSystem
+	Jailer        *jail.Jailer
+	Uptime        *uptime.Tracker
+	Metrics       *timeseries.Store     // Per-provider metric history (fee, latency) for rolling aggregates
+	Features      *feature.Catalog      // Known feature identifiers, nil if the catalog failed to load
+	Anomalies     *anomaly.Detector     // Flags suspicious provider entries seen by the pairing system
+	Maintenance   *maintenance.Schedule // Maintenance windows declared by providers
+	Quota         *quota.Tracker        // Per-consumer quotas, unlimited unless set through the environment
+	Fairness      *fairness.Tracker     // Providers' selection shares, nil unless capped through the environment
+}
+
+// Environment variables read by FromEnv
+const (
+	EnvPrefix   = "LAVA_PAIRING_"
+	EnvStrict   = EnvPrefix + "STRICT"    // Boolean: true, false, 1, 0
+	EnvTopN     = EnvPrefix + "TOPN"      // Positive integer
+	EnvWorkers  = EnvPrefix + "WORKERS"   // Positive integer
+	EnvLogLevel = EnvPrefix + "LOG_LEVEL" // debug, info, warn or error
+	EnvWeights  = EnvPrefix + "WEIGHTS"   // JSON object of scorer name -> weight, e.g. {"StakeScore": 0.6, "FeeScore": 0.4}
+	// Directory pairing calls are recorded to for replays (see replay.FileRecorder), unset disables recording
+	EnvRecordDir = EnvPrefix + "RECORD_DIR"
+	// Comma separated consumer IDs whose calls are recorded, unset records every call
+	EnvRecordConsumers = EnvPrefix + "RECORD_CONSUMERS"
+	// Share (0-1] of the calls of the recorded consumers that are recorded
+	EnvRecordRate = EnvPrefix + "RECORD_RATE"
+	// Boolean, whether results commit to the providers they considered (see system.WithSnapshotCommitment)
+	EnvSnapshotCommitment = EnvPrefix + "SNAPSHOT_COMMITMENT"
+	// Positive integers, the requests and compute units each consumer may use per quota epoch, unset is unlimited
+	EnvQuotaRequests     = EnvPrefix + "QUOTA_REQUESTS"
+	EnvQuotaComputeUnits = EnvPrefix + "QUOTA_COMPUTE_UNITS"
+	// Share (0-1) of the selections a single provider may get (see system.WithFairness), unset is uncapped
+	EnvFairnessMaxShare = EnvPrefix + "FAIRNESS_MAX_SHARE"
+	// Duration (e.g. 1h) of the rolling window selection shares are accounted over
+	EnvFairnessWindow = EnvPrefix + "FAIRNESS_WINDOW"
+)
+
+// Defaults for unset environment variables, matching the example service
+const (
+	defaultEnvStrict         = true
+	defaultEnvLogLevel       = slog.LevelDebug
+	defaultEnvRecordRate     = 0.01 // Every recording captures the whole metric history, sample sparingly
+	defaultEnvFairnessWindow = time.Hour
+)
+
+// ErrInvalidEnv is returned by FromEnv and ParseEnv when an environment variable is invalid
+var ErrInvalidEnv = errors.New("invalid environment configuration")
+
+// Env is the configuration read from the environment, see FromEnv
+// Zero TopN, Workers and nil Weights leave the configured defaults in place
+type Env struct {
+	Strict          bool
+	TopN            int
+	Workers         int
+	LogLevel        slog.Level
+	Weights         map[string]float64
+	RecordDir       string
+	RecordConsumers []string
+	RecordRate      float64 // Zero records defaultEnvRecordRate of the calls
+	// SnapshotCommitment stamps results with the Merkle root of their providers, off by default as it hashes
+	// every provider of each new pool version
+	SnapshotCommitment bool
+	Quota              quota.Limits // Default per-consumer quota, zero fields are unlimited
+	// Fairness caps providers' selection shares when its MaxShare is set, a zero Window is defaultEnvFairnessWindow
+	Fairness fairness.Config
+	// Now is the clock of the time-dependent filters, scorers and trackers, time.Now when nil
+	// It isn't read from the environment, replays pin it to the recorded call's time
+	Now func() time.Time
 }
@@ -0,0 +1,54 @@
+package errgroup
+
+import (
+	"context"
+	"errors"
+)
+
+// WithContext returns a new Group and a derived context that is canceled the first time a function passed
+// to Go returns an error, or when Wait returns, whichever occurs first
+func WithContext(ctx context.Context) (*Group, context.Context) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	return &Group{cancel: cancel}, ctx
+}
+
+// SetSpawner makes Go start functions through spawn (e.g. a worker pool's Submit) instead of the go statement
+// It must be called before the first Go
+func (g *Group) SetSpawner(spawn func(func())) {
+	g.spawn = spawn
+}
+
+// Go calls f in a new goroutine (or through the spawner, see SetSpawner)
+// The first call to return a non-nil error cancels the group's context, if any
+func (g *Group) Go(f func() error) {
+	g.wg.Add(1)
+	run := func() {
+		defer g.wg.Done()
+		if err := f(); err != nil {
+			g.mu.Lock()
+			first := len(g.errs) == 0
+			g.errs = append(g.errs, err)
+			g.mu.Unlock()
+			if first && g.cancel != nil {
+				g.cancel(err)
+			}
+		}
+	}
+	if g.spawn != nil {
+		g.spawn(run)
+		return
+	}
+	go run()
+}
+
+// Wait blocks until all function calls from Go have returned, then returns their errors joined together
+// (nil if none failed), the first error first
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	if g.cancel != nil {
+		g.cancel(nil)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return errors.Join(g.errs...)
+}
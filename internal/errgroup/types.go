@@ -0,0 +1,18 @@
+package errgroup
+
+import (
+	"context"
+	"sync"
+)
+
+// Group runs a set of goroutines working on subtasks of a common task, collecting their errors
+// It mirrors golang.org/x/sync/errgroup, except that Wait returns every error (joined) rather than only the
+// first, and goroutines can be started through a custom spawner such as a worker pool
+type Group struct {
+	cancel context.CancelCauseFunc // Cancels the group's context on the first error, nil without WithContext
+	spawn  func(func())            // Starts a goroutine, defaults to the go statement
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	errs []error
+}
@@ -1,20 +1,25 @@
 package mock
 
 import (
-	pairing "github.com/Yoaz/LavaPairingSystem/internal"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
 )
 
+// fetchedAt stamps the mocked providers' LastUpdated, as if they were fetched when the process started
+var fetchedAt = time.Now()
+
 var (
 	// Mocked Providers
 	Providers = []*pairing.Provider{
-		{ID: "1", Address: "provider1", Stake: 1000, Location: "US-West", Features: []string{"featA", "featB", "featC"}, Fee: 3.0},
-		{ID: "2", Address: "provider2", Stake: 2000, Location: "US-East", Features: []string{"featA", "featB"}, Fee: 0.015},
-		{ID: "3", Address: "provider3", Stake: 1500, Location: "EU-Central", Features: []string{"featA", "featC", "featD"}, Fee: 4.5},
-		{ID: "4", Address: "provider4", Stake: 500, Location: "US-West", Features: []string{"featB"}, Fee: 0.005},
-		{ID: "5", Address: "provider5", Stake: 2500, Location: "US-West", Features: []string{"featA", "featB", "featC", "featExtra"}, Fee: 0.8},
-		{ID: "6", Address: "provider6", Stake: 1200, Location: "EU-Central", Features: []string{"featA", "featD", "featE"}, Fee: 1.7},
-		{ID: "7", Address: "provider7", Stake: 800, Location: "US-East", Features: []string{"featA", "featB", "featC", "featX"}, Fee: 2.0},
-		{ID: "8", Address: "provider8", Stake: 3000, Location: "US-West", Features: []string{"featA", "featB", "featC", "featY", "featZ"}, Fee: 2.5},
+		{ID: "1", Address: "provider1", Commission: 10, Stake: 1000, Location: "US-West", Features: []string{"featA", "featB", "featC"}, Fee: 3.0, Endpoints: []pairing.Endpoint{{URL: "https://provider1.example.com/jsonrpc", APIInterface: pairing.APIInterfaceJSONRPC, Geolocation: "US-West"}, {URL: "https://provider1.example.com/rest", APIInterface: pairing.APIInterfaceREST, Geolocation: "US-West"}}, TLSEnabled: true, LastUpdated: fetchedAt},
+		{ID: "2", Address: "provider2", Commission: 5, Stake: 2000, Location: "US-East", Features: []string{"featA", "featB"}, Fee: 0.015, Endpoints: []pairing.Endpoint{{URL: "https://provider2.example.com/jsonrpc", APIInterface: pairing.APIInterfaceJSONRPC, Geolocation: "US-East"}}, TLSEnabled: true, LastUpdated: fetchedAt},
+		{ID: "3", Address: "provider3", Commission: 20, Stake: 1500, Location: "EU-Central", Features: []string{"featA", "featC", "featD"}, Fee: 4.5, Endpoints: []pairing.Endpoint{{URL: "https://provider3.example.com/grpc", APIInterface: pairing.APIInterfaceGRPC, Geolocation: "EU-Central"}, {URL: "https://provider3.example.com/rest", APIInterface: pairing.APIInterfaceREST, Geolocation: "EU-Central"}}, TLSEnabled: true, LastUpdated: fetchedAt},
+		{ID: "4", Address: "provider4", Commission: 0, Stake: 500, Location: "US-West", Features: []string{"featB"}, Fee: 0.005, Endpoints: []pairing.Endpoint{{URL: "https://provider4.example.com/rest", APIInterface: pairing.APIInterfaceREST, Geolocation: "US-West"}}, LastUpdated: fetchedAt},
+		{ID: "5", Address: "provider5", Commission: 7.5, Stake: 2500, SelfStake: 1500, DelegatedStake: 1000, Location: "US-West", Features: []string{"featA", "featB", "featC", "featExtra"}, Fee: 0.8, Endpoints: []pairing.Endpoint{{URL: "https://provider5.example.com/jsonrpc", APIInterface: pairing.APIInterfaceJSONRPC, Geolocation: "US-West"}, {URL: "https://provider5.example.com/grpc", APIInterface: pairing.APIInterfaceGRPC, Geolocation: "US-West"}, {URL: "https://provider5.example.com/tendermintrpc", APIInterface: pairing.APIInterfaceTendermintRPC, Geolocation: "US-West"}}, TLSEnabled: true, LastUpdated: fetchedAt},
+		{ID: "6", Address: "provider6", Commission: 15, Stake: 1200, Location: "EU-Central", Features: []string{"featA", "featD", "featE"}, Fee: 1.7, Endpoints: []pairing.Endpoint{{URL: "https://provider6.example.com/grpc", APIInterface: pairing.APIInterfaceGRPC, Geolocation: "EU-Central"}}, TLSEnabled: true, LastUpdated: fetchedAt},
+		{ID: "7", Address: "provider7", Commission: 5, Stake: 800, Location: "US-East", Features: []string{"featA", "featB", "featC", "featX"}, Fee: 2.0, Endpoints: []pairing.Endpoint{{URL: "https://provider7.example.com/jsonrpc", APIInterface: pairing.APIInterfaceJSONRPC, Geolocation: "US-East"}, {URL: "https://provider7.example.com/rest", APIInterface: pairing.APIInterfaceREST, Geolocation: "US-East"}}, TLSEnabled: true, Jailed: true, LastUpdated: fetchedAt},
+		{ID: "8", Address: "provider8", Commission: 12, Stake: 3000, SelfStake: 1000, DelegatedStake: 2000, Location: "US-West", Features: []string{"featA", "featB", "featC", "featY", "featZ"}, Fee: 2.5, Endpoints: []pairing.Endpoint{{URL: "https://provider8.example.com/jsonrpc", APIInterface: pairing.APIInterfaceJSONRPC, Geolocation: "US-West"}, {URL: "https://provider8.example.com/rest", APIInterface: pairing.APIInterfaceREST, Geolocation: "US-West"}, {URL: "https://provider8.example.com/grpc", APIInterface: pairing.APIInterfaceGRPC, Geolocation: "US-West"}}, TLSEnabled: true, LastUpdated: fetchedAt},
 	}
 
 	// Mocked Consumer Policy
@@ -22,6 +27,8 @@ var (
 		RequiredLocation: "US-West",
 		RequiredFeatures: []string{"featA", "featB"},
 		MinStake:         1000,
+		RequireTLS:       true,
+		ExcludeJailed:    true,
 		Weights: map[string]float64{
 			"StakeScore":    0.5,
 			"FeatureScore":  0.3,
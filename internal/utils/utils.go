@@ -2,10 +2,18 @@ package utils
 
 import (
 	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"time"
 
-	pairing "github.com/Yoaz/LavaPairingSystem/internal"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
 )
 
+// WeightSumTolerance is how far the sum of policy weights may be from 1.0, absorbing float rounding
+// (e.g. 0.1 + 0.2 + 0.7)
+const WeightSumTolerance = 1e-9
+
 // Min returns the minimum of two integers
 func Min(a, b int) int {
 	if a < b {
@@ -14,17 +22,55 @@ func Min(a, b int) int {
 	return b
 }
 
-// Compute max stake from a list of providers
-func ComputeMaxStake(providers []*pairing.Provider) int64 {
+// Epoch returns the index of the epoch containing t, for epochs of the given length
+// counted from the Unix epoch
+func Epoch(t time.Time, length time.Duration) uint64 {
+	if length <= 0 {
+		return 0
+	}
+	return uint64(t.UnixNano() / int64(length))
+}
+
+// EpochEnd returns the time at which the epoch containing t ends
+func EpochEnd(t time.Time, length time.Duration) time.Time {
+	if length <= 0 {
+		return t
+	}
+	return time.Unix(0, int64(Epoch(t, length)+1)*int64(length))
+}
+
+// Compute max effective stake from a list of providers, weighing delegated stake by delegationFactor
+func ComputeMaxStake(providers []*pairing.Provider, delegationFactor float64) int64 {
 	var maxStake int64
 	for _, p := range providers {
-		if p.Stake > maxStake {
-			maxStake = p.Stake
+		if stake := p.EffectiveStake(delegationFactor); stake > maxStake {
+			maxStake = stake
 		}
 	}
 	return maxStake
 }
 
+// AttachSlashInfo returns the providers with their pending slashes attached
+// Providers with slash info are shallow-copied rather than modified, since provider lists are shared
+// across concurrent requests; providers without slash info are returned as is
+func AttachSlashInfo(providers []*pairing.Provider, slashes map[string]*pairing.SlashInfo) []*pairing.Provider {
+	if len(slashes) == 0 {
+		return providers
+	}
+	result := make([]*pairing.Provider, len(providers))
+	for i, p := range providers {
+		info, ok := slashes[p.ID]
+		if !ok {
+			result[i] = p
+			continue
+		}
+		slashed := *p
+		slashed.Slashing = info
+		result[i] = &slashed
+	}
+	return result
+}
+
 // ComputeNormalizedFees computes the normalized fees for a list of providers
 // This function normalizes the fee of each provider in the list by scaling it
 // relative to the maximum fee in the list. The normalized fee is calculated as
@@ -56,7 +102,8 @@ func ComputeNormalizedFees(providers []*pairing.Provider) map[string]float64 {
 	return normalized
 }
 
-// ValidateWeights checks if the sum of weights in the given map equals 1.0
+// ValidateWeights checks that every weight is a finite number within [0, 1] and that they sum to 1.0
+// (within WeightSumTolerance)
 // The presence of all specific keys is NOT mandetory, allowing users to provide
 // weights only for the components they care about. Unspecified components will effectively
 // have a weight of 0 in the weighted scoring logic
@@ -68,21 +115,177 @@ func ValidateWeights(weights map[string]float64) error {
 		return nil // No weights provided, valid for average scoring fallback
 	}
 
-	// Only check the sum if weights are provided
+	// Only check the weights if weights are provided
+	if err := checkWeightRanges(weights); err != nil {
+		return err
+	}
 	if err := checkWeightSum(weights); err != nil {
 		return err
 	}
 	return nil
 }
 
-// CheckWeightSum checks if the sum of weights in the given map equals 1.0
+// ValidateAdjustments checks that every policy score adjustment is a finite number within [-1, 1]
+func ValidateAdjustments(adjustments map[string]float64) error {
+	ids := make([]string, 0, len(adjustments))
+	for id := range adjustments {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		adjustment := adjustments[id]
+		if math.IsNaN(adjustment) || math.IsInf(adjustment, 0) || adjustment < -1 || adjustment > 1 {
+			return fmt.Errorf("adjustment for provider %q must be a finite number within [-1, 1], got %v", id, adjustment)
+		}
+	}
+	return nil
+}
+
+// ValidateFeatureGroups checks that every policy feature group requires between 1 and all of its features,
+// listed without duplicates
+func ValidateFeatureGroups(groups []pairing.FeatureGroup) error {
+	for i, group := range groups {
+		seen := make(map[string]bool, len(group.Features))
+		for _, feat := range group.Features {
+			if seen[feat] {
+				return fmt.Errorf("feature group %d lists feature %q more than once", i, feat)
+			}
+			seen[feat] = true
+		}
+		if group.MinMatch < 1 || group.MinMatch > len(group.Features) {
+			return fmt.Errorf("feature group %d min_match must be within [1, %d], got %d", i, len(group.Features), group.MinMatch)
+		}
+	}
+	return nil
+}
+
+// ValidateFeatureValues checks that every policy feature value is a finite, non-negative number
+func ValidateFeatureValues(values map[string]float64) error {
+	features := make([]string, 0, len(values))
+	for feat := range values {
+		features = append(features, feat)
+	}
+	sort.Strings(features)
+	for _, feat := range features {
+		value := values[feat]
+		if math.IsNaN(value) || math.IsInf(value, 0) || value < 0 {
+			return fmt.Errorf("value for feature %q must be a finite, non-negative number, got %v", feat, value)
+		}
+	}
+	return nil
+}
+
+// ValidateScopedWeights checks every weight of the policy's ChainWeights and InterfaceWeights is within range, and
+// the weights it pairs with like ValidateWeights, on its chain and for each API interface it pairs on
+// (RequiredAPIInterface and APIInterfaces), alone and together, as the overrides merge with the weights below
+// them (see ConsumerPolicy.ScopedWeights)
+// Scopes the policy doesn't pair on are only range checked, merging every chain with every interface would cost
+// their product; the pairing system checks the weights of the scope it pairs on anyway
+func ValidateScopedWeights(policy *pairing.ConsumerPolicy) error {
+	for _, chainID := range sortedScopes(policy.ChainWeights) {
+		if err := checkWeightRanges(policy.ChainWeights[chainID]); err != nil {
+			return fmt.Errorf("chain_weights[%q]: %w", chainID, err)
+		}
+	}
+	for _, apiInterface := range sortedScopes(policy.InterfaceWeights) {
+		if err := checkWeightRanges(policy.InterfaceWeights[apiInterface]); err != nil {
+			return fmt.Errorf("interface_weights[%q]: %w", apiInterface, err)
+		}
+	}
+	chains := []string{""}
+	if policy.ChainID != "" {
+		chains = append(chains, policy.ChainID)
+	}
+	interfaces := []string{""}
+	for _, apiInterface := range append([]string{policy.RequiredAPIInterface}, policy.APIInterfaces...) {
+		if apiInterface != "" && !slices.Contains(interfaces, apiInterface) {
+			interfaces = append(interfaces, apiInterface)
+		}
+	}
+	for _, chainID := range chains {
+		for _, apiInterface := range interfaces {
+			scoped := *policy
+			scoped.ChainID, scoped.RequiredAPIInterface = chainID, apiInterface
+			if err := ValidateWeights(scoped.ScopedWeights()); err != nil {
+				return fmt.Errorf("%s: %w", weightScope(chainID, apiInterface), err)
+			}
+		}
+	}
+	return nil
+}
+
+// sortedScopes returns the chain IDs or API interfaces of per-chain or per-interface weights, sorted
+func sortedScopes(scoped map[string]map[string]float64) []string {
+	scopes := make([]string, 0, len(scoped))
+	for scope := range scoped {
+		if scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+// weightScope names the weights a policy pairs with on the given chain and API interface, either may be empty
+func weightScope(chainID, apiInterface string) string {
+	switch {
+	case chainID == "" && apiInterface == "":
+		return "weights"
+	case apiInterface == "":
+		return fmt.Sprintf("chain_weights[%q]", chainID)
+	case chainID == "":
+		return fmt.Sprintf("interface_weights[%q]", apiInterface)
+	}
+	return fmt.Sprintf("interface_weights[%q] on chain_weights[%q]", apiInterface, chainID)
+}
+
+// ValidateAPIInterfaces checks that a policy's per-interface pairing request lists each API interface once,
+// and doesn't also require a single API interface
+func ValidateAPIInterfaces(interfaces []string, required string) error {
+	if len(interfaces) > 0 && required != "" {
+		return fmt.Errorf("api_interfaces and required_api_interface are mutually exclusive")
+	}
+	seen := make(map[string]bool, len(interfaces))
+	for _, apiInterface := range interfaces {
+		if apiInterface == "" {
+			return fmt.Errorf("api_interfaces lists an empty API interface")
+		}
+		if seen[apiInterface] {
+			return fmt.Errorf("api_interfaces lists %q more than once", apiInterface)
+		}
+		seen[apiInterface] = true
+	}
+	return nil
+}
+
+// checkWeightRanges checks that every weight is a finite number within [0, 1]
+// Keys are checked in sorted order so the reported key is deterministic
+func checkWeightRanges(weights map[string]float64) error {
+	names := make([]string, 0, len(weights))
+	for name := range weights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		weight := weights[name]
+		if math.IsNaN(weight) || math.IsInf(weight, 0) {
+			return fmt.Errorf("weight %q must be a finite number, got %v", name, weight)
+		}
+		if weight < 0 || weight > 1 {
+			return fmt.Errorf("weight %q must be within [0, 1], got %v", name, weight)
+		}
+	}
+	return nil
+}
+
+// checkWeightSum checks if the sum of weights in the given map equals 1.0, within WeightSumTolerance
 func checkWeightSum(weights map[string]float64) error {
 	var total float64
 	for _, weight := range weights {
 		total += weight
 	}
-	if total != 1.0 {
-		return fmt.Errorf("weights must sum to 1, got %.2f", total)
+	if math.Abs(total-1.0) > WeightSumTolerance {
+		return fmt.Errorf("weights must sum to 1, got %v", total)
 	}
 	return nil
 }
@@ -0,0 +1,129 @@
+package address
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// bech32Charset maps 5 bit values to bech32 characters
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// Parse parses an address, bech32 or hex when the parser allows it, and returns its normalized form
+// Surrounding whitespace is ignored; bech32 addresses must not mix upper and lower case
+func (p Parser) Parse(s string) (Address, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", fmt.Errorf("%w: empty address", ErrInvalidAddress)
+	}
+	if digits, ok := cutHexPrefix(s); ok {
+		if !p.AllowHex {
+			return "", fmt.Errorf("%w: hex addresses aren't accepted: %q", ErrInvalidAddress, s)
+		}
+		if len(digits) != hexLength {
+			return "", fmt.Errorf("%w: hex address must have %d digits, got %d", ErrInvalidAddress, hexLength, len(digits))
+		}
+		if _, err := hex.DecodeString(digits); err != nil {
+			return "", fmt.Errorf("%w: %q isn't hex", ErrInvalidAddress, s)
+		}
+		return Address("0x" + strings.ToLower(digits)), nil
+	}
+
+	hrp, err := decodeBech32(s)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q: %v", ErrInvalidAddress, s, err)
+	}
+	if p.Prefix != "" && hrp != strings.ToLower(p.Prefix) {
+		return "", fmt.Errorf("%w: %q doesn't have the %q prefix", ErrInvalidAddress, s, p.Prefix)
+	}
+	return Address(strings.ToLower(s)), nil
+}
+
+// Validate returns an error if the address doesn't parse, see Parse
+func (p Parser) Validate(s string) error {
+	_, err := p.Parse(s)
+	return err
+}
+
+// Normalize returns the normalized form of an address, or the address itself when it doesn't parse
+func (p Parser) Normalize(s string) string {
+	if a, err := p.Parse(s); err == nil {
+		return string(a)
+	}
+	return s
+}
+
+// String returns the normalized address
+func (a Address) String() string { return string(a) }
+
+// IsHex reports whether the address is a hex address
+func (a Address) IsHex() bool { return strings.HasPrefix(string(a), "0x") }
+
+// cutHexPrefix returns the digits of a "0x" prefixed string
+func cutHexPrefix(s string) (string, bool) {
+	if len(s) >= 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X') {
+		return s[2:], true
+	}
+	return "", false
+}
+
+// decodeBech32 checks a bech32 string (BIP 173, without its 90 character limit, which Cosmos addresses don't
+// follow) and returns its lowercase human-readable part
+func decodeBech32(s string) (string, error) {
+	lower, upper := strings.ToLower(s), strings.ToUpper(s)
+	if s != lower && s != upper {
+		return "", fmt.Errorf("mixed case")
+	}
+	s = lower
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+7 > len(s) {
+		return "", fmt.Errorf("missing separator, prefix or checksum")
+	}
+	hrp, data := s[:sep], s[sep+1:]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", fmt.Errorf("invalid prefix character %q", hrp[i])
+		}
+	}
+	values := make([]byte, len(data))
+	for i := 0; i < len(data); i++ {
+		v := strings.IndexByte(bech32Charset, data[i])
+		if v < 0 {
+			return "", fmt.Errorf("invalid character %q", data[i])
+		}
+		values[i] = byte(v)
+	}
+	if bech32Polymod(append(hrpExpand(hrp), values...)) != 1 {
+		return "", fmt.Errorf("invalid checksum")
+	}
+	return hrp, nil
+}
+
+// hrpExpand expands a human-readable part for checksum computation
+func hrpExpand(hrp string) []byte {
+	expanded := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]&31)
+	}
+	return expanded
+}
+
+// bech32Polymod computes the bech32 checksum polynomial
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
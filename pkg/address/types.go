@@ -0,0 +1,24 @@
+package address
+
+import "errors"
+
+// ErrInvalidAddress is wrapped by the errors of addresses that fail to parse
+var ErrInvalidAddress = errors.New("invalid address")
+
+// LavaPrefix is the bech32 human-readable part of Lava account addresses
+const LavaPrefix = "lava@"
+
+// hexLength is the number of hex digits of a hex address, 20 bytes
+const hexLength = 40
+
+// Address is a parsed account address in its normalized form: lowercase bech32 or lowercase "0x" hex, so the
+// same account always compares equal across the store, reports and results
+type Address string
+
+// Parser parses and validates addresses
+type Parser struct {
+	// Prefix is the human-readable part bech32 addresses must have (e.g. LavaPrefix), empty accepts any
+	Prefix string
+	// AllowHex also accepts 20 byte hex addresses, "0x" followed by 40 hex digits
+	AllowHex bool
+}
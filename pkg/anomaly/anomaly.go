@@ -0,0 +1,179 @@
+package anomaly
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// NewDetector creates a new Detector with the given config
+func NewDetector(cfg Config) *Detector {
+	return &Detector{
+		cfg:         cfg,
+		previous:    make(map[string]Observation),
+		quarantined: make(map[string]Anomaly),
+		accepted:    make(map[string]float64),
+		now:         time.Now,
+	}
+}
+
+// Inspect checks a pool of providers and returns the anomalies found, ordered by provider
+// Stake and feature changes are relative to each provider's previous inspection, and an outlier fee is only
+// reported again once it changes; with Quarantine set the flagged providers are quarantined
+// NOTE: The pool should be the system's own, inspections of providers missing from it for longer than the
+// Retention are forgotten
+func (d *Detector) Inspect(providers []*pairing.Provider) []Anomaly {
+	now := d.now()
+	median := medianFee(providers)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var anomalies []Anomaly
+	for _, p := range providers {
+		if p == nil {
+			continue
+		}
+		flag := func(kind Kind, format string, args ...any) {
+			anomalies = append(anomalies, Anomaly{ProviderID: p.ID, Kind: kind, Detail: fmt.Sprintf(format, args...), At: now})
+		}
+		if fee, ok := d.accepted[p.ID]; ok && fee != p.Fee {
+			delete(d.accepted, p.ID) // The reviewed fee changed, check the new one
+		}
+		previous, seen := d.previous[p.ID]
+		observation := Observation{Stake: p.Stake, Fee: p.Fee, Features: len(p.Features), Seen: now}
+		if _, ok := d.accepted[p.ID]; !ok && d.cfg.FeeMedianFactor > 0 && median > 0 && p.Fee >= median*d.cfg.FeeMedianFactor {
+			observation.FeeFlagged = true
+			if !seen || !previous.FeeFlagged || previous.Fee != p.Fee {
+				flag(KindFeeOutlier, "fee %g is %.0fx the median fee %g", p.Fee, p.Fee/median, median)
+			}
+		}
+		if seen {
+			if d.cfg.MaxStakeChange > 0 && previous.Stake > 0 {
+				change := float64(p.Stake-previous.Stake) / float64(previous.Stake)
+				if math.Abs(change) > d.cfg.MaxStakeChange {
+					flag(KindStakeChange, "stake changed by %+.0f%% (%d -> %d)", change*100, previous.Stake, p.Stake)
+				}
+			}
+			if d.cfg.FlagEmptyFeatures && previous.Features > 0 && len(p.Features) == 0 {
+				flag(KindFeaturesDropped, "feature list went from %d features to none", previous.Features)
+			}
+		}
+		d.previous[p.ID] = observation
+	}
+	d.sweep(now)
+
+	sort.SliceStable(anomalies, func(i, j int) bool { return anomalies[i].ProviderID < anomalies[j].ProviderID })
+	if d.cfg.Quarantine {
+		for _, a := range anomalies {
+			if _, ok := d.quarantined[a.ProviderID]; !ok {
+				d.quarantined[a.ProviderID] = a // The first anomaly is what the review starts from
+			}
+		}
+	}
+	return anomalies
+}
+
+// sweep forgets the inspections older than the retention, at most once per retention
+// The caller must hold d.mu
+func (d *Detector) sweep(now time.Time) {
+	retention := d.cfg.Retention
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	if now.Sub(d.swept) < retention {
+		return
+	}
+	d.swept = now
+	for id, observation := range d.previous {
+		if now.Sub(observation.Seen) > retention {
+			delete(d.previous, id)
+			delete(d.accepted, id)
+		}
+	}
+}
+
+// IsQuarantined reports whether the provider is quarantined, returning the anomaly that got it quarantined
+func (d *Detector) IsQuarantined(providerID string) (Anomaly, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	a, ok := d.quarantined[providerID]
+	return a, ok
+}
+
+// Quarantined returns the anomalies of every quarantined provider, ordered by provider
+func (d *Detector) Quarantined() []Anomaly {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	anomalies := make([]Anomaly, 0, len(d.quarantined))
+	for _, a := range d.quarantined {
+		anomalies = append(anomalies, a)
+	}
+	sort.Slice(anomalies, func(i, j int) bool { return anomalies[i].ProviderID < anomalies[j].ProviderID })
+	return anomalies
+}
+
+// Release ends a provider's quarantine once it has been reviewed, reporting whether it was quarantined
+// Its entry as last inspected is accepted: the change that got it flagged isn't flagged again, and neither is
+// an outlier fee until it changes
+func (d *Detector) Release(providerID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.quarantined[providerID]
+	if ok {
+		delete(d.quarantined, providerID)
+		d.accepted[providerID] = d.previous[providerID].Fee
+	}
+	return ok
+}
+
+// Snapshot returns a copy of the detector's previous inspections, quarantines and accepted fees
+func (d *Detector) Snapshot() Snapshot {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return Snapshot{
+		Previous:    maps.Clone(d.previous),
+		Quarantined: maps.Clone(d.quarantined),
+		Accepted:    maps.Clone(d.accepted),
+	}
+}
+
+// Restore replaces the detector's state with a snapshot
+func (d *Detector) Restore(snapshot Snapshot) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.previous = make(map[string]Observation, len(snapshot.Previous))
+	maps.Copy(d.previous, snapshot.Previous)
+	for id, observation := range d.previous {
+		if observation.Seen.IsZero() { // Taken before inspections were timed, retain it from now on
+			observation.Seen = d.now()
+			d.previous[id] = observation
+		}
+	}
+	d.quarantined = make(map[string]Anomaly, len(snapshot.Quarantined))
+	maps.Copy(d.quarantined, snapshot.Quarantined)
+	d.accepted = make(map[string]float64, len(snapshot.Accepted))
+	maps.Copy(d.accepted, snapshot.Accepted)
+}
+
+// medianFee returns the median fee of the pool, 0 for an empty pool
+func medianFee(providers []*pairing.Provider) float64 {
+	fees := make([]float64, 0, len(providers))
+	for _, p := range providers {
+		if p != nil {
+			fees = append(fees, p.Fee)
+		}
+	}
+	if len(fees) == 0 {
+		return 0
+	}
+	sort.Float64s(fees)
+	mid := len(fees) / 2
+	if len(fees)%2 == 0 {
+		return (fees[mid-1] + fees[mid]) / 2
+	}
+	return fees[mid]
+}
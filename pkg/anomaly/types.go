@@ -0,0 +1,69 @@
+package anomaly
+
+import (
+	"sync"
+	"time"
+)
+
+// Config controls which provider entries are flagged as suspicious; zero fields disable their check
+type Config struct {
+	FeeMedianFactor float64 // Fees at least this many times the pool's median fee are flagged, e.g. 1000
+	// MaxStakeChange is the relative stake change between two inspections beyond which a provider is flagged,
+	// e.g. 0.5 for more than ±50%
+	MaxStakeChange    float64
+	FlagEmptyFeatures bool // Flag providers whose feature list became empty since the previous inspection
+	Quarantine        bool // Exclude flagged providers from pairing until released, see Detector.Release
+	// Retention is how long the inspection of a provider missing from later pools is remembered,
+	// DefaultRetention when zero
+	Retention time.Duration
+}
+
+// DefaultRetention is the Retention of a Config leaving it zero
+const DefaultRetention = 24 * time.Hour
+
+// Kind identifies what made a provider entry suspicious
+type Kind string
+
+const (
+	KindFeeOutlier      Kind = "fee_outlier"
+	KindStakeChange     Kind = "stake_change"
+	KindFeaturesDropped Kind = "features_dropped"
+)
+
+// Anomaly is a suspicious provider entry found by an inspection
+type Anomaly struct {
+	ProviderID string    `json:"provider_id"`
+	Kind       Kind      `json:"kind"`
+	Detail     string    `json:"detail"`
+	At         time.Time `json:"at"`
+}
+
+// Detector flags suspicious provider entries by comparing them to their pool and to their previous inspection,
+// optionally quarantining them until they are reviewed
+// It is safe for concurrent use
+type Detector struct {
+	mu          sync.Mutex
+	cfg         Config
+	previous    map[string]Observation // Provider ID -> entry at its latest inspection
+	quarantined map[string]Anomaly     // Provider ID -> anomaly that got it quarantined
+	accepted    map[string]float64     // Provider ID -> outlier fee accepted when released
+	swept       time.Time              // Time of the latest sweep of the expired inspections
+	now         func() time.Time
+}
+
+// Observation is what an inspection remembers of a provider entry
+type Observation struct {
+	Stake    int64   `json:"stake"`
+	Fee      float64 `json:"fee"`
+	Features int     `json:"features"` // Number of features listed
+	// FeeFlagged is set when the fee was flagged as an outlier, it isn't flagged again until it changes
+	FeeFlagged bool      `json:"fee_flagged,omitempty"`
+	Seen       time.Time `json:"seen"` // Time of the inspection
+}
+
+// Snapshot is the persistable state of a Detector, see Detector.Snapshot
+type Snapshot struct {
+	Previous    map[string]Observation `json:"previous,omitempty"`    // Provider ID -> entry at its latest inspection
+	Quarantined map[string]Anomaly     `json:"quarantined,omitempty"` // Provider ID -> anomaly that got it quarantined
+	Accepted    map[string]float64     `json:"accepted,omitempty"`    // Provider ID -> outlier fee accepted when released
+}
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+/* ***********************************************************************
+ *                              MIDDLEWARE                               *
+ *********************************************************************** */
+
+// Middleware returns an HTTP middleware that only lets authenticated requests through
+// Authenticators are tried in order; the first one that recognizes the request decides the outcome
+// The authenticated Principal is stored in the request context and can be retrieved with FromContext
+func Middleware(logger *slog.Logger, authenticators ...Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, a := range authenticators {
+				principal, err := a.Authenticate(r)
+				if errors.Is(err, ErrNoCredentials) {
+					continue // Not this authenticator's credential type, try the next one
+				}
+				if err != nil {
+					logger.Warn("Rejected request credentials", "authenticator", a.Name(), "path", r.URL.Path, "error", err)
+					http.Error(w, "unauthorized", http.StatusUnauthorized)
+					return
+				}
+				logger.Debug("Authenticated request", "authenticator", a.Name(), "principal", principal.ID)
+				next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), principal)))
+				return
+			}
+			logger.Warn("Request without credentials", "path", r.URL.Path)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		})
+	}
+}
+
+// NewContext returns a copy of ctx carrying the given principal
+func NewContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+// FromContext returns the principal stored in ctx by the middleware, if any
+func FromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(contextKey{}).(*Principal)
+	return principal, ok
+}
+
+/* ***********************************************************************
+ *                              RESTRICTIONS                             *
+ *********************************************************************** */
+
+// AllowsChain reports whether the restrictions permit pairing requests for the given chain
+func (r Restrictions) AllowsChain(chainID string) bool {
+	return len(r.AllowedChains) == 0 || slices.Contains(r.AllowedChains, chainID)
+}
+
+// AllowsTopN reports whether the restrictions permit a pairing list of n providers
+func (r Restrictions) AllowsTopN(n int) bool {
+	return r.MaxTopN == 0 || n <= r.MaxTopN
+}
+
+/* ***********************************************************************
+ *                              API KEY                                  *
+ *********************************************************************** */
+
+// Authenticate looks up the API key sent in the configured header
+// Keys are compared in constant time to avoid leaking key prefixes through timing
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	header := a.Header
+	if header == "" {
+		header = DefaultAPIKeyHeader
+	}
+	key := r.Header.Get(header)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+	for candidate, principal := range a.Keys {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(key)) == 1 {
+			return principal, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: unknown api key", ErrInvalidCredentials)
+}
+
+func (a *APIKeyAuthenticator) Name() string { return "APIKeyAuthenticator" }
+
+/* ***********************************************************************
+ *                              JWT                                      *
+ *********************************************************************** */
+
+// Authenticate verifies an HS256 bearer token from the Authorization header
+// The token's "sub" claim becomes the principal ID, the optional "max_top_n" and "chains"
+// claims become its restrictions, the optional "admin" claim grants admin access and the optional "provider"
+// claim lets it speak for that provider
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, ErrNoCredentials
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidCredentials)
+	}
+
+	// Only HS256 is accepted, anything else (including "none") is rejected
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return nil, fmt.Errorf("%w: unsupported token header", ErrInvalidCredentials)
+	}
+
+	mac := hmac.New(sha256.New, a.Secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, fmt.Errorf("%w: bad token signature", ErrInvalidCredentials)
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed token claims", ErrInvalidCredentials)
+	}
+
+	now := time.Now
+	if a.Now != nil {
+		now = a.Now
+	}
+	unix := now().Unix()
+	if claims.ExpiresAt != 0 && unix >= claims.ExpiresAt {
+		return nil, fmt.Errorf("%w: token expired", ErrInvalidCredentials)
+	}
+	if claims.NotBefore != 0 && unix < claims.NotBefore {
+		return nil, fmt.Errorf("%w: token not yet valid", ErrInvalidCredentials)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: token has no subject", ErrInvalidCredentials)
+	}
+
+	return &Principal{
+		ID: claims.Subject,
+		Restrictions: Restrictions{
+			MaxTopN:       claims.MaxTopN,
+			AllowedChains: claims.Chains,
+		},
+		Admin:      claims.Admin,
+		ProviderID: claims.Provider,
+	}, nil
+}
+
+func (a *JWTAuthenticator) Name() string { return "JWTAuthenticator" }
+
+// decodeSegment decodes a base64url encoded JSON token segment into v
+func decodeSegment(segment string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+/* ***********************************************************************
+ *                              MTLS                                     *
+ *********************************************************************** */
+
+// Authenticate maps the verified client certificate's common name to a known principal
+// Unverified certificates are ignored, so the server's TLS config is what makes this trustworthy
+func (a *MTLSAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredentials
+	}
+	commonName := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	principal, ok := a.Principals[commonName]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown client certificate %q", ErrInvalidCredentials, commonName)
+	}
+	return principal, nil
+}
+
+func (a *MTLSAuthenticator) Name() string { return "MTLSAuthenticator" }
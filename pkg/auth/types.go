@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// DefaultAPIKeyHeader is the header APIKeyAuthenticator reads when no header is configured
+const DefaultAPIKeyHeader = "X-API-Key"
+
+var (
+	// ErrNoCredentials is returned by an Authenticator when the request carries none of the credentials it handles
+	// The middleware treats it as "not mine" and falls through to the next authenticator
+	ErrNoCredentials = errors.New("no credentials supplied")
+	// ErrInvalidCredentials is returned when credentials are present but rejected
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Authenticator is an interface for identifying the consumer behind an incoming API request
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+	Name() string // for tracking authenticator name
+}
+
+// Principal is an authenticated consumer together with the restrictions attached to its credentials
+type Principal struct {
+	ID           string
+	Restrictions Restrictions
+	Admin        bool // Whether the principal may call the read-only admin endpoints
+	// ProviderID, when set, is the provider the principal speaks for: it may report that provider's own
+	// heartbeats, load and maintenance windows
+	ProviderID string
+}
+
+// Restrictions limits what an authenticated consumer is allowed to request from the pairing API
+type Restrictions struct {
+	MaxTopN       int      // Maximum number of providers per pairing (0 means no limit)
+	AllowedChains []string // Chains the consumer may request pairings for (empty means any chain)
+}
+
+// Authenticator implementations for different credential types
+type (
+	// APIKeyAuthenticator authenticates requests by a static API key sent in a header
+	APIKeyAuthenticator struct {
+		Header string                // Header carrying the key, defaults to DefaultAPIKeyHeader
+		Keys   map[string]*Principal // API key -> principal
+	}
+
+	// JWTAuthenticator authenticates requests by an HS256-signed bearer token
+	JWTAuthenticator struct {
+		Secret []byte           // Shared HMAC secret
+		Now    func() time.Time // Clock used for exp/nbf checks, defaults to time.Now
+	}
+
+	// MTLSAuthenticator authenticates requests by their verified TLS client certificate
+	// NOTE: The server must be configured with tls.RequireAndVerifyClientCert for the certificate to be trusted
+	MTLSAuthenticator struct {
+		Principals map[string]*Principal // Certificate subject common name -> principal
+	}
+)
+
+// jwtClaims are the token claims understood by JWTAuthenticator
+type jwtClaims struct {
+	Subject   string   `json:"sub"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	MaxTopN   int      `json:"max_top_n,omitempty"`
+	Chains    []string `json:"chains,omitempty"`
+	Admin     bool     `json:"admin,omitempty"`
+	Provider  string   `json:"provider,omitempty"`
+}
+
+// contextKey is the private type for values stored by this package in a request context
+type contextKey struct{}
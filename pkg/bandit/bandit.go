@@ -0,0 +1,157 @@
+package bandit
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+
+	"github.com/Yoaz/LavaPairingSystem/internal/utils"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// NewBandit creates a Bandit selecting providers with the given algorithm
+func NewBandit(algorithm Algorithm, opts ...Option) (*Bandit, error) {
+	if algorithm != UCB1 && algorithm != Thompson {
+		return nil, fmt.Errorf("unknown bandit algorithm %q", algorithm)
+	}
+	b := &Bandit{
+		algorithm:   algorithm,
+		exploration: DefaultExploration,
+		arms:        make(map[string]*arm),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b, nil
+}
+
+// WithExploration sets the weight of UCB1's confidence bonus, higher values explore more
+func WithExploration(c float64) Option {
+	return func(b *Bandit) {
+		b.exploration = c
+	}
+}
+
+// Report records how well a provider served a consumer, from 0 (failed) to 1 (perfect), e.g. 1 for a
+// successful relay and 0 for an error, or a latency-based grade in between
+func (b *Bandit) Report(providerID string, reward float64) error {
+	if math.IsNaN(reward) || reward < 0 || reward > 1 {
+		return fmt.Errorf("%w, got %v", ErrInvalidReward, reward)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a, ok := b.arms[providerID]
+	if !ok {
+		a = &arm{}
+		b.arms[providerID] = a
+	}
+	a.reports++
+	a.rewardSum += reward
+	b.reports++
+	return nil
+}
+
+// Stats returns the feedback accumulated for a provider
+func (b *Bandit) Stats(providerID string) Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a, ok := b.arms[providerID]
+	if !ok || a.reports == 0 {
+		return Stats{}
+	}
+	return Stats{Reports: a.reports, MeanReward: a.rewardSum / float64(a.reports)}
+}
+
+// Snapshot returns a copy of the feedback accumulated for every provider
+func (b *Bandit) Snapshot() Snapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	snapshot := Snapshot{Arms: make(map[string]ArmSnapshot, len(b.arms))}
+	for id, a := range b.arms {
+		snapshot.Arms[id] = ArmSnapshot{Reports: a.reports, RewardSum: a.rewardSum}
+	}
+	return snapshot
+}
+
+// Restore replaces the bandit's feedback with a snapshot
+func (b *Bandit) Restore(snapshot Snapshot) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.arms = make(map[string]*arm, len(snapshot.Arms))
+	b.reports = 0
+	for id, a := range snapshot.Arms {
+		b.arms[id] = &arm{reports: a.Reports, rewardSum: a.RewardSum}
+		b.reports += a.Reports
+	}
+}
+
+// Select returns the n eligible providers with the highest bandit index, highest first
+// Providers with equal indexes, such as providers without feedback under UCB1, keep their score order
+func (b *Bandit) Select(scored []*pairing.PairingScore, n int, _ *pairing.ConsumerPolicy) []*pairing.PairingScore {
+	indexes := make(map[string]float64, len(scored))
+	b.mu.Lock()
+	for _, s := range scored {
+		indexes[s.Provider.ID] = b.index(b.arms[s.Provider.ID])
+	}
+	b.mu.Unlock()
+
+	ranked := append([]*pairing.PairingScore(nil), scored...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return indexes[ranked[i].Provider.ID] > indexes[ranked[j].Provider.ID]
+	})
+	return ranked[:utils.Min(n, len(ranked))]
+}
+
+func (b *Bandit) Name() string {
+	if b.algorithm == UCB1 {
+		return "bandit:ucb1:" + strconv.FormatFloat(b.exploration, 'g', -1, 64)
+	}
+	return "bandit:" + string(b.algorithm)
+}
+
+// index is the value arms are ranked by, a nil arm having no feedback
+// NOTE: Must be called with b.mu held
+func (b *Bandit) index(a *arm) float64 {
+	var reports int64
+	var rewardSum float64
+	if a != nil {
+		reports, rewardSum = a.reports, a.rewardSum
+	}
+	switch b.algorithm {
+	case Thompson:
+		// Uniform Beta(1, 1) prior, a reward counts as a fractional success
+		return sampleBeta(1+rewardSum, 1+float64(reports)-rewardSum)
+	default:
+		if reports == 0 {
+			return math.Inf(1)
+		}
+		mean := rewardSum / float64(reports)
+		return mean + b.exploration*math.Sqrt(2*math.Log(float64(b.reports))/float64(reports))
+	}
+}
+
+// sampleBeta samples a Beta(alpha, beta) distribution from two Gamma samples
+func sampleBeta(alpha, beta float64) float64 {
+	x := sampleGamma(alpha)
+	return x / (x + sampleGamma(beta))
+}
+
+// sampleGamma samples a Gamma(shape, 1) distribution with Marsaglia and Tsang's method, shape >= 1
+func sampleGamma(shape float64) float64 {
+	d := shape - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := rand.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := rand.Float64()
+		if math.Log(u) < 0.5*x*x+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package bandit
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrInvalidReward is returned by Report for rewards outside [0, 1]
+var ErrInvalidReward = errors.New("reward must be within [0, 1]")
+
+// Algorithm is the rule a Bandit uses to trade off exploiting known-good providers against exploring others
+type Algorithm string
+
+const (
+	// UCB1 ranks providers by their mean reward plus a confidence bonus shrinking as feedback accumulates,
+	// providers without feedback first
+	UCB1 Algorithm = "ucb1"
+	// Thompson ranks providers by a reward sampled from the Beta posterior of their mean reward
+	Thompson Algorithm = "thompson"
+)
+
+// DefaultExploration is the weight of UCB1's confidence bonus when none is configured
+const DefaultExploration = 1.0
+
+// Bandit is a multi-armed bandit over providers, usable as a system.SelectionStrategy
+// Providers are the arms; consumers report how well a provider served them with Report
+// It is safe for concurrent use
+type Bandit struct {
+	algorithm   Algorithm
+	exploration float64 // Weight of the UCB1 confidence bonus
+	mu          sync.Mutex
+	arms        map[string]*arm // Provider ID -> accumulated feedback
+	reports     int64           // Rewards reported over every arm
+}
+
+// Option configures a Bandit
+type Option func(*Bandit)
+
+// arm is the feedback accumulated for a provider
+type arm struct {
+	reports   int64
+	rewardSum float64
+}
+
+// Snapshot is the persistable state of a Bandit, see Bandit.Snapshot
+type Snapshot struct {
+	Arms map[string]ArmSnapshot `json:"arms,omitempty"` // Provider ID -> accumulated feedback
+}
+
+// ArmSnapshot is the feedback accumulated for a provider
+type ArmSnapshot struct {
+	Reports   int64   `json:"reports"`
+	RewardSum float64 `json:"reward_sum"`
+}
+
+// Stats is the feedback accumulated for a provider, see Bandit.Stats
+type Stats struct {
+	Reports    int64   `json:"reports"`
+	MeanReward float64 `json:"mean_reward"`
+}
@@ -0,0 +1,266 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/auth"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairingerrors"
+	"github.com/Yoaz/LavaPairingSystem/pkg/policy"
+	"github.com/Yoaz/LavaPairingSystem/pkg/quota"
+	"github.com/Yoaz/LavaPairingSystem/pkg/server"
+	"github.com/Yoaz/LavaPairingSystem/pkg/system"
+)
+
+// defaultTimeout bounds every request made with the default HTTP client
+const defaultTimeout = 10 * time.Second
+
+// NewClient creates a new Client for the pairing API served at baseURL (e.g. "http://localhost:8080")
+func NewClient(baseURL string, logger *slog.Logger, opts ...Option) *Client {
+	// Ensure logger is not nil, provide a default discard logger if it is
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: defaultTimeout},
+		logger:     logger,
+		header:     make(http.Header),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithHTTPClient sends requests through the given HTTP client, e.g. one configured for mTLS
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithAPIKey authenticates requests with an API key, see auth.APIKeyAuthenticator
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.header.Set(auth.DefaultAPIKeyHeader, key)
+	}
+}
+
+// WithBearerToken authenticates requests with a bearer token, see auth.JWTAuthenticator
+func WithBearerToken(token string) Option {
+	return func(c *Client) {
+		c.header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// WithChainID requests pairings for the given chain when the policy doesn't set ChainID
+func WithChainID(chainID string) Option {
+	return func(c *Client) {
+		c.chainID = chainID
+	}
+}
+
+/* ***********************************************************************
+ *                              PAIRING SYSTEM                           *
+ *********************************************************************** */
+
+// FilterProviders returns the providers passing the server's filters
+// Providers are evaluated as given; nil evaluates the server's own providers for the policy's chain
+func (c *Client) FilterProviders(providers []*pairing.Provider, policy *pairing.ConsumerPolicy) []*pairing.Provider {
+	var response server.FilterResponse
+	if err := c.post(context.Background(), "/v1/pairing/filter", providers, policy, 0, &response); err != nil {
+		// Fail closed, a provider no filter could vouch for isn't known to qualify
+		c.logger.Error("Remote provider filtering failed", "error", err)
+		return []*pairing.Provider{}
+	}
+	return response.Providers
+}
+
+// RankProviders scores providers with the server's scorers
+// Providers are evaluated as given; nil evaluates the server's own providers for the policy's chain
+func (c *Client) RankProviders(providers []*pairing.Provider, policy *pairing.ConsumerPolicy) []*pairing.PairingScore {
+	var response server.RankResponse
+	if err := c.post(context.Background(), "/v1/pairing/rank", providers, policy, 0, &response); err != nil {
+		c.logger.Error("Remote provider ranking failed", "error", err)
+		return []*pairing.PairingScore{}
+	}
+	scores := make([]*pairing.PairingScore, 0, len(response.Scores))
+	for _, s := range response.Scores {
+		scores = append(scores, &pairing.PairingScore{Provider: s.Provider, Score: s.Score, Components: s.Components})
+	}
+	return scores
+}
+
+// GetPairingList is GetPairingListContext without a deadline beyond the HTTP client's timeout, or the options'
+// Timeout if set
+// The options' TopN is sent as the request's top_n, a per-call override like PairingOptions.TopN, and Strict and
+// Backups as the policy's strict and backups; a Selection can't be sent over the API and fails the call with
+// ErrUnsupportedOption
+func (c *Client) GetPairingList(providers []*pairing.Provider, policy *pairing.ConsumerPolicy, opts ...system.PairingOptions) (*system.PairingResult, error) {
+	ctx := context.Background()
+	if len(opts) == 0 {
+		return c.GetPairingListContext(ctx, providers, policy)
+	}
+	if policy == nil {
+		return nil, system.ErrNilPolicy
+	}
+	overridden := *policy
+	topN := 0
+	for _, o := range opts {
+		if o.Selection != nil {
+			return nil, fmt.Errorf("%w: selection strategy %s", ErrUnsupportedOption, o.Selection.Name())
+		}
+		if o.TopN > 0 {
+			topN = o.TopN
+		}
+		if o.Strict != nil {
+			overridden.Strict = o.Strict
+		}
+		if o.Backups > 0 {
+			overridden.Backups = o.Backups
+		}
+		if o.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(context.Background(), o.Timeout)
+			defer cancel()
+		}
+	}
+	return c.getPairingList(ctx, providers, &overridden, topN)
+}
+
+// GetPairingListContext requests a pairing from the server
+// Providers are evaluated as given; nil pairs over the server's own providers for the policy's chain
+// The result's Scores are not part of the API and left empty, Durations only carry the Total
+func (c *Client) GetPairingListContext(ctx context.Context, providers []*pairing.Provider, policy *pairing.ConsumerPolicy) (*system.PairingResult, error) {
+	return c.getPairingList(ctx, providers, policy, 0)
+}
+
+// getPairingList is GetPairingListContext selecting topN providers, the server's or policy's count if 0
+func (c *Client) getPairingList(ctx context.Context, providers []*pairing.Provider, policy *pairing.ConsumerPolicy, topN int) (*system.PairingResult, error) {
+	var response server.PairingResponse
+	if err := c.post(ctx, "/v1/pairing", providers, policy, topN, &response); err != nil {
+		return nil, err
+	}
+	return &system.PairingResult{
+		Providers:        response.Providers,
+		Backups:          response.Backups,
+		Hints:            response.Hints,
+		Partial:          response.Partial,
+		ConstraintsUnmet: response.ConstraintsUnmet,
+		RequestID:        response.Provenance.RequestID,
+		ConfigHash:       response.Provenance.ConfigHash,
+		ScoreSeries:      response.Provenance.ScoreSeries,
+		SnapshotRoot:     response.Provenance.SnapshotRoot,
+		Timestamp:        response.Provenance.Timestamp,
+		Counts:           response.Provenance.Counts,
+		Durations:        system.StageDurations{Total: time.Duration(response.Provenance.ElapsedMS * float64(time.Millisecond))},
+		Diagnostics:      response.Diagnostics,
+	}, nil
+}
+
+// ConfigFingerprint returns the server's configuration fingerprint, or "" if it can't be fetched
+func (c *Client) ConfigFingerprint() string {
+	var response server.ConfigResponse
+	if err := c.do(context.Background(), http.MethodGet, "/v1/config", nil, &response); err != nil {
+		c.logger.Error("Failed to fetch the remote config fingerprint", "error", err)
+		return ""
+	}
+	return response.ConfigFingerprint
+}
+
+// Close releases the client's idle connections
+func (c *Client) Close() {
+	c.httpClient.CloseIdleConnections()
+}
+
+/* ***********************************************************************
+ *                                TRANSPORT                              *
+ *********************************************************************** */
+
+// post sends a pairing request for the policy and providers to path, selecting topN providers if set, decoding the
+// response into out
+func (c *Client) post(ctx context.Context, path string, providers []*pairing.Provider, consumerPolicy *pairing.ConsumerPolicy, topN int, out any) error {
+	encoded, err := policy.Marshal(consumerPolicy)
+	if err != nil {
+		return err
+	}
+	chainID := consumerPolicy.ChainID
+	if chainID == "" {
+		chainID = c.chainID
+	}
+	return c.do(ctx, http.MethodPost, path, server.PairingRequest{ChainID: chainID, TopN: topN, Policy: encoded, Providers: providers}, out)
+}
+
+// do sends a request with an optional JSON body to path, decoding a successful JSON response into out
+// Non-2xx responses are returned as *APIError
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	for key, values := range c.header {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		var errBody struct {
+			Error  string                     `json:"error"`
+			Code   pairingerrors.Code         `json:"code"`
+			Fields []pairingerrors.FieldError `json:"fields"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&errBody) == nil && errBody.Error != "" {
+			apiErr.Message = errBody.Error
+			apiErr.Code = errBody.Code
+			apiErr.Fields = errBody.Fields
+		}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return apiErr
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("pairing API returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Is matches quota.ErrQuotaExceeded for exhausted quotas and the pairingerrors sentinel named by the
+// server's error code, so callers can check for them the same way with either implementation
+func (e *APIError) Is(target error) bool {
+	if e.StatusCode == http.StatusTooManyRequests && target == quota.ErrQuotaExceeded {
+		return true
+	}
+	return e.Code != "" && target == pairingerrors.FromCode(e.Code)
+}
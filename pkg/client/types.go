@@ -0,0 +1,36 @@
+package client
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairingerrors"
+)
+
+// ErrUnsupportedOption is returned for system.PairingOptions that can't be sent over the API
+var ErrUnsupportedOption = errors.New("pairing option not supported by the API")
+
+// Option configures optional Client behaviour
+type Option func(*Client)
+
+// Client is a PairingSystem served by a remote pairing API (see the server package), so applications can
+// switch between in-process and remote pairing by swapping the implementation
+// Methods without an error result log failures and return empty results, like the in-process system does
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	logger     *slog.Logger
+	header     http.Header // Sent with every request, e.g. credentials
+	chainID    string      // Chain requested for policies that don't set one
+}
+
+// APIError is returned for requests the server answered with a non-2xx status
+type APIError struct {
+	StatusCode int
+	Message    string
+	Code       pairingerrors.Code         // Classification of the error when the server gives one
+	RetryAfter time.Duration              // Set when the consumer's quota is exceeded
+	Fields     []pairingerrors.FieldError // Fields of the request at fault, when the server locates the problem
+}
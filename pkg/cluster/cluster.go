@@ -0,0 +1,104 @@
+package cluster
+
+import (
+	"net/url"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// Resolve returns the network of a host listed in the table
+func (r StaticResolver) Resolve(host string) (string, bool) {
+	network, ok := r[host]
+	return network, ok
+}
+
+// Lookup returns the network of a host listed in the table
+func (l StaticLookup) Lookup(host string) (Network, bool) {
+	network, ok := l[host]
+	return network, ok
+}
+
+// NetworkOf returns the network of a provider: its registered ASN when it has one, otherwise the network of its
+// first endpoint known to the lookup (which may be nil)
+func NetworkOf(p *pairing.Provider, lookup NetworkLookup) Network {
+	if p.ASN != 0 || lookup == nil {
+		return Network{ASN: p.ASN}
+	}
+	for _, e := range p.Endpoints {
+		u, err := url.Parse(e.URL)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		if network, ok := lookup.Lookup(u.Hostname()); ok {
+			return network
+		}
+	}
+	return Network{}
+}
+
+// Cluster returns the cluster of every provider, by provider ID
+// A cluster is identified by the smallest ID of its providers, so a provider sharing nothing with the others is
+// its own cluster
+func (c *Clusterer) Cluster(providers []*pairing.Provider) map[string]string {
+	parent := make(map[string]string, len(providers))
+	var find func(id string) string
+	find = func(id string) string {
+		if parent[id] != id {
+			parent[id] = find(parent[id]) // Path compression
+		}
+		return parent[id]
+	}
+	union := func(a, b string) {
+		ra, rb := find(a), find(b)
+		if ra == rb {
+			return
+		}
+		if rb < ra {
+			ra, rb = rb, ra
+		}
+		parent[rb] = ra // The smallest ID stays the root
+	}
+
+	owners := make(map[string]string) // Shared key -> first provider ID with it
+	for _, p := range providers {
+		parent[p.ID] = p.ID
+	}
+	for _, p := range providers {
+		for _, key := range c.keys(p) {
+			if owner, ok := owners[key]; ok {
+				union(owner, p.ID)
+			} else {
+				owners[key] = p.ID
+			}
+		}
+	}
+
+	clusters := make(map[string]string, len(providers))
+	for _, p := range providers {
+		clusters[p.ID] = find(p.ID)
+	}
+	return clusters
+}
+
+// keys returns what a provider may share with other providers of its operator
+func (c *Clusterer) keys(p *pairing.Provider) []string {
+	var keys []string
+	if c.AddressPrefix > 0 && len(p.Address) >= c.AddressPrefix {
+		keys = append(keys, "address:"+string(p.Address[:c.AddressPrefix]))
+	}
+	if c.Resolver == nil {
+		return keys
+	}
+	for _, e := range p.Endpoints {
+		u, err := url.Parse(e.URL)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		network, ok := c.Resolver.Resolve(u.Hostname())
+		if !ok {
+			continue // Unknown hosts don't link providers together
+		}
+		keys = append(keys, "network:"+network)
+	}
+	return keys
+}
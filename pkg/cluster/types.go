@@ -0,0 +1,35 @@
+package cluster
+
+// Resolver maps an endpoint host to the network it is served from, e.g. its IP address or autonomous system,
+// so providers sharing a network are clustered together
+type Resolver interface {
+	Resolve(host string) (network string, ok bool)
+}
+
+// StaticResolver resolves hosts from a fixed table, e.g. exported from an IP-to-ASN database
+type StaticResolver map[string]string
+
+// Network is where a provider's endpoints are served from
+type Network struct {
+	ASN uint32 // Autonomous system number, 0 if unknown
+}
+
+// NetworkLookup resolves the network of an endpoint host, e.g. from an IP-to-ASN database
+type NetworkLookup interface {
+	Lookup(host string) (Network, bool)
+}
+
+// StaticLookup resolves hosts from a fixed table
+type StaticLookup map[string]Network
+
+// Clusterer groups providers believed to be run by a single operator: providers sharing an address prefix or
+// an endpoint network end up in the same cluster, transitively
+type Clusterer struct {
+	// AddressPrefix clusters providers whose addresses share their first AddressPrefix characters, 0 disables it
+	// NOTE: Lava addresses share their "lava@1" prefix, so it should be well past it
+	AddressPrefix int
+	// Resolver, when set, clusters providers with endpoints resolving to the same network (e.g. IP address)
+	// NOTE: Without it endpoints don't cluster providers: unrelated providers often share a host, such as a CDN
+	// or proxy in front of them
+	Resolver Resolver
+}
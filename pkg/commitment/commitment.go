@@ -0,0 +1,190 @@
+package commitment
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// Build builds the Merkle tree of a provider snapshot
+// Nil providers are skipped; providers sharing an ID are kept in their snapshot order
+func Build(providers []*pairing.Provider) *Tree {
+	kept := make([]*pairing.Provider, 0, len(providers))
+	for _, p := range providers {
+		if p != nil {
+			kept = append(kept, p)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].ID < kept[j].ID })
+
+	t := &Tree{ids: make([]string, len(kept)), data: make([]string, len(kept))}
+	leaves := make([]hash, len(kept))
+	for i, p := range kept {
+		t.ids[i] = p.ID
+		t.data[i] = DataHash(p)
+		leaves[i] = leafHash(p.ID, t.data[i])
+	}
+	t.levels = [][]hash{leaves}
+	for level := leaves; len(level) > 1; {
+		next := make([]hash, 0, (len(level)+1)/2)
+		for i := 0; i+1 < len(level); i += 2 {
+			next = append(next, nodeHash(level[i], level[i+1]))
+		}
+		if len(level)%2 == 1 {
+			next = append(next, level[len(level)-1]) // Carried up
+		}
+		t.levels = append(t.levels, next)
+		level = next
+	}
+	return t
+}
+
+// DataHash returns the hex SHA-256 of a provider's JSON encoding, which is deterministic for a given provider
+func DataHash(p *pairing.Provider) string {
+	raw, _ := json.Marshal(p) // Providers always encode
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// Root returns the hex Merkle root, the SHA-256 of nothing for an empty snapshot
+func (t *Tree) Root() string {
+	if len(t.ids) == 0 {
+		sum := sha256.Sum256(nil)
+		return hex.EncodeToString(sum[:])
+	}
+	root := t.levels[len(t.levels)-1][0]
+	return hex.EncodeToString(root[:])
+}
+
+// Size returns the number of providers in the snapshot
+func (t *Tree) Size() int { return len(t.ids) }
+
+// Prove returns the proof that the provider was in the snapshot, false if it wasn't
+func (t *Tree) Prove(providerID string) (Proof, bool) {
+	i := sort.SearchStrings(t.ids, providerID)
+	if i == len(t.ids) || t.ids[i] != providerID {
+		return Proof{}, false
+	}
+	return t.prove(i), true
+}
+
+// ProveAbsence returns the proof that the provider wasn't in the snapshot, false if it was
+func (t *Tree) ProveAbsence(providerID string) (AbsenceProof, bool) {
+	i := sort.SearchStrings(t.ids, providerID)
+	if i < len(t.ids) && t.ids[i] == providerID {
+		return AbsenceProof{}, false
+	}
+	absence := AbsenceProof{ProviderID: providerID, Size: len(t.ids)}
+	if i > 0 {
+		before := t.prove(i - 1)
+		absence.Before = &before
+	}
+	if i < len(t.ids) {
+		after := t.prove(i)
+		absence.After = &after
+	}
+	return absence, true
+}
+
+// prove returns the proof of the leaf at index i
+func (t *Tree) prove(i int) Proof {
+	proof := Proof{ProviderID: t.ids[i], DataHash: t.data[i], Index: i, Size: len(t.ids)}
+	for _, level := range t.levels[:len(t.levels)-1] {
+		if sibling := i ^ 1; sibling < len(level) {
+			proof.Siblings = append(proof.Siblings, hex.EncodeToString(level[sibling][:]))
+		}
+		i /= 2
+	}
+	return proof
+}
+
+// Verify reports whether the proof leads to the given hex root
+// The path's shape is derived from Index and Size, so a valid proof also proves the leaf's position
+func (p Proof) Verify(root string) bool {
+	if p.Index < 0 || p.Index >= p.Size {
+		return false
+	}
+	current := leafHash(p.ProviderID, p.DataHash)
+	siblings := p.Siblings
+	for i, size := p.Index, p.Size; size > 1; i, size = i/2, (size+1)/2 {
+		if i^1 >= size {
+			continue // Carried up without a sibling
+		}
+		if len(siblings) == 0 {
+			return false
+		}
+		raw, err := hex.DecodeString(siblings[0])
+		if err != nil || len(raw) != len(hash{}) {
+			return false
+		}
+		siblings = siblings[1:]
+		var sibling hash
+		copy(sibling[:], raw)
+		if i%2 == 0 {
+			current = nodeHash(current, sibling)
+		} else {
+			current = nodeHash(sibling, current)
+		}
+	}
+	return len(siblings) == 0 && hex.EncodeToString(current[:]) == root
+}
+
+// VerifyProvider reports whether the proof leads to the given hex root for exactly this provider's data
+func (p Proof) VerifyProvider(root string, provider *pairing.Provider) bool {
+	return provider.ID == p.ProviderID && DataHash(provider) == p.DataHash && p.Verify(root)
+}
+
+// Verify reports whether the proof shows its provider absent from the snapshot committed to by the hex root:
+// the providers around it are adjacent leaves, or the snapshot's ends, with IDs on either side of it
+func (a AbsenceProof) Verify(root string) bool {
+	if a.Size == 0 {
+		sum := sha256.Sum256(nil)
+		return a.Before == nil && a.After == nil && hex.EncodeToString(sum[:]) == root
+	}
+	if a.Before == nil && a.After == nil {
+		return false
+	}
+	if a.Before != nil {
+		if a.Before.Size != a.Size || a.Before.ProviderID >= a.ProviderID || !a.Before.Verify(root) {
+			return false
+		}
+		if a.After == nil && a.Before.Index != a.Size-1 {
+			return false
+		}
+	}
+	if a.After != nil {
+		if a.After.Size != a.Size || a.After.ProviderID <= a.ProviderID || !a.After.Verify(root) {
+			return false
+		}
+		if a.Before == nil && a.After.Index != 0 {
+			return false
+		}
+	}
+	return a.Before == nil || a.After == nil || a.After.Index == a.Before.Index+1
+}
+
+// leafHash hashes a leaf, binding the provider ID to its data hash
+func leafHash(providerID, dataHash string) hash {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write([]byte(providerID))
+	h.Write([]byte{0})
+	h.Write([]byte(dataHash))
+	var sum hash
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// nodeHash hashes an inner node
+func nodeHash(left, right hash) hash {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left[:])
+	h.Write(right[:])
+	var sum hash
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
@@ -0,0 +1,37 @@
+package commitment
+
+// Domain separation prefixes, so a leaf can't be passed off as an inner node and vice versa
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// hash is a SHA-256 digest
+type hash [32]byte
+
+// Tree is a Merkle tree over a provider snapshot, its leaves ordered by provider ID so that absence can be
+// proven too (see ProveAbsence)
+// A level's last node without a sibling is carried up as is, rather than paired with a copy of itself
+type Tree struct {
+	ids    []string // Provider IDs, in leaf order
+	data   []string // Hex data hash of each leaf's provider, see DataHash
+	levels [][]hash // levels[0] are the leaves, the last level holds the root
+}
+
+// Proof proves a provider, with the given data, was in the snapshot committed to by a root
+type Proof struct {
+	ProviderID string   `json:"provider_id"`
+	DataHash   string   `json:"data_hash"` // See DataHash
+	Index      int      `json:"index"`     // Leaf position, in provider ID order
+	Size       int      `json:"size"`      // Number of leaves
+	Siblings   []string `json:"siblings"`  // Hex hashes from the leaf's sibling up to the root's children
+}
+
+// AbsenceProof proves a provider wasn't in the snapshot committed to by a root, with the proofs of the
+// providers right before and after its ID, nil at either end of the snapshot
+type AbsenceProof struct {
+	ProviderID string `json:"provider_id"`
+	Before     *Proof `json:"before,omitempty"`
+	After      *Proof `json:"after,omitempty"`
+	Size       int    `json:"size"` // Number of leaves, to check an empty snapshot's root
+}
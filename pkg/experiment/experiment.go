@@ -0,0 +1,116 @@
+package experiment
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/system"
+)
+
+// NewRouter creates a new Router serving the experiment's treatment to its share of consumers and the
+// control system to everyone else
+func NewRouter(control system.PairingSystem, experiment Experiment, logger *slog.Logger) (*Router, error) {
+	if experiment.Percentage < 0 || experiment.Percentage > 100 {
+		return nil, fmt.Errorf("experiment %q: percentage must be within [0, 100], got %v", experiment.Name, experiment.Percentage)
+	}
+	if control == nil || experiment.Treatment == nil {
+		return nil, fmt.Errorf("experiment %q: both control and treatment systems are required", experiment.Name)
+	}
+	// Ensure logger is not nil, provide a default discard logger if it is
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	return &Router{
+		control:    control,
+		experiment: experiment,
+		logger:     logger.With("experiment", experiment.Name),
+	}, nil
+}
+
+// Arm returns the arm a consumer is assigned to
+func (r *Router) Arm(consumerID string) Arm {
+	h := fnv.New64a()
+	h.Write([]byte(r.experiment.Name))
+	h.Write([]byte{0}) // Separator, so ("ab", "c") and ("a", "bc") don't collide
+	h.Write([]byte(consumerID))
+	bucket := h.Sum64() % bucketCount
+	if float64(bucket) < r.experiment.Percentage*bucketCount/100 {
+		return ArmTreatment
+	}
+	return ArmControl
+}
+
+// GetPairingListWithArm serves the request from the consumer's arm and reports which arm it was
+func (r *Router) GetPairingListWithArm(providers []*pairing.Provider, policy *pairing.ConsumerPolicy, opts ...system.PairingOptions) (*system.PairingResult, Arm, error) {
+	arm := r.Arm(string(policy.ConsumerID))
+	r.count(arm)
+	result, err := r.systemFor(arm).GetPairingList(providers, policy, opts...)
+	if err != nil {
+		r.logger.Debug("Experiment request failed", "arm", arm, "consumer_id", policy.ConsumerID, "error", err)
+		return nil, arm, err
+	}
+	r.logger.Debug("Experiment request served", "arm", arm, "consumer_id", policy.ConsumerID, "selected_count", len(result.Providers))
+	return result, arm, nil
+}
+
+// Stats returns the number of requests served by each arm
+func (r *Router) Stats() map[Arm]int64 {
+	return map[Arm]int64{
+		ArmControl:   r.controlServed.Load(),
+		ArmTreatment: r.treatmentServed.Load(),
+	}
+}
+
+/* ***********************************************************************
+ *                              PAIRING SYSTEM                           *
+ *********************************************************************** */
+
+// FilterProviders filters with the consumer's arm
+func (r *Router) FilterProviders(providers []*pairing.Provider, policy *pairing.ConsumerPolicy) []*pairing.Provider {
+	return r.systemFor(r.Arm(string(policy.ConsumerID))).FilterProviders(providers, policy)
+}
+
+// RankProviders ranks with the consumer's arm
+func (r *Router) RankProviders(providers []*pairing.Provider, policy *pairing.ConsumerPolicy) []*pairing.PairingScore {
+	return r.systemFor(r.Arm(string(policy.ConsumerID))).RankProviders(providers, policy)
+}
+
+// GetPairingList serves the request from the consumer's arm
+func (r *Router) GetPairingList(providers []*pairing.Provider, policy *pairing.ConsumerPolicy, opts ...system.PairingOptions) (*system.PairingResult, error) {
+	result, _, err := r.GetPairingListWithArm(providers, policy, opts...)
+	return result, err
+}
+
+// ConfigFingerprint hashes the experiment together with both arms' fingerprints, so changing either arm,
+// the split or the salt yields a new fingerprint
+func (r *Router) ConfigFingerprint() string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s|%v|%s|%s", r.experiment.Name, r.experiment.Percentage, r.control.ConfigFingerprint(), r.experiment.Treatment.ConfigFingerprint())
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// Close closes both the control and the treatment systems
+func (r *Router) Close() {
+	r.control.Close()
+	r.experiment.Treatment.Close()
+}
+
+// systemFor returns the system serving an arm
+func (r *Router) systemFor(arm Arm) system.PairingSystem {
+	if arm == ArmTreatment {
+		return r.experiment.Treatment
+	}
+	return r.control
+}
+
+// count records a request served by an arm
+func (r *Router) count(arm Arm) {
+	if arm == ArmTreatment {
+		r.treatmentServed.Add(1)
+	} else {
+		r.controlServed.Add(1)
+	}
+}
@@ -0,0 +1,36 @@
+package experiment
+
+import (
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/system"
+)
+
+// Arm identifies which strategy served a pairing request
+type Arm string
+
+const (
+	ArmControl   Arm = "control"
+	ArmTreatment Arm = "treatment"
+)
+
+// bucketCount is the number of hash buckets consumers are spread over, giving 0.01% granularity
+const bucketCount = 10000
+
+// Experiment routes a share of consumers to an alternative pairing strategy
+type Experiment struct {
+	Name       string               // Salts the bucketing, so concurrent experiments pick independent consumers
+	Percentage float64              // Share of consumers (0-100) routed to the treatment arm
+	Treatment  system.PairingSystem // The alternative strategy under evaluation
+}
+
+// Router is a PairingSystem splitting requests between a control system and an experiment's treatment
+// Consumers are bucketed by a hash of their ID, so each consumer consistently lands in the same arm
+type Router struct {
+	control         system.PairingSystem
+	experiment      Experiment
+	logger          *slog.Logger
+	controlServed   atomic.Int64 // Requests served by the control arm
+	treatmentServed atomic.Int64 // Requests served by the treatment arm
+}
@@ -0,0 +1,141 @@
+package export
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// NewCSVExporter creates a CSVExporter writing to w
+// If components are given they fix the component columns, otherwise the first export does
+func NewCSVExporter(w io.Writer, components ...string) *CSVExporter {
+	e := &CSVExporter{w: csv.NewWriter(w)}
+	if len(components) > 0 {
+		e.components = slices.Clone(components)
+	}
+	return e
+}
+
+// OpenCSV creates a CSVExporter appending to the file at path, created if missing
+// Exports to an existing file keep its columns, so a file can collect scores across runs; new files get the
+// given component columns, or those of the first export
+// TIP: Pass the name of every registered scorer, so scorers that aren't applicable in the first export
+// (e.g. UptimeScore before any heartbeat) still get a column
+func OpenCSV(path string, components ...string) (*CSVExporter, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	header, err := csv.NewReader(f).Read()
+	switch {
+	case errors.Is(err, io.EOF):
+		header = nil // New file, the first export writes the header
+	case err != nil:
+		f.Close()
+		return nil, fmt.Errorf("%s: read header: %w", path, err)
+	case len(header) < len(fixedColumns) || !slices.Equal(header[:len(fixedColumns)], fixedColumns):
+		f.Close()
+		return nil, fmt.Errorf("%s: not a score export", path)
+	}
+
+	e := NewCSVExporter(f, components...)
+	e.closer = f
+	if header != nil {
+		e.components = append([]string{}, header[len(fixedColumns):]...)
+		e.hasHeader = true
+	}
+	return e, nil
+}
+
+// Export writes one row per scored provider, timestamped with at and labelled with the policy's chain and
+// consumer, and flushes them
+func (e *CSVExporter) Export(at time.Time, policy *pairing.ConsumerPolicy, scores []*pairing.PairingScore) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.hasHeader {
+		if e.components == nil {
+			e.components = componentNames(scores)
+		}
+		if err := e.w.Write(append(slices.Clone(fixedColumns), e.components...)); err != nil {
+			return err
+		}
+		e.hasHeader = true
+	}
+	if err := e.checkComponents(scores); err != nil {
+		return err
+	}
+
+	timestamp := at.UTC().Format(time.RFC3339Nano)
+	row := make([]string, len(fixedColumns)+len(e.components))
+	for _, ps := range scores {
+		row[0], row[1], row[2] = timestamp, policy.ChainID, string(policy.ConsumerID)
+		row[3] = ps.Provider.ID
+		row[4] = formatScore(ps.Score)
+		for i, name := range e.components {
+			row[len(fixedColumns)+i] = ""
+			if value, ok := ps.Components[name]; ok {
+				row[len(fixedColumns)+i] = formatScore(value)
+			}
+		}
+		if err := e.w.Write(row); err != nil {
+			return err
+		}
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// Close flushes pending rows and closes the file opened by OpenCSV
+func (e *CSVExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.w.Flush()
+	err := e.w.Error()
+	if e.closer != nil {
+		err = errors.Join(err, e.closer.Close())
+	}
+	return err
+}
+
+// checkComponents returns ErrColumnsChanged if any score carries a component without a column
+func (e *CSVExporter) checkComponents(scores []*pairing.PairingScore) error {
+	for _, ps := range scores {
+		for name := range ps.Components {
+			if !slices.Contains(e.components, name) {
+				return fmt.Errorf("%w: unexpected component %q", ErrColumnsChanged, name)
+			}
+		}
+	}
+	return nil
+}
+
+// componentNames returns the sorted union of the scores' component names
+func componentNames(scores []*pairing.PairingScore) []string {
+	seen := make(map[string]bool)
+	names := []string{}
+	for _, ps := range scores {
+		for name := range ps.Components {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// formatScore formats a score with the shortest representation that round-trips
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
@@ -0,0 +1,27 @@
+package export
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrColumnsChanged is returned when scores carry a component the exporter's columns don't have, e.g. after
+// a scorer was added between exports to the same file
+var ErrColumnsChanged = errors.New("score components don't match the exported columns")
+
+// fixedColumns lead every row, followed by one column per score component
+var fixedColumns = []string{"timestamp", "chain_id", "consumer_id", "provider_id", "score"}
+
+// CSVExporter writes per-provider, per-component score matrices (see system.PairingSystem.RankProviders) as
+// CSV rows, one per provider and export, for offline analysis of scoring behavior over time
+// Component columns are fixed by the first export (or the existing header, see OpenCSV); components a scorer
+// wasn't applicable to are left empty
+type CSVExporter struct {
+	mu         sync.Mutex
+	w          *csv.Writer
+	closer     io.Closer // Set when the exporter owns the underlying file
+	components []string  // Component columns, nil until the first export if not given upfront
+	hasHeader  bool      // Whether the header was written, or found in the file
+}
@@ -0,0 +1,172 @@
+package fairness
+
+import (
+	"maps"
+	"time"
+)
+
+// NewTracker creates a new Tracker with the given config
+func NewTracker(cfg Config) *Tracker {
+	return &Tracker{
+		cfg:    cfg,
+		width:  cfg.Window / bucketCount,
+		counts: make(map[string]int64),
+		now:    time.Now,
+	}
+}
+
+// SetClock replaces the clock selections expire against when shares are read, snapshotted or restored, nil
+// restores time.Now; Admit and Record are given the time of their call
+// Replays pin it to the time of the recorded call
+func (t *Tracker) SetClock(now func() time.Time) {
+	if now == nil {
+		now = time.Now
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.now = now
+}
+
+// Admit settles a pairing's selection: providers whose share would exceed MaxShare give their
+// slot to the best ranked providers not selected yet that stay within theirs, appended after the remaining
+// selected providers
+// selected is the selection in order and ranked every eligible provider, best first. accept, when set, reports
+// whether a provider may take the slot of the replaced one, e.g. to keep other caps on the selection. An over-cap
+// provider keeps its slot when no replacement is left, the cap never shrinks a selection
+// now is the time of the pairing, selections older than the window before it don't count
+// NOTE: The selection isn't recorded, Record must be called once the pairing succeeds
+func (t *Tracker) Admit(now time.Time, selected, ranked []string, accept func(provider, replaced string) bool) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(now)
+
+	slots := int64(len(selected))
+	overCap := func(providerID string) bool {
+		return float64(t.counts[providerID]+1) > t.cfg.MaxShare*float64(t.total+slots)
+	}
+	taken := make(map[string]bool, len(ranked))
+	for _, id := range selected {
+		taken[id] = true
+	}
+
+	admitted := make([]string, 0, len(selected))
+	var overflow []string
+	for _, id := range selected {
+		if overCap(id) {
+			overflow = append(overflow, id)
+		} else {
+			admitted = append(admitted, id)
+		}
+	}
+	for _, id := range ranked {
+		if len(overflow) == 0 {
+			break
+		}
+		if !taken[id] && !overCap(id) && (accept == nil || accept(id, overflow[0])) {
+			taken[id] = true
+			admitted = append(admitted, id)
+			overflow = overflow[1:]
+		}
+	}
+	admitted = append(admitted, overflow...) // No replacement left
+	return admitted
+}
+
+// Record counts a pairing's final selection of the given providers, made at now, towards their shares
+func (t *Tracker) Record(now time.Time, providerIDs []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(now)
+	t.record(now, providerIDs)
+}
+
+// Share returns the provider's share (0-1) of the selections within the window, 0 if nothing was selected
+func (t *Tracker) Share(providerID string) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(t.now())
+	if t.total == 0 {
+		return 0
+	}
+	return float64(t.counts[providerID]) / float64(t.total)
+}
+
+// Shares returns every provider's share of the selections within the window, by provider ID
+func (t *Tracker) Shares() map[string]float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(t.now())
+	shares := make(map[string]float64, len(t.counts))
+	for id, count := range t.counts {
+		shares[id] = float64(count) / float64(t.total)
+	}
+	return shares
+}
+
+// Snapshot returns a copy of the selections within the window
+func (t *Tracker) Snapshot() Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(t.now())
+	snapshot := Snapshot{Window: t.cfg.Window, Buckets: make([]BucketSnapshot, len(t.buckets))}
+	for i, b := range t.buckets {
+		snapshot.Buckets[i] = BucketSnapshot{Start: b.start, Counts: maps.Clone(b.counts)}
+	}
+	return snapshot
+}
+
+// Restore replaces the tracker's selections with a snapshot
+// A snapshot accounted over another window is dropped, its buckets wouldn't line up
+func (t *Tracker) Restore(snapshot Snapshot) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buckets, t.counts, t.total = nil, make(map[string]int64), 0
+	if snapshot.Window != t.cfg.Window {
+		return
+	}
+	for _, b := range snapshot.Buckets {
+		restored := &bucket{start: b.Start, counts: make(map[string]int64, len(b.Counts))}
+		for id, count := range b.Counts {
+			restored.counts[id] = count
+			restored.total += count
+			t.counts[id] += count
+		}
+		t.total += restored.total
+		t.buckets = append(t.buckets, restored)
+	}
+	t.expire(t.now())
+}
+
+// record counts a selection of the given providers
+// NOTE: Must be called with t.mu held
+func (t *Tracker) record(now time.Time, providerIDs []string) {
+	var current *bucket
+	if n := len(t.buckets); n > 0 && now.Before(t.buckets[n-1].start.Add(t.width)) {
+		current = t.buckets[n-1]
+	} else {
+		current = &bucket{start: now.Truncate(t.width), counts: make(map[string]int64)}
+		t.buckets = append(t.buckets, current)
+	}
+	for _, id := range providerIDs {
+		current.counts[id]++
+		t.counts[id]++
+	}
+	current.total += int64(len(providerIDs))
+	t.total += int64(len(providerIDs))
+}
+
+// expire drops the buckets that fell out of the window
+// NOTE: Must be called with t.mu held
+func (t *Tracker) expire(now time.Time) {
+	cutoff := now.Add(-t.cfg.Window)
+	for len(t.buckets) > 0 && !t.buckets[0].start.Add(t.width).After(cutoff) {
+		old := t.buckets[0]
+		for id, count := range old.counts {
+			if t.counts[id] -= count; t.counts[id] == 0 {
+				delete(t.counts, id)
+			}
+		}
+		t.total -= old.total
+		t.buckets = t.buckets[1:]
+	}
+}
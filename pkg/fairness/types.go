@@ -0,0 +1,50 @@
+package fairness
+
+import (
+	"sync"
+	"time"
+)
+
+// bucketCount is the number of buckets a window is split into, the granularity at which selections expire
+const bucketCount = 10
+
+// Config controls the rolling window selection shares are accounted over and the cap enforced on them
+type Config struct {
+	Window time.Duration // Rolling window selections are counted over
+	// MaxShare is the largest fraction (0-1) of the selections within the window a single provider may get
+	// NOTE: A provider selected in every pairing of n providers gets a share of 1/n, so caps are only effective
+	// below that
+	MaxShare float64
+}
+
+// Tracker accounts each provider's share of the selections over a rolling window and caps it
+// Time is split into buckets of Window/bucketCount; a selection expires with its bucket. It is safe for
+// concurrent use
+type Tracker struct {
+	mu      sync.Mutex
+	cfg     Config
+	width   time.Duration    // Bucket length
+	buckets []*bucket        // Buckets within the window, oldest first
+	counts  map[string]int64 // Provider ID -> selections within the window, summed over the buckets
+	total   int64            // Selections within the window
+	now     func() time.Time
+}
+
+// bucket holds the selections made within a bucket length
+type bucket struct {
+	start  time.Time
+	counts map[string]int64
+	total  int64
+}
+
+// Snapshot is the persistable state of a Tracker, see Tracker.Snapshot
+type Snapshot struct {
+	Window  time.Duration    `json:"window"` // Window the selections were accounted over
+	Buckets []BucketSnapshot `json:"buckets,omitempty"`
+}
+
+// BucketSnapshot is the selections made within a bucket length, by provider ID
+type BucketSnapshot struct {
+	Start  time.Time        `json:"start"`
+	Counts map[string]int64 `json:"counts"`
+}
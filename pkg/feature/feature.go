@@ -0,0 +1,150 @@
+package feature
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// maxSuggestionDistance is the largest edit distance at which a known feature is suggested for an unknown one
+const maxSuggestionDistance = 2
+
+// NewCatalog creates a Catalog of the given features
+func NewCatalog(features ...Feature) (*Catalog, error) {
+	c := &Catalog{features: make(map[string]Feature, len(features))}
+	for _, f := range features {
+		if f.ID == "" {
+			return nil, fmt.Errorf("feature catalog: empty feature ID")
+		}
+		if _, dup := c.features[f.ID]; dup {
+			return nil, fmt.Errorf("feature catalog: duplicate feature %q", f.ID)
+		}
+		c.features[f.ID] = f
+	}
+	return c, nil
+}
+
+// LoadCatalog loads a Catalog from a JSON file of the form [{"id": "archive", "description": "Full history"}]
+func LoadCatalog(path string) (*Catalog, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := ParseCatalog(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// ParseCatalog parses a Catalog from its JSON representation (see LoadCatalog)
+func ParseCatalog(raw []byte) (*Catalog, error) {
+	var features []Feature
+	if err := json.Unmarshal(raw, &features); err != nil {
+		return nil, fmt.Errorf("parse feature catalog: %w", err)
+	}
+	return NewCatalog(features...)
+}
+
+// Lookup returns the catalog entry of a feature
+func (c *Catalog) Lookup(id string) (Feature, bool) {
+	f, ok := c.features[id]
+	return f, ok
+}
+
+// Features returns every feature of the catalog, sorted by ID
+func (c *Catalog) Features() []Feature {
+	features := make([]Feature, 0, len(c.features))
+	for _, f := range c.features {
+		features = append(features, f)
+	}
+	sort.Slice(features, func(i, j int) bool { return features[i].ID < features[j].ID })
+	return features
+}
+
+// Check returns an error wrapping ErrUnknownFeature naming the given features missing from the catalog, with
+// the closest known feature suggested for likely typos
+func (c *Catalog) Check(ids []string) error {
+	var unknown []string
+	for _, id := range ids {
+		if _, ok := c.features[id]; ok || id == "" { // Empty identifiers don't reference any feature
+			continue
+		}
+		if suggestion, ok := c.suggest(id); ok {
+			unknown = append(unknown, fmt.Sprintf("%q (did you mean %q?)", id, suggestion))
+		} else {
+			unknown = append(unknown, fmt.Sprintf("%q", id))
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownFeature, strings.Join(unknown, ", "))
+}
+
+// ValidatePolicy checks every feature referenced by a policy against the catalog
+func (c *Catalog) ValidatePolicy(policy *pairing.ConsumerPolicy) error {
+	if err := c.Check(policy.RequiredFeatures); err != nil {
+		return fmt.Errorf("policy required_features: %w", err)
+	}
+	for i, group := range policy.FeatureGroups {
+		if err := c.Check(group.Features); err != nil {
+			return fmt.Errorf("policy feature_groups[%d]: %w", i, err)
+		}
+	}
+	valued := make([]string, 0, len(policy.FeatureValues))
+	for feat := range policy.FeatureValues {
+		valued = append(valued, feat)
+	}
+	sort.Strings(valued)
+	if err := c.Check(valued); err != nil {
+		return fmt.Errorf("policy feature_values: %w", err)
+	}
+	return nil
+}
+
+// ValidateProvider checks a provider's features against the catalog
+func (c *Catalog) ValidateProvider(p *pairing.Provider) error {
+	if err := c.Check(p.Features); err != nil {
+		return fmt.Errorf("provider %s features: %w", p.ID, err)
+	}
+	return nil
+}
+
+// suggest returns the known feature closest to id, if close enough to be a typo
+func (c *Catalog) suggest(id string) (string, bool) {
+	best, bestDistance := "", maxSuggestionDistance+1
+	for known := range c.features {
+		d := editDistance(strings.ToLower(id), strings.ToLower(known))
+		if d < bestDistance || d == bestDistance && known < best {
+			best, bestDistance = known, d
+		}
+	}
+	return best, bestDistance <= maxSuggestionDistance
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,18 @@
+package feature
+
+import "errors"
+
+// ErrUnknownFeature is wrapped by the errors returned for features missing from a Catalog
+var ErrUnknownFeature = errors.New("unknown feature")
+
+// Feature is a valid feature identifier along with what it means
+type Feature struct {
+	ID          string `json:"id"`
+	Description string `json:"description,omitempty"`
+}
+
+// Catalog lists the valid feature identifiers, so typos (e.g. "featAA") in policies and provider data are
+// caught instead of silently filtering every provider out
+type Catalog struct {
+	features map[string]Feature
+}
@@ -0,0 +1,402 @@
+package filter
+
+import (
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/policy"
+	"github.com/Yoaz/LavaPairingSystem/pkg/uptime"
+)
+
+/* ***********************************************************************
+ *                            LOCATION FILTER                            *
+ *********************************************************************** */
+
+// Apply filters providers based on the required location in the policy
+// It retains only those providers that match the policy's location, see ApplySingle
+func (f LocationFilter) Apply(providers []*pairing.Provider, consumerPolicy *pairing.ConsumerPolicy) []*pairing.Provider {
+	if !f.Applicable(consumerPolicy) {
+		return providers // No location required, every location is accepted
+	}
+	compiled := policy.Compile(consumerPolicy)
+	var result []*pairing.Provider
+	for _, p := range providers {
+		if f.ApplyCompiled(p, compiled) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// ApplySingle checks if a single provider matches the required location in the policy
+// Like Lava, it returns true if the provider's geolocation intersects the policy's; when either side has no
+// known geolocation, the provider's Location field must match the policy's RequiredLocation exactly. A policy
+// without a location (see Applicable) accepts every location
+func (f LocationFilter) ApplySingle(provider *pairing.Provider, consumerPolicy *pairing.ConsumerPolicy) bool {
+	if !f.Applicable(consumerPolicy) {
+		return true
+	}
+	return f.ApplyCompiled(provider, policy.Compile(consumerPolicy))
+}
+
+// ApplyCompiled is ApplySingle against a compiled policy
+// NOTE: It matches locations exactly, a policy without a location only accepts providers without one; the
+// pairing system only applies it to such policies under WithStrictConstraints
+func (f LocationFilter) ApplyCompiled(provider *pairing.Provider, compiled *policy.CompiledPolicy) bool {
+	return compiled.MatchesLocation(provider)
+}
+
+// Applicable reports whether the policy requires a location or geolocation
+func (f LocationFilter) Applicable(policy *pairing.ConsumerPolicy) bool {
+	return policy.RequiredLocation != "" || policy.Geolocation != 0
+}
+
+func (f LocationFilter) Name() string { return "LocationFilter" }
+
+/* ***********************************************************************
+ *                            FEATURE FILTER                             *
+ *********************************************************************** */
+
+// Apply filters providers ensuring they support all features specified in the policy's RequiredFeatures
+// and at least MinMatch features of each of the policy's FeatureGroups
+func (f FeatureFilter) Apply(providers []*pairing.Provider, consumerPolicy *pairing.ConsumerPolicy) []*pairing.Provider {
+	// Index the policy's features once for the whole list
+	compiled := policy.Compile(consumerPolicy)
+	var result []*pairing.Provider
+	for _, p := range providers {
+		if f.ApplyCompiled(p, compiled) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// ApplySingle checks if a single provider supports all required features and satisfies every feature group
+func (f FeatureFilter) ApplySingle(provider *pairing.Provider, consumerPolicy *pairing.ConsumerPolicy) bool {
+	return f.ApplyCompiled(provider, policy.Compile(consumerPolicy))
+}
+
+// ApplyCompiled is ApplySingle against a compiled policy, without building any per-provider lookup
+func (f FeatureFilter) ApplyCompiled(provider *pairing.Provider, compiled *policy.CompiledPolicy) bool {
+	return compiled.HasFeatures(provider)
+}
+
+// Applicable reports whether the policy requires any non-empty feature or feature group
+func (f FeatureFilter) Applicable(policy *pairing.ConsumerPolicy) bool {
+	for _, req := range policy.RequiredFeatures {
+		if req != "" {
+			return true
+		}
+	}
+	return len(policy.FeatureGroups) > 0
+}
+
+func (f FeatureFilter) Name() string { return "FeatureFilter" }
+
+/* ***********************************************************************
+ *                            STAKE FILTER                               *
+ *********************************************************************** */
+
+// Apply filters providers based on the minimum stake requirement in the policy
+// It retains only those providers whose effective stake (self plus weighted delegated stake, minus pending
+// slashes) is greater than or equal to the policy's MinStake
+func (f StakeFilter) Apply(providers []*pairing.Provider, policy *pairing.ConsumerPolicy) []*pairing.Provider {
+	var result []*pairing.Provider
+	for _, p := range providers {
+		if p.EffectiveStake(f.DelegationFactor) >= policy.MinStake {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// ApplySingle checks if a single provider meets the minimum stake requirement in the policy
+// It returns true if the provider's effective stake is greater than or equal to the policy's MinStake
+func (f StakeFilter) ApplySingle(provider *pairing.Provider, policy *pairing.ConsumerPolicy) bool {
+	return provider.EffectiveStake(f.DelegationFactor) >= policy.MinStake
+}
+
+// Applicable always returns true, slashing can bring a provider's effective stake below even a zero MinStake
+func (f StakeFilter) Applicable(policy *pairing.ConsumerPolicy) bool { return true }
+
+func (f StakeFilter) Name() string { return "StakeFilter" }
+
+/* ***********************************************************************
+ *                            API INTERFACE FILTER                       *
+ *********************************************************************** */
+
+// Apply filters providers based on the API interface required by the policy
+// It retains only those providers with at least one endpoint serving the policy's RequiredAPIInterface
+// If the policy doesn't require an API interface, all providers are retained
+func (f APIInterfaceFilter) Apply(providers []*pairing.Provider, policy *pairing.ConsumerPolicy) []*pairing.Provider {
+	if policy.RequiredAPIInterface == "" {
+		return providers
+	}
+	var result []*pairing.Provider
+	for _, p := range providers {
+		if p.SupportsAPIInterface(policy.RequiredAPIInterface) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// ApplySingle checks if a single provider serves the API interface required by the policy
+// It returns true if the policy doesn't require an API interface or any of the provider's endpoints serves it
+func (f APIInterfaceFilter) ApplySingle(provider *pairing.Provider, policy *pairing.ConsumerPolicy) bool {
+	return policy.RequiredAPIInterface == "" || provider.SupportsAPIInterface(policy.RequiredAPIInterface)
+}
+
+// Applicable reports whether the policy requires an API interface
+func (f APIInterfaceFilter) Applicable(policy *pairing.ConsumerPolicy) bool {
+	return policy.RequiredAPIInterface != ""
+}
+
+func (f APIInterfaceFilter) Name() string { return "APIInterfaceFilter" }
+
+/* ***********************************************************************
+ *                            SECURITY FILTER                            *
+ *********************************************************************** */
+
+// Apply filters providers based on the security requirements of the policy
+// It drops providers without TLS when the policy sets RequireTLS, and jailed providers when it sets ExcludeJailed
+func (f SecurityFilter) Apply(providers []*pairing.Provider, policy *pairing.ConsumerPolicy) []*pairing.Provider {
+	if !policy.RequireTLS && !policy.ExcludeJailed {
+		return providers
+	}
+	var result []*pairing.Provider
+	for _, p := range providers {
+		if f.ApplySingle(p, policy) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// ApplySingle checks if a single provider meets the security requirements of the policy
+// It returns false if TLS is required but not enabled, or jailed providers are excluded and the provider is jailed
+func (f SecurityFilter) ApplySingle(provider *pairing.Provider, policy *pairing.ConsumerPolicy) bool {
+	if policy.RequireTLS && !provider.TLSEnabled {
+		return false
+	}
+	if policy.ExcludeJailed && provider.Jailed {
+		return false
+	}
+	return true
+}
+
+// Applicable reports whether the policy requires TLS or excludes jailed providers
+func (f SecurityFilter) Applicable(policy *pairing.ConsumerPolicy) bool {
+	return policy.RequireTLS || policy.ExcludeJailed
+}
+
+func (f SecurityFilter) Name() string { return "SecurityFilter" }
+
+/* ***********************************************************************
+ *                            JAIL FILTER                                *
+ *********************************************************************** */
+
+// Apply filters out providers currently jailed for accumulating too many failure reports
+func (f JailFilter) Apply(providers []*pairing.Provider, policy *pairing.ConsumerPolicy) []*pairing.Provider {
+	if f.Jailer == nil {
+		return providers
+	}
+	var result []*pairing.Provider
+	for _, p := range providers {
+		if !f.Jailer.IsJailed(p.ID) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// ApplySingle checks if a single provider is free of any jail term
+func (f JailFilter) ApplySingle(provider *pairing.Provider, policy *pairing.ConsumerPolicy) bool {
+	return f.Jailer == nil || !f.Jailer.IsJailed(provider.ID)
+}
+
+// Applicable always returns true, jail terms don't depend on the policy
+func (f JailFilter) Applicable(policy *pairing.ConsumerPolicy) bool { return true }
+
+func (f JailFilter) Name() string { return "JailFilter" }
+
+/* ***********************************************************************
+ *                            MIN UPTIME FILTER                          *
+ *********************************************************************** */
+
+// Apply filters providers based on the minimum uptime requirement in the policy
+// It retains only those providers whose uptime over the filter's window is at least the policy's MinUptime
+// If the policy doesn't set MinUptime, all providers are retained
+func (f MinUptimeFilter) Apply(providers []*pairing.Provider, policy *pairing.ConsumerPolicy) []*pairing.Provider {
+	if policy.MinUptime == 0 || f.Tracker == nil {
+		return providers
+	}
+	var result []*pairing.Provider
+	for _, p := range providers {
+		if f.ApplySingle(p, policy) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// ApplySingle checks if a single provider meets the minimum uptime requirement in the policy
+// Providers without any heartbeat history don't meet a non-zero MinUptime
+func (f MinUptimeFilter) ApplySingle(provider *pairing.Provider, policy *pairing.ConsumerPolicy) bool {
+	if policy.MinUptime == 0 || f.Tracker == nil {
+		return true
+	}
+	window := f.Window
+	if window == 0 {
+		window = uptime.Window24h
+	}
+	value, ok := f.Tracker.Uptime(provider.ID, window)
+	return ok && value >= policy.MinUptime
+}
+
+// Applicable reports whether the policy sets a minimum uptime
+func (f MinUptimeFilter) Applicable(policy *pairing.ConsumerPolicy) bool {
+	return policy.MinUptime != 0
+}
+
+func (f MinUptimeFilter) Name() string { return "MinUptimeFilter" }
+
+/* ***********************************************************************
+ *                            FEE FILTER                                 *
+ *********************************************************************** */
+
+// Apply filters providers based on the maximum fee in the policy
+// If the policy doesn't set MaxFee, all providers are retained
+func (f FeeFilter) Apply(providers []*pairing.Provider, policy *pairing.ConsumerPolicy) []*pairing.Provider {
+	if policy.MaxFee == 0 {
+		return providers
+	}
+	var result []*pairing.Provider
+	for _, p := range providers {
+		if f.ApplySingle(p, policy) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// ApplySingle checks if a single provider charges at most the policy's MaxFee
+func (f FeeFilter) ApplySingle(provider *pairing.Provider, policy *pairing.ConsumerPolicy) bool {
+	return policy.MaxFee == 0 || provider.Fee <= policy.MaxFee
+}
+
+// Applicable reports whether the policy sets a maximum fee
+func (f FeeFilter) Applicable(policy *pairing.ConsumerPolicy) bool {
+	return policy.MaxFee != 0
+}
+
+func (f FeeFilter) Name() string { return "FeeFilter" }
+
+/* ***********************************************************************
+ *                            STALENESS FILTER                           *
+ *********************************************************************** */
+
+// Apply filters providers based on the maximum data age in the policy
+// If the policy doesn't set MaxDataAgeSeconds, all providers are retained
+func (f StalenessFilter) Apply(providers []*pairing.Provider, policy *pairing.ConsumerPolicy) []*pairing.Provider {
+	if policy.MaxDataAgeSeconds == 0 {
+		return providers
+	}
+	var result []*pairing.Provider
+	for _, p := range providers {
+		if f.ApplySingle(p, policy) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// ApplySingle checks if a single provider's data was refreshed within the policy's MaxDataAgeSeconds
+func (f StalenessFilter) ApplySingle(provider *pairing.Provider, policy *pairing.ConsumerPolicy) bool {
+	if policy.MaxDataAgeSeconds == 0 {
+		return true
+	}
+	if provider.LastUpdated.IsZero() {
+		return false
+	}
+	now := time.Now
+	if f.Now != nil {
+		now = f.Now
+	}
+	return now().Sub(provider.LastUpdated) <= time.Duration(policy.MaxDataAgeSeconds)*time.Second
+}
+
+// Applicable reports whether the policy sets a maximum data age
+func (f StalenessFilter) Applicable(policy *pairing.ConsumerPolicy) bool {
+	return policy.MaxDataAgeSeconds != 0
+}
+
+func (f StalenessFilter) Name() string { return "StalenessFilter" }
+
+/* ***********************************************************************
+ *                          MAINTENANCE FILTER                           *
+ *********************************************************************** */
+
+// Apply filters out providers currently in a maintenance window
+func (f MaintenanceFilter) Apply(providers []*pairing.Provider, policy *pairing.ConsumerPolicy) []*pairing.Provider {
+	var result []*pairing.Provider
+	for _, p := range providers {
+		if f.ApplySingle(p, policy) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// ApplySingle checks if a single provider is out of any maintenance window
+func (f MaintenanceFilter) ApplySingle(provider *pairing.Provider, policy *pairing.ConsumerPolicy) bool {
+	now := time.Now
+	if f.Now != nil {
+		now = f.Now
+	}
+	_, inMaintenance := f.Schedule.Active(provider, now())
+	return !inMaintenance
+}
+
+// Applicable always returns true, maintenance windows don't depend on the policy
+func (f MaintenanceFilter) Applicable(policy *pairing.ConsumerPolicy) bool { return true }
+
+func (f MaintenanceFilter) Name() string { return "MaintenanceFilter" }
+
+/* ***********************************************************************
+ *                            LOCK-UP FILTER                             *
+ *********************************************************************** */
+
+// Apply filters providers based on the minimum stake lock-up in the policy
+// If the policy doesn't set MinLockUpSeconds, all providers are retained
+func (f LockUpFilter) Apply(providers []*pairing.Provider, policy *pairing.ConsumerPolicy) []*pairing.Provider {
+	if policy.MinLockUpSeconds == 0 {
+		return providers
+	}
+	var result []*pairing.Provider
+	for _, p := range providers {
+		if f.ApplySingle(p, policy) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// ApplySingle checks if a single provider's stake stays locked for at least the policy's MinLockUpSeconds
+func (f LockUpFilter) ApplySingle(provider *pairing.Provider, policy *pairing.ConsumerPolicy) bool {
+	if policy.MinLockUpSeconds == 0 {
+		return true
+	}
+	now := time.Now
+	if f.Now != nil {
+		now = f.Now
+	}
+	return provider.LockUp(now()) >= pairing.SecondsDuration(policy.MinLockUpSeconds)
+}
+
+// Applicable reports whether the policy sets a minimum lock-up
+func (f LockUpFilter) Applicable(policy *pairing.ConsumerPolicy) bool {
+	return policy.MinLockUpSeconds != 0
+}
+
+func (f LockUpFilter) Name() string { return "LockUpFilter" }
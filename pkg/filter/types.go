@@ -0,0 +1,83 @@
+package filter
+
+import (
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/jail"
+	"github.com/Yoaz/LavaPairingSystem/pkg/maintenance"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/policy"
+	"github.com/Yoaz/LavaPairingSystem/pkg/uptime"
+)
+
+// Filter is an interface for filtering providers based on a consumer policy
+type Filter interface {
+	Apply(providers []*pairing.Provider, policy *pairing.ConsumerPolicy) []*pairing.Provider
+	ApplySingle(provider *pairing.Provider, policy *pairing.ConsumerPolicy) bool
+	// Applicable reports whether the policy sets any of the filter's inputs, an empty or zero policy field
+	// meaning "no constraint"; the system skips inapplicable filters (see system.WithStrictConstraints)
+	Applicable(policy *pairing.ConsumerPolicy) bool
+	Name() string // for tracking filter name
+}
+
+// FallibleFilter is implemented by filters that can fail to decide, e.g. because they query an external
+// service; the system then calls Check instead of Apply/ApplySingle and surfaces the error
+type FallibleFilter interface {
+	Filter
+	Check(provider *pairing.Provider, policy *pairing.ConsumerPolicy) (bool, error)
+}
+
+// CompiledFilter is implemented by filters that can check providers against a compiled policy (see
+// policy.Compile), which the system compiles once per call instead of the filter redoing the policy's
+// preprocessing for every provider
+type CompiledFilter interface {
+	Filter
+	ApplyCompiled(provider *pairing.Provider, compiled *policy.CompiledPolicy) bool
+}
+
+// Filter implementations for different criteria
+type (
+	LocationFilter struct{} // Filters providers based on location
+	FeatureFilter  struct{} // Filters providers based on features
+	// Filters providers based on stake, DelegationFactor weighs delegated stake (0 means the default)
+	StakeFilter struct {
+		DelegationFactor float64
+	}
+	// Filters providers based on the API interfaces their endpoints serve
+	APIInterfaceFilter struct{}
+	// Filters providers based on security attributes (TLS, jail status)
+	SecurityFilter struct{}
+	// Filters providers charging more than the policy's MaxFee
+	FeeFilter struct{}
+)
+
+// JailFilter filters out providers currently jailed by the local jailing subsystem
+// NOTE: This is independent of Provider.Jailed, which reflects the protocol's own jail status
+type JailFilter struct {
+	Jailer *jail.Jailer
+}
+
+// StalenessFilter filters providers whose registration data is older than the policy's MaxDataAgeSeconds
+// Providers with an unknown LastUpdated can't prove they are fresh and are dropped as well
+type StalenessFilter struct {
+	Now func() time.Time // Clock the data age is measured against, defaults to time.Now
+}
+
+// MaintenanceFilter filters providers currently in a maintenance window, declared through the schedule or in
+// their metadata (see maintenance.Schedule)
+type MaintenanceFilter struct {
+	Schedule *maintenance.Schedule // Optional, windows declared in provider metadata are honoured regardless
+	Now      func() time.Time      // Clock windows are compared against, defaults to time.Now
+}
+
+// LockUpFilter filters providers whose stake stays locked for less than the policy's MinLockUpSeconds (see
+// pairing.Provider.LockUp)
+type LockUpFilter struct {
+	Now func() time.Time // Clock lock-ups are measured from, defaults to time.Now
+}
+
+// MinUptimeFilter filters providers whose tracked uptime is below the policy's MinUptime
+type MinUptimeFilter struct {
+	Tracker *uptime.Tracker
+	Window  time.Duration // Rolling window uptime is computed over, defaults to 24h
+}
@@ -0,0 +1,162 @@
+package geoip
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+/* ***********************************************************************
+ *                                INFERRER                               *
+ *********************************************************************** */
+
+// Apply fills RequiredLocation (and PreferredLocations, when empty) from the consumer's IP address
+// Policies that already specify a RequiredLocation are left untouched
+// It returns true if the policy was modified
+func (i *Inferrer) Apply(policy *pairing.ConsumerPolicy, ip netip.Addr) (bool, error) {
+	if policy.RequiredLocation != "" {
+		return false, nil
+	}
+	region, err := i.Resolver.Region(ip.Unmap())
+	if err != nil {
+		return false, fmt.Errorf("infer location for %s: %w", ip, err)
+	}
+	policy.RequiredLocation = region
+	if len(policy.PreferredLocations) == 0 {
+		policy.PreferredLocations = i.Nearby[region]
+	}
+	return true, nil
+}
+
+/* ***********************************************************************
+ *                                MAXMIND                                *
+ *********************************************************************** */
+
+// LoadMaxMindCSV loads a MaxMind CSV database from its blocks file (e.g. GeoLite2-City-Blocks-IPv4.csv)
+// and locations file (e.g. GeoLite2-City-Locations-en.csv)
+// Additional blocks files (e.g. the IPv6 one) can be added with AddBlocks
+func LoadMaxMindCSV(blocksPath, locationsPath string, regionMap map[string]string) (*MaxMindCSV, error) {
+	m := &MaxMindCSV{
+		RegionMap: regionMap,
+		locations: make(map[string]location),
+	}
+	if err := m.loadLocations(locationsPath); err != nil {
+		return nil, err
+	}
+	if err := m.AddBlocks(blocksPath); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AddBlocks loads an additional MaxMind blocks file into the resolver
+func (m *MaxMindCSV) AddBlocks(path string) error {
+	err := eachCSVRow(path, []string{"network", "geoname_id", "registered_country_geoname_id"}, func(col func(string) string) error {
+		prefix, err := netip.ParsePrefix(col("network"))
+		if err != nil {
+			return fmt.Errorf("bad network %q: %w", col("network"), err)
+		}
+		// Anonymous proxies and satellite providers have no geoname, fall back to the registered country
+		id := col("geoname_id")
+		if id == "" {
+			id = col("registered_country_geoname_id")
+		}
+		m.blocks = append(m.blocks, block{prefix: prefix.Masked(), geonameID: id})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(m.blocks, func(i, j int) bool {
+		return m.blocks[i].prefix.Addr().Less(m.blocks[j].prefix.Addr())
+	})
+	return nil
+}
+
+// Region resolves ip to a policy region
+// MaxMind networks never overlap, so the candidate is the last network starting at or before ip
+func (m *MaxMindCSV) Region(ip netip.Addr) (string, error) {
+	ip = ip.Unmap()
+	idx := sort.Search(len(m.blocks), func(i int) bool {
+		return ip.Less(m.blocks[i].prefix.Addr())
+	}) - 1
+	if idx < 0 || !m.blocks[idx].prefix.Contains(ip) {
+		return "", ErrNotFound
+	}
+
+	loc, ok := m.locations[m.blocks[idx].geonameID]
+	if !ok {
+		return "", ErrNotFound
+	}
+	for _, key := range []string{loc.country + "-" + loc.subdivision, loc.country, loc.continent} {
+		if region, ok := m.RegionMap[key]; ok {
+			return region, nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+// loadLocations loads the geoname_id -> location table
+func (m *MaxMindCSV) loadLocations(path string) error {
+	return eachCSVRow(path, []string{"geoname_id", "continent_code", "country_iso_code"}, func(col func(string) string) error {
+		m.locations[col("geoname_id")] = location{
+			continent:   col("continent_code"),
+			country:     col("country_iso_code"),
+			subdivision: col("subdivision_1_iso_code"), // Empty for Country databases
+		}
+		return nil
+	})
+}
+
+// eachCSVRow streams a headed CSV file, calling fn with a column accessor for every row
+// MaxMind City files have millions of rows, so rows are never materialized all at once
+// It fails if any of the required columns is missing from the header
+func eachCSVRow(path string, required []string, fn func(col func(name string) string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.ReuseRecord = true
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("%s: read header: %w", path, err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range required {
+		if _, ok := columns[name]; !ok {
+			return fmt.Errorf("%s: missing column %q", path, name)
+		}
+	}
+
+	var record []string
+	col := func(name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return record[i]
+	}
+	for line := 2; ; line++ {
+		record, err = r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if err := fn(col); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+}
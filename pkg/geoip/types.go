@@ -0,0 +1,43 @@
+package geoip
+
+import (
+	"errors"
+	"net/netip"
+)
+
+// ErrNotFound is returned by a Resolver when it has no region for the given address
+var ErrNotFound = errors.New("no region found for address")
+
+// Resolver maps a consumer IP address to the region name used in policies (e.g. "US-West")
+type Resolver interface {
+	Region(ip netip.Addr) (string, error)
+}
+
+// Inferrer fills in a policy's location requirements from the consumer's IP address
+type Inferrer struct {
+	Resolver Resolver
+	Nearby   map[string][]string // Region -> regions close enough to be preferred as fallbacks
+}
+
+// MaxMindCSV is a Resolver backed by MaxMind GeoLite2/GeoIP2 Country or City CSV databases
+// MaxMind locations are mapped to policy regions through RegionMap, looked up from the most to the
+// least specific key: "<country>-<subdivision>" (e.g. "US-CA"), "<country>" (e.g. "DE"), then
+// "<continent>" (e.g. "EU")
+type MaxMindCSV struct {
+	RegionMap map[string]string
+	blocks    []block // Sorted by network start address
+	locations map[string]location
+}
+
+// block is a single network row of a MaxMind blocks file
+type block struct {
+	prefix    netip.Prefix
+	geonameID string
+}
+
+// location is a single row of a MaxMind locations file
+type location struct {
+	continent   string
+	country     string
+	subdivision string // Only present in City databases
+}
@@ -0,0 +1,215 @@
+package jail
+
+import (
+	"fmt"
+	"maps"
+	"time"
+)
+
+// NewJailer creates a new Jailer with the given config
+func NewJailer(cfg Config) *Jailer {
+	return &Jailer{
+		cfg:      cfg,
+		failures: make(map[string]map[string]time.Time),
+		reports:  make(map[string][]time.Time),
+		jailed:   make(map[string]time.Time),
+		now:      time.Now,
+	}
+}
+
+// SetClock replaces the clock failure reports and jail terms are measured against, nil restores time.Now
+// Replays pin it to the time of the recorded call
+func (j *Jailer) SetClock(now func() time.Time) {
+	if now == nil {
+		now = time.Now
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.now = now
+}
+
+// Subscribe registers fn to be called for every jail event
+// Events are delivered synchronously, so fn should return quickly
+func (j *Jailer) Subscribe(fn func(Event)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.subscribers = append(j.subscribers, fn)
+}
+
+// ReportFailure records a reporter's failure report for a provider and jails it once MaxFailures distinct
+// reporters reported it within the window; a reporter repeating its report only refreshes it
+// It returns true if this report caused the provider to be jailed, and ErrRateLimited if the reporter already
+// sent MaxReportsPerReporter reports within the window
+func (j *Jailer) ReportFailure(providerID, reporter, reason string) (bool, error) {
+	j.mu.Lock()
+	now := j.now()
+	cutoff := now.Add(-j.cfg.Window)
+	if !j.swept.After(cutoff) {
+		j.sweep(now, cutoff)
+	}
+
+	sent := j.reports[reporter][:0]
+	for _, t := range j.reports[reporter] {
+		if t.After(cutoff) {
+			sent = append(sent, t)
+		}
+	}
+	if j.cfg.MaxReportsPerReporter > 0 && len(sent) >= j.cfg.MaxReportsPerReporter {
+		j.reports[reporter] = sent
+		j.mu.Unlock()
+		return false, fmt.Errorf("%w: %d within %s", ErrRateLimited, len(sent), j.cfg.Window)
+	}
+	j.reports[reporter] = append(sent, now)
+
+	// Reports against an already jailed provider don't extend its term
+	if until, ok := j.jailed[providerID]; ok && now.Before(until) {
+		j.mu.Unlock()
+		return false, nil
+	}
+
+	// Keep only the reporters still inside the sliding window
+	reporters := j.failures[providerID]
+	if reporters == nil {
+		reporters = make(map[string]time.Time)
+		j.failures[providerID] = reporters
+	}
+	for id, at := range reporters {
+		if !at.After(cutoff) {
+			delete(reporters, id)
+		}
+	}
+	reporters[reporter] = now
+
+	if len(reporters) < j.cfg.MaxFailures {
+		j.mu.Unlock()
+		return false, nil
+	}
+
+	until := now.Add(j.cfg.JailDuration)
+	j.jailed[providerID] = until
+	delete(j.failures, providerID) // Start from a clean slate once released
+	subscribers := j.subscribers
+	j.mu.Unlock()
+
+	emit(subscribers, Event{
+		Type:       EventJailed,
+		ProviderID: providerID,
+		At:         now,
+		Until:      until,
+		Reason:     fmt.Sprintf("%d reporters within %s, last: %s", len(reporters), j.cfg.Window, reason),
+	})
+	return true, nil
+}
+
+// IsJailed reports whether the provider is currently serving a jail term
+func (j *Jailer) IsJailed(providerID string) bool {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	until, ok := j.jailed[providerID]
+	return ok && j.now().Before(until)
+}
+
+// Unjail releases a provider before its jail term ends
+// It returns false if the provider wasn't jailed
+func (j *Jailer) Unjail(providerID, reason string) bool {
+	j.mu.Lock()
+	until, ok := j.jailed[providerID]
+	now := j.now()
+	if !ok || !now.Before(until) {
+		delete(j.jailed, providerID) // Drop an expired term, if any
+		j.mu.Unlock()
+		return false
+	}
+	delete(j.jailed, providerID)
+	subscribers := j.subscribers
+	j.mu.Unlock()
+
+	emit(subscribers, Event{Type: EventUnjailed, ProviderID: providerID, At: now, Reason: reason})
+	return true
+}
+
+// Jailed returns the currently jailed providers and their jail expiry
+func (j *Jailer) Jailed() map[string]time.Time {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	now := j.now()
+	result := make(map[string]time.Time, len(j.jailed))
+	for id, until := range j.jailed {
+		if now.Before(until) {
+			result[id] = until
+		}
+	}
+	return result
+}
+
+// Snapshot returns a copy of the jailer's failure reports and active jail terms
+func (j *Jailer) Snapshot() Snapshot {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	snapshot := Snapshot{
+		Failures: make(map[string]map[string]time.Time, len(j.failures)),
+		Jailed:   make(map[string]time.Time, len(j.jailed)),
+	}
+	for id, reporters := range j.failures {
+		snapshot.Failures[id] = maps.Clone(reporters)
+	}
+	now := j.now()
+	for id, until := range j.jailed {
+		if now.Before(until) {
+			snapshot.Jailed[id] = until
+		}
+	}
+	return snapshot
+}
+
+// Restore replaces the jailer's state with a snapshot, so providers stay jailed across restarts
+// Terms that expired in the meantime are dropped and no events are emitted
+func (j *Jailer) Restore(snapshot Snapshot) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.failures = make(map[string]map[string]time.Time, len(snapshot.Failures))
+	for id, reporters := range snapshot.Failures {
+		j.failures[id] = maps.Clone(reporters)
+	}
+	j.jailed = make(map[string]time.Time, len(snapshot.Jailed))
+	now := j.now()
+	for id, until := range snapshot.Jailed {
+		if now.Before(until) {
+			j.jailed[id] = until
+		}
+	}
+}
+
+// sweep drops the reporters and providers whose reports all expired, and the jail terms that ended, so reports
+// about many providers or from many reporters don't pile up; it runs at most once per window
+// NOTE: Must be called with j.mu held
+func (j *Jailer) sweep(now, cutoff time.Time) {
+	j.swept = now
+	for reporter, sent := range j.reports {
+		if len(sent) == 0 || !sent[len(sent)-1].After(cutoff) {
+			delete(j.reports, reporter)
+		}
+	}
+	for providerID, reporters := range j.failures {
+		for reporter, at := range reporters {
+			if !at.After(cutoff) {
+				delete(reporters, reporter)
+			}
+		}
+		if len(reporters) == 0 {
+			delete(j.failures, providerID)
+		}
+	}
+	for providerID, until := range j.jailed {
+		if !now.Before(until) {
+			delete(j.jailed, providerID)
+		}
+	}
+}
+
+// emit delivers an event to every subscriber
+func emit(subscribers []func(Event), event Event) {
+	for _, fn := range subscribers {
+		fn(event)
+	}
+}
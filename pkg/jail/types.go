@@ -0,0 +1,59 @@
+package jail
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned by ReportFailure when the reporter sent MaxReportsPerReporter reports within the
+// window already
+var ErrRateLimited = errors.New("too many failure reports")
+
+// Config controls when providers are jailed and for how long
+type Config struct {
+	// MaxFailures is the number of distinct reporters whose failure reports within Window trigger jailing, so a
+	// single caller can't get a provider jailed by repeating its report
+	MaxFailures  int
+	Window       time.Duration // Sliding window failure reports are counted over
+	JailDuration time.Duration // How long a jailed provider stays excluded
+	// MaxReportsPerReporter, when set, is the number of failure reports a reporter may send within Window,
+	// across providers
+	MaxReportsPerReporter int
+}
+
+// EventType identifies what happened to a provider's jail status
+type EventType string
+
+const (
+	EventJailed   EventType = "jailed"
+	EventUnjailed EventType = "unjailed"
+)
+
+// Event is emitted to subscribers whenever a provider is jailed or unjailed
+type Event struct {
+	Type       EventType
+	ProviderID string
+	At         time.Time
+	Until      time.Time // Jail expiry, only set for EventJailed
+	Reason     string
+}
+
+// Jailer tracks provider failure reports and jails providers that fail too often
+// Jail terms expire on their own; Unjail ends one early. It is safe for concurrent use
+type Jailer struct {
+	mu          sync.RWMutex
+	cfg         Config
+	failures    map[string]map[string]time.Time // Provider ID -> reporter -> time of its latest report
+	reports     map[string][]time.Time          // Reporter -> times of its reports within the window
+	jailed      map[string]time.Time            // Provider ID -> jail expiry
+	swept       time.Time                       // Last time expired reports and terms were dropped, see sweep
+	subscribers []func(Event)
+	now         func() time.Time
+}
+
+// Snapshot is the persistable state of a Jailer, see Jailer.Snapshot
+type Snapshot struct {
+	Failures map[string]map[string]time.Time `json:"reporters,omitempty"` // Provider ID -> reporter -> time of its latest report
+	Jailed   map[string]time.Time            `json:"jailed,omitempty"`    // Provider ID -> jail expiry
+}
@@ -0,0 +1,75 @@
+package latency
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// NewMatrix creates a Matrix from a nested region -> region -> milliseconds map
+func NewMatrix(latencies map[string]map[string]float64) (*Matrix, error) {
+	m := &Matrix{latencies: make(map[string]map[string]float64)}
+	for from, row := range latencies {
+		for to, ms := range row {
+			if ms < 0 {
+				return nil, fmt.Errorf("negative latency %v between %q and %q", ms, from, to)
+			}
+			m.set(from, to, ms)
+			// Fill the reverse direction unless it is explicitly defined
+			if _, ok := latencies[to][from]; !ok {
+				m.set(to, from, ms)
+			}
+		}
+	}
+	return m, nil
+}
+
+// LoadMatrix loads a Matrix from a JSON file of the form {"US-West": {"US-East": 65, "EU-Central": 145}}
+func LoadMatrix(path string) (*Matrix, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := ParseMatrix(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// ParseMatrix parses a Matrix from its JSON representation (see LoadMatrix)
+func ParseMatrix(raw []byte) (*Matrix, error) {
+	var latencies map[string]map[string]float64
+	if err := json.Unmarshal(raw, &latencies); err != nil {
+		return nil, fmt.Errorf("parse latency matrix: %w", err)
+	}
+	return NewMatrix(latencies)
+}
+
+// Latency returns the latency in milliseconds between two regions
+// A region's latency to itself is 0 unless the matrix says otherwise
+func (m *Matrix) Latency(from, to string) (float64, bool) {
+	if ms, ok := m.latencies[from][to]; ok {
+		return ms, true
+	}
+	if from == to {
+		return 0, true
+	}
+	return 0, false
+}
+
+// Max returns the largest latency in the matrix
+func (m *Matrix) Max() float64 {
+	return m.max
+}
+
+// set stores a single directed latency
+func (m *Matrix) set(from, to string, ms float64) {
+	if m.latencies[from] == nil {
+		m.latencies[from] = make(map[string]float64)
+	}
+	m.latencies[from][to] = ms
+	if ms > m.max {
+		m.max = ms
+	}
+}
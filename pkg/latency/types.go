@@ -0,0 +1,8 @@
+package latency
+
+// Matrix holds measured round-trip latencies (in milliseconds) between regions
+// Lookups are symmetric: a latency defined only as A -> B is also used for B -> A
+type Matrix struct {
+	latencies map[string]map[string]float64
+	max       float64 // Largest latency in the matrix, used as the default normalization bound
+}
@@ -0,0 +1,143 @@
+package lava
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/address"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// LoadExport loads a stake entry export file (see ParseExport), dated with the file's modification time
+func LoadExport(path string) (Export, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	export, err := ParseExport(raw, info.ModTime())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return export, nil
+}
+
+// ParseExport maps Lava stake entries to providers, by chain
+// It accepts the output of `lavad query pairing providers <chain> --output json` ({"stakeEntry": [...]}) as
+// well as a bare array of stake entries, possibly spanning several chains
+// exportedAt is used as the providers' LastUpdated, and to tell whether jailed providers are still jailed
+func ParseExport(raw []byte, exportedAt time.Time) (Export, error) {
+	var entries []stakeEntry
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &entries); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidExport, err)
+		}
+	} else {
+		var doc stakeEntries
+		if err := json.Unmarshal(trimmed, &doc); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidExport, err)
+		}
+		entries = doc.StakeEntry
+	}
+
+	export := make(Export)
+	for i, entry := range entries {
+		if entry.Address == "" {
+			return nil, fmt.Errorf("%w: stake entry %d has no address", ErrInvalidExport, i)
+		}
+		export[entry.Chain] = append(export[entry.Chain], entry.provider(entry.Chain, exportedAt))
+	}
+	return export, nil
+}
+
+// Providers returns the providers staked on the given chain, none if the chain isn't in the export
+func (e Export) Providers(chainID string) ([]*pairing.Provider, error) {
+	return e[chainID], nil
+}
+
+// Chains returns the chain IDs in the export, sorted
+func (e Export) Chains() []string {
+	chains := make([]string, 0, len(e))
+	for chain := range e {
+		chains = append(chains, chain)
+	}
+	sort.Strings(chains)
+	return chains
+}
+
+// provider maps a stake entry to a Provider
+// Its address is normalized when it is a Lava address (see address.Parser), and its ID derived from it and the chain
+// as by the registry (see pairing.DeriveProviderID). It keeps its geolocation bitmask and is located in the first
+// region of it (see pairing.Geolocation); each endpoint is listed once per API interface and region it serves
+// Only delegations up to the provider's delegation limit count towards its stake, as on chain
+func (entry stakeEntry) provider(chainID string, exportedAt time.Time) *pairing.Provider {
+	delegated := int64(entry.DelegateTotal.Amount)
+	if limit := int64(entry.DelegateLimit.Amount); limit < delegated {
+		delegated = limit
+	}
+	addr := address.Parser{Prefix: address.LavaPrefix}.Normalize(entry.Address)
+	p := &pairing.Provider{
+		ID:             pairing.DeriveProviderID(addr, chainID),
+		Address:        address.Address(addr),
+		Stake:          int64(entry.Stake.Amount) + delegated,
+		SelfStake:      int64(entry.Stake.Amount),
+		DelegatedStake: delegated,
+		Commission:     float64(entry.DelegateCommission),
+		TLSEnabled:     true, // Lava consumers reach providers over TLS
+		Geolocation:    pairing.Geolocation(entry.Geolocation),
+		Jailed:         int64(entry.JailEndTime) > exportedAt.Unix(),
+		LastUpdated:    exportedAt,
+	}
+	if locations := p.Geolocation.Regions(); len(locations) > 0 {
+		p.Location = locations[0]
+	}
+
+	features := make(map[string]bool)
+	for _, e := range entry.Endpoints {
+		for _, feature := range append(append([]string{}, e.Addons...), e.Extensions...) {
+			if feature != "" && !features[feature] {
+				features[feature] = true
+				p.Features = append(p.Features, feature)
+			}
+		}
+		for _, apiInterface := range e.APIInterfaces {
+			for _, region := range pairing.Geolocation(e.Geolocation).Regions() {
+				p.Endpoints = append(p.Endpoints, pairing.Endpoint{
+					URL:          "https://" + e.IPPort,
+					APIInterface: strings.ToLower(apiInterface),
+					Geolocation:  region,
+				})
+			}
+		}
+	}
+	p.InternFeatures() // Exports are loaded once and paired many times
+	return p
+}
+
+// UnmarshalJSON decodes an integer given either as a JSON number or as a string (lavad's encoding of 64-bit
+// integers), an empty string being 0
+func (n *number) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	if s == "" || s == "null" {
+		*n = 0
+		return nil
+	}
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid integer %s: %w", data, err)
+	}
+	*n = number(value)
+	return nil
+}
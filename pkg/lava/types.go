@@ -0,0 +1,56 @@
+package lava
+
+import (
+	"errors"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// ErrInvalidExport is wrapped by the errors returned for malformed stake entry exports
+var ErrInvalidExport = errors.New("invalid lava stake entry export")
+
+// Export is a Lava stake entry export mapped to providers, by chain ID
+// It implements the server and scheduler ProviderSource interfaces
+type Export map[string][]*pairing.Provider
+
+// stakeEntries is the export document, as printed by `lavad query pairing providers <chain> --output json`
+type stakeEntries struct {
+	StakeEntry []stakeEntry `json:"stakeEntry"`
+}
+
+// stakeEntry is a provider's on-chain stake entry for one chain
+// Integer fields are strings in lavad's JSON output, see number
+type stakeEntry struct {
+	Address            string      `json:"address"`
+	Chain              string      `json:"chain"`
+	Moniker            string      `json:"moniker"`
+	Stake              coin        `json:"stake"`
+	DelegateTotal      coin        `json:"delegate_total"`
+	DelegateLimit      coin        `json:"delegate_limit"`
+	DelegateCommission number      `json:"delegate_commission"`
+	Geolocation        number      `json:"geolocation"`
+	Endpoints          []endpoint  `json:"endpoints"`
+	Jails              number      `json:"jails"`
+	JailEndTime        number      `json:"jail_end_time"` // Unix seconds
+	Description        description `json:"description"`
+}
+
+type endpoint struct {
+	IPPort        string   `json:"iPPORT"`
+	Geolocation   number   `json:"geolocation"`
+	Addons        []string `json:"addons"`
+	APIInterfaces []string `json:"api_interfaces"`
+	Extensions    []string `json:"extensions"`
+}
+
+type coin struct {
+	Denom  string `json:"denom"`
+	Amount number `json:"amount"`
+}
+
+type description struct {
+	Moniker string `json:"moniker"`
+}
+
+// number is an integer encoded either as a JSON number or as a string
+type number int64
@@ -0,0 +1,151 @@
+package maintenance
+
+import (
+	"math"
+	"slices"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// NewSchedule creates an empty Schedule
+func NewSchedule() *Schedule {
+	return &Schedule{
+		windows: make(map[string][]Window),
+		now:     time.Now,
+	}
+}
+
+// Contains reports whether the given time falls within the window
+func (w Window) Contains(at time.Time) bool {
+	return !at.Before(w.Start) && at.Before(w.End)
+}
+
+// Declare records a maintenance window of the provider
+// Windows that are over are dropped as new ones are declared, along with providers left without any
+func (s *Schedule) Declare(providerID string, w Window) error {
+	now := s.now()
+	if !w.End.After(w.Start) || !w.End.After(now) || w.End.Sub(w.Start) > MaxWindowLength {
+		return ErrInvalidWindow
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.windows[providerID]; !ok {
+		for id, windows := range s.windows {
+			if !slices.ContainsFunc(windows, func(w Window) bool { return w.End.After(now) }) {
+				delete(s.windows, id)
+			}
+		}
+	}
+	windows := slices.DeleteFunc(s.windows[providerID], func(existing Window) bool { return !existing.End.After(now) })
+	if len(windows) >= MaxWindows {
+		s.windows[providerID] = windows
+		return ErrTooManyWindows
+	}
+	idx, _ := slices.BinarySearchFunc(windows, w, func(a, b Window) int { return a.Start.Compare(b.Start) })
+	s.windows[providerID] = slices.Insert(windows, idx, w)
+	return nil
+}
+
+// Snapshot returns a copy of the windows declared through the schedule
+func (s *Schedule) Snapshot() Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snapshot := Snapshot{Windows: make(map[string][]Window, len(s.windows))}
+	for id, windows := range s.windows {
+		snapshot.Windows[id] = slices.Clone(windows)
+	}
+	return snapshot
+}
+
+// Restore replaces the schedule's windows with a snapshot
+func (s *Schedule) Restore(snapshot Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.windows = make(map[string][]Window, len(snapshot.Windows))
+	for id, windows := range snapshot.Windows {
+		if len(windows) > 0 {
+			s.windows[id] = slices.Clone(windows)
+		}
+	}
+}
+
+// Cancel removes every window the provider declared through the schedule, reporting whether there were any
+func (s *Schedule) Cancel(providerID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.windows[providerID]
+	delete(s.windows, providerID)
+	return ok
+}
+
+// Windows returns the windows the provider declared through the schedule that aren't over, by start time
+func (s *Schedule) Windows(providerID string) []Window {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.now()
+	var windows []Window
+	for _, w := range s.windows[providerID] {
+		if w.End.After(now) {
+			windows = append(windows, w)
+		}
+	}
+	return windows
+}
+
+// Active returns the maintenance window of the provider containing the given time, if any, either declared
+// through the schedule or in the provider's metadata
+func (s *Schedule) Active(p *pairing.Provider, at time.Time) (Window, bool) {
+	for _, w := range s.windowsOf(p) {
+		if w.Contains(at) {
+			return w, true
+		}
+	}
+	return Window{}, false
+}
+
+// Next returns the earliest maintenance window of the provider starting after the given time, if any
+func (s *Schedule) Next(p *pairing.Provider, at time.Time) (Window, bool) {
+	var next Window
+	found := false
+	for _, w := range s.windowsOf(p) {
+		if w.Start.After(at) && (!found || w.Start.Before(next.Start)) {
+			next, found = w, true
+		}
+	}
+	return next, found
+}
+
+// windowsOf returns the provider's windows declared in its metadata followed by those declared through the
+// schedule (which may be nil)
+func (s *Schedule) windowsOf(p *pairing.Provider) []Window {
+	var windows []Window
+	if w, ok := metadataWindow(p); ok {
+		windows = append(windows, w)
+	}
+	if s == nil {
+		return windows
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append(windows, s.windows[p.ID]...)
+}
+
+// metadataWindow returns the window declared in the provider's metadata, if it holds a valid one
+func metadataWindow(p *pairing.Provider) (Window, bool) {
+	start, okStart := p.Metadata[MetadataStart]
+	end, okEnd := p.Metadata[MetadataEnd]
+	if !okStart || !okEnd || end <= start || math.IsNaN(start) || math.IsNaN(end) {
+		return Window{}, false
+	}
+	return Window{Start: unixTime(start), End: unixTime(end)}, true
+}
+
+// unixTime converts Unix seconds, possibly fractional, to a time
+func unixTime(seconds float64) time.Time {
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*float64(time.Second)))
+}
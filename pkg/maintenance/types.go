@@ -0,0 +1,48 @@
+package maintenance
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Provider metadata keys declaring a maintenance window, in Unix seconds (see pairing.Provider.Metadata)
+const (
+	MetadataStart = "maintenance_start"
+	MetadataEnd   = "maintenance_end"
+)
+
+// Bounds on the windows declared through a Schedule, so a provider can't be taken out of pairing indefinitely
+// and the schedule can't grow without bound
+const (
+	MaxWindowLength = 7 * 24 * time.Hour // Longest window Declare accepts
+	MaxWindows      = 16                 // Windows not over yet a provider may have declared
+)
+
+var (
+	// ErrInvalidWindow is returned by Schedule.Declare for windows not ending after they start, already over, or
+	// longer than MaxWindowLength
+	ErrInvalidWindow = errors.New("maintenance window must end after it starts, in the future, and last at most 7 days")
+	// ErrTooManyWindows is returned by Schedule.Declare when the provider already declared MaxWindows windows
+	ErrTooManyWindows = errors.New("too many maintenance windows declared")
+)
+
+// Window is a period during which a provider is down for maintenance
+type Window struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"` // Exclusive
+}
+
+// Snapshot is the persistable state of a Schedule, see Schedule.Snapshot
+type Snapshot struct {
+	Windows map[string][]Window `json:"windows,omitempty"` // Provider ID -> windows not over yet, by start time
+}
+
+// Schedule holds the maintenance windows providers declared through the registration API
+// Windows declared in provider metadata are read from the providers themselves, so a nil Schedule still honours
+// them. It is safe for concurrent use
+type Schedule struct {
+	mu      sync.RWMutex
+	windows map[string][]Window // Provider ID -> windows not over yet, by start time
+	now     func() time.Time
+}
@@ -0,0 +1,294 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// NewCounterVec registers a counter with the given label names
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{desc: desc{metricName: name, help: help, labelNames: labelNames}, series: make(map[string]*counterSeries)}
+	r.register(c)
+	return c
+}
+
+// NewCounterFunc registers a counter read from fn at every scrape, fn's keys being values of labelName
+// Without labelName, the counter is unlabelled and fn returns its value under the "" key
+func (r *Registry) NewCounterFunc(name, help, labelName string, fn func() map[string]float64) *CounterFunc {
+	c := &CounterFunc{desc: desc{metricName: name, help: help}, fn: fn}
+	if labelName != "" {
+		c.labelNames = []string{labelName}
+	}
+	r.register(c)
+	return c
+}
+
+// NewHistogramVec registers a histogram with the given bucket upper bounds (DefaultBuckets if nil) and label names
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{desc: desc{metricName: name, help: help, labelNames: labelNames}, buckets: buckets, series: make(map[string]*histogramSeries)}
+	r.register(h)
+	return h
+}
+
+// register adds a metric family, panicking on duplicate names as that is a programming error
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[m.name()] {
+		panic(fmt.Sprintf("metrics: duplicate metric %q", m.name()))
+	}
+	r.names[m.name()] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// ServeHTTP serves the registered metrics, in OpenMetrics (with exemplars) if the scraper accepts it and in
+// the Prometheus text format otherwise
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	openMetrics := strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text")
+	contentType := ContentTypeText
+	if openMetrics {
+		contentType = ContentTypeOpenMetrics
+	}
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write([]byte(r.Expose(openMetrics)))
+}
+
+// Expose returns the registered metrics in the Prometheus text format, or OpenMetrics if openMetrics is set
+func (r *Registry) Expose(openMetrics bool) string {
+	r.mu.Lock()
+	metrics := append([]metric{}, r.metrics...)
+	r.mu.Unlock()
+
+	b := &exposition{openMetrics: openMetrics}
+	for _, m := range metrics {
+		m.write(b)
+	}
+	if openMetrics {
+		b.WriteString("# EOF\n")
+	}
+	return b.String()
+}
+
+/* ***********************************************************************
+ *                                COUNTERS                               *
+ *********************************************************************** */
+
+// Add adds v (which must not be negative) to the counter of the given label values
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	c.checkLabels(labelValues)
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{labelValues: append([]string{}, labelValues...)}
+		c.series[key] = s
+	}
+	s.value += v
+}
+
+// Inc increments the counter of the given label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) write(b *exposition) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b.header(c.desc, "counter")
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		b.sample(b.counterName(c.metricName), c.labelNames, s.labelValues, s.value)
+	}
+}
+
+func (c *CounterFunc) write(b *exposition) {
+	values := c.fn()
+	b.header(c.desc, "counter")
+	for _, key := range sortedKeys(values) {
+		if len(c.labelNames) == 0 && key != "" {
+			continue
+		}
+		b.sample(b.counterName(c.metricName), c.labelNames, []string{key}, values[key])
+	}
+}
+
+/* ***********************************************************************
+ *                               HISTOGRAMS                              *
+ *********************************************************************** */
+
+// Observe records a value for the given label values
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.ObserveWithExemplar(v, "", labelValues...)
+}
+
+// ObserveWithExemplar records a value for the given label values, made within the given trace
+// The observation becomes its bucket's exemplar, unless traceID is empty
+func (h *HistogramVec) ObserveWithExemplar(v float64, traceID string, labelValues ...string) {
+	h.checkLabels(labelValues)
+	key := strings.Join(labelValues, "\xff")
+	bucket := sort.SearchFloat64s(h.buckets, v) // First bucket whose upper bound is >= v, len(buckets) for +Inf
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			labelValues: append([]string{}, labelValues...),
+			counts:      make([]uint64, len(h.buckets)+1),
+			exemplars:   make([]*Exemplar, len(h.buckets)+1),
+		}
+		h.series[key] = s
+	}
+	s.counts[bucket]++
+	s.count++
+	s.sum += v
+	if traceID != "" {
+		s.exemplars[bucket] = &Exemplar{TraceID: traceID, Value: v, Timestamp: time.Now()}
+	}
+}
+
+func (h *HistogramVec) write(b *exposition) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	b.header(h.desc, "histogram")
+	labelNames := append(append([]string{}, h.labelNames...), "le")
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, count := range s.counts {
+			cumulative += count
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = formatFloat(h.buckets[i])
+			}
+			labelValues := append(append([]string{}, s.labelValues...), le)
+			b.sampleWithExemplar(h.metricName+"_bucket", labelNames, labelValues, float64(cumulative), s.exemplars[i])
+		}
+		b.sample(h.metricName+"_sum", h.labelNames, s.labelValues, s.sum)
+		b.sample(h.metricName+"_count", h.labelNames, s.labelValues, float64(s.count))
+	}
+}
+
+/* ***********************************************************************
+ *                                 TRACING                               *
+ *********************************************************************** */
+
+// TraceIDFromTraceparent returns the trace ID of a W3C Trace Context traceparent header,
+// e.g. "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+func TraceIDFromTraceparent(header string) (string, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", false
+	}
+	traceID := strings.ToLower(parts[1])
+	if strings.Trim(traceID, "0123456789abcdef") != "" || traceID == strings.Repeat("0", 32) {
+		return "", false
+	}
+	return traceID, true
+}
+
+/* ***********************************************************************
+ *                               EXPOSITION                              *
+ *********************************************************************** */
+
+// exposition builds the exposition of a scrape
+type exposition struct {
+	strings.Builder
+	openMetrics bool
+}
+
+func (b *exposition) header(d desc, metricType string) {
+	name := d.metricName
+	if b.openMetrics && metricType == "counter" {
+		name = strings.TrimSuffix(name, "_total") // OpenMetrics names the counter family without the suffix
+	}
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(d.help), name, metricType)
+}
+
+// counterName returns the sample name of a counter, OpenMetrics requiring the _total suffix
+func (b *exposition) counterName(name string) string {
+	if b.openMetrics && !strings.HasSuffix(name, "_total") {
+		return name + "_total"
+	}
+	return name
+}
+
+func (b *exposition) sample(name string, labelNames, labelValues []string, value float64) {
+	b.sampleWithExemplar(name, labelNames, labelValues, value, nil)
+}
+
+func (b *exposition) sampleWithExemplar(name string, labelNames, labelValues []string, value float64, e *Exemplar) {
+	b.WriteString(name)
+	if len(labelNames) > 0 {
+		b.WriteByte('{')
+		for i, labelName := range labelNames {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(b, `%s="%s"`, labelName, escapeLabelValue(labelValues[i]))
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(value))
+	if e != nil && b.openMetrics {
+		fmt.Fprintf(b, ` # {trace_id="%s"} %s %s`, escapeLabelValue(e.TraceID), formatFloat(e.Value), strconv.FormatFloat(float64(e.Timestamp.UnixMilli())/1000, 'f', 3, 64))
+	}
+	b.WriteByte('\n')
+}
+
+// checkLabels panics when label values don't match the metric's label names, as that is a programming error
+func (d desc) checkLabels(labelValues []string) {
+	if len(labelValues) != len(d.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.metricName, len(d.labelNames), len(labelValues)))
+	}
+}
+
+func (d desc) name() string { return d.metricName }
+
+// escapeHelp escapes a HELP text as required by the exposition formats
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+// escapeLabelValue escapes a label value as required by the exposition formats
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// formatFloat formats a sample value, with the exposition formats' spelling of infinities
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sortedKeys returns the keys of m in sorted order, for a deterministic exposition
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// Naming scheme: every metric is prefixed with Namespace, followed by the subsystem and what is measured,
+// in base units (seconds, not milliseconds), with counters suffixed _total
+// The dashboards bundled under deploy/grafana query these names
+const (
+	Namespace = "lps"
+
+	// HTTPRequests counts pairing API requests, by route and status code
+	HTTPRequests = Namespace + "_http_requests_total"
+	// HTTPRequestDuration is the latency of pairing API requests, by route, with trace exemplars
+	HTTPRequestDuration = Namespace + "_http_request_duration_seconds"
+	// PairingStageDuration is the time spent in each pairing pipeline stage (filter, rank, sort, total)
+	PairingStageDuration = Namespace + "_pairing_stage_duration_seconds"
+	// PairingPartial counts pairings that ran out of time and returned a partial selection
+	PairingPartial = Namespace + "_pairing_partial_total"
+	// FilterEvaluated counts providers that went through the filters
+	FilterEvaluated = Namespace + "_filter_evaluated_providers_total"
+	// FilterRejected counts providers rejected, by filter
+	FilterRejected = Namespace + "_filter_rejected_providers_total"
+)
+
+// Content types of the supported exposition formats; exemplars are only exposed in OpenMetrics
+const (
+	ContentTypeText        = "text/plain; version=0.0.4; charset=utf-8"
+	ContentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// DefaultBuckets are latency histogram bucket upper bounds in seconds, from 1ms to 10s
+var DefaultBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry holds metrics and serves them in the Prometheus text or OpenMetrics exposition format
+// It implements http.Handler, typically mounted on GET /metrics
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+// metric is a registered metric family
+type metric interface {
+	name() string
+	write(b *exposition)
+}
+
+// Exemplar links an observation to the trace of the request it was made in
+type Exemplar struct {
+	TraceID   string
+	Value     float64
+	Timestamp time.Time
+}
+
+// CounterVec is a counter partitioned by label values
+type CounterVec struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labelValues []string
+	value       float64
+}
+
+// CounterFunc is a counter read from fn at every scrape, by value of a single label, for counts already kept
+// elsewhere (e.g. system.FilterStats)
+type CounterFunc struct {
+	desc
+	fn func() map[string]float64
+}
+
+// HistogramVec is a histogram partitioned by label values
+// Each bucket keeps the exemplar of its latest traced observation, so a slow bucket links to a slow trace
+type HistogramVec struct {
+	desc
+	buckets []float64 // Sorted upper bounds, +Inf excluded
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64    // Per bucket, non-cumulative, the last one is +Inf
+	exemplars   []*Exemplar // Per bucket, nil until a traced observation falls in it
+	count       uint64
+	sum         float64
+}
+
+// desc describes a metric family
+type desc struct {
+	metricName string
+	help       string
+	labelNames []string
+}
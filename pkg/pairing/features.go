@@ -0,0 +1,142 @@
+package pairing
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// FeatureSet is a set of features as a bitset, one bit per feature interned by InternFeature
+// Bits are only meaningful within a process, feature sets must not be persisted or sent to other processes
+type FeatureSet []uint64
+
+// features interns feature names to bit positions, shared by every feature set of the process
+var features = struct {
+	mu    sync.RWMutex
+	index map[string]int
+}{index: make(map[string]int)}
+
+// InternFeature returns the bit position of a feature, assigning the next one to a feature seen for the
+// first time
+// Interned names are kept for the life of the process
+func InternFeature(feature string) int {
+	features.mu.RLock()
+	i, ok := features.index[feature]
+	features.mu.RUnlock()
+	if ok {
+		return i
+	}
+	features.mu.Lock()
+	defer features.mu.Unlock()
+	if i, ok = features.index[feature]; !ok {
+		i = len(features.index)
+		features.index[feature] = i
+	}
+	return i
+}
+
+// LookupFeature returns the bit position of a feature that is already interned, or false if no provider
+// or caller interned it yet
+// Use it over InternFeature for names coming from requests, which must not grow the process-wide index
+func LookupFeature(feature string) (int, bool) {
+	features.mu.RLock()
+	defer features.mu.RUnlock()
+	i, ok := features.index[feature]
+	return i, ok
+}
+
+// FeatureSetOf returns the set of the given features, interning them
+func FeatureSetOf(names ...string) FeatureSet {
+	var s FeatureSet
+	for _, name := range names {
+		s = s.With(InternFeature(name))
+	}
+	return s
+}
+
+// knownFeatureSet returns the set of the given features that are already interned, ignoring the others
+// Nothing compares against features that were never interned, so they can't change a containment check
+func knownFeatureSet(names []string) FeatureSet {
+	var s FeatureSet
+	features.mu.RLock()
+	defer features.mu.RUnlock()
+	for _, name := range names {
+		if i, ok := features.index[name]; ok {
+			s = s.With(i)
+		}
+	}
+	return s
+}
+
+// With returns the set with the feature at bit i added, reusing s's storage when it is large enough
+func (s FeatureSet) With(i int) FeatureSet {
+	for len(s) <= i/64 {
+		s = append(s, 0)
+	}
+	s[i/64] |= 1 << (i % 64)
+	return s
+}
+
+// Has reports whether the feature at bit i is in the set
+func (s FeatureSet) Has(i int) bool {
+	return i/64 < len(s) && s[i/64]&(1<<(i%64)) != 0
+}
+
+// Contains reports whether every feature of other is in the set
+func (s FeatureSet) Contains(other FeatureSet) bool {
+	for i, w := range other {
+		if i >= len(s) {
+			if w != 0 {
+				return false
+			}
+			continue
+		}
+		if w&^s[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Union returns the set of the features in either set, reusing s's storage when it is large enough
+func (s FeatureSet) Union(other FeatureSet) FeatureSet {
+	for len(s) < len(other) {
+		s = append(s, 0)
+	}
+	for i, w := range other {
+		s[i] |= w
+	}
+	return s
+}
+
+// CountCommon returns the number of features in both sets
+func (s FeatureSet) CountCommon(other FeatureSet) int {
+	n := 0
+	for i := 0; i < len(s) && i < len(other); i++ {
+		n += bits.OnesCount64(s[i] & other[i])
+	}
+	return n
+}
+
+// Len returns the number of features in the set
+func (s FeatureSet) Len() int {
+	n := 0
+	for _, w := range s {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// FeatureMask returns the provider's features as a set: its FeatureSet, or the set of its Features that are
+// already interned when it has none
+func (p *Provider) FeatureMask() FeatureSet {
+	if p.FeatureSet != nil {
+		return p.FeatureSet
+	}
+	return knownFeatureSet(p.Features)
+}
+
+// InternFeatures sets the provider's FeatureSet from its Features, for providers loaded once and paired many
+// times; it must be called again whenever Features changes
+func (p *Provider) InternFeatures() {
+	p.FeatureSet = FeatureSetOf(p.Features...)
+}
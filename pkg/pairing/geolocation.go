@@ -0,0 +1,123 @@
+package pairing
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Geolocation is a set of regions encoded as Lava's geolocation bitmask, one bit per region
+type Geolocation uint64
+
+// Geolocation bits, matching Lava's x/plans Geolocation enum
+const (
+	GeolocationUSC Geolocation = 1 << iota // US-Central
+	GeolocationEU                          // Europe
+	GeolocationUSE                         // US-East
+	GeolocationUSW                         // US-West
+	GeolocationAF                          // Africa
+	GeolocationAS                          // Asia
+	GeolocationAU                          // Australia
+	// GeolocationGlobal is every region, including ones not defined yet
+	GeolocationGlobal Geolocation = 0xFFFF
+)
+
+// geolocationRegions names each geolocation bit, in bit order: the region name used in Location strings and
+// Lava's short code
+var geolocationRegions = []struct {
+	bit          Geolocation
+	region, code string
+}{
+	{GeolocationUSC, "US-Central", "USC"},
+	{GeolocationEU, "EU-Central", "EU"},
+	{GeolocationUSE, "US-East", "USE"},
+	{GeolocationUSW, "US-West", "USW"},
+	{GeolocationAF, "Africa", "AF"},
+	{GeolocationAS, "Asia", "AS"},
+	{GeolocationAU, "Australia", "AU"},
+}
+
+// GeolocationOf returns the geolocation bit of a region name or Lava short code (case-insensitive), 0 if
+// the location isn't a known region
+func GeolocationOf(location string) Geolocation {
+	if strings.EqualFold(location, "GL") {
+		return GeolocationGlobal
+	}
+	for _, r := range geolocationRegions {
+		if strings.EqualFold(location, r.region) || strings.EqualFold(location, r.code) {
+			return r.bit
+		}
+	}
+	return 0
+}
+
+// ParseGeolocation parses a comma or pipe separated list of region names or Lava short codes, e.g.
+// "US-East,EU-Central" or "USE|EU"
+func ParseGeolocation(s string) (Geolocation, error) {
+	var g Geolocation
+	for _, location := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '|' }) {
+		bit := GeolocationOf(strings.TrimSpace(location))
+		if bit == 0 {
+			return 0, fmt.Errorf("unknown geolocation %q", location)
+		}
+		g |= bit
+	}
+	return g, nil
+}
+
+// Regions returns the names of the regions in the geolocation, in bit order
+// Bits without a known region are ignored
+func (g Geolocation) Regions() []string {
+	var regions []string
+	for _, r := range geolocationRegions {
+		if g&r.bit != 0 {
+			regions = append(regions, r.region)
+		}
+	}
+	return regions
+}
+
+// Count returns the number of known regions in the geolocation
+func (g Geolocation) Count() int {
+	count := 0
+	for _, r := range geolocationRegions {
+		if g&r.bit != 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// Intersects reports whether the two geolocations share at least one region
+func (g Geolocation) Intersects(other Geolocation) bool { return g&other != 0 }
+
+// String returns the Lava short codes of the geolocation's regions, e.g. "USE|EU", or "GL" for every region
+func (g Geolocation) String() string {
+	if g&GeolocationGlobal == GeolocationGlobal {
+		return "GL"
+	}
+	var codes []string
+	for _, r := range geolocationRegions {
+		if g&r.bit != 0 {
+			codes = append(codes, r.code)
+		}
+	}
+	return strings.Join(codes, "|")
+}
+
+// GeolocationMask returns the regions the provider serves: its Geolocation, or the region named by its
+// Location when it has none (0 if that isn't a known region)
+func (p *Provider) GeolocationMask() Geolocation {
+	if p.Geolocation != 0 {
+		return p.Geolocation
+	}
+	return GeolocationOf(p.Location)
+}
+
+// GeolocationMask returns the regions the policy requires: its Geolocation, or the region named by its
+// RequiredLocation when it has none (0 if that isn't a known region)
+func (policy *ConsumerPolicy) GeolocationMask() Geolocation {
+	if policy.Geolocation != 0 {
+		return policy.Geolocation
+	}
+	return GeolocationOf(policy.RequiredLocation)
+}
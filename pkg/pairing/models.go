@@ -0,0 +1,434 @@
+package pairing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/address"
+)
+
+// API interfaces a provider endpoint can serve, matching the interfaces Lava providers register with
+const (
+	APIInterfaceJSONRPC       = "jsonrpc"
+	APIInterfaceREST          = "rest"
+	APIInterfaceGRPC          = "grpc"
+	APIInterfaceTendermintRPC = "tendermintrpc"
+)
+
+// MetadataAttributePrefix prefixes Provider.Attribute names read from Provider.Metadata
+const MetadataAttributePrefix = "metadata."
+
+// ConsumerPolicy schema versions, see ConsumerPolicy.Version
+const (
+	PolicyVersion1 = 1 // Original schema
+	PolicyVersion2 = 2 // Adds ChainID and MaxFee
+	// CurrentPolicyVersion is the schema version written by this code
+	CurrentPolicyVersion = PolicyVersion2
+)
+
+// DefaultDelegationFactor is the weight of delegated stake relative to self stake when none is configured
+const DefaultDelegationFactor = 1.0
+
+// MaxComputeUnits bounds ConsumerPolicy.ComputeUnits, far above what any single pairing costs while keeping
+// quota accounting clear of int64 overflow
+const MaxComputeUnits int64 = 1 << 40
+
+// Provider represents a provider in the pairing system.
+type Provider struct {
+	ID      string          `json:"id"`      // Unique identifier for the provider (--> NOTE: ADDED TO GIVE AN EXAMPLE FOR ANOTHER SCORE TYPE)
+	Fee     float64         `json:"fee"`     // Fee charged by the provider (--> NOTE: ADDED TO GIVE AN EXAMPLE FOR ANOTHER SCORE TYPE)
+	Address address.Address `json:"address"` // Normalized by the registry and by sources parsing addresses, see address.Parser
+	Stake   int64           `json:"stake"`   // Total stake, used as is when SelfStake and DelegatedStake are not set
+	// Stake split as in Lava's delegation model, see EffectiveStake
+	SelfStake      int64 `json:"self_stake,omitempty"`
+	DelegatedStake int64 `json:"delegated_stake,omitempty"`
+	// Stake lock-up, the longer the provider's stake is locked the more it has at stake (see LockUp)
+	UnbondingSeconds int64     `json:"unbonding_seconds,omitempty"`  // Time withdrawn stake takes to unbond
+	StakeLockedUntil time.Time `json:"stake_locked_until,omitempty"` // Until when stake can't be withdrawn, zero if it isn't locked
+	// Commission is the percentage (0-100) of rewards the provider keeps before sharing with its delegators
+	Commission float64 `json:"commission,omitempty"`
+	Location   string  `json:"location"`
+	// Geolocation is the bitmask of regions the provider serves, as registered on Lava, 0 to use Location
+	Geolocation Geolocation `json:"geolocation,omitempty"`
+	Features    []string    `json:"features"`
+	// FeatureSet is Features as a bitset, nil to compute it when needed (see InternFeatures and FeatureMask)
+	FeatureSet FeatureSet `json:"-"`
+	// Endpoints the provider serves requests on, one per API interface/location combination
+	Endpoints []Endpoint `json:"endpoints,omitempty"`
+	// ASN is the autonomous system number of the network the endpoints are served from, 0 when unknown (see
+	// cluster.NetworkOf)
+	ASN uint32 `json:"asn,omitempty"`
+	// Security attributes
+	TLSEnabled      bool   `json:"tls_enabled"`                // Whether the provider serves its endpoints over TLS
+	CertFingerprint string `json:"cert_fingerprint,omitempty"` // SHA-256 fingerprint of the provider's TLS certificate
+	Jailed          bool   `json:"jailed"`                     // Whether the provider is currently jailed by the protocol
+	// Slashing holds slashes not yet settled against Stake, nil if there are none
+	Slashing *SlashInfo `json:"slashing,omitempty"`
+	// Metadata holds free-form numeric attributes (e.g. "archive_depth"), usable by ConfigurableScore
+	Metadata map[string]float64 `json:"metadata,omitempty"`
+	// LastUpdated is when the provider's registration data was last refreshed by its source, zero if unknown
+	LastUpdated time.Time `json:"last_updated,omitempty"`
+}
+
+// ProviderView is the read-only view of a provider the pairing system needs, letting callers pair over their
+// own provider types (see FromView and system.PairViews) instead of copying them into Provider themselves
+// Provider implements it
+type ProviderView interface {
+	GetID() string // Must be unique within a pairing
+	GetStake() int64
+	GetLocation() string
+	GetFeatures() []string
+	GetFee() float64
+}
+
+// ProviderFiller is optionally implemented by a ProviderView to set the Provider attributes the view doesn't
+// cover (endpoints, stake split, commission, metadata, ...) when converted by FromView
+type ProviderFiller interface {
+	FillProvider(p *Provider)
+}
+
+// SlashInfo describes stake slashes against a provider that are not yet reflected in its Stake
+type SlashInfo struct {
+	PendingAmount int64     `json:"pending_amount"`
+	LastSlashedAt time.Time `json:"last_slashed_at,omitempty"`
+}
+
+// SlashSource supplies pending slash information per provider ID (e.g. from the chain or an indexer)
+type SlashSource interface {
+	PendingSlashes() (map[string]*SlashInfo, error)
+}
+
+// Endpoint represents a single network endpoint registered by a provider
+type Endpoint struct {
+	URL          string `json:"url"`
+	APIInterface string `json:"api_interface"` // One of the APIInterface* constants
+	Geolocation  string `json:"geolocation"`   // Location the endpoint is served from
+}
+
+// ConsumerPolicy represents the policy requirements for a consumer
+// Empty string fields don't constrain pairing: an empty RequiredLocation, RequiredAPIInterface or ChainID
+// accepts every provider
+type ConsumerPolicy struct {
+	// Version of the schema the policy was written with, 0 means PolicyVersion1 (see the policy package)
+	Version          int             `json:"version,omitempty"`
+	ConsumerID       address.Address `json:"consumer_id,omitempty"`   // Identity (e.g. address, normalized by the API) of the consumer, used for quota accounting
+	ComputeUnits     int64           `json:"compute_units,omitempty"` // Compute units requested with this pairing, charged against the consumer's quota
+	RequiredLocation string          `json:"required_location"`       // Empty (and no Geolocation) accepts every location
+	// Geolocation, when set, is the bitmask of acceptable regions, superseding RequiredLocation: providers
+	// serving any of them match
+	Geolocation Geolocation `json:"geolocation,omitempty"`
+	// PreferredLocations are fallback locations scored above other non-matching locations, empty entries are ignored
+	PreferredLocations []string `json:"preferred_locations,omitempty"`
+	RequiredFeatures   []string `json:"required_features"` // Empty entries are ignored
+	// FeatureGroups require interchangeable features, e.g. at least 2 of {featA, featB, featC}
+	FeatureGroups []FeatureGroup `json:"feature_groups,omitempty"`
+	// FeatureValues weigh extra features in FeatureScore, e.g. {"archive": 3}; unlisted features are worth 1
+	FeatureValues map[string]float64 `json:"feature_values,omitempty"`
+	MinStake      int64              `json:"min_stake"`
+	// TopN, when set, is the number of providers to select instead of the configured one
+	TopN int `json:"top_n,omitempty"`
+	// Strict, when set, overrides the configured strict mode: whether finding no provider is an error
+	Strict *bool `json:"strict,omitempty"`
+	// MinProviders, when set, fails pairing with pairingerrors.InsufficientProvidersError when fewer providers
+	// are selected
+	MinProviders int `json:"min_providers,omitempty"`
+	// Backups, when set, is the number of eligible providers ranked after the selection returned alongside it,
+	// so consumers can fail over without requesting a fresh pairing
+	Backups int `json:"backups,omitempty"`
+	// MinASNs, when set, is the number of distinct ASNs the selected providers must span when enough eligible
+	// providers allow it, limiting correlated failures and censorship (see Provider.ASN)
+	MinASNs int `json:"min_asns,omitempty"`
+	// MinLockUpSeconds, when set, only keeps providers whose stake stays locked for at least this many seconds
+	// (see Provider.LockUp)
+	MinLockUpSeconds int64 `json:"min_lockup_seconds,omitempty"`
+	// WarmUp, when set, protects the consumer from providers seen for the first time recently
+	WarmUp *WarmUp `json:"warm_up,omitempty"`
+	// RequiredAPIInterface, when set, only keeps providers with an endpoint serving this API interface
+	RequiredAPIInterface string `json:"required_api_interface,omitempty"`
+	// APIInterfaces, when set, requests one pairing per API interface in a single call instead of a single
+	// pairing, each only keeping providers serving that interface (see system.PairInterfaces)
+	APIInterfaces []string `json:"api_interfaces,omitempty"`
+	RequireTLS    bool     `json:"require_tls,omitempty"`    // Only keep providers serving over TLS
+	ExcludeJailed bool     `json:"exclude_jailed,omitempty"` // Drop providers that are currently jailed
+	// MinUptime, when set, only keeps providers whose tracked uptime (0-1) is at least this value
+	MinUptime float64 `json:"min_uptime,omitempty"`
+	// ChainID, when set, is the chain the policy applies to
+	ChainID string `json:"chain_id,omitempty"`
+	// MaxFee, when set, only keeps providers charging at most this fee
+	MaxFee float64 `json:"max_fee,omitempty"`
+	// MaxDataAgeSeconds, when set, only keeps providers whose registration data was refreshed within this
+	// many seconds (see Provider.LastUpdated)
+	MaxDataAgeSeconds int64 `json:"max_data_age_seconds,omitempty"`
+	// Transforms post-process component scores before weighting, by scorer name (matched like Weights), e.g.
+	// {"StakeScore": ["cap:0.8"], "FeeScore": ["sqrt"]} (see score.ParseTransforms)
+	Transforms map[string][]string `json:"transforms,omitempty"`
+	// Adjustments are added to the final score of the given provider IDs after weighting, e.g.
+	// {"5": 0.1, "7": -0.2}, to express known preferences without excluding providers entirely
+	Adjustments map[string]float64 `json:"adjustments,omitempty"`
+	// ChainWeights override Weights per scorer when pairing for the given chain, by chain ID, and
+	// InterfaceWeights override both when pairing for the given API interface (see ScopedWeights), so one
+	// policy can drive different trade-offs for different workloads
+	ChainWeights     map[string]map[string]float64 `json:"chain_weights,omitempty"`
+	InterfaceWeights map[string]map[string]float64 `json:"interface_weights,omitempty"`
+	// Weights for different scoring components (e.g., {"Stake": 0.5, "Location": 0.3, "Feature": 0.2})
+	// This allows for flexible scoring based on the consumer's preferences.
+	// NOTE: Th weights should sum to 1.0
+	Weights map[string]float64 `json:"weights,omitempty"` // (--> NOTE: ADDED TO GIVE AN EXAMPLE FOR WEIGHTED SCORING MECHANISM)
+}
+
+// WarmUp is the grace period of new providers: providers first seen less than Hours ago are unproven, and are
+// excluded from the selection or, when ScoreCap is set, have their final score capped to it
+type WarmUp struct {
+	Hours    float64 `json:"hours"`
+	ScoreCap float64 `json:"score_cap,omitempty"` // Within (0, 1]
+	// ExplorationSlots is the number of selected providers that may be unproven regardless, taken by the best
+	// ranked unproven providers, so new providers still get a chance to prove themselves
+	ExplorationSlots int `json:"exploration_slots,omitempty"`
+}
+
+// FeatureGroup is a set of interchangeable features of which a provider must support at least MinMatch
+type FeatureGroup struct {
+	Features []string `json:"features"`
+	MinMatch int      `json:"min_match"` // Within [1, len(Features)]
+}
+
+// PairingScore represents the score of a provider based on the consumer policy
+type PairingScore struct {
+	Provider   *Provider
+	Score      float64
+	Components map[string]float64 // (e.g., {"StakeScore": 0.8, "FeatureScore": 1.0}, set on the scores returned
+	Vector     ComponentVector    // Components by scorer position, set while ranking
+}
+
+// ComponentVector holds the component scores of a provider by scorer position, so ranking doesn't allocate a
+// map per provider; Components is only built from it for the scores handed out (see Map)
+type ComponentVector struct {
+	Names   []string  // Scorer names by position, shared by every vector of a ranking
+	Values  []float64 // Component scores by position
+	Applied []bool    // Whether each scorer applied to the provider, the others have no component
+}
+
+// Map returns the applied components by scorer name, nil for an empty vector
+func (v ComponentVector) Map() map[string]float64 {
+	if v.Names == nil {
+		return nil
+	}
+	components := make(map[string]float64, len(v.Names))
+	for i, name := range v.Names {
+		if v.Applied[i] {
+			components[name] = v.Values[i]
+		}
+	}
+	return components
+}
+
+// LogValue logs the applied components as a group, building it only if the record is logged
+func (v ComponentVector) LogValue() slog.Value {
+	attrs := make([]slog.Attr, 0, len(v.Names))
+	for i, name := range v.Names {
+		if v.Applied[i] {
+			attrs = append(attrs, slog.Float64(name, v.Values[i]))
+		}
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// SupportsAPIInterface reports whether the provider has at least one endpoint serving the given API interface
+func (p *Provider) SupportsAPIInterface(apiInterface string) bool {
+	for _, e := range p.Endpoints {
+		if strings.EqualFold(e.APIInterface, apiInterface) {
+			return true
+		}
+	}
+	return false
+}
+
+// EffectiveStake returns the stake the provider is evaluated on, never below zero:
+// SelfStake + delegationFactor×DelegatedStake when the stake split is known, Stake otherwise,
+// minus any pending slashes
+// A delegationFactor of 0 means DefaultDelegationFactor
+func (p *Provider) EffectiveStake(delegationFactor float64) int64 {
+	if delegationFactor == 0 {
+		delegationFactor = DefaultDelegationFactor
+	}
+	stake := p.Stake
+	if p.SelfStake != 0 || p.DelegatedStake != 0 {
+		stake = p.SelfStake + int64(delegationFactor*float64(p.DelegatedStake))
+	}
+	if p.Slashing != nil {
+		stake -= p.Slashing.PendingAmount
+	}
+	if stake < 0 {
+		return 0
+	}
+	return stake
+}
+
+// DeriveProviderID returns the ID of the provider with the given address on the given chain, for providers
+// ingested without one: the first 16 bytes of the SHA-256 of the chain ID and the trimmed, lowercase address,
+// in hex, so every ingestion source derives the same ID for the same provider
+func DeriveProviderID(address, chainID string) string {
+	sum := sha256.Sum256([]byte(chainID + "\x00" + strings.ToLower(strings.TrimSpace(address))))
+	return hex.EncodeToString(sum[:16])
+}
+
+// ScopedWeights returns the weights the policy pairs with: Weights, overridden per scorer by those its ChainID
+// sets in ChainWeights, in turn overridden by those its RequiredAPIInterface sets in InterfaceWeights
+// It returns Weights itself when no override applies
+// NOTE: Overrides are merged by key, a scorer must be named the same way in every map
+func (c *ConsumerPolicy) ScopedWeights() map[string]float64 {
+	var chainWeights, interfaceWeights map[string]float64
+	if c.ChainID != "" {
+		chainWeights = c.ChainWeights[c.ChainID]
+	}
+	if c.RequiredAPIInterface != "" {
+		interfaceWeights = c.InterfaceWeights[c.RequiredAPIInterface]
+	}
+	if len(chainWeights) == 0 && len(interfaceWeights) == 0 {
+		return c.Weights
+	}
+	weights := make(map[string]float64, len(c.Weights)+len(chainWeights)+len(interfaceWeights))
+	maps.Copy(weights, c.Weights)
+	maps.Copy(weights, chainWeights)
+	maps.Copy(weights, interfaceWeights)
+	return weights
+}
+
+// LockUp returns how long the provider's stake stays locked as of now: the rest of its lock, if any, plus the
+// unbonding period withdrawing it takes
+// Lock-ups beyond the longest time.Duration (about 292 years) saturate to it rather than overflowing
+func (p *Provider) LockUp(now time.Time) time.Duration {
+	lockUp := SecondsDuration(p.UnbondingSeconds)
+	if p.StakeLockedUntil.After(now) {
+		rest := p.StakeLockedUntil.Sub(now) // Saturates too
+		if lockUp > math.MaxInt64-rest {
+			return math.MaxInt64
+		}
+		lockUp += rest
+	}
+	return lockUp
+}
+
+// HasLockUp reports whether the provider reports how long its stake stays locked, an unbonding period or a lock
+func (p *Provider) HasLockUp() bool {
+	return p.UnbondingSeconds > 0 || !p.StakeLockedUntil.IsZero()
+}
+
+// SecondsDuration converts seconds to a time.Duration, saturating to the longest (or shortest) Duration instead
+// of overflowing past about 292 years
+func SecondsDuration(seconds int64) time.Duration {
+	const maxSeconds = math.MaxInt64 / int64(time.Second)
+	switch {
+	case seconds > maxSeconds:
+		return math.MaxInt64
+	case seconds < -maxSeconds:
+		return math.MinInt64
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// Attribute returns a numeric provider attribute by its JSON field name (e.g. "fee", "self_stake"), or a
+// Metadata entry as "metadata.<key>"
+// It returns false if the attribute is unknown or, for Metadata, not set on this provider
+func (p *Provider) Attribute(name string) (float64, bool) {
+	if key, ok := strings.CutPrefix(name, MetadataAttributePrefix); ok {
+		value, ok := p.Metadata[key]
+		return value, ok
+	}
+	switch name {
+	case "fee":
+		return p.Fee, true
+	case "stake":
+		return float64(p.Stake), true
+	case "self_stake":
+		return float64(p.SelfStake), true
+	case "delegated_stake":
+		return float64(p.DelegatedStake), true
+	case "commission":
+		return p.Commission, true
+	case "unbonding_seconds":
+		return float64(p.UnbondingSeconds), true
+	default:
+		return 0, false
+	}
+}
+
+// Validate returns an error describing the first inconsistency in the provider's data (empty ID, negative
+// stake, non-finite or negative fee, commission outside [0, 100], negative unbonding period, non-finite
+// metadata), nil if there is none
+// Such data would otherwise corrupt the normalization of every other provider's scores
+func (p *Provider) Validate() error {
+	if p.ID == "" {
+		return fmt.Errorf("empty provider ID")
+	}
+	if p.Stake < 0 || p.SelfStake < 0 || p.DelegatedStake < 0 {
+		return fmt.Errorf("negative stake (stake %d, self %d, delegated %d)", p.Stake, p.SelfStake, p.DelegatedStake)
+	}
+	if math.IsNaN(p.Fee) || math.IsInf(p.Fee, 0) || p.Fee < 0 {
+		return fmt.Errorf("fee must be a finite, non-negative number, got %v", p.Fee)
+	}
+	if math.IsNaN(p.Commission) || p.Commission < 0 || p.Commission > 100 {
+		return fmt.Errorf("commission must be within [0, 100], got %v", p.Commission)
+	}
+	if p.UnbondingSeconds < 0 {
+		return fmt.Errorf("negative unbonding period %d", p.UnbondingSeconds)
+	}
+	keys := make([]string, 0, len(p.Metadata))
+	for key := range p.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // Deterministic report when several entries are invalid
+	for _, key := range keys {
+		if value := p.Metadata[key]; math.IsNaN(value) || math.IsInf(value, 0) {
+			return fmt.Errorf("metadata %q must be a finite number, got %v", key, value)
+		}
+	}
+	return nil
+}
+
+// IsAttribute reports whether name is an attribute Provider.Attribute can read
+func IsAttribute(name string) bool {
+	_, ok := (&Provider{}).Attribute(name)
+	return ok || strings.HasPrefix(name, MetadataAttributePrefix) && len(name) > len(MetadataAttributePrefix)
+}
+
+// GetID returns the provider's ID, see ProviderView
+func (p *Provider) GetID() string { return p.ID }
+
+// GetStake returns the provider's total stake, see ProviderView
+func (p *Provider) GetStake() int64 { return p.Stake }
+
+// GetLocation returns the provider's location, see ProviderView
+func (p *Provider) GetLocation() string { return p.Location }
+
+// GetFeatures returns the provider's features, see ProviderView
+func (p *Provider) GetFeatures() []string { return p.Features }
+
+// GetFee returns the provider's fee, see ProviderView
+func (p *Provider) GetFee() float64 { return p.Fee }
+
+// FromView returns the Provider the pairing system evaluates for a view
+// A *Provider is returned as is; other views are converted, calling FillProvider if they implement ProviderFiller
+func FromView(v ProviderView) *Provider {
+	if p, ok := v.(*Provider); ok {
+		return p
+	}
+	p := &Provider{
+		ID:       v.GetID(),
+		Stake:    v.GetStake(),
+		Location: v.GetLocation(),
+		Features: v.GetFeatures(),
+		Fee:      v.GetFee(),
+	}
+	if filler, ok := v.(ProviderFiller); ok {
+		filler.FillProvider(p)
+	}
+	return p
+}
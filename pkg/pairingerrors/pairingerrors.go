@@ -0,0 +1,58 @@
+package pairingerrors
+
+import (
+	"errors"
+	"fmt"
+)
+
+// New returns an error with the given message that matches kind with errors.Is
+// It lets packages define their own, more specific sentinels within the taxonomy, e.g.
+// New(ErrInvalidPolicy, "invalid policy weights")
+func New(kind error, msg string) error {
+	return &kindError{kind: kind, msg: msg}
+}
+
+// CodeOf returns the code of the sentinel err is classified under, "" if none
+func CodeOf(err error) Code {
+	for _, c := range codes {
+		if errors.Is(err, c.sentinel) {
+			return c.code
+		}
+	}
+	return ""
+}
+
+// FromCode returns the sentinel identified by code, nil if unknown
+func FromCode(code Code) error {
+	for _, c := range codes {
+		if c.code == code {
+			return c.sentinel
+		}
+	}
+	return nil
+}
+
+func (e *kindError) Error() string { return e.msg }
+
+func (e *kindError) Unwrap() error { return e.kind }
+
+func (e *InsufficientProvidersError) Error() string {
+	return fmt.Sprintf("%s: %d selected, policy requires at least %d", ErrInsufficientProviders, e.Available, e.Required)
+}
+
+func (e *InsufficientProvidersError) Unwrap() error { return ErrInsufficientProviders }
+
+func (e *LimitExceededError) Error() string {
+	if e.Actual == 0 {
+		return fmt.Sprintf("%s: %s above the maximum of %d", ErrLimitExceeded, e.Limit, e.Max)
+	}
+	return fmt.Sprintf("%s: %d %s, the maximum is %d", ErrLimitExceeded, e.Actual, e.Limit, e.Max)
+}
+
+func (e *LimitExceededError) Unwrap() error { return ErrLimitExceeded }
+
+func (e *SourceUnavailableError) Error() string {
+	return fmt.Sprintf("%s for chain %q: %v", ErrSourceUnavailable, e.ChainID, e.Err)
+}
+
+func (e *SourceUnavailableError) Unwrap() []error { return []error{ErrSourceUnavailable, e.Err} }
@@ -0,0 +1,76 @@
+package pairingerrors
+
+import "errors"
+
+// Sentinels every pairing error is classified under, for use with errors.Is
+var (
+	// ErrInvalidPolicy is wrapped by errors caused by the consumer policy (weights, transforms, schema version)
+	ErrInvalidPolicy = errors.New("invalid policy")
+	// ErrNoProviders is returned in strict mode when no provider matched the policy
+	ErrNoProviders = errors.New("no providers matched the filter criteria")
+	// ErrInsufficientProviders is wrapped by InsufficientProvidersError
+	ErrInsufficientProviders = errors.New("insufficient providers")
+	// ErrTimeout is wrapped by errors returned when pairing ran out of time
+	ErrTimeout = errors.New("pairing timed out")
+	// ErrSourceUnavailable is wrapped by SourceUnavailableError
+	ErrSourceUnavailable = errors.New("provider source unavailable")
+	// ErrLimitExceeded is wrapped by LimitExceededError
+	ErrLimitExceeded = errors.New("limit exceeded")
+)
+
+// Code identifies a sentinel over the wire, e.g. in the "code" field of the pairing API's error responses
+type Code string
+
+const (
+	CodeInvalidPolicy         Code = "invalid_policy"
+	CodeNoProviders           Code = "no_providers"
+	CodeInsufficientProviders Code = "insufficient_providers"
+	CodeTimeout               Code = "timeout"
+	CodeSourceUnavailable     Code = "source_unavailable"
+	CodeLimitExceeded         Code = "limit_exceeded"
+)
+
+// codes maps every sentinel to its code, in the order errors are classified
+var codes = []struct {
+	sentinel error
+	code     Code
+}{
+	{ErrInvalidPolicy, CodeInvalidPolicy},
+	{ErrNoProviders, CodeNoProviders},
+	{ErrInsufficientProviders, CodeInsufficientProviders},
+	{ErrTimeout, CodeTimeout},
+	{ErrSourceUnavailable, CodeSourceUnavailable},
+	{ErrLimitExceeded, CodeLimitExceeded},
+}
+
+// InsufficientProvidersError is returned when fewer providers were selected than the policy's MinProviders
+type InsufficientProvidersError struct {
+	Required  int
+	Available int
+}
+
+// SourceUnavailableError is returned when the providers of a chain couldn't be loaded
+type SourceUnavailableError struct {
+	ChainID string
+	Err     error // Underlying source error
+}
+
+// LimitExceededError is returned when a request is larger than a configured limit allows, e.g. sends too many
+// providers, so a caller can't exhaust the server's memory
+type LimitExceededError struct {
+	Limit  string // What is limited, e.g. "providers"
+	Max    int64
+	Actual int64 // 0 when the input was cut off before it could be measured
+}
+
+// FieldError locates a problem in a request to one of its fields, e.g. "feature_groups[0].min_match"
+type FieldError struct {
+	Field   string `json:"field"` // Path of the field: object keys joined by '.', list indexes and map keys in brackets
+	Message string `json:"message"`
+}
+
+// kindError is an error with its own message, classified under a sentinel (see New)
+type kindError struct {
+	kind error
+	msg  string
+}
@@ -0,0 +1,131 @@
+package policy
+
+import (
+	"math"
+	"strings"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// Compile preprocesses a consumer policy to evaluate many providers against it: its features are looked up
+// among the interned ones (see pairing.LookupFeature), its locations resolved to geolocations and its weights laid out by position for the given scorer names
+// The policy must not be modified while the compiled policy is in use
+func Compile(consumerPolicy *pairing.ConsumerPolicy, scorers ...string) *CompiledPolicy {
+	c := &CompiledPolicy{
+		Policy:      consumerPolicy,
+		Required:    make(map[string]struct{}, len(consumerPolicy.RequiredFeatures)),
+		Geolocation: consumerPolicy.GeolocationMask(),
+		Location:    strings.ToLower(consumerPolicy.RequiredLocation),
+		Preferred:   make([]pairing.Geolocation, len(consumerPolicy.PreferredLocations)),
+		Scorers:     scorers,
+		groups:      make([]featureGroup, len(consumerPolicy.FeatureGroups)),
+	}
+	for _, feature := range consumerPolicy.RequiredFeatures {
+		c.Required[feature] = struct{}{}
+		if feature == "" { // Empty entries don't require anything from providers
+			continue
+		}
+		if bit, ok := pairing.LookupFeature(feature); ok {
+			c.Features = c.Features.With(bit)
+		} else {
+			c.unsatisfiable = true // No provider interned it, so none supports it
+		}
+	}
+	for i, group := range consumerPolicy.FeatureGroups {
+		c.groups[i].minMatch = group.MinMatch
+		c.groups[i].names = group.Features
+		for _, feature := range group.Features {
+			bit, ok := pairing.LookupFeature(feature)
+			if !ok {
+				continue // No provider supports it, it can't count towards the group
+			}
+			if c.groups[i].set.Has(bit) {
+				c.groups[i].repeats = append(c.groups[i].repeats, bit) // Counted once per listing
+				continue
+			}
+			c.groups[i].set = c.groups[i].set.With(bit)
+		}
+	}
+	for i, location := range consumerPolicy.PreferredLocations {
+		c.Preferred[i] = pairing.GeolocationOf(location)
+	}
+	if len(consumerPolicy.Weights) > 0 {
+		c.Weights = make([]float64, len(scorers))
+		for i, name := range scorers {
+			weight, ok := consumerPolicy.Weights[name]
+			if !ok {
+				weight = math.NaN() // No weight, unlike a zero weight
+			}
+			c.Weights[i] = weight
+		}
+	}
+	return c
+}
+
+// HasFeatures reports whether the provider supports every required feature and at least MinMatch features of
+// every feature group, comparing bitsets for providers with a FeatureSet and names otherwise
+func (c *CompiledPolicy) HasFeatures(p *pairing.Provider) bool {
+	if p.FeatureSet == nil {
+		return c.hasFeatureNames(p.Features)
+	}
+	if c.unsatisfiable {
+		return false
+	}
+	if c.Features == nil && len(c.groups) == 0 {
+		return true
+	}
+	supported := p.FeatureSet
+	if !supported.Contains(c.Features) {
+		return false // A required feature wasn't found in the provider's list
+	}
+	for _, group := range c.groups {
+		matched := supported.CountCommon(group.set)
+		for _, bit := range group.repeats {
+			if supported.Has(bit) {
+				matched++
+			}
+		}
+		if matched < group.minMatch {
+			return false // Not enough of the group's interchangeable features
+		}
+	}
+	return true
+}
+
+// hasFeatureNames is HasFeatures for providers whose features were never interned, nothing is interned for them
+func (c *CompiledPolicy) hasFeatureNames(names []string) bool {
+	if len(c.Required) == 0 && len(c.groups) == 0 {
+		return true
+	}
+	supported := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		supported[name] = struct{}{}
+	}
+	for feature := range c.Required {
+		if _, ok := supported[feature]; !ok && feature != "" {
+			return false
+		}
+	}
+	for _, group := range c.groups {
+		matched := 0
+		for _, feature := range group.names {
+			if _, ok := supported[feature]; ok {
+				matched++ // Counted once per listing, like the bitset check
+			}
+		}
+		if matched < group.minMatch {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchesLocation reports whether the provider's geolocation intersects the policy's or, when either side has
+// no known geolocation, the provider's Location matches the policy's RequiredLocation ignoring case, like
+// score.LocationScore matches them
+func (c *CompiledPolicy) MatchesLocation(p *pairing.Provider) bool {
+	if served := p.GeolocationMask(); c.Geolocation != 0 && served != 0 {
+		return c.Geolocation.Intersects(served)
+	}
+	return strings.EqualFold(p.Location, c.Location)
+}
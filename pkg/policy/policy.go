@@ -0,0 +1,112 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// migrations maps a schema version to the migration upgrading it to the next one
+var migrations = map[int]migration{
+	pairing.PolicyVersion1: migrateV1ToV2,
+}
+
+// Unmarshal decodes a serialized ConsumerPolicy of any known schema version, migrating it to
+// pairing.CurrentPolicyVersion
+// Policies without a version are treated as pairing.PolicyVersion1
+func Unmarshal(data []byte) (*pairing.ConsumerPolicy, error) {
+	doc, err := migratedDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	return decode(doc)
+}
+
+// migratedDocument parses a serialized policy as a raw document migrated to pairing.CurrentPolicyVersion
+func migratedDocument(data []byte) (map[string]json.RawMessage, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("decode policy: %w", err)
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("decode policy: not a JSON object")
+	}
+	if err := Migrate(doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// decode decodes a migrated policy document
+func decode(doc map[string]json.RawMessage) (*pairing.ConsumerPolicy, error) {
+	migrated, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("encode migrated policy: %w", err)
+	}
+	var policy pairing.ConsumerPolicy
+	if err := json.Unmarshal(migrated, &policy); err != nil {
+		return nil, fmt.Errorf("decode policy: %w", err)
+	}
+	return &policy, nil
+}
+
+// Marshal serializes a ConsumerPolicy, stamping it with pairing.CurrentPolicyVersion
+func Marshal(policy *pairing.ConsumerPolicy) ([]byte, error) {
+	stamped := *policy
+	stamped.Version = pairing.CurrentPolicyVersion
+	return json.Marshal(&stamped)
+}
+
+// Migrate upgrades a raw policy document to pairing.CurrentPolicyVersion, one version at a time
+func Migrate(doc map[string]json.RawMessage) error {
+	version, err := documentVersion(doc)
+	if err != nil {
+		return err
+	}
+	if version > pairing.CurrentPolicyVersion {
+		return fmt.Errorf("%w: %d (latest known is %d)", ErrUnsupportedVersion, version, pairing.CurrentPolicyVersion)
+	}
+	for version < pairing.CurrentPolicyVersion {
+		migrate, ok := migrations[version]
+		if !ok {
+			return fmt.Errorf("%w: no migration from version %d", ErrUnsupportedVersion, version)
+		}
+		if err := migrate(doc); err != nil {
+			return fmt.Errorf("migrate policy from version %d: %w", version, err)
+		}
+		version++
+		doc["version"] = json.RawMessage(fmt.Sprint(version))
+	}
+	return nil
+}
+
+// documentVersion returns the schema version of a raw policy document
+func documentVersion(doc map[string]json.RawMessage) (int, error) {
+	raw, ok := doc["version"]
+	if !ok || string(raw) == "null" {
+		return pairing.PolicyVersion1, nil
+	}
+	var version int
+	if err := json.Unmarshal(raw, &version); err != nil {
+		return 0, fmt.Errorf("decode policy version: %w", err)
+	}
+	if version == 0 {
+		return pairing.PolicyVersion1, nil
+	}
+	if version < 0 {
+		return 0, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+	return version, nil
+}
+
+/* ***********************************************************************
+ *                               MIGRATIONS                              *
+ *********************************************************************** */
+
+// migrateV1ToV2 upgrades a version 1 policy
+// Version 2 only adds fields (chain_id, max_fee) whose zero values mean "any chain" and "no fee cap", so
+// version 1 policies keep their exact meaning as they are
+func migrateV1ToV2(map[string]json.RawMessage) error {
+	return nil
+}
@@ -0,0 +1,186 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairingerrors"
+)
+
+// UnmarshalStrict decodes a serialized ConsumerPolicy like Unmarshal, then checks it against the schema of
+// pairing.CurrentPolicyVersion: fields the schema doesn't define (typically typos, silently ignored otherwise),
+// lists and maps of more than maxItems entries (0 is unlimited) and values no policy can mean, e.g. a negative
+// min_stake. Every field at fault is reported in a single *SchemaError
+// NOTE: Field names are matched exactly, unlike encoding/json which also accepts them in any case
+func UnmarshalStrict(data []byte, maxItems int) (*pairing.ConsumerPolicy, error) {
+	doc, err := migratedDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("encode migrated policy: %w", err)
+	}
+	fields := checkSchema(nil, "", raw, reflect.TypeFor[pairing.ConsumerPolicy](), maxItems)
+	if len(fields) > 0 {
+		// Decoding can't be trusted with a document that doesn't fit the schema, e.g. a huge list
+		return nil, &SchemaError{Fields: fields}
+	}
+
+	policy, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckValues(policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// CheckValues checks a policy for values no policy can mean, however it was built (decoded by UnmarshalStrict,
+// executed from a template, ...), reporting every field at fault in a single *SchemaError
+func CheckValues(policy *pairing.ConsumerPolicy) error {
+	if fields := checkValues(policy); len(fields) > 0 {
+		return &SchemaError{Fields: fields}
+	}
+	return nil
+}
+
+func (e *SchemaError) Error() string {
+	problems := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		problems[i] = field.Field + ": " + field.Message
+	}
+	return "policy does not fit the schema: " + strings.Join(problems, "; ")
+}
+
+func (e *SchemaError) Unwrap() error { return pairingerrors.ErrInvalidPolicy }
+
+// checkSchema appends to fields the unknown fields and oversized lists and maps of a raw JSON value decoded
+// into t, walking nested objects, lists and maps; values of the wrong JSON type are left to decoding
+func checkSchema(fields []pairingerrors.FieldError, path string, raw json.RawMessage, t reflect.Type, maxItems int) []pairingerrors.FieldError {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		var obj map[string]json.RawMessage
+		if json.Unmarshal(raw, &obj) != nil {
+			return fields
+		}
+		known := jsonFields(t)
+		for _, key := range sortedKeys(obj) {
+			field := joinPath(path, key)
+			ft, ok := known[key]
+			if !ok {
+				fields = append(fields, pairingerrors.FieldError{Field: field, Message: "unknown field"})
+				continue
+			}
+			fields = checkSchema(fields, field, obj[key], ft, maxItems)
+		}
+	case reflect.Slice, reflect.Array:
+		var items []json.RawMessage
+		if json.Unmarshal(raw, &items) != nil {
+			return fields
+		}
+		if maxItems > 0 && len(items) > maxItems {
+			return append(fields, tooManyItems(path, len(items), maxItems))
+		}
+		for i, item := range items {
+			fields = checkSchema(fields, path+"["+strconv.Itoa(i)+"]", item, t.Elem(), maxItems)
+		}
+	case reflect.Map:
+		var entries map[string]json.RawMessage
+		if json.Unmarshal(raw, &entries) != nil {
+			return fields
+		}
+		if maxItems > 0 && len(entries) > maxItems {
+			return append(fields, tooManyItems(path, len(entries), maxItems))
+		}
+		for _, key := range sortedKeys(entries) {
+			fields = checkSchema(fields, path+"["+strconv.Quote(key)+"]", entries[key], t.Elem(), maxItems)
+		}
+	}
+	return fields
+}
+
+// checkValues returns the fields of a decoded policy set to values no policy can mean
+// Constraints checked when pairing (weights, feature groups, negative counts, ...) are left to the caller
+func checkValues(policy *pairing.ConsumerPolicy) []pairingerrors.FieldError {
+	var fields []pairingerrors.FieldError
+	negative := func(field string, value int64) {
+		if value < 0 {
+			fields = append(fields, pairingerrors.FieldError{Field: field, Message: "must not be negative"})
+		}
+	}
+	negative("compute_units", policy.ComputeUnits)
+	if policy.ComputeUnits > pairing.MaxComputeUnits {
+		fields = append(fields, pairingerrors.FieldError{
+			Field:   "compute_units",
+			Message: fmt.Sprintf("must not exceed %d", pairing.MaxComputeUnits),
+		})
+	}
+	negative("min_stake", policy.MinStake)
+	negative("max_data_age_seconds", policy.MaxDataAgeSeconds)
+	if policy.Geolocation&^pairing.GeolocationGlobal != 0 {
+		fields = append(fields, pairingerrors.FieldError{
+			Field:   "geolocation",
+			Message: fmt.Sprintf("must be a bitmask within %#x", uint64(pairing.GeolocationGlobal)),
+		})
+	}
+	if policy.MinUptime < 0 || policy.MinUptime > 1 {
+		fields = append(fields, pairingerrors.FieldError{Field: "min_uptime", Message: "must be within [0, 1]"})
+	}
+	if policy.MaxFee < 0 {
+		fields = append(fields, pairingerrors.FieldError{Field: "max_fee", Message: "must not be negative"})
+	}
+	return fields
+}
+
+// jsonFields maps the JSON names of a struct's fields to their types, as encoding/json names them
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// tooManyItems reports a list or map of more than maxItems entries
+func tooManyItems(path string, items, maxItems int) pairingerrors.FieldError {
+	return pairingerrors.FieldError{Field: path, Message: fmt.Sprintf("has %d entries, the maximum is %d", items, maxItems)}
+}
+
+// joinPath appends an object key to a field path
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// sortedKeys returns the keys of a JSON object in sorted order, so fields are reported deterministically
+func sortedKeys(obj map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,204 @@
+package policy
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// NewStore creates an empty policy Store
+func NewStore() *Store {
+	return &Store{
+		policies: make(map[string][]StoredPolicy),
+		deleted:  make(map[string]int),
+		now:      time.Now,
+	}
+}
+
+// Put saves a new revision of the named policy on behalf of owner and returns it
+// Only the owner of a policy may save it, others get ErrNotOwner; the check is made as the revision is saved, so
+// of concurrent saves of a new name only the first takes it. An owner may keep up to MaxPoliciesPerOwner
+// policies, saving another fails with ErrTooManyPolicies
+// Revisions are numbered from the policy's last one, even if it was deleted, and only the latest MaxRevisions
+// are kept
+func (s *Store) Put(name, owner string, policy *pairing.ConsumerPolicy) (StoredPolicy, error) {
+	return s.put(name, owner, false, policy)
+}
+
+// Override is Put for admins: it saves the named policy whoever owns it, keeping its owner, and a new name is
+// owned by admin without counting against MaxPoliciesPerOwner
+func (s *Store) Override(name, admin string, policy *pairing.ConsumerPolicy) (StoredPolicy, error) {
+	return s.put(name, admin, true, policy)
+}
+
+// put saves a revision of the named policy, see Put; override skips the ownership checks
+func (s *Store) put(name, owner string, override bool, policy *pairing.ConsumerPolicy) (StoredPolicy, error) {
+	if !validName(name) {
+		return StoredPolicy{}, fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	data, err := Marshal(policy)
+	if err != nil {
+		return StoredPolicy{}, fmt.Errorf("encode policy: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	revisions := s.policies[name]
+	stored := StoredPolicy{
+		Name:      name,
+		Revision:  s.deleted[name] + 1,
+		Owner:     owner,
+		CreatedAt: s.now(),
+		Policy:    data,
+	}
+	if len(revisions) > 0 {
+		last := revisions[len(revisions)-1]
+		if last.Owner != owner && !override {
+			return StoredPolicy{}, fmt.Errorf("%w: %q", ErrNotOwner, name)
+		}
+		stored.Revision = last.Revision + 1
+		stored.Owner = last.Owner
+	} else if !override && s.owned(owner) >= MaxPoliciesPerOwner {
+		return StoredPolicy{}, fmt.Errorf("%w: %q already has %d", ErrTooManyPolicies, owner, MaxPoliciesPerOwner)
+	}
+	delete(s.deleted, name)
+	revisions = append(revisions, stored)
+	if len(revisions) > MaxRevisions {
+		revisions = append([]StoredPolicy(nil), revisions[len(revisions)-MaxRevisions:]...)
+	}
+	s.policies[name] = revisions
+	return stored, nil
+}
+
+// owned returns the number of policies owned by owner
+// NOTE: Must be called with s.mu held
+func (s *Store) owned(owner string) int {
+	count := 0
+	for _, revisions := range s.policies {
+		if revisions[len(revisions)-1].Owner == owner {
+			count++
+		}
+	}
+	return count
+}
+
+// Get returns a revision of the named policy, the latest if revision is 0
+func (s *Store) Get(name string, revision int) (StoredPolicy, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	revisions := s.policies[name]
+	if len(revisions) == 0 {
+		return StoredPolicy{}, fmt.Errorf("%w: %q", ErrPolicyNotFound, name)
+	}
+	if revision == 0 {
+		return revisions[len(revisions)-1], nil
+	}
+	for _, stored := range revisions {
+		if stored.Revision == revision {
+			return stored, nil
+		}
+	}
+	return StoredPolicy{}, fmt.Errorf("%w: %q revision %d", ErrPolicyNotFound, name, revision)
+}
+
+// Revisions returns every revision of the named policy, oldest first
+func (s *Store) Revisions(name string) ([]StoredPolicy, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	revisions := s.policies[name]
+	if len(revisions) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrPolicyNotFound, name)
+	}
+	return append([]StoredPolicy(nil), revisions...), nil
+}
+
+// List returns the latest revision of every policy whose latest revision was saved by owner, or of every
+// policy if owner is empty, sorted by name
+func (s *Store) List(owner string) []StoredPolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	latest := make([]StoredPolicy, 0, len(s.policies))
+	for _, revisions := range s.policies {
+		if last := revisions[len(revisions)-1]; owner == "" || last.Owner == owner {
+			latest = append(latest, last)
+		}
+	}
+	sort.Slice(latest, func(i, j int) bool { return latest[i].Name < latest[j].Name })
+	return latest
+}
+
+// Delete removes the named policy with all its revisions on behalf of owner, remembering its last revision number
+// Only the owner of a policy may delete it, others get ErrNotOwner; the check is made as the policy is deleted,
+// like Put's
+func (s *Store) Delete(name, owner string) error {
+	return s.delete(name, owner, false)
+}
+
+// ForceDelete is Delete for admins: it deletes the named policy whoever owns it
+func (s *Store) ForceDelete(name string) error {
+	return s.delete(name, "", true)
+}
+
+// delete removes the named policy, see Delete; override skips the ownership check
+func (s *Store) delete(name, owner string, override bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	revisions, ok := s.policies[name]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrPolicyNotFound, name)
+	}
+	if !override && revisions[len(revisions)-1].Owner != owner {
+		return fmt.Errorf("%w: %q", ErrNotOwner, name)
+	}
+	s.deleted[name] = revisions[len(revisions)-1].Revision
+	delete(s.policies, name)
+	return nil
+}
+
+// Snapshot returns a copy of the store's policies, for persistence
+func (s *Store) Snapshot() StoreSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snapshot := StoreSnapshot{Policies: make(map[string][]StoredPolicy, len(s.policies)), Deleted: maps.Clone(s.deleted)}
+	for name, revisions := range s.policies {
+		snapshot.Policies[name] = append([]StoredPolicy(nil), revisions...)
+	}
+	return snapshot
+}
+
+// Restore replaces the store's policies with a snapshot taken by Snapshot
+func (s *Store) Restore(snapshot StoreSnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policies = make(map[string][]StoredPolicy, len(snapshot.Policies))
+	for name, revisions := range snapshot.Policies {
+		if len(revisions) > 0 {
+			s.policies[name] = append([]StoredPolicy(nil), revisions...)
+		}
+	}
+	s.deleted = make(map[string]int, len(snapshot.Deleted))
+	maps.Copy(s.deleted, snapshot.Deleted)
+}
+
+// Decode returns the stored ConsumerPolicy, migrated to pairing.CurrentPolicyVersion
+func (sp StoredPolicy) Decode() (*pairing.ConsumerPolicy, error) {
+	return Unmarshal(sp.Policy)
+}
+
+// validName reports whether name is 1 to maxNameLength letters, digits, '.', '_' or '-'
+func validName(name string) bool {
+	if name == "" || len(name) > maxNameLength {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,216 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// placeholderPattern matches a "${param}" template placeholder
+var placeholderPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// LoadTemplates loads a JSON array of policy templates from a file, see ParseTemplates
+func LoadTemplates(path string) ([]*Template, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	templates, err := ParseTemplates(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return templates, nil
+}
+
+// ParseTemplates parses a JSON array of policy templates, see ParseTemplate
+// Template names must be unique
+func ParseTemplates(raw []byte) ([]*Template, error) {
+	var docs []json.RawMessage
+	if err := json.Unmarshal(raw, &docs); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTemplate, err)
+	}
+	templates := make([]*Template, 0, len(docs))
+	seen := make(map[string]bool, len(docs))
+	for i, doc := range docs {
+		t, err := ParseTemplate(doc)
+		if err != nil {
+			return nil, fmt.Errorf("template %d: %w", i, err)
+		}
+		if seen[t.Name] {
+			return nil, fmt.Errorf("%w: duplicate name %q", ErrInvalidTemplate, t.Name)
+		}
+		seen[t.Name] = true
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+// ParseTemplate parses a policy template, checking that its parameters are well declared and that every
+// placeholder of its policy refers to one of them
+func ParseTemplate(raw []byte) (*Template, error) {
+	var t Template
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTemplate, err)
+	}
+	if !validName(t.Name) {
+		return nil, fmt.Errorf("%w: invalid name %q", ErrInvalidTemplate, t.Name)
+	}
+	for name, param := range t.Params {
+		switch param.Type {
+		case "":
+			param.Type = ParamString
+			t.Params[name] = param
+		case ParamString, ParamNumber, ParamBool:
+		default:
+			return nil, fmt.Errorf("%w: parameter %q has unknown type %q", ErrInvalidTemplate, name, param.Type)
+		}
+		if len(param.Enum) > 0 && param.Type != ParamString {
+			return nil, fmt.Errorf("%w: parameter %q: only string parameters take an enum", ErrInvalidTemplate, name)
+		}
+		if param.Default != nil {
+			if _, err := param.value(name, param.Default); err != nil {
+				return nil, fmt.Errorf("%w: default: %w", ErrInvalidTemplate, err)
+			}
+		}
+	}
+
+	doc, err := t.document()
+	if err != nil {
+		return nil, err
+	}
+	var undeclared []string
+	walkStrings(doc, func(s string) any {
+		for _, match := range placeholderPattern.FindAllStringSubmatch(s, -1) {
+			if _, ok := t.Params[match[1]]; !ok && !slices.Contains(undeclared, match[1]) {
+				undeclared = append(undeclared, match[1])
+			}
+		}
+		return s
+	})
+	if len(undeclared) > 0 {
+		sort.Strings(undeclared)
+		return nil, fmt.Errorf("%w: undeclared parameters %s", ErrInvalidTemplate, strings.Join(undeclared, ", "))
+	}
+	return &t, nil
+}
+
+// ParamNames returns the names of the template's parameters, sorted
+func (t *Template) ParamNames() []string {
+	names := make([]string, 0, len(t.Params))
+	for name := range t.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Execute fills the template's placeholders with the given parameters and decodes the resulting policy
+// Parameters must be declared by the template and match their type and enum; missing ones take their default.
+// Numbers may be given as any Go integer or float type, or as a json.Number
+func (t *Template) Execute(params map[string]any) (*pairing.ConsumerPolicy, error) {
+	for name := range params {
+		if _, ok := t.Params[name]; !ok {
+			return nil, fmt.Errorf("%w: unknown parameter %q (template %s takes %s)", ErrTemplateParams, name, t.Name, strings.Join(t.ParamNames(), ", "))
+		}
+	}
+	values := make(map[string]any, len(t.Params))
+	for _, name := range t.ParamNames() {
+		param := t.Params[name]
+		given, ok := params[name]
+		if !ok || given == nil {
+			if param.Default == nil {
+				return nil, fmt.Errorf("%w: missing parameter %q", ErrTemplateParams, name)
+			}
+			given = param.Default
+		}
+		value, err := param.value(name, given)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrTemplateParams, err)
+		}
+		values[name] = value
+	}
+
+	doc, err := t.document()
+	if err != nil {
+		return nil, err
+	}
+	filled, err := json.Marshal(walkStrings(doc, func(s string) any {
+		if match := placeholderPattern.FindStringSubmatch(s); match != nil && match[0] == s {
+			return values[match[1]]
+		}
+		return placeholderPattern.ReplaceAllStringFunc(s, func(placeholder string) string {
+			return fmt.Sprint(values[placeholder[2:len(placeholder)-1]])
+		})
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("encode template %s policy: %w", t.Name, err)
+	}
+	policy, err := Unmarshal(filled)
+	if err != nil {
+		return nil, fmt.Errorf("%w: template %s: %w", ErrTemplateParams, t.Name, err)
+	}
+	return policy, nil
+}
+
+// document decodes the template's policy into a generic JSON document, numbers kept as json.Number
+func (t *Template) document() (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(t.Policy))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%w: policy: %w", ErrInvalidTemplate, err)
+	}
+	if _, ok := doc.(map[string]any); !ok {
+		return nil, fmt.Errorf("%w: policy is not a JSON object", ErrInvalidTemplate)
+	}
+	return doc, nil
+}
+
+// value checks a parameter value against its declaration and normalizes it for encoding
+func (param TemplateParam) value(name string, v any) (any, error) {
+	switch param.Type {
+	case ParamNumber:
+		switch n := v.(type) {
+		case json.Number:
+			return n, nil
+		case float64, float32, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+			return n, nil
+		}
+	case ParamBool:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	default:
+		if s, ok := v.(string); ok {
+			if len(param.Enum) > 0 && !slices.Contains(param.Enum, s) {
+				return nil, fmt.Errorf("parameter %q must be one of %s, got %q", name, strings.Join(param.Enum, ", "), s)
+			}
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("parameter %q must be a %s, got %T", name, param.Type, v)
+}
+
+// walkStrings replaces every string value of a generic JSON document (object keys excluded) with fn's result
+func walkStrings(doc any, fn func(string) any) any {
+	switch v := doc.(type) {
+	case map[string]any:
+		for key, item := range v {
+			v[key] = walkStrings(item, fn)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = walkStrings(item, fn)
+		}
+	case string:
+		return fn(v)
+	}
+	return doc
+}
@@ -0,0 +1,135 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairingerrors"
+)
+
+// ErrUnsupportedVersion is returned when a policy was written with a schema version newer than this code knows
+// It matches pairingerrors.ErrInvalidPolicy
+var ErrUnsupportedVersion = pairingerrors.New(pairingerrors.ErrInvalidPolicy, "unsupported policy version")
+
+// SchemaError is returned by UnmarshalStrict for a policy that doesn't fit the schema, listing every field at
+// fault. It matches pairingerrors.ErrInvalidPolicy
+type SchemaError struct {
+	Fields []pairingerrors.FieldError
+}
+
+// migration upgrades a raw policy document by exactly one schema version, in place
+// Policies are migrated as raw JSON so renamed or restructured fields can be carried over before decoding
+type migration func(doc map[string]json.RawMessage) error
+
+// ErrPolicyNotFound is returned by Store when no policy (or revision) is saved under the requested name
+var ErrPolicyNotFound = errors.New("policy not found")
+
+// ErrInvalidName is returned by Store.Put for names that aren't 1 to 64 letters, digits, '.', '_' or '-'
+var ErrInvalidName = errors.New("invalid policy name")
+
+// ErrNotOwner is returned by Store.Put and Store.Delete for a name whose policy is owned by another consumer
+var ErrNotOwner = errors.New("policy owned by another consumer")
+
+// ErrTooManyPolicies is returned by Store.Put for a new name when its owner already has MaxPoliciesPerOwner policies
+var ErrTooManyPolicies = errors.New("too many policies")
+
+// maxNameLength is the longest policy name a Store accepts
+const maxNameLength = 64
+
+// MaxRevisions is the number of revisions a Store keeps per policy, older ones are dropped as new ones are saved
+const MaxRevisions = 32
+
+// MaxPoliciesPerOwner is the number of policies a Store keeps per owner, bounding what a single consumer can save
+const MaxPoliciesPerOwner = 64
+
+// Store keeps named ConsumerPolicies (e.g. "prod-eth-archive") with their revision history, so consumers can
+// request pairings by name instead of sending the full policy every call
+// Policies are kept serialized (see Marshal) and decoded on use, so saved policies are migrated like any
+// other after a schema upgrade. It is safe for concurrent use
+type Store struct {
+	mu       sync.RWMutex
+	policies map[string][]StoredPolicy // Name -> revisions, oldest first
+	// deleted maps the names of deleted policies to their last revision, so a policy saved again under the name
+	// continues its numbering and a pinned revision never points at different content
+	deleted map[string]int
+	now     func() time.Time
+}
+
+// StoredPolicy is a revision of a named policy
+type StoredPolicy struct {
+	Name      string          `json:"name"`
+	Revision  int             `json:"revision"`        // Starts at 1, incremented by every Put under the name
+	Owner     string          `json:"owner,omitempty"` // Identity of the consumer owning the policy, see Store.Put
+	CreatedAt time.Time       `json:"created_at"`
+	Policy    json.RawMessage `json:"policy"` // Serialized ConsumerPolicy, see Decode
+}
+
+// StoreSnapshot is the persistable state of a Store, see Store.Snapshot
+type StoreSnapshot struct {
+	Policies map[string][]StoredPolicy `json:"policies,omitempty"` // Name -> revisions, oldest first
+	Deleted  map[string]int            `json:"deleted,omitempty"`  // Name of a deleted policy -> its last revision
+}
+
+// CompiledPolicy is a consumer policy preprocessed once to evaluate many providers against it, see Compile
+// It is read-only, so a compiled policy can be shared across goroutines and calls
+type CompiledPolicy struct {
+	Policy      *pairing.ConsumerPolicy
+	Required    map[string]struct{}   // Set of RequiredFeatures
+	Geolocation pairing.Geolocation   // Acceptable regions, see pairing.ConsumerPolicy.GeolocationMask
+	Location    string                // RequiredLocation, lowercased
+	Preferred   []pairing.Geolocation // Geolocation of each PreferredLocations entry, 0 for names that aren't a region
+	Scorers     []string              // Scorer names Weights is laid out for
+	Weights     []float64             // Weight by scorer position, NaN where a scorer has no weight; nil without weights
+	Features    pairing.FeatureSet    // Non-empty required features as a bitset
+
+	groups        []featureGroup // Feature groups as bitsets, by position
+	unsatisfiable bool           // A required feature isn't supported by any provider
+}
+
+// featureGroup is a feature group of a compiled policy
+type featureGroup struct {
+	set      pairing.FeatureSet
+	repeats  []int    // Bits of the features listed more than once, once per extra listing
+	names    []string // Features as listed, for providers without a FeatureSet
+	minMatch int
+}
+
+// ErrInvalidTemplate is returned when a policy template can't be parsed
+var ErrInvalidTemplate = errors.New("invalid policy template")
+
+// ErrTemplateParams is returned by Template.Execute when the parameters don't fit the template
+// It matches pairingerrors.ErrInvalidPolicy
+var ErrTemplateParams = pairingerrors.New(pairingerrors.ErrInvalidPolicy, "invalid policy template parameters")
+
+// ParamType is the type of a policy template parameter
+type ParamType string
+
+const (
+	ParamString ParamType = "string" // The default
+	ParamNumber ParamType = "number"
+	ParamBool   ParamType = "bool"
+)
+
+// Template is a policy with "${param}" placeholders, filled per request by Execute, e.g. gateways creating
+// per-consumer policies from a small set of templates
+// A string value that is exactly a placeholder is replaced by the parameter's value with its type (e.g. a
+// number for "min_stake": "${min_stake}"); placeholders within longer strings are interpolated
+type Template struct {
+	Name        string                   `json:"name"`
+	Description string                   `json:"description,omitempty"`
+	Params      map[string]TemplateParam `json:"params,omitempty"`
+	Policy      json.RawMessage          `json:"policy"` // Serialized ConsumerPolicy with placeholders
+}
+
+// TemplateParam declares a policy template parameter
+type TemplateParam struct {
+	Type        ParamType `json:"type,omitempty"`
+	Description string    `json:"description,omitempty"`
+	// Default is used when the parameter isn't given, parameters without one are required
+	Default any `json:"default,omitempty"`
+	// Enum, when set, lists the values a string parameter may take
+	Enum []string `json:"enum,omitempty"`
+}
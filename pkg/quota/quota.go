@@ -0,0 +1,175 @@
+package quota
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/internal/utils"
+)
+
+// NewTracker creates a new Tracker with epochs of the given length and default limits applied to every consumer
+func NewTracker(epochLength time.Duration, defaults Limits) *Tracker {
+	return &Tracker{
+		epochLength: epochLength,
+		defaults:    defaults,
+		overrides:   make(map[string]Limits),
+		usage:       make(map[string]*usage),
+		now:         time.Now,
+	}
+}
+
+// SetClock replaces the clock epochs are accounted against, nil restores time.Now
+// Replays pin it to the time of the recorded call
+func (t *Tracker) SetClock(now func() time.Time) {
+	if now == nil {
+		now = time.Now
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.now = now
+}
+
+// SetLimits overrides the default limits for a single consumer
+func (t *Tracker) SetLimits(consumerID string, limits Limits) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.overrides[consumerID] = limits
+}
+
+// Consume charges one request and the given compute units to the consumer's quota for the current epoch
+// If either limit would be exceeded nothing is charged and an *ExceededError is returned; negative compute
+// units are rejected with ErrNegativeComputeUnits
+// Consumers without usage in the current epoch are evicted once per epoch, so usage only grows with the
+// consumers active within an epoch
+// NOTE: Requests without a consumer ID share a single anonymous quota
+func (t *Tracker) Consume(consumerID string, computeUnits int64) error {
+	_, err := t.consume(consumerID, computeUnits)
+	return err
+}
+
+// Charge is Consume returning a func that refunds the charge, e.g. when the request it paid for fails
+// A refund once the epoch has ended does nothing, the usage it was charged to is gone
+func (t *Tracker) Charge(consumerID string, computeUnits int64) (refund func(), err error) {
+	epoch, err := t.consume(consumerID, computeUnits)
+	if err != nil {
+		return nil, err
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if u, ok := t.usage[consumerID]; ok && u.epoch == epoch {
+				u.requests--
+				u.computeUnits -= computeUnits
+			}
+		})
+	}, nil
+}
+
+// consume charges the consumer's quota, see Consume, and returns the epoch it was charged to
+func (t *Tracker) consume(consumerID string, computeUnits int64) (uint64, error) {
+	if computeUnits < 0 {
+		return 0, fmt.Errorf("%w, got %d", ErrNegativeComputeUnits, computeUnits)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	epoch := utils.Epoch(now, t.epochLength)
+	if t.swept != epoch {
+		t.swept = epoch
+		for id, u := range t.usage {
+			if u.epoch != epoch {
+				delete(t.usage, id)
+			}
+		}
+	}
+
+	u, ok := t.usage[consumerID]
+	if !ok || u.epoch != epoch {
+		// First request of the epoch, start from a clean slate
+		u = &usage{epoch: epoch}
+		t.usage[consumerID] = u
+	}
+
+	limits := t.limitsFor(consumerID)
+	retryAfter := utils.EpochEnd(now, t.epochLength).Sub(now)
+	if limits.MaxRequests > 0 && u.requests+1 > limits.MaxRequests {
+		return 0, &ExceededError{ConsumerID: consumerID, Limit: "requests", RetryAfter: retryAfter}
+	}
+	if limits.MaxComputeUnits > 0 && computeUnits > limits.MaxComputeUnits-u.computeUnits {
+		// Compared against the headroom left, as the sum could overflow
+		return 0, &ExceededError{ConsumerID: consumerID, Limit: "compute_units", RetryAfter: retryAfter}
+	}
+
+	u.requests++
+	u.computeUnits += computeUnits
+	return epoch, nil
+}
+
+// Usage returns the requests and compute units consumed in the current epoch
+func (t *Tracker) Usage(consumerID string) (requests, computeUnits int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u, ok := t.usage[consumerID]
+	if !ok || u.epoch != utils.Epoch(t.now(), t.epochLength) {
+		return 0, 0
+	}
+	return u.requests, u.computeUnits
+}
+
+// Snapshot returns a copy of the per-consumer limit overrides and usage
+func (t *Tracker) Snapshot() Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	snapshot := Snapshot{
+		EpochLength: t.epochLength,
+		Overrides:   make(map[string]Limits, len(t.overrides)),
+		Usage:       make(map[string]Usage, len(t.usage)),
+	}
+	for id, limits := range t.overrides {
+		snapshot.Overrides[id] = limits
+	}
+	for id, u := range t.usage {
+		snapshot.Usage[id] = Usage{Epoch: u.epoch, Requests: u.requests, ComputeUnits: u.computeUnits}
+	}
+	return snapshot
+}
+
+// Restore replaces the tracker's overrides and usage with a snapshot, so a restart doesn't hand every
+// consumer a fresh quota
+// Usage is only restored if the snapshot was taken with the same epoch length, as epochs wouldn't line up
+// otherwise; usage of past epochs is harmless and reset on the consumer's next request
+func (t *Tracker) Restore(snapshot Snapshot) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.overrides = make(map[string]Limits, len(snapshot.Overrides))
+	for id, limits := range snapshot.Overrides {
+		t.overrides[id] = limits
+	}
+	t.usage = make(map[string]*usage, len(snapshot.Usage))
+	if snapshot.EpochLength != t.epochLength {
+		return
+	}
+	for id, u := range snapshot.Usage {
+		t.usage[id] = &usage{epoch: u.Epoch, requests: u.Requests, computeUnits: u.ComputeUnits}
+	}
+}
+
+// limitsFor returns the effective limits for a consumer
+// NOTE: Must be called with t.mu held
+func (t *Tracker) limitsFor(consumerID string) Limits {
+	if limits, ok := t.overrides[consumerID]; ok {
+		return limits
+	}
+	return t.defaults
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("consumer %q exceeded its %s quota, retry after %s", e.ConsumerID, e.Limit, e.RetryAfter.Round(time.Second))
+}
+
+func (e *ExceededError) Unwrap() error { return ErrQuotaExceeded }
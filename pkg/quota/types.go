@@ -0,0 +1,62 @@
+package quota
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQuotaExceeded is the sentinel wrapped by every ExceededError, for use with errors.Is
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrNegativeComputeUnits is returned by Tracker.Consume for negative compute units, which would refund quota
+	ErrNegativeComputeUnits = errors.New("compute units must not be negative")
+)
+
+// Limits caps what a single consumer can obtain within one epoch
+// A zero value for a field means that dimension is unlimited
+type Limits struct {
+	MaxRequests     int64 `json:"max_requests"`
+	MaxComputeUnits int64 `json:"max_compute_units"`
+}
+
+// ExceededError is returned when a consumer has used up its quota for the current epoch
+// RetryAfter tells the caller how long until the quota resets, so it can throttle instead of giving up
+type ExceededError struct {
+	ConsumerID string
+	Limit      string // Which limit was hit ("requests" or "compute_units")
+	RetryAfter time.Duration
+}
+
+// Tracker accounts pairing requests and compute units per consumer per epoch
+// It is safe for concurrent use
+type Tracker struct {
+	mu          sync.Mutex
+	epochLength time.Duration
+	defaults    Limits
+	overrides   map[string]Limits // Per-consumer limits replacing the defaults
+	usage       map[string]*usage
+	swept       uint64 // Epoch whose idle consumers were last evicted from usage
+	now         func() time.Time
+}
+
+// usage is a consumer's consumption within a single epoch
+type usage struct {
+	epoch        uint64
+	requests     int64
+	computeUnits int64
+}
+
+// Snapshot is the persistable state of a Tracker, see Tracker.Snapshot
+type Snapshot struct {
+	EpochLength time.Duration     `json:"epoch_length"` // Epoch length the usage was accounted with
+	Overrides   map[string]Limits `json:"overrides,omitempty"`
+	Usage       map[string]Usage  `json:"usage,omitempty"`
+}
+
+// Usage is a consumer's consumption within a single epoch
+type Usage struct {
+	Epoch        uint64 `json:"epoch"`
+	Requests     int64  `json:"requests"`
+	ComputeUnits int64  `json:"compute_units"`
+}
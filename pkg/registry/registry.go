@@ -0,0 +1,244 @@
+package registry
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/address"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// NewRegistry creates an empty Registry enforcing the given config
+func NewRegistry(cfg Config) *Registry {
+	return &Registry{
+		cfg:    cfg,
+		chains: make(map[string]*chain),
+		now:    time.Now,
+	}
+}
+
+// Subscribe registers fn to be called for every rejected insert or update
+// Events are delivered synchronously, so fn should return quickly
+func (r *Registry) Subscribe(fn func(RejectionEvent)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subscribers = append(r.subscribers, fn)
+}
+
+// Watch registers fn to be called with the chain ID after every change to a chain's providers, once per Load
+// Changes are announced synchronously, so fn should return quickly (e.g. by scheduling the work it triggers)
+func (r *Registry) Watch(fn func(chainID string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.watchers = append(r.watchers, fn)
+}
+
+// Upsert inserts the provider into the chain, or updates the provider registered with the same ID
+// It returns an error wrapping ErrRejected, and emits a RejectionEvent, if the provider breaks an invariant;
+// the chain is then left unchanged. A provider without an ID, or whose address isn't in its normalized form, is
+// stored as a copy with its ID derived from its address (see pairing.DeriveProviderID) and its address normalized
+func (r *Registry) Upsert(chainID string, p *pairing.Provider) error {
+	if err := r.upsert(chainID, p, nil); err != nil {
+		return err
+	}
+	r.changed(chainID)
+	return nil
+}
+
+// upsert is Upsert, rejecting providers whose ID is in batch and adding the ID of accepted ones when batch is set
+func (r *Registry) upsert(chainID string, p *pairing.Provider, batch map[string]bool) error {
+	r.mu.Lock()
+	c := r.chain(chainID)
+	p, reason := r.check(c, chainID, p)
+	if reason == "" && batch[p.ID] {
+		reason = "duplicate provider ID in batch"
+	}
+	if reason != "" {
+		subscribers := r.subscribers
+		r.mu.Unlock()
+		return r.reject(subscribers, chainID, p, reason)
+	}
+	if i, ok := c.byID[p.ID]; ok {
+		delete(c.addresses, c.providers[i].Address)
+		c.providers[i] = p
+	} else {
+		c.byID[p.ID] = len(c.providers)
+		c.providers = append(c.providers, p)
+	}
+	c.addresses[p.Address] = p.ID
+	c.version++
+	c.updatedAt = r.now()
+	if batch != nil {
+		batch[p.ID] = true
+	}
+	r.mu.Unlock()
+	return nil
+}
+
+// Load upserts every provider into the chain, e.g. from a Lava export, and returns the errors of the rejected
+// ones (see Upsert), nil if all were accepted
+// A provider listed twice is rejected the second time instead of overwriting the first
+func (r *Registry) Load(chainID string, providers []*pairing.Provider) []error {
+	var errs []error
+	batch := make(map[string]bool, len(providers))
+	for _, p := range providers {
+		if err := r.upsert(chainID, p, batch); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(batch) > 0 {
+		r.changed(chainID)
+	}
+	return errs
+}
+
+// Remove deletes a provider from the chain, reporting whether it was registered
+func (r *Registry) Remove(chainID, providerID string) bool {
+	if !r.remove(chainID, providerID) {
+		return false
+	}
+	r.changed(chainID)
+	return true
+}
+
+// remove is Remove, without announcing the change
+func (r *Registry) remove(chainID, providerID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.chains[chainID]
+	if !ok {
+		return false
+	}
+	i, ok := c.byID[providerID]
+	if !ok {
+		return false
+	}
+	delete(c.addresses, c.providers[i].Address)
+	delete(c.byID, providerID)
+	c.providers = append(c.providers[:i:i], c.providers[i+1:]...) // Copy, so returned pools stay untouched
+	for j := i; j < len(c.providers); j++ {
+		c.byID[c.providers[j].ID] = j
+	}
+	c.version++
+	c.updatedAt = r.now()
+	return true
+}
+
+// Providers returns the providers registered for the chain, in registration order
+func (r *Registry) Providers(chainID string) ([]*pairing.Provider, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.chains[chainID]
+	if !ok {
+		return nil, nil
+	}
+	return append([]*pairing.Provider(nil), c.providers...), nil
+}
+
+// VersionedProviders returns the providers registered for the chain along with their version, which changes
+// whenever they do, see server.VersionedSource
+func (r *Registry) VersionedProviders(chainID string) ([]*pairing.Provider, string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.chains[chainID]
+	if !ok {
+		return nil, "0", nil
+	}
+	return append([]*pairing.Provider(nil), c.providers...), strconv.FormatUint(c.version, 10), nil
+}
+
+// Registered reports whether a provider with the given ID is registered on any chain, see
+// server.RegisteredSource
+func (r *Registry) Registered(providerID string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, c := range r.chains {
+		if _, ok := c.byID[providerID]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdatedAt returns when the providers of each chain last changed, by chain ID, see server.AgedSource
+func (r *Registry) UpdatedAt() map[string]time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	updated := make(map[string]time.Time, len(r.chains))
+	for chainID, c := range r.chains {
+		if !c.updatedAt.IsZero() {
+			updated[chainID] = c.updatedAt
+		}
+	}
+	return updated
+}
+
+// changed announces a change to the chain's providers to the watchers, see Watch
+func (r *Registry) changed(chainID string) {
+	r.mu.RLock()
+	watchers := r.watchers
+	r.mu.RUnlock()
+	for _, fn := range watchers {
+		fn(chainID)
+	}
+}
+
+// chain returns the chain's providers, registering the chain if needed
+// NOTE: Must be called with the write lock held
+func (r *Registry) chain(chainID string) *chain {
+	c, ok := r.chains[chainID]
+	if !ok {
+		c = &chain{byID: make(map[string]int), addresses: make(map[address.Address]string)}
+		r.chains[chainID] = c
+	}
+	return c
+}
+
+// check returns the provider to register in the chain, its address normalized and its ID derived when missing
+// (see pairing.DeriveProviderID), or why it can't be registered
+func (r *Registry) check(c *chain, chainID string, p *pairing.Provider) (*pairing.Provider, string) {
+	if p == nil {
+		return nil, "nil provider"
+	}
+	if r.cfg.Addresses != nil {
+		normalized, err := r.cfg.Addresses.Parse(string(p.Address))
+		if err != nil {
+			return p, err.Error()
+		}
+		if normalized != p.Address {
+			copied := *p
+			copied.Address = normalized
+			p = &copied
+		}
+	} else if p.Address == "" {
+		return p, "empty address"
+	}
+	if p.ID == "" {
+		copied := *p
+		copied.ID = pairing.DeriveProviderID(string(p.Address), chainID)
+		p = &copied
+	}
+	if err := p.Validate(); err != nil {
+		return p, err.Error()
+	}
+	if owner, ok := c.addresses[p.Address]; ok && owner != p.ID {
+		return p, fmt.Sprintf("address already registered by provider %q", owner)
+	}
+	if !r.cfg.AllowUnknownLocations && p.Geolocation == 0 && pairing.GeolocationOf(p.Location) == 0 {
+		return p, fmt.Sprintf("unknown location %q", p.Location)
+	}
+	return p, ""
+}
+
+// reject emits a RejectionEvent and returns the matching error
+func (r *Registry) reject(subscribers []func(RejectionEvent), chainID string, p *pairing.Provider, reason string) error {
+	event := RejectionEvent{ChainID: chainID, At: r.now(), Reason: reason}
+	if p != nil {
+		event.ProviderID, event.Address = p.ID, string(p.Address)
+	}
+	for _, fn := range subscribers {
+		fn(event)
+	}
+	return fmt.Errorf("%w: provider %q: %s", ErrRejected, event.ProviderID, reason)
+}
@@ -0,0 +1,51 @@
+package registry
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/address"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// ErrRejected is wrapped by the errors of rejected provider inserts and updates
+var ErrRejected = errors.New("provider rejected")
+
+// Config controls the invariants the registry enforces on top of pairing.Provider.Validate
+type Config struct {
+	// Addresses parses provider addresses, which are stored normalized; nil only requires a non-empty address
+	Addresses *address.Parser
+	// AllowUnknownLocations accepts providers located outside the known regions (see pairing.GeolocationOf)
+	AllowUnknownLocations bool
+}
+
+// RejectionEvent is emitted to subscribers whenever a provider insert or update is rejected
+type RejectionEvent struct {
+	ChainID    string
+	ProviderID string
+	Address    string
+	At         time.Time
+	Reason     string
+}
+
+// Registry stores the providers of each chain, enforcing their invariants (unique ID and address, address
+// format, valid stake and fee, known location) when they are inserted or updated, so invalid data never
+// reaches pairing. It serves as a server.VersionedSource and is safe for concurrent use
+type Registry struct {
+	mu          sync.RWMutex
+	cfg         Config
+	chains      map[string]*chain
+	subscribers []func(RejectionEvent)
+	watchers    []func(chainID string)
+	now         func() time.Time
+}
+
+// chain is the providers registered for a single chain
+type chain struct {
+	providers []*pairing.Provider        // In registration order
+	byID      map[string]int             // Provider ID -> index in providers
+	addresses map[address.Address]string // Address -> ID of the provider registered with it
+	version   uint64                     // Bumped on every change
+	updatedAt time.Time                  // Time of the last change
+}
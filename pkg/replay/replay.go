@@ -0,0 +1,176 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/snapshot"
+	"github.com/Yoaz/LavaPairingSystem/pkg/system"
+)
+
+// NewFileRecorder creates a FileRecorder writing to dir in the background, capturing the given state
+// components along with every recording; log reports the failed writes (slog.Default() when nil)
+func NewFileRecorder(dir string, state snapshot.Components, log *slog.Logger) *FileRecorder {
+	if log == nil {
+		log = slog.Default()
+	}
+	r := &FileRecorder{
+		dir:   dir,
+		state: state,
+		log:   log,
+		queue: make(chan pendingFile, DefaultQueueSize),
+		done:  make(chan struct{}),
+	}
+	go r.write()
+	return r
+}
+
+// Capture takes a snapshot of the recorder's state components, nil without any, see system.Recorder
+func (r *FileRecorder) Capture() any {
+	if r.state == (snapshot.Components{}) {
+		return nil
+	}
+	return r.state.Capture()
+}
+
+// Record encodes the recording with the state captured for it and queues it for writing, see system.Recorder
+func (r *FileRecorder) Record(rec *system.Recording, state any) error {
+	file := File{Version: CurrentVersion, Recording: rec}
+	file.State, _ = state.(*snapshot.State)
+	data, err := json.Marshal(file) // Now, callers may modify the providers once the call returned
+	if err != nil {
+		return fmt.Errorf("encode recording: %w", err)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return ErrRecorderClosed
+	}
+	select {
+	case r.queue <- pendingFile{name: fileName(rec.Options.Now), data: data}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close stops accepting recordings and waits for the queued ones to be written
+func (r *FileRecorder) Close() error {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.queue)
+	}
+	r.mu.Unlock()
+	<-r.done
+	return nil
+}
+
+// write writes the queued recordings until the recorder is closed
+func (r *FileRecorder) write() {
+	defer close(r.done)
+	for pending := range r.queue {
+		if err := r.writeFile(pending); err != nil {
+			r.log.Warn("Failed to write pairing call recording", "file", pending.name, "error", err)
+		}
+	}
+}
+
+// writeFile writes an encoded recording to the recorder's directory
+// The file is replaced atomically, a crash mid-write never leaves a truncated recording behind
+func (r *FileRecorder) writeFile(pending pendingFile) error {
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(r.dir, pending.name)
+	tmp, err := os.CreateTemp(r.dir, pending.name+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	if _, err := tmp.Write(pending.data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load reads a recording file written by FileRecorder
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file File
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("decode recording %s: %w", path, err)
+	}
+	if file.Version != CurrentVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, file.Version)
+	}
+	if file.Recording == nil {
+		return nil, fmt.Errorf("decode recording %s: no recording", path)
+	}
+	return &file, nil
+}
+
+// Replay re-runs a recorded call on the system with the recorded inputs, seed and clock, and compares the
+// outcome to the recorded one
+// selection is the call-level strategy the recorded call ran with (see system.RecordedOptions.Selection), nil
+// when it used the system's
+// The system must be in the state the call was paired against (see File.State), with its time-dependent
+// filters and scorers pinned to the recorded clock; the state the system keeps itself (see
+// system.ReplayPreparer) is restored from the recording
+func Replay(ps system.PairingSystem, rec *system.Recording, selection system.SelectionStrategy) (*Report, error) {
+	if selection != nil && selection.Name() != rec.Options.Selection || selection == nil && rec.Options.Selection != "" {
+		return nil, fmt.Errorf("recorded call selected with %q, replay must pass the same strategy", rec.Options.Selection)
+	}
+	if preparer, ok := ps.(system.ReplayPreparer); ok {
+		preparer.PrepareReplay(rec)
+	}
+	opts := rec.Options.PairingOptions(rec.RequestID, selection)
+	var result *system.PairingResult
+	var err error
+	runner, staged := ps.(system.StageRunner)
+	switch {
+	case rec.Mode == system.ModeFull:
+		result, err = ps.GetPairingList(rec.Providers, rec.Policy, opts)
+	case rec.Mode == system.ModeScoreOnly && staged:
+		result, err = runner.ScoreOnly(rec.Providers, rec.Policy, opts)
+	case rec.Mode == system.ModeFilterOnly && staged:
+		result, err = runner.FilterOnly(rec.Providers, rec.Policy, opts)
+	default:
+		return nil, fmt.Errorf("can't replay pipeline mode %q on this system", rec.Mode)
+	}
+	report := &Report{
+		RequestID:   rec.RequestID,
+		ConfigMatch: ps.ConfigFingerprint() == rec.ConfigFingerprint,
+		Recorded:    rec.Outcome,
+		Replayed:    system.NewOutcome(result, err),
+	}
+	recorded, err := json.Marshal(report.Recorded)
+	if err != nil {
+		return nil, err
+	}
+	replayed, err := json.Marshal(report.Replayed)
+	if err != nil {
+		return nil, err
+	}
+	report.Match = bytes.Equal(recorded, replayed)
+	return report, nil
+}
+
+// fileName returns a unique recording file name for a call made at the given time, sorting by time
+func fileName(at time.Time) string {
+	return fmt.Sprintf("%s-%016x.json", at.UTC().Format("20060102T150405.000000000Z"), rand.Uint64())
+}
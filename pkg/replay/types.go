@@ -0,0 +1,69 @@
+package replay
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/snapshot"
+	"github.com/Yoaz/LavaPairingSystem/pkg/system"
+)
+
+// CurrentVersion is the recording file schema version written by FileRecorder
+const CurrentVersion = 1
+
+// DefaultQueueSize is the number of recordings a FileRecorder buffers while writing, when none is configured
+const DefaultQueueSize = 64
+
+var (
+	// ErrUnsupportedVersion is returned when a recording file was written with a schema version this code doesn't know
+	ErrUnsupportedVersion = errors.New("unsupported recording version")
+	// ErrQueueFull is returned by FileRecorder.Record when it is still writing DefaultQueueSize recordings, the
+	// recording is dropped rather than delaying the call
+	ErrQueueFull = errors.New("recording queue full")
+	// ErrRecorderClosed is returned by FileRecorder.Record once the recorder is closed
+	ErrRecorderClosed = errors.New("recorder closed")
+)
+
+// File is a recorded call as persisted by FileRecorder: its inputs and outcome, and the service state it was
+// paired against (jail terms, uptime, metric history, anomaly inspections, ...), nil when none was captured
+type File struct {
+	Version   int               `json:"version"`
+	Recording *system.Recording `json:"recording"`
+	State     *snapshot.State   `json:"state,omitempty"`
+}
+
+// FileRecorder is a system.Recorder writing every recording to its own JSON file in a directory, named with an
+// ID of its own: the time of the call and a random suffix, so recordings never overwrite each other whatever
+// request IDs callers send
+// State components are captured right before every recorded call, so replays pair against the same state.
+// Recordings are encoded on the request path and written by a background goroutine, dropped when it falls
+// behind. Build it with NewFileRecorder and Close it to flush the pending writes
+type FileRecorder struct {
+	dir   string
+	state snapshot.Components
+	log   *slog.Logger
+
+	mu     sync.RWMutex // Guards closed against concurrent Record and Close
+	closed bool
+	queue  chan pendingFile
+	done   chan struct{} // Closed once the writer drained the queue
+}
+
+// pendingFile is an encoded recording waiting to be written
+type pendingFile struct {
+	name string
+	data []byte
+}
+
+// Report compares a replayed call's outcome to the recorded one
+type Report struct {
+	RequestID string
+	// Match is set when both outcomes encode to the same JSON, byte for byte
+	Match bool
+	// ConfigMatch is set when the replaying system's configuration fingerprint is the recorded one, a replay
+	// against another configuration isn't expected to match
+	ConfigMatch bool
+	Recorded    system.Outcome
+	Replayed    system.Outcome
+}
@@ -0,0 +1,337 @@
+package scheduler
+
+import (
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/internal/utils"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/system"
+)
+
+// NewScheduler creates a new Scheduler re-pairing registered consumers every interval (e.g. the epoch length)
+// with the given system, over providers supplied by source
+func NewScheduler(ps system.PairingSystem, source ProviderSource, interval time.Duration, logger *slog.Logger, opts ...Option) *Scheduler {
+	// Ensure logger is not nil, provide a default discard logger if it is
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	s := &Scheduler{
+		system:        ps,
+		source:        source,
+		interval:      interval,
+		logger:        logger,
+		changes:       make(chan struct{}, 1),
+		subscriptions: make(map[string]*subscription),
+		dirty:         make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// WithSlashSource attaches pending slashes from the given source to providers before re-pairing, as
+// server.WithSlashSource does for requests, so pushed selections match those requests get
+func WithSlashSource(source pairing.SlashSource) Option {
+	return func(s *Scheduler) {
+		s.slashes = source
+	}
+}
+
+/* ***********************************************************************
+ *                             SUBSCRIPTIONS                             *
+ *********************************************************************** */
+
+// Register re-pairs the policy under key, calling notify whenever the selected provider set changes;
+// registering an existing key replaces its subscription
+// The consumer is paired right away, so notify receives the initial selection before Register returns unless
+// that pairing fails. Notifications are delivered in order, one at a time; changes made while notify runs are
+// merged into a single update. notify may call Unregister, and a notification already being delivered may
+// complete after Unregister returns
+// NOTE: Every re-pairing is a regular GetPairingList call, charged against the consumer's quota if any
+func (s *Scheduler) Register(key string, policy *pairing.ConsumerPolicy, notify func(Update)) {
+	s.add(key, &subscription{policy: policy, notify: notify})
+}
+
+// Subscribe is Register delivering updates on a channel with the given buffer, closed by Unregister
+// Updates are dropped (and logged) rather than blocking the scheduler when the channel is full; the next
+// change carries the full selection anyway
+func (s *Scheduler) Subscribe(key string, policy *pairing.ConsumerPolicy, buffer int) <-chan Update {
+	ch := make(chan Update, buffer)
+	var mu sync.Mutex // Keeps a notification in flight from sending on the closed channel
+	closed := false
+	s.add(key, &subscription{
+		policy: policy,
+		notify: func(u Update) {
+			mu.Lock()
+			defer mu.Unlock()
+			if closed {
+				return
+			}
+			select {
+			case ch <- u:
+			default:
+				s.logger.Warn("Dropped pairing update, subscriber is not keeping up", "key", u.Key)
+			}
+		},
+		onClose: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			closed = true
+			close(ch)
+		},
+	})
+	return ch
+}
+
+// Unregister stops re-pairing the consumer registered under key
+func (s *Scheduler) Unregister(key string) {
+	s.mu.Lock()
+	sub, ok := s.subscriptions[key]
+	delete(s.subscriptions, key)
+	s.mu.Unlock()
+	if ok {
+		sub.close()
+	}
+}
+
+// Len returns the number of registered consumers
+func (s *Scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subscriptions)
+}
+
+// add registers a subscription, replacing any previous one under key, and pairs it right away
+func (s *Scheduler) add(key string, sub *subscription) {
+	s.mu.Lock()
+	previous, replaced := s.subscriptions[key]
+	s.subscriptions[key] = sub
+	s.mu.Unlock()
+	if replaced {
+		previous.close()
+	}
+	s.refresh(key, sub, time.Now(), make(map[string][]*pairing.Provider))
+}
+
+// close stops notifying the subscription, waiting for a running refresh to finish
+// A notification already being delivered isn't waited for, it may call close itself
+func (sub *subscription) close() {
+	sub.running.Lock()
+	if sub.closed {
+		sub.running.Unlock()
+		return
+	}
+	sub.closed = true
+	sub.pending = nil
+	sub.running.Unlock()
+	if sub.onClose != nil {
+		sub.onClose()
+	}
+}
+
+/* ***********************************************************************
+ *                               LIFECYCLE                               *
+ *********************************************************************** */
+
+// Start re-pairs every registered consumer at each interval boundary until Stop is called
+// Starting a running scheduler is a no-op
+func (s *Scheduler) Start() {
+	if s.interval <= 0 {
+		s.logger.Error("Scheduler not started, interval must be positive", "interval", s.interval)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		return
+	}
+	s.stop, s.done = make(chan struct{}), make(chan struct{})
+	go s.run(s.stop, s.done)
+}
+
+// Stop stops the periodic re-pairing and waits for a running one to finish
+// Subscriptions are kept, so the scheduler can be started again
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+// Refresh re-pairs every registered consumer now, notifying those whose selection changed
+func (s *Scheduler) Refresh() {
+	s.mu.Lock()
+	clear(s.dirty) // Their consumers are re-paired along with the others
+	s.mu.Unlock()
+	count := s.refreshWhere(func(string) bool { return true })
+	s.logger.Debug("Re-paired registered consumers", "count", count)
+}
+
+// Changed re-pairs the consumers of the chain as soon as possible instead of at the next interval boundary,
+// e.g. when its providers changed (see registry.Registry.Watch)
+// It only schedules the re-pairing, so it can be called while the providers are being written: the running
+// scheduler re-pairs the changed chains in the background, coalescing changes made in the meantime
+func (s *Scheduler) Changed(chainID string) {
+	s.mu.Lock()
+	s.dirty[chainID] = true
+	s.mu.Unlock()
+	select {
+	case s.changes <- struct{}{}:
+	default: // Already signaled, the pending re-pairing covers this chain too
+	}
+}
+
+// refreshChanged re-pairs the consumers of the chains that changed since they were last re-paired
+func (s *Scheduler) refreshChanged() {
+	s.mu.Lock()
+	dirty := s.dirty
+	s.dirty = make(map[string]bool)
+	s.mu.Unlock()
+	if len(dirty) == 0 {
+		return
+	}
+	count := s.refreshWhere(func(chainID string) bool { return dirty[chainID] })
+	s.logger.Debug("Re-paired consumers of changed chains", "chains", len(dirty), "count", count)
+}
+
+// refreshWhere re-pairs the consumers of the chains matching the predicate, returning how many it re-paired
+func (s *Scheduler) refreshWhere(match func(chainID string) bool) int {
+	s.mu.Lock()
+	keys := make([]string, 0, len(s.subscriptions))
+	subs := make([]*subscription, 0, len(s.subscriptions))
+	for key, sub := range s.subscriptions {
+		if match(sub.policy.ChainID) {
+			keys = append(keys, key)
+			subs = append(subs, sub)
+		}
+	}
+	s.mu.Unlock()
+
+	now := time.Now()
+	providers := make(map[string][]*pairing.Provider) // Chain ID -> providers, fetched once per refresh
+	for i, sub := range subs {
+		s.refresh(keys[i], sub, now, providers)
+	}
+	return len(subs)
+}
+
+// run calls Refresh at every interval boundary, and re-pairs the consumers of changed chains in between, until
+// stop is closed
+func (s *Scheduler) run(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	for {
+		timer := time.NewTimer(time.Until(utils.EpochEnd(time.Now(), s.interval)))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-s.changes:
+			timer.Stop()
+			s.refreshChanged()
+		case <-timer.C:
+			s.Refresh()
+		}
+	}
+}
+
+// refresh re-pairs a single subscription and notifies it if its selected provider set changed
+// providers caches the provider pools fetched so far, by chain ID
+// A failed pairing keeps the previous selection, the consumer is retried at the next refresh
+func (s *Scheduler) refresh(key string, sub *subscription, now time.Time, providers map[string][]*pairing.Provider) {
+	if s.repair(key, sub, now, providers) {
+		s.deliver(sub)
+	}
+}
+
+// repair re-pairs a single subscription, queueing an update if its selected provider set changed
+// It reports whether the caller must deliver the pending updates, no other refresh delivering them already
+func (s *Scheduler) repair(key string, sub *subscription, now time.Time, providers map[string][]*pairing.Provider) bool {
+	sub.running.Lock()
+	defer sub.running.Unlock()
+	if sub.closed {
+		return false
+	}
+
+	chainID := sub.policy.ChainID
+	pool, ok := providers[chainID]
+	if !ok {
+		var err error
+		if pool, err = s.source.Providers(chainID); err != nil {
+			s.logger.Warn("Failed to load providers for re-pairing", "key", key, "chain_id", chainID, "error", err)
+			return false
+		}
+		if s.slashes != nil {
+			if slashes, err := s.slashes.PendingSlashes(); err != nil {
+				// Re-pairing on nominal stake beats keeping a selection the change made stale
+				s.logger.Warn("Failed to load pending slashes, using nominal stake", "chain_id", chainID, "error", err)
+			} else {
+				pool = utils.AttachSlashInfo(pool, slashes)
+			}
+		}
+		providers[chainID] = pool
+	}
+
+	result, err := s.system.GetPairingList(pool, sub.policy)
+	if err != nil {
+		s.logger.Warn("Re-pairing failed, keeping the previous selection", "key", key, "error", err)
+		return false
+	}
+	if sub.paired && sameProviders(sub.selected, result.Providers) {
+		s.logger.Debug("Pairing unchanged", "key", key)
+		return false
+	}
+
+	update := Update{Key: key, At: now, Previous: sub.selected, Current: result.Providers, Result: result}
+	if sub.pending != nil {
+		update.Previous = sub.pending.Previous // Merged with the update not delivered yet
+	}
+	sub.selected, sub.paired, sub.pending = result.Providers, true, &update
+	s.logger.Debug("Pairing changed, notifying consumer", "key", key, "previous_count", len(update.Previous), "current_count", len(update.Current))
+	if sub.delivering {
+		return false
+	}
+	sub.delivering = true
+	return true
+}
+
+// deliver notifies the subscription of its pending updates until none is left, without holding its lock
+func (s *Scheduler) deliver(sub *subscription) {
+	for {
+		sub.running.Lock()
+		update := sub.pending
+		sub.pending = nil
+		if update == nil || sub.closed {
+			sub.delivering = false
+			sub.running.Unlock()
+			return
+		}
+		sub.running.Unlock()
+		sub.notify(*update)
+	}
+}
+
+// sameProviders reports whether two selections hold the same set of providers, regardless of order
+func sameProviders(a, b []*pairing.Provider) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	ids := make(map[string]bool, len(a))
+	for _, p := range a {
+		ids[p.ID] = true
+	}
+	for _, p := range b {
+		if !ids[p.ID] {
+			return false
+		}
+	}
+	return true
+}
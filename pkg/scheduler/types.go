@@ -0,0 +1,60 @@
+package scheduler
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/system"
+)
+
+// ProviderSource supplies the pool of providers a consumer is re-paired against
+// It has the same shape as server.ProviderSource, so any API server source can be reused
+type ProviderSource interface {
+	Providers(chainID string) ([]*pairing.Provider, error)
+}
+
+// Update is delivered to a subscription whenever its consumer's selected provider set changes
+type Update struct {
+	Key      string              // Key the subscription was registered under
+	At       time.Time           // When the pairing ran
+	Previous []*pairing.Provider // Previously selected providers, empty on the first pairing
+	Current  []*pairing.Provider // Newly selected providers, best first
+	Result   *system.PairingResult
+}
+
+// Option configures optional Scheduler behaviour
+type Option func(*Scheduler)
+
+// Scheduler periodically re-runs pairing for registered consumers and notifies them only when their selected
+// provider set changes, so clients can keep their provider connections fresh without polling
+// Runs are aligned to epoch boundaries of the configured interval, and the consumers of a chain are also re-paired
+// as soon as its providers change (see Changed). It is safe for concurrent use
+type Scheduler struct {
+	system   system.PairingSystem
+	source   ProviderSource
+	interval time.Duration
+	logger   *slog.Logger
+	slashes  pairing.SlashSource // Optional, attaches pending slashes to providers before re-pairing
+	changes  chan struct{}       // Signals the running scheduler that chains changed, see Changed
+
+	mu            sync.Mutex
+	subscriptions map[string]*subscription
+	dirty         map[string]bool // Chain IDs whose providers changed since their consumers were last re-paired
+	stop          chan struct{}
+	done          chan struct{}
+}
+
+// subscription is a consumer registered for re-pairing
+type subscription struct {
+	policy     *pairing.ConsumerPolicy
+	notify     func(Update)
+	onClose    func()              // Optional, called once when the subscription is removed
+	running    sync.Mutex          // Serializes refreshes, never held while notifying
+	selected   []*pairing.Provider // Last selection the consumer was notified of, guarded by running
+	paired     bool                // Whether the consumer was notified at least once, guarded by running
+	closed     bool                // Guarded by running
+	pending    *Update             // Update not delivered yet, guarded by running
+	delivering bool                // Whether a refresh is delivering the pending updates, guarded by running
+}
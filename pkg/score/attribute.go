@@ -0,0 +1,127 @@
+package score
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// valueKeyPrefix prefixes the AttributeRanges keys of values requested by AttributeScorer, keeping them apart
+// from provider attribute names
+const valueKeyPrefix = "scorer."
+
+/* ***********************************************************************
+ *                             NORMALIZATION                             *
+ *********************************************************************** */
+
+// Normalize maps value onto [0, 1] relative to the [min, max] range, clamping values outside of it
+// A degenerate range (min == max) yields 1, every provider then being equally good
+func Normalize[T Number](value, min, max T) float64 {
+	if max <= min {
+		return 1.0
+	}
+	return clamp01((float64(value) - float64(min)) / (float64(max) - float64(min)))
+}
+
+// NormalizeToMax maps a non-negative value onto [0, 1] as a share of max, clamping values above it
+// A non-positive max yields 1
+func NormalizeToMax[T Number](value, max T) float64 {
+	if max <= 0 {
+		return 1.0
+	}
+	return clamp01(float64(value) / float64(max))
+}
+
+// normalizeRange normalizes value against a pool range and orients it so that 1 is always best
+// A pool without spread scores everyone 1, whatever the direction
+func normalizeRange(value float64, r AttributeRange, normalization Normalization, direction Direction) float64 {
+	var normalized float64
+	switch normalization {
+	case NormalizeMax:
+		if r.Max <= 0 {
+			return 1.0
+		}
+		normalized = NormalizeToMax(value, r.Max)
+	default:
+		if r.Max == r.Min {
+			return 1.0
+		}
+		normalized = Normalize(value, r.Min, r.Max)
+	}
+	if direction == LowerIsBetter {
+		return 1.0 - normalized
+	}
+	return normalized
+}
+
+// clamp01 clamps v to [0, 1], mapping NaN to 0
+func clamp01(v float64) float64 {
+	if math.IsNaN(v) {
+		return 0.0
+	}
+	return math.Max(0, math.Min(1, v))
+}
+
+/* ***********************************************************************
+ *                            ATTRIBUTE SCORER                           *
+ *********************************************************************** */
+
+// NewAttributeScorer validates an AttributeScorer definition and applies its defaults
+func NewAttributeScorer[T Number](name string, value func(*pairing.Provider) (T, bool), direction Direction, normalization Normalization) (*AttributeScorer[T], error) {
+	if name == "" {
+		return nil, fmt.Errorf("attribute scorer: missing name")
+	}
+	if value == nil {
+		return nil, fmt.Errorf("attribute scorer %s: missing value function", name)
+	}
+	switch direction {
+	case "":
+		direction = HigherIsBetter
+	case HigherIsBetter, LowerIsBetter:
+	default:
+		return nil, fmt.Errorf("attribute scorer %s: unknown direction %q", name, direction)
+	}
+	switch normalization {
+	case "":
+		normalization = NormalizeMinMax
+	case NormalizeMinMax, NormalizeMax:
+	default:
+		return nil, fmt.Errorf("attribute scorer %s: unknown normalization %q", name, normalization)
+	}
+	return &AttributeScorer[T]{ScoreName: name, Value: value, Direction: direction, Normalization: normalization}, nil
+}
+
+// Score normalizes the provider's value against the range of values across the pool
+func (s *AttributeScorer[T]) Score(p *pairing.Provider, _ *pairing.ConsumerPolicy, ctx *PreScoreContext) float64 {
+	value, ok := s.Value(p)
+	if !ok {
+		return 0.0
+	}
+	r, ok := ctx.AttributeRanges[s.valueKey()]
+	if !ok {
+		return 0.0
+	}
+	return normalizeRange(float64(value), r, s.Normalization, s.Direction)
+}
+
+// Applicable reports whether the provider has a value
+func (s *AttributeScorer[T]) Applicable(p *pairing.Provider, _ *pairing.ConsumerPolicy, _ *PreScoreContext) bool {
+	_, ok := s.Value(p)
+	return ok
+}
+
+// RequiredValues requests the range of the scored value from the PreScoreContext
+func (s *AttributeScorer[T]) RequiredValues() map[string]func(*pairing.Provider) (float64, bool) {
+	return map[string]func(*pairing.Provider) (float64, bool){
+		s.valueKey(): func(p *pairing.Provider) (float64, bool) {
+			value, ok := s.Value(p)
+			return float64(value), ok
+		},
+	}
+}
+
+func (s *AttributeScorer[T]) Name() string { return s.ScoreName }
+
+// valueKey is the AttributeRanges key of the scored value
+func (s *AttributeScorer[T]) valueKey() string { return valueKeyPrefix + s.ScoreName }
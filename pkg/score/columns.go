@@ -0,0 +1,81 @@
+package score
+
+import "github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+
+// NewColumns lays out the providers in columns, computing effective stakes with the delegation factor
+func NewColumns(providers []*pairing.Provider, delegationFactor float64) *Columns {
+	cols := &Columns{
+		IDs:         make([]string, len(providers)),
+		Stakes:      make([]int64, len(providers)),
+		Fees:        make([]float64, len(providers)),
+		Commissions: make([]float64, len(providers)),
+		index:       make(map[string]int, len(providers)),
+	}
+	for i, p := range providers {
+		cols.IDs[i] = p.ID
+		cols.Stakes[i] = p.EffectiveStake(delegationFactor)
+		cols.Fees[i] = p.Fee
+		cols.Commissions[i] = p.Commission
+		cols.index[p.ID] = i
+	}
+	return cols
+}
+
+// Len returns the number of providers in the columns
+func (c *Columns) Len() int {
+	return len(c.IDs)
+}
+
+// Index returns the position of a provider in the columns
+func (c *Columns) Index(providerID string) (int, bool) {
+	i, ok := c.index[providerID]
+	return i, ok
+}
+
+// BatchScore returns the score a BatchScorer computed for a provider
+// It returns false if the scorer didn't score the pool in a batch or the provider isn't part of it
+func (ctx *PreScoreContext) BatchScore(scorerName, providerID string) (float64, bool) {
+	scores, ok := ctx.Batched[scorerName]
+	if !ok || ctx.Columns == nil {
+		return 0, false
+	}
+	i, ok := ctx.Columns.Index(providerID)
+	if !ok || i >= len(scores) {
+		return 0, false
+	}
+	return scores[i], true
+}
+
+/* ***********************************************************************
+ *                            BATCH SCORING                              *
+ *********************************************************************** */
+
+// ScoreBatch scores every provider's effective stake relative to the pool's maximum, see Score
+func (s *StakeScore) ScoreBatch(cols *Columns, _ *pairing.ConsumerPolicy, ctx *PreScoreContext, out []float64) {
+	for i, stake := range cols.Stakes {
+		out[i] = StakeShare(stake, ctx.MaxStake)
+	}
+}
+
+// ScoreBatch scores every provider's fee relative to the pool's maximum fee, see Score
+func (s *FeeScore) ScoreBatch(cols *Columns, _ *pairing.ConsumerPolicy, _ *PreScoreContext, out []float64) {
+	maxFee := 0.0
+	for _, fee := range cols.Fees {
+		if fee > maxFee {
+			maxFee = fee
+		}
+	}
+	if maxFee == 0 {
+		maxFee = 1 // Same as the normalized fees, see utils.ComputeNormalizedFees
+	}
+	for i, fee := range cols.Fees {
+		out[i] = InverseShare(fee/maxFee, 1)
+	}
+}
+
+// ScoreBatch scores every provider's commission, see Score
+func (s *CommissionScore) ScoreBatch(cols *Columns, _ *pairing.ConsumerPolicy, _ *PreScoreContext, out []float64) {
+	for i, commission := range cols.Commissions {
+		out[i] = CommissionShare(commission)
+	}
+}
@@ -0,0 +1,26 @@
+package score
+
+import (
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/policy"
+)
+
+// compiled returns the compiled policy of the context if it was compiled from the given policy, nil otherwise
+func (ctx *PreScoreContext) compiled(consumerPolicy *pairing.ConsumerPolicy) *policy.CompiledPolicy {
+	if ctx == nil || ctx.Policy == nil || ctx.Policy.Policy != consumerPolicy {
+		return nil
+	}
+	return ctx.Policy
+}
+
+// requiredFeatures returns the policy's required features, from its compiled policy when the context has it
+func requiredFeatures(consumerPolicy *pairing.ConsumerPolicy, ctx *PreScoreContext) map[string]struct{} {
+	if compiled := ctx.compiled(consumerPolicy); compiled != nil {
+		return compiled.Required
+	}
+	required := make(map[string]struct{}, len(consumerPolicy.RequiredFeatures))
+	for _, feature := range consumerPolicy.RequiredFeatures {
+		required[feature] = struct{}{}
+	}
+	return required
+}
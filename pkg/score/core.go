@@ -0,0 +1,152 @@
+package score
+
+import (
+	"math"
+	"sort"
+)
+
+/* ***********************************************************************
+ *                           PURE SCORING CORE                           *
+ *********************************************************************** */
+
+// The functions below hold the scoring math of the built-in scorers and of the weighting engine
+// They are pure (no logger, no shared state), always return a value within [0, 1], NaN inputs included, and are
+// monotonic in their inputs as documented, which makes them suitable targets for property-based and fuzz testing
+// None allocates except Combine, which sorts the component names to sum them in a fixed order; the pairing
+// system's hot path uses CombineVector instead
+
+// StakeShare scores a stake as a share of the pool's largest stake
+// Non-decreasing in stake; 0 when maxStake isn't positive
+func StakeShare(stake, maxStake int64) float64 {
+	if maxStake <= 0 {
+		return 0.0
+	}
+	return clamp01(float64(stake) / float64(maxStake))
+}
+
+// ExtraFeatureShare scores the value of a provider's extra features relative to its total number of features
+// Non-decreasing in extraValue; 0 when the provider has no features
+func ExtraFeatureShare(extraValue float64, totalFeatures int) float64 {
+	if totalFeatures <= 0 {
+		return 0.0
+	}
+	return clamp01(extraValue / float64(totalFeatures))
+}
+
+// LocationMatch scores an exact location match 1.0, a preferred location 0.75 and anything else 0.5
+func LocationMatch(exact, preferred bool) float64 {
+	switch {
+	case exact:
+		return 1.0
+	case preferred:
+		return 0.75
+	default:
+		return 0.5
+	}
+}
+
+// InverseShare scores a lower-is-better value linearly, 0 scoring 1.0 and max (or worse) scoring 0.0
+// Non-increasing in value; 1 when max isn't positive, every value then being equally good
+func InverseShare(value, max float64) float64 {
+	if max <= 0 {
+		return 1.0
+	}
+	return clamp01(1.0 - value/max)
+}
+
+// CommissionShare scores a commission percentage, 0% scoring 1.0 and 100% (or more) scoring 0.0
+// Non-increasing in commission
+func CommissionShare(commission float64) float64 {
+	return InverseShare(commission, 100)
+}
+
+// Rescale maps a score from a scorer's declared [min, max] range onto [0, 1], clamping values outside of it
+// Non-decreasing in value; 0 for a degenerate range (max <= min), which can't rank anything
+func Rescale(value, min, max float64) float64 {
+	if !(max > min) || math.IsInf(max-min, 0) {
+		return 0.0
+	}
+	return clamp01((value - min) / (max - min))
+}
+
+// Combine combines component scores into a final score
+// Without weights, it is the average of the components; with weights, the weighted sum of the weighted
+// components (components without a weight count 0), scaled back up to configuredWeight when some weighted
+// scorers weren't applicable
+// Components are summed in name order, so the result is bit-identical whatever the maps' iteration order; the
+// sorted names are allocated on every call
+// Non-decreasing in every component
+func Combine(components, weights map[string]float64, configuredWeight float64) float64 {
+	if len(weights) == 0 {
+		if len(components) == 0 {
+			return 0.0
+		}
+		var total float64
+		for _, name := range sortedNames(components) {
+			total += components[name]
+		}
+		return clamp01(total / float64(len(components)))
+	}
+
+	var weightedSum, appliedWeight float64
+	for _, name := range sortedNames(components) {
+		if weight, ok := weights[name]; ok {
+			weightedSum += components[name] * weight
+			appliedWeight += weight
+		}
+	}
+	// Scale the weights of applicable scorers back up to the weight of all configured ones
+	if appliedWeight > 0 && appliedWeight < configuredWeight {
+		weightedSum = weightedSum * configuredWeight / appliedWeight
+	}
+	return clamp01(weightedSum)
+}
+
+// CombineVector is Combine over components laid out by scorer position, applied telling which scorers have
+// a component, and weights laid out the same way, NaN where a scorer has no weight (nil averages the components)
+// Components are summed in position order, so a ranking's scores don't depend on which worker computed them
+func CombineVector(components []float64, applied []bool, weights []float64, configuredWeight float64) float64 {
+	if weights == nil {
+		var total float64
+		var count int
+		for i, s := range components {
+			if applied[i] {
+				total += s
+				count++
+			}
+		}
+		if count == 0 {
+			return 0.0
+		}
+		return clamp01(total / float64(count))
+	}
+
+	var weightedSum, appliedWeight float64
+	for i, s := range components {
+		if applied[i] && !math.IsNaN(weights[i]) {
+			weightedSum += s * weights[i]
+			appliedWeight += weights[i]
+		}
+	}
+	// Scale the weights of applicable scorers back up to the weight of all configured ones
+	if appliedWeight > 0 && appliedWeight < configuredWeight {
+		weightedSum = weightedSum * configuredWeight / appliedWeight
+	}
+	return clamp01(weightedSum)
+}
+
+// Adjust adds a policy adjustment to a final score, keeping it within [0, 1]
+// Non-decreasing in both score and adjustment
+func Adjust(score, adjustment float64) float64 {
+	return clamp01(score + adjustment)
+}
+
+// sortedNames returns the names of a map of scores, sorted, to accumulate them in a fixed order
+func sortedNames(m map[string]float64) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,156 @@
+package score
+
+import (
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/timeseries"
+)
+
+// Memoize wraps scorer so its score per provider is computed at most once every ttl
+// The wrapper keeps the scorer's name and forwards its optional interfaces (applicability, declared range,
+// requested aggregates, attributes and values)
+func Memoize(scorer Scorer, ttl time.Duration) *MemoizedScorer {
+	return &MemoizedScorer{
+		scorer:  scorer,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]*memoEntry),
+	}
+}
+
+// Score returns the provider's cached score, computing it if missing or expired
+// Scores of fallible scorers that failed are reported as 0, see TryScore
+func (m *MemoizedScorer) Score(p *pairing.Provider, policy *pairing.ConsumerPolicy, ctx *PreScoreContext) float64 {
+	value, _ := m.TryScore(p, policy, ctx)
+	return value
+}
+
+// TryScore returns the provider's cached score, computing it if missing or expired
+// Errors of fallible scorers are returned to every caller waiting on that computation but aren't cached, the
+// next call tries again. Callers stop waiting once ctx.Context is done, and waiters on a computation that
+// panicked get ErrScoreAborted while the panic propagates to the computing caller
+func (m *MemoizedScorer) TryScore(p *pairing.Provider, policy *pairing.ConsumerPolicy, ctx *PreScoreContext) (float64, error) {
+	m.mu.Lock()
+	now := m.now()
+	if entry, ok := m.entries[p.ID]; ok && (entry.expires.IsZero() || now.Before(entry.expires)) {
+		m.mu.Unlock()
+		return m.wait(entry, ctx)
+	}
+	entry := &memoEntry{ready: make(chan struct{})}
+	m.entries[p.ID] = entry
+	m.sweep(now)
+	m.mu.Unlock()
+
+	computed := false
+	defer func() {
+		if !computed {
+			entry.value, entry.err = 0, ErrScoreAborted
+		}
+		m.mu.Lock()
+		if entry.err != nil {
+			// Only drop our own entry, an invalidation may have made room for a newer computation
+			if m.entries[p.ID] == entry {
+				delete(m.entries, p.ID)
+			}
+		} else {
+			entry.expires = m.now().Add(m.ttl)
+		}
+		m.mu.Unlock()
+		close(entry.ready)
+	}()
+	entry.value, entry.err = m.compute(p, policy, ctx)
+	computed = true
+	return entry.value, entry.err
+}
+
+// wait returns the score of an in-flight (zero expiry) or cached entry, giving up once ctx.Context is done
+func (m *MemoizedScorer) wait(entry *memoEntry, ctx *PreScoreContext) (float64, error) {
+	var done <-chan struct{}
+	if ctx != nil && ctx.Context != nil {
+		done = ctx.Context.Done()
+	}
+	select {
+	case <-entry.ready:
+		return entry.value, entry.err
+	case <-done:
+		return 0, ctx.Context.Err()
+	}
+}
+
+// Len returns the number of cached and in-flight scores
+func (m *MemoizedScorer) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+// Invalidate drops the cached score of a provider, e.g. after it reported a configuration change
+func (m *MemoizedScorer) Invalidate(providerID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry, ok := m.entries[providerID]; ok && !entry.expires.IsZero() {
+		delete(m.entries, providerID)
+	}
+}
+
+// Applicable forwards to the wrapped scorer, which is always applicable if it doesn't report applicability
+func (m *MemoizedScorer) Applicable(p *pairing.Provider, policy *pairing.ConsumerPolicy, ctx *PreScoreContext) bool {
+	if reporter, ok := m.scorer.(ApplicabilityReporter); ok {
+		return reporter.Applicable(p, policy, ctx)
+	}
+	return true
+}
+
+// Range returns the wrapped scorer's declared range, see DeclaredRange
+func (m *MemoizedScorer) Range() (min, max float64) {
+	return DeclaredRange(m.scorer)
+}
+
+// RequiredAggregates forwards to the wrapped scorer, see AggregateRequester
+func (m *MemoizedScorer) RequiredAggregates() []timeseries.AggregateSpec {
+	if requester, ok := m.scorer.(AggregateRequester); ok {
+		return requester.RequiredAggregates()
+	}
+	return nil
+}
+
+// RequiredAttributes forwards to the wrapped scorer, see AttributeRequester
+func (m *MemoizedScorer) RequiredAttributes() []string {
+	if requester, ok := m.scorer.(AttributeRequester); ok {
+		return requester.RequiredAttributes()
+	}
+	return nil
+}
+
+// RequiredValues forwards to the wrapped scorer, see ValueRequester
+func (m *MemoizedScorer) RequiredValues() map[string]func(*pairing.Provider) (float64, bool) {
+	if requester, ok := m.scorer.(ValueRequester); ok {
+		return requester.RequiredValues()
+	}
+	return nil
+}
+
+func (m *MemoizedScorer) Name() string { return m.scorer.Name() }
+
+// compute scores a provider with the wrapped scorer, through TryScore for fallible scorers
+func (m *MemoizedScorer) compute(p *pairing.Provider, policy *pairing.ConsumerPolicy, ctx *PreScoreContext) (float64, error) {
+	if fallible, ok := m.scorer.(FallibleScorer); ok {
+		return fallible.TryScore(p, policy, ctx)
+	}
+	return m.scorer.Score(p, policy, ctx), nil
+}
+
+// sweep drops expired entries, at most once per TTL so the cache doesn't grow with departed providers
+// NOTE: Must be called with m.mu held
+func (m *MemoizedScorer) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < m.ttl {
+		return
+	}
+	m.lastSweep = now
+	for id, entry := range m.entries {
+		if !entry.expires.IsZero() && !now.Before(entry.expires) {
+			delete(m.entries, id)
+		}
+	}
+}
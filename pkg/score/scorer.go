@@ -0,0 +1,447 @@
+package score
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/timeseries"
+	"github.com/Yoaz/LavaPairingSystem/pkg/uptime"
+)
+
+// Aggregate returns the value of a requested rolling aggregate for a provider
+// It returns false if the aggregate wasn't computed or the provider has no samples for it
+func (ctx *PreScoreContext) Aggregate(spec timeseries.AggregateSpec, providerID string) (float64, bool) {
+	value, ok := ctx.Aggregates[spec.Key()][providerID]
+	return value, ok
+}
+
+// DeclaredRange returns the range of scores a scorer produces: its own if it implements RangeReporter,
+// [0, 1] otherwise
+func DeclaredRange(s Scorer) (min, max float64) {
+	if reporter, ok := s.(RangeReporter); ok {
+		return reporter.Range()
+	}
+	return 0, 1
+}
+
+/* ***********************************************************************
+ *                            STAKE SCORE                                *
+ *********************************************************************** */
+
+// Score calculates a normalized score based on the provider's effective stake (SelfStake plus
+// DelegationFactor×DelegatedStake, minus pending slashes) relative to the maximum effective stake observed
+// in the currently considered provider pool
+// The maxStake value is in the PreScoreContext, which is passed to the Score method
+// This allows the score to be calculated dynamically based on the current pool of providers
+func (s *StakeScore) Score(p *pairing.Provider, _ *pairing.ConsumerPolicy, ctx *PreScoreContext) float64 {
+	// Normalize stake: provider's effective stake / maximum effective stake in the pool
+	return StakeShare(p.EffectiveStake(ctx.DelegationFactor), ctx.MaxStake)
+}
+
+func (s *StakeScore) Name() string { return "StakeScore" }
+
+/* ***********************************************************************
+ *                            FEATURE SCORE                              *
+ *********************************************************************** */
+
+// Score calculates a score based on the extra features the provider offers beyond those required by the
+// policy, normalized by the total number of features the provider has
+// Each extra feature counts for its value in the policy's FeatureValues (1 if unlisted), capped to 1 overall
+func (s *FeatureScore) Score(p *pairing.Provider, policy *pairing.ConsumerPolicy, ctx *PreScoreContext) float64 {
+	extra := 0.0
+	required := requiredFeatures(policy, ctx)
+	for _, pf := range p.Features {
+		if _, ok := required[pf]; ok {
+			continue
+		}
+		if value, ok := policy.FeatureValues[pf]; ok {
+			extra += value
+		} else {
+			extra++
+		}
+	}
+	// Normalize score: value of extra features / total number of features
+	// Without FeatureValues this is the proportion of extra features; valuable features can push it past 1
+	return ExtraFeatureShare(extra, len(p.Features))
+}
+
+func (s *FeatureScore) Name() string { return "FeatureScore" }
+
+/* ***********************************************************************
+ *                            LOCATION SCORE                             *
+ *********************************************************************** */
+
+// Score assigns a perfect score (1.0) if the provider's location matches the required location, a slightly lower
+// score (0.75) if it matches one of the preferred locations, and a lower, fixed score (0.5) otherwise
+// Locations match when their geolocations intersect, or by name (case-insensitive) when either isn't a known region
+func (s *LocationScore) Score(p *pairing.Provider, policy *pairing.ConsumerPolicy, ctx *PreScoreContext) float64 {
+	served := p.GeolocationMask()
+	compiled := ctx.compiled(policy) // Geolocations resolved once for the pool, if available
+	var wanted pairing.Geolocation
+	if compiled != nil {
+		wanted = compiled.Geolocation
+	} else {
+		wanted = policy.GeolocationMask()
+	}
+	exact := locationMatches(served, wanted, p.Location, policy.RequiredLocation)
+	preferred := false
+	for i, location := range policy.PreferredLocations {
+		var geolocation pairing.Geolocation
+		if compiled != nil {
+			geolocation = compiled.Preferred[i]
+		} else {
+			geolocation = pairing.GeolocationOf(location)
+		}
+		preferred = preferred || locationMatches(served, geolocation, p.Location, location)
+	}
+	// Non-matching locations get an arbitrary lower score
+	// NOTE: A more sophisticated approach might consider geographic proximity or other factors
+	return LocationMatch(exact, preferred)
+}
+
+func (s *LocationScore) Name() string { return "LocationScore" }
+
+// locationMatches reports whether the served and wanted geolocations intersect, comparing the location names
+// instead when either geolocation is unknown
+// Nothing matches an empty wanted location, so providers without a location don't score as a match
+func locationMatches(served, wanted pairing.Geolocation, servedName, wantedName string) bool {
+	if served != 0 && wanted != 0 {
+		return served.Intersects(wanted)
+	}
+	return wantedName != "" && strings.EqualFold(servedName, wantedName)
+}
+
+/* ***********************************************************************
+ *                            FEE SCORE                                  *
+ *********************************************************************** */
+
+// Score calculates a score based on the provider's fee, normalized to a range of 0 to 1
+func (s *FeeScore) Score(provider *pairing.Provider, policy *pairing.ConsumerPolicy, ctx *PreScoreContext) float64 {
+	fee, ok := ctx.NormalizedFees[provider.ID]
+	if !ok {
+		return 0
+	}
+	return InverseShare(fee, 1) // Lower fee is better
+}
+
+func (s *FeeScore) Name() string { return "FeeScore" }
+
+/* ***********************************************************************
+ *                            COMMISSION SCORE                           *
+ *********************************************************************** */
+
+// Score calculates a score based on the provider's commission percentage, favoring providers that share
+// more of their rewards with delegators: 0% commission scores 1.0 and 100% scores 0.0
+func (s *CommissionScore) Score(p *pairing.Provider, _ *pairing.ConsumerPolicy, _ *PreScoreContext) float64 {
+	return CommissionShare(p.Commission)
+}
+
+func (s *CommissionScore) Name() string { return "CommissionScore" }
+
+/* ***********************************************************************
+ *                            PROXIMITY SCORE                            *
+ *********************************************************************** */
+
+// Score calculates a score based on the latency between the policy's RequiredLocation and the provider's location,
+// looked up in the latency matrix and scaled linearly so that 0ms scores 1.0 and MaxLatency (or worse) scores 0.0
+// NOTE: Region pairs missing from the matrix score 0.0, the same as the worst known latency
+func (s *ProximityScore) Score(p *pairing.Provider, policy *pairing.ConsumerPolicy, ctx *PreScoreContext) float64 {
+	if s.Matrix == nil {
+		return 0.0
+	}
+	ms, ok := s.Matrix.Latency(policy.RequiredLocation, p.Location)
+	if !ok {
+		return 0.0
+	}
+
+	maxLatency := s.MaxLatency
+	if maxLatency <= 0 {
+		maxLatency = s.Matrix.Max()
+	}
+	// If every known latency is 0, every provider is equally close
+	return InverseShare(ms, maxLatency)
+}
+
+// Applicable reports whether the matrix knows the latency between the consumer's and the provider's regions
+func (s *ProximityScore) Applicable(p *pairing.Provider, policy *pairing.ConsumerPolicy, _ *PreScoreContext) bool {
+	if s.Matrix == nil {
+		return false
+	}
+	_, ok := s.Matrix.Latency(policy.RequiredLocation, p.Location)
+	return ok
+}
+
+func (s *ProximityScore) Name() string { return "ProximityScore" }
+
+/* ***********************************************************************
+ *                            UPTIME SCORE                               *
+ *********************************************************************** */
+
+// Score returns the provider's uptime over the configured rolling window, which is already within [0, 1]
+// Providers that never sent a heartbeat score 0.0
+func (s *UptimeScore) Score(p *pairing.Provider, _ *pairing.ConsumerPolicy, _ *PreScoreContext) float64 {
+	if s.Tracker == nil {
+		return 0.0
+	}
+	window := s.Window
+	if window == 0 {
+		window = uptime.Window24h
+	}
+	value, ok := s.Tracker.Uptime(p.ID, window)
+	if !ok {
+		return 0.0
+	}
+	return clamp01(value)
+}
+
+// Applicable reports whether the provider has sent heartbeats within the window
+func (s *UptimeScore) Applicable(p *pairing.Provider, _ *pairing.ConsumerPolicy, _ *PreScoreContext) bool {
+	if s.Tracker == nil {
+		return false
+	}
+	window := s.Window
+	if window == 0 {
+		window = uptime.Window24h
+	}
+	_, ok := s.Tracker.Uptime(p.ID, window)
+	return ok
+}
+
+func (s *UptimeScore) Name() string { return "UptimeScore" }
+
+/* ***********************************************************************
+ *                            LATENCY SCORE                              *
+ *********************************************************************** */
+
+// Score calculates a score based on the EWMA of the provider's reported latency, scaled linearly so that
+// 0ms scores 1.0 and MaxLatency (or worse) scores 0.0
+// Providers without latency samples score 0.0
+func (s *LatencyScore) Score(p *pairing.Provider, _ *pairing.ConsumerPolicy, ctx *PreScoreContext) float64 {
+	ms, ok := ctx.Aggregate(s.aggregate(), p.ID)
+	if !ok || s.MaxLatency <= 0 {
+		return 0.0
+	}
+	return InverseShare(ms, s.MaxLatency)
+}
+
+// RequiredAggregates requests the latency EWMA from the PreScoreContext
+func (s *LatencyScore) RequiredAggregates() []timeseries.AggregateSpec {
+	return []timeseries.AggregateSpec{s.aggregate()}
+}
+
+// aggregate returns the latency aggregate this scorer is based on
+func (s *LatencyScore) aggregate() timeseries.AggregateSpec {
+	halfLife := s.HalfLife
+	if halfLife == 0 {
+		halfLife = 5 * time.Minute
+	}
+	return timeseries.AggregateSpec{Metric: timeseries.MetricLatency, Kind: timeseries.EWMA, Window: halfLife}
+}
+
+// Applicable reports whether latency samples exist for the provider
+func (s *LatencyScore) Applicable(p *pairing.Provider, _ *pairing.ConsumerPolicy, ctx *PreScoreContext) bool {
+	_, ok := ctx.Aggregate(s.aggregate(), p.ID)
+	return ok && s.MaxLatency > 0
+}
+
+func (s *LatencyScore) Name() string { return "LatencyScore" }
+
+/* ***********************************************************************
+ *                            LOCK-UP SCORE                              *
+ *********************************************************************** */
+
+// Score returns the provider's stake lock-up as a share of MaxLockUp, capped to 1.0
+// Providers whose stake can be withdrawn at once score 0.0
+func (s *LockUpScore) Score(p *pairing.Provider, _ *pairing.ConsumerPolicy, _ *PreScoreContext) float64 {
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	maxLockUp := s.MaxLockUp
+	if maxLockUp <= 0 {
+		maxLockUp = 30 * 24 * time.Hour
+	}
+	return clamp01(float64(p.LockUp(now())) / float64(maxLockUp))
+}
+
+// Applicable reports whether the provider reports its lock-up, see pairing.Provider.HasLockUp
+func (s *LockUpScore) Applicable(p *pairing.Provider, _ *pairing.ConsumerPolicy, _ *PreScoreContext) bool {
+	return p.HasLockUp()
+}
+
+func (s *LockUpScore) Name() string { return "LockUpScore" }
+
+/* ***********************************************************************
+ *                              LOAD SCORE                               *
+ *********************************************************************** */
+
+// Score calculates a score based on the EWMA of the provider's load: 1.0 up to LowLoad, 0.0 from HighLoad,
+// linear in between
+// Providers without load samples score 0.0
+func (s *LoadScore) Score(p *pairing.Provider, _ *pairing.ConsumerPolicy, ctx *PreScoreContext) float64 {
+	load, ok := ctx.Aggregate(s.aggregate(), p.ID)
+	if !ok {
+		return 0.0
+	}
+	low, high := s.thresholds()
+	if load <= low {
+		return 1.0
+	}
+	return InverseShare(load-low, high-low)
+}
+
+// RequiredAggregates requests the load EWMA from the PreScoreContext
+func (s *LoadScore) RequiredAggregates() []timeseries.AggregateSpec {
+	return []timeseries.AggregateSpec{s.aggregate()}
+}
+
+// aggregate returns the load aggregate this scorer is based on
+func (s *LoadScore) aggregate() timeseries.AggregateSpec {
+	halfLife := s.HalfLife
+	if halfLife == 0 {
+		halfLife = 5 * time.Minute
+	}
+	return timeseries.AggregateSpec{Metric: timeseries.MetricLoad, Kind: timeseries.EWMA, Window: halfLife}
+}
+
+// thresholds returns the configured load thresholds or their defaults, high always above low
+func (s *LoadScore) thresholds() (low, high float64) {
+	low, high = s.LowLoad, s.HighLoad
+	if low <= 0 {
+		low = 0.5
+	}
+	if high <= low {
+		high = max(1.0, low*2)
+	}
+	return low, high
+}
+
+// Applicable reports whether load samples exist for the provider
+func (s *LoadScore) Applicable(p *pairing.Provider, _ *pairing.ConsumerPolicy, ctx *PreScoreContext) bool {
+	_, ok := ctx.Aggregate(s.aggregate(), p.ID)
+	return ok
+}
+
+func (s *LoadScore) Name() string { return "LoadScore" }
+
+/* ***********************************************************************
+ *                          MAINTENANCE SCORE                            *
+ *********************************************************************** */
+
+// Score returns how much of the horizon is left before the provider's next maintenance window, scaled to [0, 1]
+// Providers in maintenance score 0.0, providers without a window within the horizon 1.0
+// NOTE: It applies to every provider, skipping those without a window would renormalize their other components
+// and could rank them below providers penalized for an imminent window
+func (s *MaintenanceScore) Score(p *pairing.Provider, _ *pairing.ConsumerPolicy, _ *PreScoreContext) float64 {
+	until, ok := s.untilWindow(p)
+	if !ok {
+		return 1.0
+	}
+	return clamp01(float64(until) / float64(s.horizon()))
+}
+
+// untilWindow returns the time left before the provider's maintenance window, 0 if it is in one, and false
+// when none starts within the horizon
+func (s *MaintenanceScore) untilWindow(p *pairing.Provider) (time.Duration, bool) {
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	at := now()
+	if _, ok := s.Schedule.Active(p, at); ok {
+		return 0, true
+	}
+	next, ok := s.Schedule.Next(p, at)
+	if !ok || next.Start.Sub(at) >= s.horizon() {
+		return 0, false
+	}
+	return next.Start.Sub(at), true
+}
+
+// horizon returns the configured horizon or its default
+func (s *MaintenanceScore) horizon() time.Duration {
+	if s.Horizon <= 0 {
+		return time.Hour
+	}
+	return s.Horizon
+}
+
+func (s *MaintenanceScore) Name() string { return "MaintenanceScore" }
+
+/* ***********************************************************************
+ *                          CONFIGURABLE SCORE                           *
+ *********************************************************************** */
+
+// NewConfigurableScore validates a ConfigurableScore configuration, filling in defaults
+func NewConfigurableScore(cfg ConfigurableScore) (*ConfigurableScore, error) {
+	if cfg.ScoreName == "" {
+		return nil, fmt.Errorf("configurable score: missing name")
+	}
+	if !pairing.IsAttribute(cfg.Attribute) {
+		return nil, fmt.Errorf("configurable score %s: unknown attribute %q", cfg.ScoreName, cfg.Attribute)
+	}
+	switch cfg.Direction {
+	case "":
+		cfg.Direction = HigherIsBetter
+	case HigherIsBetter, LowerIsBetter:
+	default:
+		return nil, fmt.Errorf("configurable score %s: unknown direction %q", cfg.ScoreName, cfg.Direction)
+	}
+	switch cfg.Normalization {
+	case "":
+		cfg.Normalization = NormalizeMinMax
+	case NormalizeMinMax, NormalizeMax:
+	default:
+		return nil, fmt.Errorf("configurable score %s: unknown normalization %q", cfg.ScoreName, cfg.Normalization)
+	}
+	return &cfg, nil
+}
+
+// ParseConfigurableScores decodes and validates a JSON array of ConfigurableScore definitions, e.g.
+// [{"name": "ArchiveDepthScore", "attribute": "metadata.archive_depth", "direction": "higher"}]
+func ParseConfigurableScores(data []byte) ([]*ConfigurableScore, error) {
+	var configs []ConfigurableScore
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("decode configurable scores: %w", err)
+	}
+	scorers := make([]*ConfigurableScore, 0, len(configs))
+	for _, cfg := range configs {
+		scorer, err := NewConfigurableScore(cfg)
+		if err != nil {
+			return nil, err
+		}
+		scorers = append(scorers, scorer)
+	}
+	return scorers, nil
+}
+
+// Score normalizes the provider's attribute against its range across the pool, inverting it when lower
+// values are better
+// If every provider has the same value, all of them score 1.0
+func (s *ConfigurableScore) Score(p *pairing.Provider, _ *pairing.ConsumerPolicy, ctx *PreScoreContext) float64 {
+	value, ok := p.Attribute(s.Attribute)
+	if !ok {
+		return 0.0
+	}
+	r, ok := ctx.AttributeRanges[s.Attribute]
+	if !ok {
+		return 0.0
+	}
+	return normalizeRange(value, r, s.Normalization, s.Direction)
+}
+
+// Applicable reports whether the provider has the attribute (Metadata entries may be missing)
+func (s *ConfigurableScore) Applicable(p *pairing.Provider, _ *pairing.ConsumerPolicy, _ *PreScoreContext) bool {
+	_, ok := p.Attribute(s.Attribute)
+	return ok
+}
+
+// RequiredAttributes requests the range of the scored attribute from the PreScoreContext
+func (s *ConfigurableScore) RequiredAttributes() []string {
+	return []string{s.Attribute}
+}
+
+func (s *ConfigurableScore) Name() string { return s.ScoreName }
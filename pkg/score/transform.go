@@ -0,0 +1,140 @@
+package score
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// Clamp limits a component score to [min, max]
+func Clamp(min, max float64) Transform {
+	return func(value float64, _ *pairing.Provider, _ *pairing.ConsumerPolicy) float64 {
+		return math.Max(min, math.Min(max, value))
+	}
+}
+
+// Pow raises a component score to the given exponent, e.g. 0.5 (sqrt) flattens differences among high
+// scores while 2 emphasizes them
+func Pow(exponent float64) Transform {
+	return func(value float64, _ *pairing.Provider, _ *pairing.ConsumerPolicy) float64 {
+		return math.Pow(math.Max(0, value), exponent)
+	}
+}
+
+// Penalty subtracts a flat amount from a component score when the condition holds, never going below 0
+func Penalty(amount float64, when func(p *pairing.Provider, policy *pairing.ConsumerPolicy) bool) Transform {
+	return func(value float64, p *pairing.Provider, policy *pairing.ConsumerPolicy) float64 {
+		if !when(p, policy) {
+			return value
+		}
+		return math.Max(0, value-amount)
+	}
+}
+
+// MissingFeaturePenalty subtracts a flat amount from a component score when the provider lacks the feature
+func MissingFeaturePenalty(feature string, amount float64) Transform {
+	return Penalty(amount, func(p *pairing.Provider, _ *pairing.ConsumerPolicy) bool {
+		for _, f := range p.Features {
+			if f == feature {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Chain composes transforms, applying them in order
+func Chain(transforms ...Transform) Transform {
+	return func(value float64, p *pairing.Provider, policy *pairing.ConsumerPolicy) float64 {
+		for _, t := range transforms {
+			value = t(value, p, policy)
+		}
+		return value
+	}
+}
+
+// ParseTransforms parses transform specs and chains them, in order
+// Supported specs:
+//
+//	cap:<max>                          Clamp(0, max)
+//	clamp:<min>:<max>                  Clamp(min, max)
+//	sqrt                               Pow(0.5)
+//	pow:<exponent>                     Pow(exponent)
+//	missing-feature:<feature>:<amount> MissingFeaturePenalty(feature, amount)
+func ParseTransforms(specs []string) (Transform, error) {
+	transforms := make([]Transform, 0, len(specs))
+	for _, spec := range specs {
+		t, err := parseTransform(spec)
+		if err != nil {
+			return nil, fmt.Errorf("transform %q: %w", spec, err)
+		}
+		transforms = append(transforms, t)
+	}
+	return Chain(transforms...), nil
+}
+
+// parseTransform parses a single transform spec, see ParseTransforms
+func parseTransform(spec string) (Transform, error) {
+	parts := strings.Split(strings.TrimSpace(spec), ":")
+	args := parts[1:]
+	numbers := func(want int) ([]float64, error) {
+		if len(args) != want {
+			return nil, fmt.Errorf("expected %d arguments, got %d", want, len(args))
+		}
+		values := make([]float64, want)
+		for i, arg := range args {
+			v, err := strconv.ParseFloat(arg, 64)
+			if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+				return nil, fmt.Errorf("bad number %q", arg)
+			}
+			values[i] = v
+		}
+		return values, nil
+	}
+
+	switch parts[0] {
+	case "cap":
+		v, err := numbers(1)
+		if err != nil {
+			return nil, err
+		}
+		return Clamp(0, v[0]), nil
+	case "clamp":
+		v, err := numbers(2)
+		if err != nil {
+			return nil, err
+		}
+		if v[0] > v[1] {
+			return nil, fmt.Errorf("min %v is above max %v", v[0], v[1])
+		}
+		return Clamp(v[0], v[1]), nil
+	case "sqrt":
+		if _, err := numbers(0); err != nil {
+			return nil, err
+		}
+		return Pow(0.5), nil
+	case "pow":
+		v, err := numbers(1)
+		if err != nil {
+			return nil, err
+		}
+		if v[0] <= 0 {
+			return nil, fmt.Errorf("exponent must be positive, got %v", v[0])
+		}
+		return Pow(v[0]), nil
+	case "missing-feature":
+		if len(args) != 2 || args[0] == "" {
+			return nil, fmt.Errorf("expected missing-feature:<feature>:<amount>")
+		}
+		amount, err := strconv.ParseFloat(args[1], 64)
+		if err != nil || amount < 0 {
+			return nil, fmt.Errorf("bad penalty amount %q", args[1])
+		}
+		return MissingFeaturePenalty(args[0], amount), nil
+	default:
+		return nil, fmt.Errorf("unknown transform %q", parts[0])
+	}
+}
@@ -0,0 +1,237 @@
+package score
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/latency"
+	"github.com/Yoaz/LavaPairingSystem/pkg/maintenance"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/policy"
+	"github.com/Yoaz/LavaPairingSystem/pkg/timeseries"
+	"github.com/Yoaz/LavaPairingSystem/pkg/uptime"
+)
+
+// ErrScoreAborted is returned by a MemoizedScorer to the callers waiting on a computation that panicked
+var ErrScoreAborted = errors.New("score computation aborted")
+
+// Scorer is an interface for scoring providers based on a consumer policy
+type Scorer interface {
+	Score(provider *pairing.Provider, policy *pairing.ConsumerPolicy, ctx *PreScoreContext) float64
+	Name() string
+}
+
+// FallibleScorer is implemented by scorers that can fail to score, e.g. because they query an external
+// service; the system then calls TryScore instead of Score and surfaces the error
+type FallibleScorer interface {
+	Scorer
+	TryScore(provider *pairing.Provider, policy *pairing.ConsumerPolicy, ctx *PreScoreContext) (float64, error)
+}
+
+// AggregateRequester is implemented by scorers that need rolling aggregates of provider metrics
+// The system computes the requested aggregates once per ranking and exposes them through the PreScoreContext
+type AggregateRequester interface {
+	RequiredAggregates() []timeseries.AggregateSpec
+}
+
+// ApplicabilityReporter is implemented by scorers whose inputs may be missing for some providers (e.g. no
+// latency samples yet)
+// Scorers that are not applicable to a provider are left out of its score, and the remaining weights are
+// renormalized instead of counting the missing component as zero
+type ApplicabilityReporter interface {
+	Applicable(provider *pairing.Provider, policy *pairing.ConsumerPolicy, ctx *PreScoreContext) bool
+}
+
+// RangeReporter is implemented by scorers whose scores aren't within [0, 1], declaring the range they produce
+// The system rescales their scores onto [0, 1] before weighting (see Rescale), so a scorer with a larger range
+// doesn't dominate the weighted sum
+// Scorers that don't implement it are expected to score within [0, 1], see DeclaredRange
+type RangeReporter interface {
+	Range() (min, max float64)
+}
+
+// BatchScorer is implemented by scorers that can score a whole pool in a single loop over its Columns
+// When the system lays the pool out in columns (see system.WithColumnarScoring), it calls ScoreBatch once per
+// ranking and uses its scores instead of calling Score for every provider
+type BatchScorer interface {
+	Scorer
+	// ScoreBatch writes the score of provider i of the columns to out[i], out has an entry per provider
+	ScoreBatch(cols *Columns, policy *pairing.ConsumerPolicy, ctx *PreScoreContext, out []float64)
+}
+
+// Columns is a struct-of-arrays layout of a provider pool: entry i of every slice belongs to the same provider
+// Built once per pool with NewColumns, it lets batch scorers run tight loops over contiguous arrays instead of
+// following a pointer per provider
+type Columns struct {
+	IDs         []string
+	Stakes      []int64 // Effective stakes, see pairing.Provider.EffectiveStake
+	Fees        []float64
+	Commissions []float64
+	index       map[string]int // Provider ID -> position
+}
+
+// Transform post-processes a scorer's component score for a provider, before weighting
+// Transforms are composable, see Chain
+type Transform func(value float64, provider *pairing.Provider, policy *pairing.ConsumerPolicy) float64
+
+// AttributeRequester is implemented by scorers that normalize provider attributes against the pool
+// The system computes the range of every requested attribute once per ranking and exposes them through the
+// PreScoreContext
+type AttributeRequester interface {
+	RequiredAttributes() []string
+}
+
+type (
+	StakeScore    struct{}
+	FeatureScore  struct{}
+	LocationScore struct{}
+	FeeScore      struct{}
+	// Scores providers by the commission they keep from delegator rewards
+	CommissionScore struct{}
+)
+
+// ProximityScore scores providers by the network latency between the consumer's region and theirs
+type ProximityScore struct {
+	Matrix     *latency.Matrix
+	MaxLatency float64 // Latency (ms) at which the score reaches 0, defaults to the largest latency in the matrix
+}
+
+// UptimeScore scores providers by their rolling uptime as reported by heartbeats
+type UptimeScore struct {
+	Tracker *uptime.Tracker
+	Window  time.Duration // Rolling window uptime is computed over, defaults to 24h
+}
+
+// LatencyScore scores providers by the EWMA of their reported latency
+type LatencyScore struct {
+	MaxLatency float64       // Latency (ms) at which the score reaches 0
+	HalfLife   time.Duration // EWMA half-life, defaults to 5 minutes
+}
+
+// LockUpScore rewards providers whose stake stays locked longer (see pairing.Provider.LockUp), having more skin
+// in the game
+type LockUpScore struct {
+	MaxLockUp time.Duration    // Lock-up from which providers score 1, defaults to 30 days
+	Now       func() time.Time // Clock lock-ups are measured from, defaults to time.Now
+}
+
+// LoadScore down-weights providers under heavy load, by the EWMA of their reported or probed utilization (see
+// timeseries.MetricLoad), so load spikes fade as they pass
+type LoadScore struct {
+	LowLoad  float64       // Load up to which providers score 1, defaults to 0.5
+	HighLoad float64       // Load from which providers score 0, defaults to 1
+	HalfLife time.Duration // EWMA half-life, defaults to 5 minutes
+}
+
+// MaintenanceScore penalizes providers with a maintenance window imminent or in progress, so consumers pair with
+// providers that stay up for the pairing's lifetime
+type MaintenanceScore struct {
+	Schedule *maintenance.Schedule // Optional, windows declared in provider metadata are honoured regardless
+	Horizon  time.Duration         // How long before a window providers start being penalized, defaults to 1h
+	Now      func() time.Time      // Clock windows are compared against, defaults to time.Now
+}
+
+// Direction tells whether higher or lower attribute values are better
+type Direction string
+
+const (
+	HigherIsBetter Direction = "higher"
+	LowerIsBetter  Direction = "lower"
+)
+
+// Normalization is how an attribute value is mapped to [0, 1] against the pool
+type Normalization string
+
+const (
+	NormalizeMinMax Normalization = "minmax" // (value - min) / (max - min)
+	NormalizeMax    Normalization = "max"    // value / max, for non-negative attributes
+)
+
+// ConfigurableScore scores providers on any numeric provider attribute (see pairing.Provider.Attribute),
+// defined purely by configuration
+// Build it with NewConfigurableScore to validate the configuration
+type ConfigurableScore struct {
+	ScoreName     string        `json:"name"`          // Name used in policy weights, e.g. "ArchiveDepthScore"
+	Attribute     string        `json:"attribute"`     // e.g. "commission" or "metadata.archive_depth"
+	Direction     Direction     `json:"direction"`     // Defaults to HigherIsBetter
+	Normalization Normalization `json:"normalization"` // Defaults to NormalizeMinMax
+}
+
+// Number is the set of numeric types generic scoring helpers and AttributeScorer work over
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// ValueRequester is implemented by scorers normalizing values they extract from providers themselves (see
+// AttributeScorer)
+// The system computes the range of every requested value once per ranking and exposes them through the
+// PreScoreContext's AttributeRanges, under the requested keys
+type ValueRequester interface {
+	RequiredValues() map[string]func(*pairing.Provider) (float64, bool)
+}
+
+// AttributeScorer scores providers on a typed value extracted by Value, e.g. a field of a custom provider
+// type's Metadata, normalized against the pool like ConfigurableScore
+// Build it with NewAttributeScorer to validate the configuration
+type AttributeScorer[T Number] struct {
+	ScoreName     string                            // Name used in policy weights
+	Value         func(*pairing.Provider) (T, bool) // Returns false when the provider has no value, see Applicable
+	Direction     Direction                         // Defaults to HigherIsBetter
+	Normalization Normalization                     // Defaults to NormalizeMinMax
+}
+
+// MemoizedScorer wraps an expensive scorer (e.g. latency probing, reputation lookup), caching its score per
+// provider for TTL so near-simultaneous requests don't recompute it for the same provider
+// Concurrent requests for a provider whose score is being computed wait for that computation instead of
+// starting their own
+// NOTE: Scores are cached by provider ID only, so only wrap scorers whose score doesn't depend on the policy
+// or on the rest of the pool
+// Build it with Memoize
+type MemoizedScorer struct {
+	scorer Scorer
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]*memoEntry // Provider ID -> cached or in-flight score
+	lastSweep time.Time
+}
+
+// memoEntry is a provider's cached score, ready is closed once it is computed
+type memoEntry struct {
+	ready   chan struct{}
+	value   float64
+	err     error
+	expires time.Time
+}
+
+// AttributeRange is the range of an attribute's values across the considered provider pool
+type AttributeRange struct {
+	Min float64
+	Max float64
+}
+
+// PreScoreContext holds the context for pre-scoring calculations
+type PreScoreContext struct {
+	DelegationFactor float64 // Weight of delegated stake in effective stake, 0 means the default
+	MaxStake         int64
+	AverageLatency   float64
+	NormalizedFees   map[string]float64
+	// Aggregates requested by AggregateRequester scorers: aggregate key -> provider ID -> value
+	Aggregates map[string]map[string]float64
+	// Ranges of the attributes requested by AttributeRequester scorers, by attribute name, and of the values
+	// requested by ValueRequester scorers, by key
+	AttributeRanges map[string]AttributeRange
+	// Columns lays out the pool for BatchScorer scorers, nil unless the system builds it
+	Columns *Columns
+	// Scores computed by BatchScorer scorers, by scorer name, aligned with Columns
+	Batched map[string][]float64
+	// Policy is the scored policy compiled once for the pool, nil unless the system compiles it
+	Policy *policy.CompiledPolicy
+	// Context is cancelled once the pairing is abandoned (e.g. its deadline passed), nil means never
+	Context context.Context
+}
@@ -0,0 +1,1822 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/pprof"
+	"net/netip"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/internal/utils"
+	"github.com/Yoaz/LavaPairingSystem/pkg/address"
+	"github.com/Yoaz/LavaPairingSystem/pkg/anomaly"
+	"github.com/Yoaz/LavaPairingSystem/pkg/auth"
+	"github.com/Yoaz/LavaPairingSystem/pkg/bandit"
+	"github.com/Yoaz/LavaPairingSystem/pkg/experiment"
+	"github.com/Yoaz/LavaPairingSystem/pkg/feature"
+	"github.com/Yoaz/LavaPairingSystem/pkg/geoip"
+	"github.com/Yoaz/LavaPairingSystem/pkg/jail"
+	"github.com/Yoaz/LavaPairingSystem/pkg/maintenance"
+	"github.com/Yoaz/LavaPairingSystem/pkg/metrics"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairingerrors"
+	"github.com/Yoaz/LavaPairingSystem/pkg/policy"
+	"github.com/Yoaz/LavaPairingSystem/pkg/quota"
+	"github.com/Yoaz/LavaPairingSystem/pkg/scheduler"
+	"github.com/Yoaz/LavaPairingSystem/pkg/system"
+	"github.com/Yoaz/LavaPairingSystem/pkg/timeseries"
+	"github.com/Yoaz/LavaPairingSystem/pkg/uptime"
+)
+
+// NewServer creates a new Server serving pairings from the given system over providers supplied by source
+func NewServer(ps system.PairingSystem, source ProviderSource, logger *slog.Logger, opts ...Option) *Server {
+	// Ensure logger is not nil, provide a default discard logger if it is
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	s := &Server{
+		system: ps,
+		source: source,
+		logger: logger,
+		limits: DefaultLimits,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// WithLimits replaces DefaultLimits, bounding the size of request bodies, policies and the providers requests
+// may send; requests over a limit are rejected with 413 and the limit_exceeded code
+func WithLimits(limits Limits) Option {
+	return func(s *Server) {
+		s.limits = limits
+	}
+}
+
+// WithAuthenticators protects the API with the given authenticators, tried in order
+func WithAuthenticators(authenticators ...auth.Authenticator) Option {
+	return func(s *Server) {
+		s.authenticators = append(s.authenticators, authenticators...)
+	}
+}
+
+// WithTLSConfig serves the API over TLS using the given config
+// Set ClientAuth to tls.RequireAndVerifyClientCert to use MTLSAuthenticator
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(s *Server) {
+		s.tlsConfig = cfg
+	}
+}
+
+// WithGeoIP infers a policy's RequiredLocation/PreferredLocations from the client IP when it is left empty
+// NOTE: The client IP is taken from the connection, so put the server behind proxies with care
+func WithGeoIP(inferrer *geoip.Inferrer) Option {
+	return func(s *Server) {
+		s.geoIP = inferrer
+	}
+}
+
+// WithJailer accepts provider failure reports on POST /v1/providers/{id}/failures, feeding the given jailer
+// Only authenticated consumers may report failures, each counted once per provider (see jail.Config.MaxFailures),
+// for a registered provider (see RegisteredSource)
+func WithJailer(jailer *jail.Jailer) Option {
+	return func(s *Server) {
+		s.jailer = jailer
+	}
+}
+
+// WithSlashSource attaches pending slashes from the given source to providers before pairing,
+// so stake filtering and scoring operate on effective stake
+// Subscriptions are re-paired by the scheduler, which needs the same source (see scheduler.WithSlashSource)
+func WithSlashSource(source pairing.SlashSource) Option {
+	return func(s *Server) {
+		s.slashes = source
+	}
+}
+
+// WithUptimeTracker accepts provider heartbeats on POST /v1/providers/{id}/heartbeat, feeding the given tracker
+// Only the provider itself (or an admin) may send them, for a registered provider (see RegisteredSource)
+func WithUptimeTracker(tracker *uptime.Tracker) Option {
+	return func(s *Server) {
+		s.uptime = tracker
+	}
+}
+
+// WithBandit accepts provider reward reports on POST /v1/providers/{id}/reward, feeding the given bandit
+// The bandit only affects pairing if it is also the system's selection strategy (see system.WithSelection)
+// Rewards are only accepted from authenticated consumers, once per provider of a pairing served to them over the
+// source's providers, among the last rewardablePairings pairings
+func WithBandit(b *bandit.Bandit) Option {
+	return func(s *Server) {
+		s.bandit = b
+		s.rewards = &rewardLedger{pairings: make(map[string]map[string]bool)}
+	}
+}
+
+// WithLoadReports accepts provider load reports on POST /v1/providers/{id}/load, recording them in the given
+// store as timeseries.MetricLoad samples for score.LoadScore
+// Only the provider itself (or an admin) may report its load, for a registered provider (see RegisteredSource)
+func WithLoadReports(store *timeseries.Store) Option {
+	return func(s *Server) {
+		s.loads = store
+	}
+}
+
+// WithLatencyReports accepts provider latencies measured by admin probes on
+// POST /v1/admin/providers/{id}/latency, recording them in the given store as timeseries.MetricLatency samples
+// for score.LatencyScore
+// NOTE: Providers can't report their own latency, they would report the best one
+func WithLatencyReports(store *timeseries.Store) Option {
+	return func(s *Server) {
+		s.latencies = store
+	}
+}
+
+// WithFeeHistory serves the fees recorded in the given store (see system.WithTimeSeries) on
+// GET /v1/providers/{id}/fees, with their mean over the period
+func WithFeeHistory(store *timeseries.Store) Option {
+	return func(s *Server) {
+		s.fees = store
+	}
+}
+
+// WithAddressParser requires the consumer_id of policies, when set, to be an address the parser accepts, and
+// normalizes it, so quotas and reports key each consumer the same way however its address is written
+// The consumer ID of authenticated requests is the caller's principal ID, normalized when it is an address the
+// parser accepts; other principal IDs (e.g. those of API keys) are kept as is
+func WithAddressParser(parser *address.Parser) Option {
+	return func(s *Server) {
+		s.addresses = parser
+	}
+}
+
+// WithDiagnostics serves live diagnostics for performance issues: the net/http/pprof profiles under
+// /debug/pprof/ and the expvar variables on GET /debug/vars, along with a "pairing" variable (see RuntimeVars)
+// reporting the system's worker pool depth and cache sizes and the age of each chain's provider snapshot
+// Only admin principals may call them, so they are only served on an authenticated API
+func WithDiagnostics() Option {
+	return func(s *Server) {
+		s.diagnostics = true
+	}
+}
+
+// WithAnomalyDetector serves the review of the providers quarantined by the given detector (see
+// system.WithAnomalyDetection): GET /v1/admin/quarantine lists them and POST /v1/admin/quarantine/{id}/release
+// releases one once reviewed
+// Only admin principals may call them, so they are only served on an authenticated API
+func WithAnomalyDetector(detector *anomaly.Detector) Option {
+	return func(s *Server) {
+		s.anomalies = detector
+	}
+}
+
+// WithMaintenance lets providers declare maintenance windows in the given schedule (see
+// filter.MaintenanceFilter): POST /v1/providers/{id}/maintenance declares one, GET lists those not over yet and
+// DELETE cancels them
+// Only the provider itself (or an admin) may declare or cancel windows, for a registered provider (see
+// RegisteredSource), and windows are bounded by maintenance.MaxWindowLength and maintenance.MaxWindows
+func WithMaintenance(schedule *maintenance.Schedule) Option {
+	return func(s *Server) {
+		s.maintenance = schedule
+	}
+}
+
+// WithQuota charges POST /v1/pairing/filter and POST /v1/pairing/rank requests against the consumer quotas of
+// the given tracker, which should be the system's (see system.WithQuota) as it charges the other pairing routes
+func WithQuota(tracker *quota.Tracker) Option {
+	return func(s *Server) {
+		s.quota = tracker
+	}
+}
+
+// WithScoreHistory serves the final scores recorded in the given store (see system.WithScoreHistory) on
+// GET /v1/providers/{id}/scores, per policy as identified by the score_series of pairing responses
+func WithScoreHistory(store *timeseries.Store) Option {
+	return func(s *Server) {
+		s.scoreHistory = store
+	}
+}
+
+// WithPolicyStore serves named policy management on /v1/policies from the given store, and lets pairing
+// requests reference a saved policy by name (see PairingRequest.PolicyName)
+// Named policies require authentication: a policy may only be read, paired with, overwritten or deleted by the
+// consumer who saved it, or an admin, consumers only list their own policies and may keep up to
+// policy.MaxPoliciesPerOwner of them
+func WithPolicyStore(store *policy.Store) Option {
+	return func(s *Server) {
+		s.policies = store
+	}
+}
+
+// WithPolicyTemplates lets pairing requests execute one of the given policy templates, by name, with their
+// own parameters (see PairingRequest.Template)
+func WithPolicyTemplates(templates ...*policy.Template) Option {
+	return func(s *Server) {
+		if s.templates == nil {
+			s.templates = make(map[string]*policy.Template, len(templates))
+		}
+		for _, t := range templates {
+			s.templates[t.Name] = t
+		}
+	}
+}
+
+// WithScheduler accepts pairing subscriptions on POST /v1/pairing/subscribe, re-paired and pushed to the
+// subscriber by the given scheduler whenever their selection changes
+// The scheduler pairs with its own system and provider source, and must be started by the caller; each consumer
+// holds at most Limits.MaxSubscriptions subscriptions at a time
+func WithScheduler(sched *scheduler.Scheduler) Option {
+	return func(s *Server) {
+		s.scheduler = sched
+	}
+}
+
+// WithAdmin serves read-only admin endpoints: providers scored under samplePolicy (GET /v1/admin/providers),
+// recent pairing decisions (GET /v1/admin/decisions) and filter rejection statistics (GET /v1/admin/filters)
+// Only admin principals may call them, so they are only served on an authenticated API
+func WithAdmin(samplePolicy *pairing.ConsumerPolicy) Option {
+	return func(s *Server) {
+		if samplePolicy == nil {
+			samplePolicy = &pairing.ConsumerPolicy{}
+		}
+		s.admin = &adminState{samplePolicy: samplePolicy}
+	}
+}
+
+// WithFeatureCatalog validates features against the given catalog: policies requiring unknown features are
+// rejected with 400, and providers advertising unknown features are logged
+func WithFeatureCatalog(catalog *feature.Catalog) Option {
+	return func(s *Server) {
+		s.features = catalog
+	}
+}
+
+// WithMetrics records request, pipeline stage and filter metrics in the given registry and serves it on
+// GET /metrics, without authentication so scrapers need no credentials
+// Requests carrying a W3C traceparent header are linked to their trace through exemplars (OpenMetrics only)
+func WithMetrics(registry *metrics.Registry) Option {
+	return func(s *Server) {
+		s.metrics = &serverMetrics{
+			registry: registry,
+			requests: registry.NewCounterVec(metrics.HTTPRequests, "Pairing API requests by route and status code.", "route", "code"),
+			duration: registry.NewHistogramVec(metrics.HTTPRequestDuration, "Pairing API request latency in seconds.", nil, "route"),
+			stages:   registry.NewHistogramVec(metrics.PairingStageDuration, "Time spent in each pairing pipeline stage in seconds.", nil, "stage"),
+			partial:  registry.NewCounterVec(metrics.PairingPartial, "Pairings that timed out and returned a partial selection."),
+		}
+		if reporter, ok := s.system.(system.FilterStatsReporter); ok {
+			registry.NewCounterFunc(metrics.FilterEvaluated, "Providers that went through the filters.", "", func() map[string]float64 {
+				return map[string]float64{"": float64(reporter.FilterStats().Evaluated)}
+			})
+			registry.NewCounterFunc(metrics.FilterRejected, "Providers rejected by each filter.", "filter", func() map[string]float64 {
+				rejected := make(map[string]float64)
+				for name, count := range reporter.FilterStats().Rejected {
+					rejected[name] = float64(count)
+				}
+				return rejected
+			})
+		}
+	}
+}
+
+// Providers returns the static provider list regardless of the requested chain
+func (s StaticSource) Providers(string) ([]*pairing.Provider, error) {
+	return s, nil
+}
+
+// VersionedProviders returns the static provider list with the same version for every chain, the list never
+// changes
+func (s StaticSource) VersionedProviders(string) ([]*pairing.Provider, string, error) {
+	return s, "static", nil
+}
+
+// Registered reports whether a provider of the list has the given ID
+func (s StaticSource) Registered(providerID string) bool {
+	for _, p := range s {
+		if p != nil && p.ID == providerID {
+			return true
+		}
+	}
+	return false
+}
+
+/* ***********************************************************************
+ *                                LIFECYCLE                              *
+ *********************************************************************** */
+
+// Handler returns the HTTP handler serving the pairing API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/pairing", s.instrument("pairing", s.handlePairing))
+	mux.HandleFunc("POST /v1/pairing/interfaces", s.instrument("interfaces", s.handlePairInterfaces))
+	mux.HandleFunc("POST /v1/pairing/filter", s.instrument("filter", s.handleFilter))
+	mux.HandleFunc("POST /v1/pairing/rank", s.instrument("rank", s.handleRank))
+	mux.HandleFunc("GET /v1/config", s.handleConfig)
+	if s.scheduler != nil {
+		mux.HandleFunc("POST /v1/pairing/subscribe", s.handleSubscribe)
+	}
+	if s.jailer != nil {
+		mux.HandleFunc("POST /v1/providers/{id}/failures", s.handleFailureReport)
+	}
+	if s.uptime != nil {
+		mux.HandleFunc("POST /v1/providers/{id}/heartbeat", s.handleHeartbeat)
+	}
+	if s.bandit != nil {
+		mux.HandleFunc("POST /v1/providers/{id}/reward", s.handleReward)
+	}
+	if s.loads != nil {
+		mux.HandleFunc("POST /v1/providers/{id}/load", s.handleLoadReport)
+	}
+	if s.maintenance != nil {
+		mux.HandleFunc("GET /v1/providers/{id}/maintenance", s.handleGetMaintenance)
+		mux.HandleFunc("POST /v1/providers/{id}/maintenance", s.handleDeclareMaintenance)
+		mux.HandleFunc("DELETE /v1/providers/{id}/maintenance", s.handleCancelMaintenance)
+	}
+	if s.scoreHistory != nil {
+		mux.HandleFunc("GET /v1/providers/{id}/scores", s.handleScoreHistory)
+	}
+	if s.fees != nil {
+		mux.HandleFunc("GET /v1/providers/{id}/fees", s.handleFeeHistory)
+	}
+	if _, ok := s.system.(system.SnapshotProver); ok {
+		mux.HandleFunc("GET /v1/snapshots/{root}/providers/{id}/proof", s.handleSnapshotProof)
+	}
+	// Admin routes need an admin principal, which only an authenticated API has
+	admin := len(s.authenticators) > 0
+	if !admin && (s.anomalies != nil || s.admin != nil || s.latencies != nil || s.diagnostics) {
+		s.logger.Warn("Admin endpoints are not served without authentication")
+	}
+	if s.anomalies != nil && admin {
+		mux.HandleFunc("GET /v1/admin/quarantine", s.requireAdmin(s.handleQuarantine))
+		mux.HandleFunc("POST /v1/admin/quarantine/{id}/release", s.requireAdmin(s.handleQuarantineRelease))
+	}
+	if s.latencies != nil && admin {
+		mux.HandleFunc("POST /v1/admin/providers/{id}/latency", s.requireAdmin(s.handleLatencyReport))
+	}
+	if s.policies != nil {
+		mux.HandleFunc("GET /v1/policies", s.handleListPolicies)
+		mux.HandleFunc("GET /v1/policies/{name}", s.handleGetPolicy)
+		mux.HandleFunc("GET /v1/policies/{name}/revisions", s.handlePolicyRevisions)
+		mux.HandleFunc("PUT /v1/policies/{name}", s.handlePutPolicy)
+		mux.HandleFunc("DELETE /v1/policies/{name}", s.handleDeletePolicy)
+	}
+	if s.diagnostics && admin {
+		mux.HandleFunc("GET /debug/pprof/", s.requireAdmin(pprof.Index))
+		mux.HandleFunc("GET /debug/pprof/cmdline", s.requireAdmin(pprof.Cmdline))
+		mux.HandleFunc("GET /debug/pprof/profile", s.requireAdmin(pprof.Profile))
+		mux.HandleFunc("GET /debug/pprof/symbol", s.requireAdmin(pprof.Symbol))
+		mux.HandleFunc("POST /debug/pprof/symbol", s.requireAdmin(pprof.Symbol))
+		mux.HandleFunc("GET /debug/pprof/trace", s.requireAdmin(pprof.Trace))
+		mux.HandleFunc("GET /debug/vars", s.requireAdmin(s.handleVars))
+	}
+	if s.admin != nil && admin {
+		mux.HandleFunc("GET /v1/admin/providers", s.requireAdmin(s.handleAdminProviders))
+		mux.HandleFunc("GET /v1/admin/decisions", s.requireAdmin(s.handleAdminDecisions))
+		mux.HandleFunc("GET /v1/admin/filters", s.requireAdmin(s.handleAdminFilters))
+	}
+
+	var handler http.Handler = mux
+	if len(s.authenticators) == 0 {
+		s.logger.Warn("Pairing API is served without authentication")
+	} else {
+		if s.addresses != nil {
+			handler = s.normalizePrincipals(handler)
+		}
+		handler = auth.Middleware(s.logger, s.authenticators...)(handler)
+	}
+	if s.limits.MaxBodyBytes > 0 {
+		handler = limitBody(handler, s.limits.MaxBodyBytes)
+	}
+	if s.metrics == nil {
+		return handler
+	}
+	// Metrics are served in front of authentication
+	root := http.NewServeMux()
+	root.Handle("GET /metrics", s.metrics.registry)
+	root.Handle("/", handler)
+	return root
+}
+
+// ListenAndServe starts serving the API on addr and blocks until the server stops
+func (s *Server) ListenAndServe(addr string) error {
+	s.httpServer = &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		TLSConfig:         s.tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	s.logger.Info("Starting pairing API server", "addr", addr, "tls", s.tlsConfig != nil)
+
+	if s.tlsConfig != nil {
+		// Certificates are taken from the TLS config
+		return s.httpServer.ListenAndServeTLS("", "")
+	}
+	return s.httpServer.ListenAndServe()
+}
+
+// Shutdown gracefully stops the server started by ListenAndServe
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.httpServer == nil {
+		return nil
+	}
+	return s.httpServer.Shutdown(ctx)
+}
+
+/* ***********************************************************************
+ *                                HANDLERS                               *
+ *********************************************************************** */
+
+// handlePairing serves POST /v1/pairing
+// It enforces the caller's restrictions, runs the pairing system over the chain's providers and
+// returns the selected providers
+func (s *Server) handlePairing(w http.ResponseWriter, r *http.Request) {
+	req, consumerPolicy, ok := s.parsePairingRequest(w, r)
+	if !ok {
+		return
+	}
+	if len(consumerPolicy.APIInterfaces) > 0 {
+		s.writeErrorCode(w, http.StatusBadRequest, pairingerrors.CodeInvalidPolicy, "api_interfaces pairings are served by POST /v1/pairing/interfaces")
+		return
+	}
+
+	providers, version, ok := s.requestProviders(w, req)
+	if !ok {
+		return
+	}
+
+	var (
+		result *system.PairingResult
+		arm    experiment.Arm
+		err    error
+	)
+	opts := system.PairingOptions{
+		TopN:         req.TopN,
+		RequestID:    r.Header.Get(requestIDHeader),
+		PoolVersion:  version,
+		ExternalPool: req.Providers != nil, // Caller-supplied providers don't feed the system's histories
+	}
+	if router, ok := s.system.(*experiment.Router); ok {
+		result, arm, err = router.GetPairingListWithArm(providers, consumerPolicy, opts)
+	} else {
+		result, err = s.system.GetPairingList(providers, consumerPolicy, opts)
+	}
+	if err != nil {
+		s.writePairingError(w, req, err)
+		return
+	}
+	s.observeResult(r, result)
+	response := newPairingResponse(result, arm)
+	if s.admin != nil {
+		s.admin.record(consumerPolicy, response)
+	}
+	if _, authenticated := auth.FromContext(r.Context()); s.rewards != nil && authenticated && req.Providers == nil {
+		s.rewards.serve(string(consumerPolicy.ConsumerID), response)
+	}
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+// handlePairInterfaces serves POST /v1/pairing/interfaces
+// The request is the same as for POST /v1/pairing, with the policy listing its APIInterfaces; one pairing is
+// returned per interface (see system.PairInterfaces), each selecting top_n providers
+func (s *Server) handlePairInterfaces(w http.ResponseWriter, r *http.Request) {
+	req, consumerPolicy, ok := s.parsePairingRequest(w, r)
+	if !ok {
+		return
+	}
+	if len(consumerPolicy.APIInterfaces) == 0 {
+		s.writeErrorCode(w, http.StatusBadRequest, pairingerrors.CodeInvalidPolicy, "policy lists no api_interfaces")
+		return
+	}
+
+	providers, version, ok := s.requestProviders(w, req)
+	if !ok {
+		return
+	}
+
+	opts := system.PairingOptions{
+		TopN:         req.TopN,
+		RequestID:    r.Header.Get(requestIDHeader),
+		PoolVersion:  version,
+		ExternalPool: req.Providers != nil, // Caller-supplied providers don't feed the system's histories
+	}
+	results, err := system.PairInterfaces(s.system, providers, consumerPolicy, opts)
+	if err != nil {
+		s.writePairingError(w, req, err)
+		return
+	}
+	response := InterfacesPairingResponse{Interfaces: make(map[string]PairingResponse, len(results))}
+	for apiInterface, result := range results {
+		s.observeResult(r, result)
+		response.Interfaces[apiInterface] = newPairingResponse(result, "")
+	}
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+// writePairingError writes the error response of a failed pairing, its status depending on the error kind
+func (s *Server) writePairingError(w http.ResponseWriter, req PairingRequest, err error) {
+	var exceeded *quota.ExceededError
+	if errors.As(err, &exceeded) {
+		// Tell well-behaved clients when to come back instead of hammering the API
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(exceeded.RetryAfter.Seconds()))))
+		s.writeError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	code := pairingerrors.CodeOf(err)
+	switch {
+	case errors.Is(err, pairingerrors.ErrInvalidPolicy):
+		s.writeErrorCode(w, http.StatusBadRequest, code, err.Error())
+	case errors.Is(err, system.ErrPipeline) || errors.Is(err, pairingerrors.ErrTimeout):
+		s.logger.Error("Pairing failed", "chain_id", req.ChainID, "error", err)
+		s.writeErrorCode(w, http.StatusServiceUnavailable, code, "pairing temporarily unavailable")
+	case errors.Is(err, pairingerrors.ErrInsufficientProviders):
+		s.writeErrorCode(w, http.StatusUnprocessableEntity, code, err.Error())
+	default:
+		s.writeErrorCode(w, http.StatusNotFound, code, err.Error())
+	}
+}
+
+// handleFilter serves POST /v1/pairing/filter
+// The request is the same as for POST /v1/pairing; the providers passing the policy's filters are returned
+// unranked, capped only to the caller's MaxTopN restriction
+func (s *Server) handleFilter(w http.ResponseWriter, r *http.Request) {
+	req, consumerPolicy, ok := s.parsePairingRequest(w, r)
+	if !ok {
+		return
+	}
+	providers, _, ok := s.requestProviders(w, req)
+	if !ok {
+		return
+	}
+	if !s.chargeQuota(w, req, consumerPolicy) {
+		return
+	}
+	filtered := s.system.FilterProviders(providers, consumerPolicy)
+	if maxTopN := restrictedTopN(r); maxTopN > 0 {
+		filtered = filtered[:utils.Min(maxTopN, len(filtered))]
+	}
+	s.writeJSON(w, http.StatusOK, FilterResponse{Providers: filtered})
+}
+
+// handleRank serves POST /v1/pairing/rank
+// The request is the same as for POST /v1/pairing; every provider is scored under the policy, unfiltered and
+// in input order. Callers restricted to a MaxTopN only get that many of the best-scored providers
+func (s *Server) handleRank(w http.ResponseWriter, r *http.Request) {
+	req, consumerPolicy, ok := s.parsePairingRequest(w, r)
+	if !ok {
+		return
+	}
+	providers, _, ok := s.requestProviders(w, req)
+	if !ok {
+		return
+	}
+	if !s.chargeQuota(w, req, consumerPolicy) {
+		return
+	}
+	scored := s.system.RankProviders(providers, consumerPolicy)
+	if maxTopN := restrictedTopN(r); maxTopN > 0 && len(scored) > maxTopN {
+		scored = bestScored(scored, maxTopN)
+	}
+	response := RankResponse{Scores: make([]ProviderScore, 0, len(scored))}
+	for _, ps := range scored {
+		response.Scores = append(response.Scores, ProviderScore{Provider: ps.Provider, Score: ps.Score, Components: ps.Components})
+	}
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+// chargeQuota charges a filter or rank request against the consumer's quota (see WithQuota), pairings are
+// charged by the system
+// On failure the error response is already written and ok is false
+func (s *Server) chargeQuota(w http.ResponseWriter, req PairingRequest, consumerPolicy *pairing.ConsumerPolicy) (ok bool) {
+	if s.quota == nil {
+		return true
+	}
+	if err := s.quota.Consume(string(consumerPolicy.ConsumerID), consumerPolicy.ComputeUnits); err != nil {
+		s.logger.Warn("Consumer quota exceeded", "consumer_id", consumerPolicy.ConsumerID, "error", err)
+		s.writePairingError(w, req, err)
+		return false
+	}
+	return true
+}
+
+// restrictedTopN returns the MaxTopN restriction of the request's principal, 0 when it has none
+func restrictedTopN(r *http.Request) int {
+	if principal, ok := auth.FromContext(r.Context()); ok {
+		return principal.Restrictions.MaxTopN
+	}
+	return 0
+}
+
+// bestScored returns the n best-scored of the scores, keeping their order
+func bestScored(scores []*pairing.PairingScore, n int) []*pairing.PairingScore {
+	ranked := append([]*pairing.PairingScore(nil), scores...)
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Score > ranked[j].Score })
+	best := make(map[*pairing.PairingScore]bool, n)
+	for _, ps := range ranked[:n] {
+		best[ps] = true
+	}
+	kept := make([]*pairing.PairingScore, 0, n)
+	for _, ps := range scores {
+		if best[ps] {
+			kept = append(kept, ps)
+		}
+	}
+	return kept
+}
+
+// handleConfig serves GET /v1/config
+func (s *Server) handleConfig(w http.ResponseWriter, _ *http.Request) {
+	s.writeJSON(w, http.StatusOK, ConfigResponse{ConfigFingerprint: s.system.ConfigFingerprint()})
+}
+
+// handleSubscribe serves POST /v1/pairing/subscribe
+// The request is the same as for POST /v1/pairing; the response is a Server-Sent Events stream carrying a
+// "pairing" event with a PairingResponse every time the consumer's selected providers change, starting with
+// the initial selection. The subscription ends when the client disconnects
+func (s *Server) handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	req, consumerPolicy, ok := s.parsePairingRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Providers != nil {
+		// Subscriptions follow provider churn, which only the scheduler's source can reflect
+		s.writeError(w, http.StatusBadRequest, "providers can't be set on subscriptions")
+		return
+	}
+
+	consumer := string(consumerPolicy.ConsumerID)
+	if consumer == "" {
+		consumer = "anonymous"
+	}
+	if !s.subscribers.acquire(consumer, s.limits.MaxSubscriptions) {
+		err := &pairingerrors.LimitExceededError{Limit: "subscriptions of consumer " + consumer, Max: int64(s.limits.MaxSubscriptions)}
+		s.logger.Warn("Rejected pairing subscription", "consumer_id", consumer, "error", err)
+		s.writeErrorCode(w, http.StatusTooManyRequests, pairingerrors.CodeLimitExceeded, err.Error())
+		return
+	}
+	defer s.subscribers.release(consumer)
+	key := consumer + "#" + strconv.FormatUint(s.subscriptionSeq.Add(1), 10)
+	if req.TopN > 0 {
+		// The scheduler re-pairs with the policy alone, it carries the request's count
+		consumerPolicy.TopN = req.TopN
+	}
+	updates := s.scheduler.Subscribe(key, consumerPolicy, subscriptionBuffer)
+	defer s.scheduler.Unregister(key)
+	s.logger.Debug("Pairing subscription started", "key", key, "chain_id", req.ChainID)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(subscriptionKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			s.logger.Debug("Pairing subscription ended", "key", key)
+			return
+		case <-keepAlive.C:
+			// Comment lines keep proxies from closing an idle stream
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case update, open := <-updates:
+			if !open {
+				return
+			}
+			data, err := json.Marshal(newPairingResponse(update.Result, ""))
+			if err != nil {
+				s.logger.Error("Failed to encode pairing update", "key", key, "error", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: pairing\ndata: %s\n\n", data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// acquire opens a subscription of the consumer, false if it already holds max of them (0 for no limit)
+func (c *subscriberCounts) acquire(consumer string, max int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if max > 0 && c.counts[consumer] >= max {
+		return false
+	}
+	if c.counts == nil {
+		c.counts = make(map[string]int)
+	}
+	c.counts[consumer]++
+	return true
+}
+
+// release closes a subscription of the consumer
+func (c *subscriberCounts) release(consumer string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts[consumer]--; c.counts[consumer] <= 0 {
+		delete(c.counts, consumer)
+	}
+}
+
+// parsePairingRequest decodes and validates a pairing request and enforces the caller's restrictions on it
+// On failure the error response is already written and ok is false
+func (s *Server) parsePairingRequest(w http.ResponseWriter, r *http.Request) (req PairingRequest, consumerPolicy *pairing.ConsumerPolicy, ok bool) {
+	if err := decodeStrictBody(r, &req); err != nil {
+		s.writeBodyError(w, err)
+		return req, nil, false
+	}
+	if err := s.checkLimits(req); err != nil {
+		s.logger.Warn("Rejected oversized pairing request", "error", err)
+		s.writeErrorCode(w, http.StatusRequestEntityTooLarge, pairingerrors.CodeLimitExceeded, err.Error())
+		return req, nil, false
+	}
+	consumerPolicy, ok = s.requestPolicy(w, r, req)
+	if !ok {
+		return req, nil, false
+	}
+	// The chain can be given by the request, the policy, or both as long as they agree
+	switch {
+	case req.ChainID == "":
+		req.ChainID = consumerPolicy.ChainID
+	case consumerPolicy.ChainID == "":
+		consumerPolicy.ChainID = req.ChainID
+	case consumerPolicy.ChainID != req.ChainID:
+		s.writeError(w, http.StatusBadRequest, "policy chain_id doesn't match the request's")
+		return req, nil, false
+	}
+	// Validated once the chain is known, the policy's weights are checked on the chain it pairs on
+	if err := s.validatePolicy(consumerPolicy); err != nil {
+		s.writeErrorCode(w, http.StatusBadRequest, pairingerrors.CodeInvalidPolicy, err.Error())
+		return req, nil, false
+	}
+
+	// Enforce per-credential restrictions when the API is authenticated
+	if principal, ok := auth.FromContext(r.Context()); ok {
+		// The authenticated identity is the consumer, never trust the one sent in the body
+		consumerPolicy.ConsumerID = address.Address(principal.ID)
+
+		restrictions := principal.Restrictions
+		if !restrictions.AllowsChain(req.ChainID) {
+			s.writeError(w, http.StatusForbidden, "chain not allowed: "+req.ChainID)
+			return req, nil, false
+		}
+		// Backups are providers too, they count against the caller's maximum along with the selection
+		if !restrictions.AllowsTopN(consumerPolicy.Backups) {
+			s.writeError(w, http.StatusForbidden, "backups exceed the allowed maximum")
+			return req, nil, false
+		}
+		if req.TopN == 0 {
+			req.TopN = consumerPolicy.TopN // The policy's own count is held to the same maximum
+		}
+		if req.TopN == 0 && restrictions.MaxTopN > 0 {
+			// Default to the largest list the caller may receive
+			req.TopN = restrictions.MaxTopN - consumerPolicy.Backups
+			if req.TopN == 0 {
+				s.writeError(w, http.StatusForbidden, "backups leave no room for a selection within the allowed maximum")
+				return req, nil, false
+			}
+		} else if !restrictions.AllowsTopN(req.TopN + consumerPolicy.Backups) {
+			s.writeError(w, http.StatusForbidden, "top_n and backups exceed the allowed maximum")
+			return req, nil, false
+		}
+	}
+
+	if s.geoIP != nil && consumerPolicy.RequiredLocation == "" {
+		s.inferLocation(r, consumerPolicy)
+	}
+	return req, consumerPolicy, true
+}
+
+// requestPolicy decodes the policy of a request: sent inline, saved under a name (see WithPolicyStore), or
+// executed from a template (see WithPolicyTemplates)
+// On failure the error response is already written and ok is false
+func (s *Server) requestPolicy(w http.ResponseWriter, r *http.Request, req PairingRequest) (consumerPolicy *pairing.ConsumerPolicy, ok bool) {
+	inline := len(req.Policy) != 0 && string(req.Policy) != "null"
+	sources := 0
+	for _, set := range []bool{inline, req.PolicyName != "", req.Template != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		s.writeError(w, http.StatusBadRequest, "policy, policy_name and template are mutually exclusive")
+		return nil, false
+	}
+
+	rawPolicy := req.Policy
+	switch {
+	case req.Template != "":
+		template, found := s.templates[req.Template]
+		if !found {
+			s.writeError(w, http.StatusNotFound, "unknown policy template: "+req.Template)
+			return nil, false
+		}
+		consumerPolicy, err := template.Execute(req.Params)
+		if err != nil {
+			s.writeErrorCode(w, http.StatusBadRequest, pairingerrors.CodeInvalidPolicy, err.Error())
+			return nil, false
+		}
+		// Parameters can set any value, the executed policy is checked like an inline one
+		if err := policy.CheckValues(consumerPolicy); err != nil {
+			s.writePolicyError(w, err)
+			return nil, false
+		}
+		return consumerPolicy, true
+	case req.PolicyName != "":
+		if s.policies == nil {
+			s.writeError(w, http.StatusBadRequest, "named policies are not enabled")
+			return nil, false
+		}
+		if !s.authorizePolicyRead(w, r, req.PolicyName) {
+			return nil, false
+		}
+		stored, err := s.policies.Get(req.PolicyName, req.PolicyRevision)
+		if err != nil {
+			s.writeError(w, http.StatusNotFound, err.Error())
+			return nil, false
+		}
+		rawPolicy = stored.Policy
+	case !inline:
+		s.writeError(w, http.StatusBadRequest, "missing policy")
+		return nil, false
+	}
+	var err error
+	if inline {
+		consumerPolicy, err = policy.UnmarshalStrict(rawPolicy, s.limits.MaxListItems)
+	} else if consumerPolicy, err = policy.Unmarshal(rawPolicy); err == nil {
+		// Named policies were checked against the schema they were saved with, their values are checked again
+		// as the checks may have tightened since
+		err = policy.CheckValues(consumerPolicy)
+	}
+	if err != nil {
+		s.writePolicyError(w, err)
+		return nil, false
+	}
+	return consumerPolicy, true
+}
+
+// validatePolicy checks a decoded policy's fields beyond what decoding guarantees, normalizing its consumer ID
+// when addresses are parsed (see WithAddressParser)
+func (s *Server) validatePolicy(consumerPolicy *pairing.ConsumerPolicy) error {
+	if s.addresses != nil && consumerPolicy.ConsumerID != "" {
+		consumerID, err := s.addresses.Parse(string(consumerPolicy.ConsumerID))
+		if err != nil {
+			return fmt.Errorf("consumer_id: %w", err)
+		}
+		consumerPolicy.ConsumerID = consumerID
+	}
+	if err := utils.ValidateScopedWeights(consumerPolicy); err != nil {
+		return err
+	}
+	if err := utils.ValidateAdjustments(consumerPolicy.Adjustments); err != nil {
+		return err
+	}
+	if consumerPolicy.MinProviders < 0 {
+		return fmt.Errorf("min_providers must not be negative")
+	}
+	if consumerPolicy.ComputeUnits < 0 {
+		return fmt.Errorf("compute_units must not be negative")
+	}
+	if consumerPolicy.ComputeUnits > pairing.MaxComputeUnits {
+		return fmt.Errorf("compute_units must not exceed %d", pairing.MaxComputeUnits)
+	}
+	if consumerPolicy.TopN < 0 {
+		return fmt.Errorf("top_n must not be negative")
+	}
+	if consumerPolicy.Backups < 0 {
+		return fmt.Errorf("backups must not be negative")
+	}
+	if consumerPolicy.MinLockUpSeconds < 0 {
+		return fmt.Errorf("min_lockup_seconds must not be negative")
+	}
+	if consumerPolicy.MinASNs < 0 {
+		return fmt.Errorf("min_asns must not be negative")
+	}
+	if w := consumerPolicy.WarmUp; w != nil {
+		if w.Hours < 0 || w.ExplorationSlots < 0 {
+			return fmt.Errorf("warm_up hours and exploration_slots must not be negative")
+		}
+		if w.ScoreCap < 0 || w.ScoreCap > 1 {
+			return fmt.Errorf("warm_up score_cap must be within [0, 1]")
+		}
+	}
+	if err := utils.ValidateFeatureGroups(consumerPolicy.FeatureGroups); err != nil {
+		return err
+	}
+	if err := utils.ValidateFeatureValues(consumerPolicy.FeatureValues); err != nil {
+		return err
+	}
+	if err := utils.ValidateAPIInterfaces(consumerPolicy.APIInterfaces, consumerPolicy.RequiredAPIInterface); err != nil {
+		return err
+	}
+	if s.features != nil {
+		// An unknown feature (typically a typo) would silently filter every provider out
+		if err := s.features.ValidatePolicy(consumerPolicy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkLimits checks a decoded pairing request against the server's Limits, returning a
+// *pairingerrors.LimitExceededError for the first one it exceeds
+func (s *Server) checkLimits(req PairingRequest) error {
+	if max := s.limits.MaxPolicyBytes; max > 0 && len(req.Policy) > max {
+		return &pairingerrors.LimitExceededError{Limit: "policy bytes", Max: int64(max), Actual: int64(len(req.Policy))}
+	}
+	if err := s.checkName("policy_name", req.PolicyName); err != nil {
+		return err
+	}
+	if err := s.checkName("template", req.Template); err != nil {
+		return err
+	}
+	if max := s.limits.MaxListItems; max > 0 && len(req.Params) > max {
+		return &pairingerrors.LimitExceededError{Limit: "template params", Max: int64(max), Actual: int64(len(req.Params))}
+	}
+	if max := s.limits.MaxPolicyBytes; max > 0 && len(req.Params) > 0 {
+		// Params fill a policy, they are held to the size of one
+		raw, _ := json.Marshal(req.Params)
+		if len(raw) > max {
+			return &pairingerrors.LimitExceededError{Limit: "template params bytes", Max: int64(max), Actual: int64(len(raw))}
+		}
+	}
+	if max := s.limits.MaxProviders; max > 0 && len(req.Providers) > max {
+		return &pairingerrors.LimitExceededError{Limit: "providers", Max: int64(max), Actual: int64(len(req.Providers))}
+	}
+	if max := s.limits.MaxFeaturesPerProvider; max > 0 {
+		for _, p := range req.Providers {
+			if p != nil && len(p.Features) > max {
+				return &pairingerrors.LimitExceededError{Limit: "features of provider " + p.ID, Max: int64(max), Actual: int64(len(p.Features))}
+			}
+		}
+	}
+	return nil
+}
+
+// checkName checks the length of a policy or template name against Limits.MaxNameBytes, field naming it in errors
+func (s *Server) checkName(field, name string) error {
+	if max := s.limits.MaxNameBytes; max > 0 && len(name) > max {
+		return &pairingerrors.LimitExceededError{Limit: field + " bytes", Max: int64(max), Actual: int64(len(name))}
+	}
+	return nil
+}
+
+// normalizePrincipals normalizes the ID of authenticated principals that are addresses (see WithAddressParser),
+// so every handler keys a consumer authenticated by its address the same way as one sending it in a policy
+func (s *Server) normalizePrincipals(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principal, ok := auth.FromContext(r.Context()); ok {
+			if id := s.addresses.Normalize(principal.ID); id != principal.ID {
+				normalized := *principal
+				normalized.ID = id
+				r = r.WithContext(auth.NewContext(r.Context(), &normalized))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitBody caps the body of every request at max bytes, see Limits.MaxBodyBytes
+func limitBody(next http.Handler, max int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestProviders returns the providers a request is evaluated against, with pending slashes attached: the
+// request's own providers if it sends any, the chain's providers from the source otherwise
+// version is the version of the pool, read along with the providers, empty when it can't be versioned: the
+// request carries its own providers, the source isn't a VersionedSource, or pending slashes change stakes
+// On failure the error response is already written and ok is false
+func (s *Server) requestProviders(w http.ResponseWriter, req PairingRequest) (providers []*pairing.Provider, version string, ok bool) {
+	providers = req.Providers
+	if providers == nil {
+		var err error
+		if versioned, ok := s.source.(VersionedSource); ok && s.slashes == nil {
+			providers, version, err = versioned.VersionedProviders(req.ChainID)
+			if version != "" {
+				version = req.ChainID + "@" + version
+			}
+		} else {
+			providers, err = s.source.Providers(req.ChainID)
+		}
+		if err != nil {
+			err = &pairingerrors.SourceUnavailableError{ChainID: req.ChainID, Err: err}
+			s.logger.Error("Failed to load providers", "chain_id", req.ChainID, "error", err)
+			s.writeErrorCode(w, http.StatusServiceUnavailable, pairingerrors.CodeSourceUnavailable, "providers unavailable")
+			return nil, "", false
+		}
+	}
+	if s.features != nil {
+		// Unknown provider features are only flagged, the provider may well serve them
+		for _, p := range providers {
+			if err := s.features.ValidateProvider(p); err != nil {
+				s.logger.Warn("Provider advertises unknown features", "chain_id", req.ChainID, "error", err)
+			}
+		}
+	}
+	if s.slashes != nil {
+		slashes, err := s.slashes.PendingSlashes()
+		if err != nil {
+			// Pairing on nominal stake beats failing the request outright
+			s.logger.Warn("Failed to load pending slashes, using nominal stake", "error", err)
+		} else {
+			providers = utils.AttachSlashInfo(providers, slashes)
+		}
+	}
+	return providers, version, true
+}
+
+// newPairingResponse builds the response for a pairing result
+func newPairingResponse(result *system.PairingResult, arm experiment.Arm) PairingResponse {
+	topProviders := result.Providers
+	var hints map[string]system.ConnectionHints
+	if result.Hints != nil {
+		hints = make(map[string]system.ConnectionHints, len(topProviders)+len(result.Backups))
+		for _, p := range append(topProviders[:len(topProviders):len(topProviders)], result.Backups...) {
+			if hint, ok := result.Hints[p.ID]; ok {
+				hints[p.ID] = hint
+			}
+		}
+	}
+	return PairingResponse{
+		Providers:        topProviders,
+		Backups:          result.Backups,
+		Hints:            hints,
+		Partial:          result.Partial,
+		ConstraintsUnmet: result.ConstraintsUnmet,
+		ExperimentArm:    string(arm),
+		Diagnostics:      result.Diagnostics,
+		Provenance: Provenance{
+			RequestID:    result.RequestID,
+			ConfigHash:   result.ConfigHash,
+			ScoreSeries:  result.ScoreSeries,
+			SnapshotRoot: result.SnapshotRoot,
+			Timestamp:    result.Timestamp,
+			Counts:       result.Counts,
+			ElapsedMS:    float64(result.Durations.Total.Microseconds()) / 1000,
+		},
+	}
+}
+
+// handleFailureReport serves POST /v1/providers/{id}/failures
+// Authenticated consumers report providers that failed to serve them; reports from enough distinct consumers
+// get the provider jailed
+func (s *Server) handleFailureReport(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		// Anonymous reports can't be told apart, anyone could jail any provider by repeating one
+		s.writeError(w, http.StatusUnauthorized, "failure reports require authentication")
+		return
+	}
+	var report FailureReport
+	if err := decodeBody(r, &report); err != nil && err != io.EOF {
+		s.writeBodyError(w, err)
+		return
+	}
+
+	providerID := r.PathValue("id")
+	if registered, ok := s.source.(RegisteredSource); ok && !registered.Registered(providerID) {
+		s.writeError(w, http.StatusNotFound, "unknown provider: "+providerID)
+		return
+	}
+	s.logger.Debug("Received provider failure report", "provider_id", providerID, "reporter", principal.ID, "reason", report.Reason)
+
+	if _, err := s.jailer.ReportFailure(providerID, principal.ID, report.Reason); err != nil {
+		s.logger.Warn("Rejected provider failure report", "provider_id", providerID, "reporter", principal.ID, "error", err)
+		s.writeError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, FailureReportResponse{Jailed: s.jailer.IsJailed(providerID)})
+}
+
+// handleHeartbeat serves POST /v1/providers/{id}/heartbeat, from the provider itself
+func (s *Server) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	providerID := r.PathValue("id")
+	if !s.authorizeProvider(w, r, providerID) {
+		return
+	}
+	s.uptime.Heartbeat(providerID, time.Now())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleLoadReport serves POST /v1/providers/{id}/load
+// Providers, or admin probes watching them, report their current utilization, clamped to maxReportedLoad
+func (s *Server) handleLoadReport(w http.ResponseWriter, r *http.Request) {
+	providerID := r.PathValue("id")
+	if !s.authorizeProvider(w, r, providerID) {
+		return
+	}
+	var report LoadReport
+	if err := decodeBody(r, &report); err != nil {
+		s.writeBodyError(w, err)
+		return
+	}
+	if report.Load < 0 || math.IsNaN(report.Load) || math.IsInf(report.Load, 0) {
+		s.writeError(w, http.StatusBadRequest, "load must be a non-negative number")
+		return
+	}
+	s.loads.Record(providerID, timeseries.MetricLoad, min(report.Load, maxReportedLoad), time.Now())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleLatencyReport serves POST /v1/admin/providers/{id}/latency
+// Admin probes report the latency they measured to a registered provider, clamped to maxReportedLatency
+func (s *Server) handleLatencyReport(w http.ResponseWriter, r *http.Request) {
+	providerID := r.PathValue("id")
+	if !s.authorizeProvider(w, r, providerID) {
+		return
+	}
+	var report LatencyReport
+	if err := decodeBody(r, &report); err != nil {
+		s.writeBodyError(w, err)
+		return
+	}
+	if report.LatencyMS < 0 || math.IsNaN(report.LatencyMS) || math.IsInf(report.LatencyMS, 0) {
+		s.writeError(w, http.StatusBadRequest, "latency_ms must be a non-negative number")
+		return
+	}
+	s.latencies.Record(providerID, timeseries.MetricLatency, min(report.LatencyMS, maxReportedLatency), time.Now())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGetMaintenance serves GET /v1/providers/{id}/maintenance
+func (s *Server) handleGetMaintenance(w http.ResponseWriter, r *http.Request) {
+	providerID := r.PathValue("id")
+	s.writeJSON(w, http.StatusOK, MaintenanceResponse{ProviderID: providerID, Windows: s.maintenance.Windows(providerID)})
+}
+
+// handleDeclareMaintenance serves POST /v1/providers/{id}/maintenance
+// Providers declare a window with {"start": ..., "end": ...} (RFC 3339 times), during which they aren't paired
+func (s *Server) handleDeclareMaintenance(w http.ResponseWriter, r *http.Request) {
+	providerID := r.PathValue("id")
+	if !s.authorizeProvider(w, r, providerID) {
+		return
+	}
+	var window maintenance.Window
+	if err := decodeBody(r, &window); err != nil {
+		s.writeBodyError(w, err)
+		return
+	}
+	if err := s.maintenance.Declare(providerID, window); err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.logger.Info("Provider declared a maintenance window", "provider_id", providerID, "start", window.Start, "end", window.End)
+	s.writeJSON(w, http.StatusCreated, MaintenanceResponse{ProviderID: providerID, Windows: s.maintenance.Windows(providerID)})
+}
+
+// handleCancelMaintenance serves DELETE /v1/providers/{id}/maintenance, cancelling every declared window
+func (s *Server) handleCancelMaintenance(w http.ResponseWriter, r *http.Request) {
+	providerID := r.PathValue("id")
+	if !s.authorizeProvider(w, r, providerID) {
+		return
+	}
+	if !s.maintenance.Cancel(providerID) {
+		s.writeError(w, http.StatusNotFound, "provider has no declared maintenance window")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleScoreHistory serves GET /v1/providers/{id}/scores?series=...&since=..., the provider's final scores
+// under a policy (the score_series of pairing responses) over the given period (a duration, 24h by default) and
+// their trend
+func (s *Server) handleScoreHistory(w http.ResponseWriter, r *http.Request) {
+	series := r.URL.Query().Get("series")
+	if series == "" {
+		s.writeError(w, http.StatusBadRequest, "series is required, see score_series in pairing responses")
+		return
+	}
+	window := defaultScoreHistoryWindow
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		var err error
+		if window, err = time.ParseDuration(raw); err != nil || window <= 0 {
+			s.writeError(w, http.StatusBadRequest, "since must be a positive duration, e.g. 24h")
+			return
+		}
+	}
+	providerID := r.PathValue("id")
+	since := time.Now().Add(-window)
+	response := ScoreHistoryResponse{
+		ProviderID: providerID,
+		Series:     series,
+		Samples:    s.scoreHistory.History(providerID, timeseries.ScoreMetric(series), since),
+	}
+	if trend, ok := s.scoreHistory.Trend(providerID, timeseries.ScoreMetric(series), since); ok {
+		response.TrendPerHour = &trend
+	}
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+// handleSnapshotProof serves GET /v1/snapshots/{root}/providers/{id}/proof
+// The proof shows the provider was, or wasn't, among the providers committed to by the snapshot_root of a recent
+// pairing over the server's providers; older snapshots are no longer kept
+func (s *Server) handleSnapshotProof(w http.ResponseWriter, r *http.Request) {
+	root := r.PathValue("root")
+	tree, ok := s.system.(system.SnapshotProver).SnapshotTree(root)
+	if !ok {
+		s.writeError(w, http.StatusNotFound, "unknown or expired snapshot root")
+		return
+	}
+	providerID := r.PathValue("id")
+	response := SnapshotProofResponse{Root: root, ProviderID: providerID}
+	if proof, ok := tree.Prove(providerID); ok {
+		response.Present = true
+		response.Proof = &proof
+	} else if absence, ok := tree.ProveAbsence(providerID); ok {
+		response.Absence = &absence
+	}
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+// handleFeeHistory serves GET /v1/providers/{id}/fees
+// The fees are those of the pairings over the system's own pool, over the since period (24h by default)
+func (s *Server) handleFeeHistory(w http.ResponseWriter, r *http.Request) {
+	window := defaultScoreHistoryWindow
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		var err error
+		if window, err = time.ParseDuration(raw); err != nil || window <= 0 {
+			s.writeError(w, http.StatusBadRequest, "since must be a positive duration, e.g. 24h")
+			return
+		}
+	}
+	providerID := r.PathValue("id")
+	response := FeeHistoryResponse{
+		ProviderID: providerID,
+		Samples:    s.fees.History(providerID, timeseries.MetricFee, time.Now().Add(-window)),
+	}
+	spec := timeseries.AggregateSpec{Metric: timeseries.MetricFee, Kind: timeseries.Mean, Window: window}
+	if mean, ok := s.fees.Aggregate(providerID, spec); ok {
+		response.Mean = &mean
+	}
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+// handleReward serves POST /v1/providers/{id}/reward
+// Consumers grade how well a provider of one of their pairings served them, from 0 to 1; the bandit favours
+// providers earning more
+func (s *Server) handleReward(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		s.writeError(w, http.StatusUnauthorized, "rewards require authentication")
+		return
+	}
+	var report RewardReport
+	if err := decodeBody(r, &report); err != nil {
+		s.writeBodyError(w, err)
+		return
+	}
+	if math.IsNaN(report.Reward) || report.Reward < 0 || report.Reward > 1 {
+		s.writeError(w, http.StatusBadRequest, bandit.ErrInvalidReward.Error())
+		return
+	}
+	providerID := r.PathValue("id")
+	// Redeemed before reporting, so concurrent reports can't both count, and given back if the report fails
+	unredeem, err := s.rewards.redeem(principal.ID, report.RequestID, providerID)
+	if err != nil {
+		s.writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err := s.bandit.Report(providerID, report.Reward); err != nil {
+		unredeem()
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, s.bandit.Stats(providerID))
+}
+
+/* ***********************************************************************
+ *                                POLICIES                               *
+ *********************************************************************** */
+
+// handleListPolicies serves GET /v1/policies, the latest revision of every policy the caller saved, or of every
+// saved policy for admins
+func (s *Server) handleListPolicies(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		s.writeError(w, http.StatusUnauthorized, "listing policies requires authentication")
+		return
+	}
+	owner := principal.ID
+	if principal.Admin {
+		owner = ""
+	}
+	s.writeJSON(w, http.StatusOK, PoliciesResponse{Policies: s.policies.List(owner)})
+}
+
+// handleGetPolicy serves GET /v1/policies/{name}?revision=..., the latest revision unless one is requested
+// Only the policy's owner, or an admin, may read it
+func (s *Server) handleGetPolicy(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizePolicyRead(w, r, r.PathValue("name")) {
+		return
+	}
+	revision := 0
+	if raw := r.URL.Query().Get("revision"); raw != "" {
+		var err error
+		if revision, err = strconv.Atoi(raw); err != nil || revision < 1 {
+			s.writeError(w, http.StatusBadRequest, "revision must be a positive integer")
+			return
+		}
+	}
+	stored, err := s.policies.Get(r.PathValue("name"), revision)
+	if err != nil {
+		s.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, stored)
+}
+
+// handlePolicyRevisions serves GET /v1/policies/{name}/revisions, oldest first
+// Only the policy's owner, or an admin, may read them
+func (s *Server) handlePolicyRevisions(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizePolicyRead(w, r, r.PathValue("name")) {
+		return
+	}
+	revisions, err := s.policies.Revisions(r.PathValue("name"))
+	if err != nil {
+		s.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, PoliciesResponse{Policies: revisions})
+}
+
+// handlePutPolicy serves PUT /v1/policies/{name}
+// The body is a serialized ConsumerPolicy, validated like pairing requests' and saved as a new revision
+func (s *Server) handlePutPolicy(w http.ResponseWriter, r *http.Request) {
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.writeBodyError(w, err)
+		return
+	}
+	if max := s.limits.MaxPolicyBytes; max > 0 && len(raw) > max {
+		err := &pairingerrors.LimitExceededError{Limit: "policy bytes", Max: int64(max), Actual: int64(len(raw))}
+		s.writeErrorCode(w, http.StatusRequestEntityTooLarge, pairingerrors.CodeLimitExceeded, err.Error())
+		return
+	}
+	if err := s.checkName("policy name", r.PathValue("name")); err != nil {
+		s.writeErrorCode(w, http.StatusRequestEntityTooLarge, pairingerrors.CodeLimitExceeded, err.Error())
+		return
+	}
+	consumerPolicy, err := policy.UnmarshalStrict(raw, s.limits.MaxListItems)
+	if err != nil {
+		s.writePolicyError(w, err)
+		return
+	}
+	if err := s.validatePolicy(consumerPolicy); err != nil {
+		s.writeErrorCode(w, http.StatusBadRequest, pairingerrors.CodeInvalidPolicy, err.Error())
+		return
+	}
+	name := r.PathValue("name")
+	principal, authenticated := auth.FromContext(r.Context())
+	if !authenticated {
+		s.writeError(w, http.StatusUnauthorized, "changing policies requires authentication")
+		return
+	}
+	// Ownership is checked by the store as the revision is saved, concurrent saves can't take a name over
+	var stored policy.StoredPolicy
+	if principal.Admin {
+		stored, err = s.policies.Override(name, principal.ID, consumerPolicy)
+	} else {
+		stored, err = s.policies.Put(name, principal.ID, consumerPolicy)
+	}
+	switch {
+	case errors.Is(err, policy.ErrNotOwner):
+		s.writeError(w, http.StatusForbidden, err.Error())
+		return
+	case errors.Is(err, policy.ErrTooManyPolicies):
+		s.writeErrorCode(w, http.StatusForbidden, pairingerrors.CodeLimitExceeded, err.Error())
+		return
+	case err != nil:
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.logger.Info("Saved policy", "name", name, "revision", stored.Revision, "owner", stored.Owner, "saved_by", principal.ID)
+	s.writeJSON(w, http.StatusOK, stored)
+}
+
+// handleDeletePolicy serves DELETE /v1/policies/{name}, removing every revision
+// Only the policy's owner, or an admin, may delete it
+func (s *Server) handleDeletePolicy(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	principal, authenticated := auth.FromContext(r.Context())
+	if !authenticated {
+		s.writeError(w, http.StatusUnauthorized, "changing policies requires authentication")
+		return
+	}
+	// Ownership is checked by the store as the policy is deleted, like when it is saved
+	var err error
+	if principal.Admin {
+		err = s.policies.ForceDelete(name)
+	} else {
+		err = s.policies.Delete(name, principal.ID)
+	}
+	switch {
+	case errors.Is(err, policy.ErrNotOwner):
+		s.writeError(w, http.StatusForbidden, err.Error())
+		return
+	case err != nil:
+		s.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	s.logger.Info("Deleted policy", "name", name, "deleted_by", principal.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizeProvider checks that the caller speaks for the provider (see auth.Principal.ProviderID), or is an
+// admin, and that the provider is registered (see RegisteredSource)
+// On failure the error response is already written and false is returned
+func (s *Server) authorizeProvider(w http.ResponseWriter, r *http.Request, providerID string) bool {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		s.writeError(w, http.StatusUnauthorized, "provider reports require the provider's credentials")
+		return false
+	}
+	if !principal.Admin && principal.ProviderID != providerID {
+		s.writeError(w, http.StatusForbidden, "credentials don't belong to provider "+providerID)
+		return false
+	}
+	if registered, ok := s.source.(RegisteredSource); ok && !registered.Registered(providerID) {
+		s.writeError(w, http.StatusNotFound, "unknown provider: "+providerID)
+		return false
+	}
+	return true
+}
+
+// authorizePolicyRead checks that the caller may read, or pair with, the named policy: only its owner or an admin
+// Policies owned by others are reported as not found, so their names don't leak
+// On failure the error response is already written and false is returned
+func (s *Server) authorizePolicyRead(w http.ResponseWriter, r *http.Request, name string) bool {
+	principal, authenticated := auth.FromContext(r.Context())
+	if !authenticated {
+		s.writeError(w, http.StatusUnauthorized, "named policies require authentication")
+		return false
+	}
+	if existing, err := s.policies.Get(name, 0); principal.Admin || (err == nil && existing.Owner == principal.ID) {
+		return true
+	}
+	s.writeError(w, http.StatusNotFound, fmt.Sprintf("%v: %q", policy.ErrPolicyNotFound, name))
+	return false
+}
+
+/* ***********************************************************************
+ *                                METRICS                                *
+ *********************************************************************** */
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// instrument records the request count and latency of a route when metrics are enabled
+func (s *Server) instrument(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.metrics == nil {
+			next(w, r)
+			return
+		}
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r)
+		traceID, _ := metrics.TraceIDFromTraceparent(r.Header.Get("traceparent"))
+		s.metrics.requests.Inc(route, strconv.Itoa(recorder.status))
+		s.metrics.duration.ObserveWithExemplar(time.Since(start).Seconds(), traceID, route)
+	}
+}
+
+// observeResult records the pipeline stage durations of a pairing when metrics are enabled
+func (s *Server) observeResult(r *http.Request, result *system.PairingResult) {
+	if s.metrics == nil {
+		return
+	}
+	traceID, _ := metrics.TraceIDFromTraceparent(r.Header.Get("traceparent"))
+	s.metrics.stages.ObserveWithExemplar(result.Durations.Filter.Seconds(), traceID, "filter")
+	s.metrics.stages.ObserveWithExemplar(result.Durations.Rank.Seconds(), traceID, "rank")
+	s.metrics.stages.ObserveWithExemplar(result.Durations.Sort.Seconds(), traceID, "sort")
+	s.metrics.stages.ObserveWithExemplar(result.Durations.Total.Seconds(), traceID, "total")
+	if result.Partial {
+		s.metrics.partial.Inc()
+	}
+}
+
+/* ***********************************************************************
+ *                                 ADMIN                                 *
+ *********************************************************************** */
+
+// requireAdmin restricts a handler to admin principals, denying requests without a principal
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if principal, ok := auth.FromContext(r.Context()); !ok || !principal.Admin {
+			s.writeError(w, http.StatusForbidden, "admin access required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleAdminProviders serves GET /v1/admin/providers?chain_id=...
+// Every provider of the chain is scored under the sample policy, unfiltered, best first
+func (s *Server) handleAdminProviders(w http.ResponseWriter, r *http.Request) {
+	chainID := r.URL.Query().Get("chain_id")
+	providers, err := s.source.Providers(chainID)
+	if err != nil {
+		s.logger.Error("Failed to load providers", "chain_id", chainID, "error", err)
+		s.writeError(w, http.StatusServiceUnavailable, "providers unavailable")
+		return
+	}
+	policy := *s.admin.samplePolicy
+	if policy.ChainID == "" {
+		policy.ChainID = chainID
+	}
+
+	scored := s.system.RankProviders(providers, &policy)
+	sort.SliceStable(scored, func(i, j int) bool { return scored[i].Score > scored[j].Score })
+	response := make([]ProviderScore, 0, len(scored))
+	for _, ps := range scored {
+		response = append(response, ProviderScore{Provider: ps.Provider, Score: ps.Score, Components: ps.Components})
+	}
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+// handleAdminDecisions serves GET /v1/admin/decisions, newest first
+func (s *Server) handleAdminDecisions(w http.ResponseWriter, _ *http.Request) {
+	s.writeJSON(w, http.StatusOK, s.admin.recent())
+}
+
+// handleAdminFilters serves GET /v1/admin/filters
+func (s *Server) handleAdminFilters(w http.ResponseWriter, _ *http.Request) {
+	reporter, ok := s.system.(system.FilterStatsReporter)
+	if !ok {
+		s.writeError(w, http.StatusNotFound, "the pairing system doesn't report filter statistics")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, reporter.FilterStats())
+}
+
+// handleVars serves GET /debug/vars in the expvar format: every published expvar variable (e.g. cmdline and
+// memstats) and the service's RuntimeVars under "pairing"
+// The runtime variables are computed per request instead of published, publishing a name twice panics
+func (s *Server) handleVars(w http.ResponseWriter, _ *http.Request) {
+	vars := RuntimeVars{Goroutines: runtime.NumGoroutine()}
+	if reporter, ok := s.system.(system.RuntimeStatsReporter); ok {
+		stats := reporter.RuntimeStats()
+		vars.System = &stats
+	}
+	if aged, ok := s.source.(AgedSource); ok {
+		now := time.Now()
+		vars.Snapshots = make(map[string]SnapshotAge)
+		for chainID, at := range aged.UpdatedAt() {
+			vars.Snapshots[chainID] = SnapshotAge{UpdatedAt: at, AgeSeconds: now.Sub(at).Seconds()}
+		}
+	}
+	pairingVars, err := json.Marshal(vars)
+	if err != nil {
+		s.logger.Error("Failed to encode runtime variables", "error", err)
+		s.writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(w, "{\n%q: %s", "pairing", pairingVars)
+	expvar.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, ",\n%q: %s", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "\n}\n")
+}
+
+// handleQuarantine serves GET /v1/admin/quarantine
+func (s *Server) handleQuarantine(w http.ResponseWriter, _ *http.Request) {
+	s.writeJSON(w, http.StatusOK, QuarantineResponse{Quarantined: s.anomalies.Quarantined()})
+}
+
+// handleQuarantineRelease serves POST /v1/admin/quarantine/{id}/release
+func (s *Server) handleQuarantineRelease(w http.ResponseWriter, r *http.Request) {
+	providerID := r.PathValue("id")
+	if !s.anomalies.Release(providerID) {
+		s.writeError(w, http.StatusNotFound, "provider is not quarantined")
+		return
+	}
+	s.logger.Info("Released provider from quarantine", "provider_id", providerID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serve records the providers of a pairing served to the consumer as rewardable, forgetting the oldest pairing
+// once rewardablePairings are remembered
+func (l *rewardLedger) serve(consumer string, response PairingResponse) {
+	providers := make(map[string]bool, len(response.Providers)+len(response.Backups))
+	for _, p := range append(response.Providers[:len(response.Providers):len(response.Providers)], response.Backups...) {
+		providers[p.ID] = false
+	}
+	key := consumer + "\x00" + response.Provenance.RequestID
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.pairings[key]; !ok {
+		l.order = append(l.order, key)
+	}
+	l.pairings[key] = providers
+	if len(l.order) > rewardablePairings {
+		delete(l.pairings, l.order[0])
+		l.order = l.order[1:]
+	}
+}
+
+// redeem marks the provider of the consumer's pairing as rewarded, failing if the pairing is unknown, didn't
+// return the provider, or the provider was rewarded for it already
+// The returned func gives the redemption back, e.g. when the reward couldn't be reported
+func (l *rewardLedger) redeem(consumer, requestID, providerID string) (unredeem func(), err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := consumer + "\x00" + requestID
+	providers, ok := l.pairings[key]
+	if !ok {
+		return nil, fmt.Errorf("no recent pairing %q served to the consumer", requestID)
+	}
+	rewarded, ok := providers[providerID]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("pairing %q didn't return provider %s", requestID, providerID)
+	case rewarded:
+		return nil, fmt.Errorf("provider %s was already rewarded for pairing %q", providerID, requestID)
+	}
+	providers[providerID] = true
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		// The pairing may have been evicted, or served again under the same request ID, in the meantime
+		if current, ok := l.pairings[key]; ok && current[providerID] {
+			current[providerID] = false
+		}
+	}, nil
+}
+
+// record adds a served pairing to the recent decisions, overwriting the oldest one once full
+func (a *adminState) record(policy *pairing.ConsumerPolicy, response PairingResponse) {
+	decision := Decision{
+		At:            response.Provenance.Timestamp,
+		ConsumerID:    policy.ConsumerID,
+		ChainID:       policy.ChainID,
+		Providers:     make([]string, 0, len(response.Providers)),
+		ConfigHash:    response.Provenance.ConfigHash,
+		Partial:       response.Partial,
+		ExperimentArm: response.ExperimentArm,
+		Counts:        response.Provenance.Counts,
+	}
+	for _, p := range response.Providers {
+		decision.Providers = append(decision.Providers, p.ID)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.decisions) < recentDecisionCount {
+		a.decisions = append(a.decisions, decision)
+		return
+	}
+	a.decisions[a.next] = decision
+	a.next = (a.next + 1) % recentDecisionCount
+}
+
+// recent returns a copy of the recorded decisions, newest first
+func (a *adminState) recent() []Decision {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	result := make([]Decision, 0, len(a.decisions))
+	for i := len(a.decisions) - 1; i >= 0; i-- {
+		result = append(result, a.decisions[(a.next+i)%len(a.decisions)])
+	}
+	return result
+}
+
+// inferLocation fills the policy location from the client IP
+// Failures are only logged, the request then proceeds with the policy as sent
+func (s *Server) inferLocation(r *http.Request, policy *pairing.ConsumerPolicy) {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		s.logger.Debug("Cannot parse client address for location inference", "remote_addr", r.RemoteAddr, "error", err)
+		return
+	}
+	if _, err := s.geoIP.Apply(policy, addrPort.Addr()); err != nil {
+		s.logger.Debug("Location inference failed", "error", err)
+		return
+	}
+	s.logger.Debug("Inferred consumer location", "client_ip", addrPort.Addr(), "required_location", policy.RequiredLocation, "preferred_locations", policy.PreferredLocations)
+}
+
+// writeJSON writes v as a JSON response body with the given status code
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.Error("Failed to write response", "error", err)
+	}
+}
+
+// writeError writes a JSON error response with the given status code
+func (s *Server) writeError(w http.ResponseWriter, status int, msg string) {
+	s.writeJSON(w, status, errorResponse{Error: msg})
+}
+
+// writeBodyError writes the error response of a request body that couldn't be decoded, 413 if it was cut off
+// at Limits.MaxBodyBytes
+func (s *Server) writeBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		err := &pairingerrors.LimitExceededError{Limit: "request body bytes", Max: tooLarge.Limit}
+		s.logger.Warn("Rejected oversized request body", "error", err)
+		s.writeErrorCode(w, http.StatusRequestEntityTooLarge, pairingerrors.CodeLimitExceeded, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid request body: " + err.Error(), Fields: decodeFields(err)})
+}
+
+// writePolicyError writes the 400 response of a policy that couldn't be decoded, with the fields at fault
+func (s *Server) writePolicyError(w http.ResponseWriter, err error) {
+	resp := errorResponse{Error: "invalid policy: " + err.Error(), Code: pairingerrors.CodeInvalidPolicy}
+	var schemaErr *policy.SchemaError
+	if errors.As(err, &schemaErr) {
+		resp.Fields = schemaErr.Fields
+	} else {
+		resp.Fields = decodeFields(err)
+	}
+	s.writeJSON(w, http.StatusBadRequest, resp)
+}
+
+// decodeBody decodes a JSON request body into v, ignoring fields v doesn't define
+func decodeBody(r *http.Request, v any) error {
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+// decodeStrictBody is decodeBody, rejecting fields v doesn't define
+// Only pairing requests are decoded strictly, a typo there silently changes which providers are paired
+func decodeStrictBody(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// decodeFields locates a JSON decoding error to the field at fault, nil when it can't be
+func decodeFields(err error) []pairingerrors.FieldError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return []pairingerrors.FieldError{{Field: typeErr.Field, Message: "must be a JSON " + jsonType(typeErr.Type)}}
+	}
+	// NOTE: encoding/json has no error type for unknown fields, only this message
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		if field, err := strconv.Unquote(field); err == nil {
+			return []pairingerrors.FieldError{{Field: field, Message: "unknown field"}}
+		}
+	}
+	return nil
+}
+
+// jsonType names the JSON type a Go type decodes from
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// writeErrorCode writes a JSON error response with the given status code, classified with a
+// pairingerrors code so clients don't have to match on the message
+func (s *Server) writeErrorCode(w http.ResponseWriter, status int, code pairingerrors.Code, msg string) {
+	s.writeJSON(w, status, errorResponse{Error: msg, Code: code})
+}
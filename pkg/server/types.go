@@ -0,0 +1,359 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/address"
+	"github.com/Yoaz/LavaPairingSystem/pkg/anomaly"
+	"github.com/Yoaz/LavaPairingSystem/pkg/auth"
+	"github.com/Yoaz/LavaPairingSystem/pkg/bandit"
+	"github.com/Yoaz/LavaPairingSystem/pkg/commitment"
+	"github.com/Yoaz/LavaPairingSystem/pkg/feature"
+	"github.com/Yoaz/LavaPairingSystem/pkg/geoip"
+	"github.com/Yoaz/LavaPairingSystem/pkg/jail"
+	"github.com/Yoaz/LavaPairingSystem/pkg/maintenance"
+	"github.com/Yoaz/LavaPairingSystem/pkg/metrics"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairingerrors"
+	"github.com/Yoaz/LavaPairingSystem/pkg/policy"
+	"github.com/Yoaz/LavaPairingSystem/pkg/quota"
+	"github.com/Yoaz/LavaPairingSystem/pkg/scheduler"
+	"github.com/Yoaz/LavaPairingSystem/pkg/system"
+	"github.com/Yoaz/LavaPairingSystem/pkg/timeseries"
+	"github.com/Yoaz/LavaPairingSystem/pkg/uptime"
+)
+
+// Pairing subscriptions (see WithScheduler) buffer a few updates per subscriber and send a keep-alive while idle
+const (
+	subscriptionBuffer    = 4
+	subscriptionKeepAlive = 30 * time.Second
+)
+
+// maxReportedLoad caps reported loads (see LoadReport), an overloaded provider is well past 0 in score.LoadScore
+// long before it, and a single report can't skew the load EWMA further
+const maxReportedLoad = 2.0
+
+// maxReportedLatency caps reported latencies in milliseconds (see LatencyReport), a provider is well past 0 in
+// score.LatencyScore long before it, and a single report can't skew the latency EWMA further
+const maxReportedLatency = 60_000.0
+
+// requestIDHeader carries a caller-chosen pairing request ID, one is generated when it's missing
+const requestIDHeader = "X-Request-ID"
+
+// ProviderSource supplies the pool of providers a pairing request is evaluated against
+type ProviderSource interface {
+	Providers(chainID string) ([]*pairing.Provider, error)
+}
+
+// VersionedSource is a ProviderSource versioning each chain's providers, so the pairing system can reuse their
+// pool-wide aggregates across requests (see system.WithAggregateCache)
+type VersionedSource interface {
+	ProviderSource
+	// VersionedProviders returns the chain's providers along with their version, read together so the version
+	// always matches the providers; it changes whenever they do
+	VersionedProviders(chainID string) ([]*pairing.Provider, string, error)
+}
+
+// AgedSource is a ProviderSource reporting when each chain's providers last changed, so the diagnostics can
+// report the age of the snapshot being paired over (see WithDiagnostics)
+type AgedSource interface {
+	ProviderSource
+	// UpdatedAt returns when the providers of each chain last changed, by chain ID
+	UpdatedAt() map[string]time.Time
+}
+
+// RegisteredSource is a ProviderSource telling whether a provider is registered on any chain, so reports
+// about providers (failures, heartbeats, load, maintenance windows, rewards) are only accepted for real ones
+// Reports are accepted for any provider ID with a source that isn't a RegisteredSource
+type RegisteredSource interface {
+	ProviderSource
+	Registered(providerID string) bool
+}
+
+// StaticSource is a ProviderSource serving the same fixed provider list for every chain
+type StaticSource []*pairing.Provider
+
+// Limits bound what a single request can make the server hold in memory, see WithLimits
+// Zero fields are unlimited
+type Limits struct {
+	MaxBodyBytes           int64 // Size of a request body, reading stops past it
+	MaxPolicyBytes         int   // Size of a serialized policy, sent inline or saved under a name, or of template params
+	MaxNameBytes           int   // Length of a policy or template name
+	MaxProviders           int   // Providers sent with a pairing request
+	MaxFeaturesPerProvider int   // Features of each provider sent with a pairing request
+	MaxListItems           int   // Entries of each list or map of a policy, see policy.UnmarshalStrict, and template params
+	MaxSubscriptions       int   // Open pairing subscriptions of each consumer, see WithScheduler
+}
+
+// DefaultLimits are the limits of a server created without WithLimits, roomy for any Lava chain
+var DefaultLimits = Limits{
+	MaxBodyBytes:           16 << 20,
+	MaxPolicyBytes:         64 << 10,
+	MaxNameBytes:           256,
+	MaxProviders:           10_000,
+	MaxFeaturesPerProvider: 256,
+	MaxListItems:           256,
+	MaxSubscriptions:       8,
+}
+
+// Option configures optional Server behaviour
+type Option func(*Server)
+
+// Server exposes a PairingSystem over HTTP
+type Server struct {
+	system          system.PairingSystem
+	source          ProviderSource
+	logger          *slog.Logger
+	authenticators  []auth.Authenticator // If empty, the API is served without authentication
+	tlsConfig       *tls.Config
+	geoIP           *geoip.Inferrer             // Optional, infers RequiredLocation from the client IP when the policy leaves it empty
+	jailer          *jail.Jailer                // Optional, enables provider failure reports
+	slashes         pairing.SlashSource         // Optional, attaches pending slashes to providers before pairing
+	uptime          *uptime.Tracker             // Optional, enables provider heartbeats
+	bandit          *bandit.Bandit              // Optional, enables provider reward reports
+	rewards         *rewardLedger               // Pairings rewards may be reported for, set with bandit
+	scheduler       *scheduler.Scheduler        // Optional, enables pairing subscriptions
+	subscriptionSeq atomic.Uint64               // Makes subscription keys unique per connection
+	subscribers     subscriberCounts            // Open subscriptions by consumer, see Limits.MaxSubscriptions
+	admin           *adminState                 // Optional, enables the read-only admin endpoints
+	features        *feature.Catalog            // Optional, rejects policies and flags providers referencing unknown features
+	policies        *policy.Store               // Optional, enables named policies
+	templates       map[string]*policy.Template // Policy templates requests may execute, by name
+	metrics         *serverMetrics              // Optional, instruments the API and serves GET /metrics
+	scoreHistory    *timeseries.Store           // Optional, serves provider score history
+	anomalies       *anomaly.Detector           // Optional, enables quarantine review
+	maintenance     *maintenance.Schedule       // Optional, enables maintenance window declarations
+	loads           *timeseries.Store           // Optional, enables provider load reports
+	latencies       *timeseries.Store           // Optional, enables provider latency reports by admin probes
+	fees            *timeseries.Store           // Optional, serves provider fee history
+	quota           *quota.Tracker              // Optional, charges filter and rank requests against consumer quotas
+	addresses       *address.Parser             // Optional, validates and normalizes the consumer IDs of policies
+	diagnostics     bool                        // Serve the pprof and expvar endpoints, see WithDiagnostics
+	limits          Limits                      // Bounds on request sizes, DefaultLimits unless set with WithLimits
+	httpServer      *http.Server
+}
+
+// subscriberCounts counts the open pairing subscriptions of each consumer
+type subscriberCounts struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// serverMetrics are the metrics recorded by the server, see WithMetrics
+type serverMetrics struct {
+	registry *metrics.Registry
+	requests *metrics.CounterVec   // By route and status code
+	duration *metrics.HistogramVec // By route, with trace exemplars
+	stages   *metrics.HistogramVec // By pairing pipeline stage
+	partial  *metrics.CounterVec
+}
+
+// PairingRequest is the body of a POST /v1/pairing request
+type PairingRequest struct {
+	ChainID string `json:"chain_id"`
+	TopN    int    `json:"top_n,omitempty"` // Optional number of providers to select, overriding the configured and policy ones
+	// Policy is a serialized ConsumerPolicy of any supported schema version, see policy.Unmarshal
+	Policy json.RawMessage `json:"policy"`
+	// PolicyName, instead of Policy, pairs with a policy saved under this name (see WithPolicyStore), at
+	// PolicyRevision or its latest revision if that is 0
+	PolicyName     string `json:"policy_name,omitempty"`
+	PolicyRevision int    `json:"policy_revision,omitempty"`
+	// Template, instead of Policy, pairs with the policy template of this name (see WithPolicyTemplates) filled
+	// with Params
+	Template string         `json:"template,omitempty"`
+	Params   map[string]any `json:"params,omitempty"`
+	// Providers, when set, are evaluated instead of the chain's providers from the server's source
+	Providers []*pairing.Provider `json:"providers,omitempty"`
+}
+
+// PairingResponse is the body of a successful POST /v1/pairing response
+type PairingResponse struct {
+	Providers []*pairing.Provider `json:"providers"`
+	Backups   []*pairing.Provider `json:"backups,omitempty"` // Next eligible providers to fail over to, see the policy's backups
+	// Hints tell how to dial the returned providers, by provider ID, see system.WithConnectionHints
+	Hints   map[string]system.ConnectionHints `json:"hints,omitempty"`
+	Partial bool                              `json:"partial,omitempty"` // A pairing stage timed out, see system.WithStageTimeouts
+	// ConstraintsUnmet is set when the selection fell back to a best-effort one, see system.ConstrainedSelection
+	ConstraintsUnmet bool       `json:"constraints_unmet,omitempty"`
+	ExperimentArm    string     `json:"experiment_arm,omitempty"` // Set when the system is an experiment.Router
+	Provenance       Provenance `json:"provenance"`
+	// Diagnostics report the providers excluded because their data is invalid
+	Diagnostics []system.Diagnostic `json:"diagnostics,omitempty"`
+}
+
+// InterfacesPairingResponse is the body of a successful POST /v1/pairing/interfaces response
+type InterfacesPairingResponse struct {
+	Interfaces map[string]PairingResponse `json:"interfaces"` // Pairing by API interface
+}
+
+// PoliciesResponse is the body of a successful GET /v1/policies or GET /v1/policies/{name}/revisions response
+type PoliciesResponse struct {
+	Policies []policy.StoredPolicy `json:"policies"`
+}
+
+// FilterResponse is the body of a successful POST /v1/pairing/filter response
+type FilterResponse struct {
+	Providers []*pairing.Provider `json:"providers"`
+}
+
+// RankResponse is the body of a successful POST /v1/pairing/rank response
+type RankResponse struct {
+	Scores []ProviderScore `json:"scores"`
+}
+
+// ConfigResponse is the body of a successful GET /v1/config response
+type ConfigResponse struct {
+	ConfigFingerprint string `json:"config_fingerprint"` // See system.PairingSystem.ConfigFingerprint
+}
+
+// Provenance describes how a pairing response was produced
+type Provenance struct {
+	RequestID  string `json:"request_id"`  // Identifies the request in the pairing system's logs
+	ConfigHash string `json:"config_hash"` // Identifies the configuration and weights used
+	// ScoreSeries identifies the policy the scores were recorded under, see GET /v1/providers/{id}/scores
+	ScoreSeries string `json:"score_series,omitempty"`
+	// SnapshotRoot commits to the providers considered, see system.PairingResult.SnapshotRoot
+	SnapshotRoot string             `json:"snapshot_root,omitempty"`
+	Timestamp    time.Time          `json:"timestamp"`
+	Counts       system.StageCounts `json:"counts"`
+	ElapsedMS    float64            `json:"elapsed_ms"`
+}
+
+// RuntimeVars are the service's runtime diagnostics, published as the "pairing" variable of GET /debug/vars
+type RuntimeVars struct {
+	Goroutines int `json:"goroutines"`
+	// System is the pairing system's worker pool and cache usage, nil when it doesn't report it
+	System *system.RuntimeStats `json:"system,omitempty"`
+	// Snapshots are the ages of the providers served for each chain, by chain ID, when the source reports them
+	Snapshots map[string]SnapshotAge `json:"provider_snapshots,omitempty"`
+}
+
+// SnapshotAge is how long ago a chain's providers last changed
+type SnapshotAge struct {
+	UpdatedAt  time.Time `json:"updated_at"`
+	AgeSeconds float64   `json:"age_seconds"`
+}
+
+// FailureReport is the body of a POST /v1/providers/{id}/failures request
+type FailureReport struct {
+	Reason string `json:"reason"`
+}
+
+// RewardReport is the body of a POST /v1/providers/{id}/reward request
+type RewardReport struct {
+	Reward float64 `json:"reward"` // How well the provider served the consumer, from 0 to 1
+	// RequestID is the provenance request ID of the pairing that returned the provider to the consumer
+	RequestID string `json:"request_id"`
+}
+
+// LoadReport is the body of a POST /v1/providers/{id}/load request
+type LoadReport struct {
+	Load float64 `json:"load"` // Utilization, 0 idle and 1 at capacity
+}
+
+// LatencyReport is the body of a POST /v1/admin/providers/{id}/latency request
+type LatencyReport struct {
+	LatencyMS float64 `json:"latency_ms"` // Round trip of a probe request to the provider, in milliseconds
+}
+
+// FailureReportResponse is the body of a successful POST /v1/providers/{id}/failures response
+type FailureReportResponse struct {
+	Jailed bool `json:"jailed"` // Whether the provider is jailed after this report
+}
+
+// QuarantineResponse is the body of a successful GET /v1/admin/quarantine response
+type QuarantineResponse struct {
+	Quarantined []anomaly.Anomaly `json:"quarantined"` // Anomaly that got each quarantined provider quarantined
+}
+
+// MaintenanceResponse is the body of a successful GET or POST /v1/providers/{id}/maintenance response
+type MaintenanceResponse struct {
+	ProviderID string               `json:"provider_id"`
+	Windows    []maintenance.Window `json:"windows"` // Declared windows not over yet, by start time
+}
+
+// ScoreHistoryResponse is the body of a successful GET /v1/providers/{id}/scores response
+type ScoreHistoryResponse struct {
+	ProviderID string              `json:"provider_id"`
+	Series     string              `json:"series"`  // Policy the scores were recorded under, see Provenance.ScoreSeries
+	Samples    []timeseries.Sample `json:"samples"` // Final scores, oldest first
+	// TrendPerHour is the least-squares slope of the scores, negative when the provider's quality degrades;
+	// omitted with fewer than two samples
+	TrendPerHour *float64 `json:"trend_per_hour,omitempty"`
+}
+
+// FeeHistoryResponse is the body of a successful GET /v1/providers/{id}/fees response
+type FeeHistoryResponse struct {
+	ProviderID string              `json:"provider_id"`
+	Samples    []timeseries.Sample `json:"samples"`        // Fees the provider was paired with, oldest first
+	Mean       *float64            `json:"mean,omitempty"` // Mean fee over the period, omitted without samples
+}
+
+// SnapshotProofResponse is the body of a successful GET /v1/snapshots/{root}/providers/{id}/proof response,
+// Proof or Absence verifying against Root (see commitment.Proof.Verify and commitment.AbsenceProof.Verify)
+type SnapshotProofResponse struct {
+	Root       string                   `json:"root"`
+	ProviderID string                   `json:"provider_id"`
+	Present    bool                     `json:"present"`
+	Proof      *commitment.Proof        `json:"proof,omitempty"`   // Inclusion proof, when Present
+	Absence    *commitment.AbsenceProof `json:"absence,omitempty"` // Absence proof, otherwise
+}
+
+// defaultScoreHistoryWindow is the period GET /v1/providers/{id}/scores and GET /v1/providers/{id}/fees cover
+// without a since parameter
+const defaultScoreHistoryWindow = 24 * time.Hour
+
+// rewardablePairings is the number of recent pairings consumers may still report rewards for, see WithBandit
+const rewardablePairings = 10_000
+
+// rewardLedger remembers the recent pairings served to authenticated consumers over the source's providers, so
+// each consumer rewards each provider it was paired with at most once per pairing
+type rewardLedger struct {
+	mu       sync.Mutex
+	pairings map[string]map[string]bool // Consumer and request ID -> provider ID -> whether it was rewarded
+	order    []string                   // Keys of pairings, oldest first
+}
+
+// recentDecisionCount is the number of pairing decisions kept for GET /v1/admin/decisions
+const recentDecisionCount = 100
+
+// ProviderScore is a provider scored under the admin sample policy, see GET /v1/admin/providers
+type ProviderScore struct {
+	Provider   *pairing.Provider  `json:"provider"`
+	Score      float64            `json:"score"`
+	Components map[string]float64 `json:"components"`
+}
+
+// Decision is a pairing served by POST /v1/pairing, see GET /v1/admin/decisions
+type Decision struct {
+	At            time.Time          `json:"at"`
+	ConsumerID    address.Address    `json:"consumer_id,omitempty"`
+	ChainID       string             `json:"chain_id,omitempty"`
+	Providers     []string           `json:"providers"` // Selected provider IDs, best first
+	ConfigHash    string             `json:"config_hash"`
+	Partial       bool               `json:"partial,omitempty"`
+	ExperimentArm string             `json:"experiment_arm,omitempty"`
+	Counts        system.StageCounts `json:"counts"`
+}
+
+// adminState backs the admin endpoints, see WithAdmin
+type adminState struct {
+	samplePolicy *pairing.ConsumerPolicy // Policy providers are scored under by GET /v1/admin/providers
+	mu           sync.Mutex
+	decisions    []Decision // Ring buffer of the latest decisions
+	next         int        // Index the next decision is written at once the buffer is full
+}
+
+// errorResponse is the body of any non-2xx response
+type errorResponse struct {
+	Error string             `json:"error"`
+	Code  pairingerrors.Code `json:"code,omitempty"` // See pairingerrors.CodeOf
+	// Fields locate the problem to the request's fields, when it can be, e.g. a policy's unknown fields
+	Fields []pairingerrors.FieldError `json:"fields,omitempty"`
+}
@@ -0,0 +1,167 @@
+package snapshot
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Capture takes a snapshot of every non-nil component
+func (c Components) Capture() *State {
+	state := &State{Version: CurrentVersion, TakenAt: time.Now()}
+	if c.Jailer != nil {
+		snapshot := c.Jailer.Snapshot()
+		state.Jail = &snapshot
+	}
+	if c.Uptime != nil {
+		snapshot := c.Uptime.Snapshot()
+		state.Uptime = &snapshot
+	}
+	if c.Quota != nil {
+		snapshot := c.Quota.Snapshot()
+		state.Quota = &snapshot
+	}
+	if c.Metrics != nil {
+		snapshot := c.Metrics.Snapshot()
+		state.Metrics = &snapshot
+	}
+	if c.Policies != nil {
+		snapshot := c.Policies.Snapshot()
+		state.Policies = &snapshot
+	}
+	if c.Anomalies != nil {
+		snapshot := c.Anomalies.Snapshot()
+		state.Anomalies = &snapshot
+	}
+	if c.Maintenance != nil {
+		snapshot := c.Maintenance.Snapshot()
+		state.Maintenance = &snapshot
+	}
+	if c.Fairness != nil {
+		snapshot := c.Fairness.Snapshot()
+		state.Fairness = &snapshot
+	}
+	if c.Bandit != nil {
+		snapshot := c.Bandit.Snapshot()
+		state.Bandit = &snapshot
+	}
+	return state
+}
+
+// Restore loads a state into every non-nil component
+// Components missing from the state are left untouched
+func (c Components) Restore(state *State) error {
+	if state.Version < 1 || state.Version > CurrentVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, state.Version)
+	}
+	if c.Uptime != nil && state.Uptime != nil {
+		// Checked first, so a mismatching snapshot doesn't leave the other components half restored
+		if err := c.Uptime.Restore(*state.Uptime); err != nil {
+			return fmt.Errorf("restore uptime: %w", err)
+		}
+	}
+	if c.Jailer != nil && state.Jail != nil {
+		c.Jailer.Restore(*state.Jail)
+	}
+	if c.Quota != nil && state.Quota != nil {
+		c.Quota.Restore(*state.Quota)
+	}
+	if c.Metrics != nil && state.Metrics != nil {
+		c.Metrics.Restore(*state.Metrics)
+	}
+	if c.Policies != nil && state.Policies != nil {
+		c.Policies.Restore(*state.Policies)
+	}
+	if c.Anomalies != nil && state.Anomalies != nil {
+		c.Anomalies.Restore(*state.Anomalies)
+	}
+	if c.Maintenance != nil && state.Maintenance != nil {
+		c.Maintenance.Restore(*state.Maintenance)
+	}
+	if c.Fairness != nil && state.Fairness != nil {
+		c.Fairness.Restore(*state.Fairness)
+	}
+	if c.Bandit != nil && state.Bandit != nil {
+		c.Bandit.Restore(*state.Bandit)
+	}
+	return nil
+}
+
+// Export captures the components and writes their state to w in the given format
+func (c Components) Export(w io.Writer, format Format) error {
+	state := c.Capture()
+	switch format {
+	case FormatJSON:
+		if err := json.NewEncoder(w).Encode(state); err != nil {
+			return fmt.Errorf("encode state: %w", err)
+		}
+	case FormatGob:
+		if err := gob.NewEncoder(w).Encode(state); err != nil {
+			return fmt.Errorf("encode state: %w", err)
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+	return nil
+}
+
+// Import reads a state in the given format from r and restores it into the components
+func (c Components) Import(r io.Reader, format Format) error {
+	var state State
+	switch format {
+	case FormatJSON:
+		if err := json.NewDecoder(r).Decode(&state); err != nil {
+			return fmt.Errorf("decode state: %w", err)
+		}
+	case FormatGob:
+		if err := gob.NewDecoder(r).Decode(&state); err != nil {
+			return fmt.Errorf("decode state: %w", err)
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+	return c.Restore(&state)
+}
+
+// SaveFile exports the components' state to path, in gob if it ends in ".gob" and JSON otherwise
+// The file is replaced atomically, so a crash mid-write never leaves a truncated state behind
+func (c Components) SaveFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	if err := c.Export(tmp, FormatForPath(path)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadFile imports the state saved at path by SaveFile
+// A missing file is reported with an error satisfying errors.Is(err, fs.ErrNotExist), i.e. a cold start
+func (c Components) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.Import(f, FormatForPath(path))
+}
+
+// FormatForPath picks the format of a state file from its extension
+func FormatForPath(path string) Format {
+	if strings.EqualFold(filepath.Ext(path), ".gob") {
+		return FormatGob
+	}
+	return FormatJSON
+}
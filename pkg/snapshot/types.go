@@ -0,0 +1,65 @@
+package snapshot
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/anomaly"
+	"github.com/Yoaz/LavaPairingSystem/pkg/bandit"
+	"github.com/Yoaz/LavaPairingSystem/pkg/fairness"
+	"github.com/Yoaz/LavaPairingSystem/pkg/jail"
+	"github.com/Yoaz/LavaPairingSystem/pkg/maintenance"
+	"github.com/Yoaz/LavaPairingSystem/pkg/policy"
+	"github.com/Yoaz/LavaPairingSystem/pkg/quota"
+	"github.com/Yoaz/LavaPairingSystem/pkg/timeseries"
+	"github.com/Yoaz/LavaPairingSystem/pkg/uptime"
+)
+
+// CurrentVersion is the state schema version written by Export
+const CurrentVersion = 1
+
+// ErrUnsupportedVersion is returned when a state was written with a schema version this code doesn't know
+var ErrUnsupportedVersion = errors.New("unsupported state version")
+
+// ErrUnknownFormat is returned for encodings other than FormatJSON and FormatGob
+var ErrUnknownFormat = errors.New("unknown state format")
+
+// Format is the encoding of an exported state
+type Format string
+
+const (
+	FormatJSON Format = "json" // Human readable, suited for inspection and hand edits
+	FormatGob  Format = "gob"  // Compact and faster to load, suited for large metric histories
+)
+
+// Components are the stateful parts of a running service captured by a snapshot
+// Nil components are neither exported nor restored
+type Components struct {
+	Jailer   *jail.Jailer      // Failure reports and jail terms (cooldowns)
+	Uptime   *uptime.Tracker   // Heartbeat histories behind uptime scores
+	Quota    *quota.Tracker    // Per-consumer limit overrides and usage of the current epoch
+	Metrics  *timeseries.Store // Provider metric history behind rolling aggregates
+	Policies *policy.Store     // Named consumer policies and their revisions
+	// Previous inspections, quarantines and accepted fees of the anomaly detector
+	Anomalies   *anomaly.Detector
+	Maintenance *maintenance.Schedule // Maintenance windows declared through the API
+	Fairness    *fairness.Tracker     // Selection shares within the fairness window
+	Bandit      *bandit.Bandit        // Reward feedback of the bandit selection strategy
+}
+
+// State is the accumulated knowledge of a service, exported so a restart resumes with it instead of
+// cold-starting
+type State struct {
+	Version  int                   `json:"version"`
+	TakenAt  time.Time             `json:"taken_at"`
+	Jail     *jail.Snapshot        `json:"jail,omitempty"`
+	Uptime   *uptime.Snapshot      `json:"uptime,omitempty"`
+	Quota    *quota.Snapshot       `json:"quota,omitempty"`
+	Metrics  *timeseries.Snapshot  `json:"metrics,omitempty"`
+	Policies *policy.StoreSnapshot `json:"policies,omitempty"`
+
+	Anomalies   *anomaly.Snapshot     `json:"anomalies,omitempty"`
+	Maintenance *maintenance.Snapshot `json:"maintenance,omitempty"`
+	Fairness    *fairness.Snapshot    `json:"fairness,omitempty"`
+	Bandit      *bandit.Snapshot      `json:"bandit,omitempty"`
+}
@@ -0,0 +1,79 @@
+package system
+
+import (
+	"context"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/internal/utils"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/score"
+)
+
+// poolKey is the context key of the provider pool of a GetPairingList call
+type poolKey struct{}
+
+// pool identifies the validated providers of a GetPairingList call, see PairingOptions.PoolVersion
+type pool struct {
+	version string
+	size    int
+}
+
+// withPool returns a context carrying the call's provider pool
+func withPool(ctx context.Context, p pool) context.Context {
+	return context.WithValue(ctx, poolKey{}, p)
+}
+
+// newAggregateCache returns an empty cache keeping entries for ttl
+func newAggregateCache(ttl time.Duration) *aggregateCache {
+	return &aggregateCache{ttl: ttl, entries: make(map[string]cachedAggregates)}
+}
+
+// get returns the aggregates cached for a pool version, if they haven't expired
+func (c *aggregateCache) get(version string, now time.Time) (cachedAggregates, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[version]
+	if !ok || now.After(entry.expires) {
+		return cachedAggregates{}, false
+	}
+	return entry, true
+}
+
+// put caches the aggregates of a pool version, dropping expired entries such as those of replaced versions
+func (c *aggregateCache) put(version string, entry cachedAggregates, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for v, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, v)
+		}
+	}
+	entry.expires = now.Add(c.ttl)
+	c.entries[version] = entry
+}
+
+// poolAggregates returns the max effective stake and normalized fees of the providers, and their columns with
+// WithColumnarScoring, from the cache when they are the call's whole versioned pool (see WithAggregateCache)
+// Filtering only removes providers, so providers as many as the pool are the pool itself
+func (ps *pairingSystem) poolAggregates(ctx context.Context, providers []*pairing.Provider) cachedAggregates {
+	p, ok := ctx.Value(poolKey{}).(pool)
+	cacheable := ps.aggregates != nil && ok && p.version != "" && p.size == len(providers)
+	now := time.Now()
+	if cacheable {
+		if entry, ok := ps.aggregates.get(p.version, now); ok {
+			ps.log(ctx).Debug("Reusing cached pool aggregates", "pool_version", p.version)
+			return entry
+		}
+	}
+	entry := cachedAggregates{
+		maxStake: utils.ComputeMaxStake(providers, ps.delegationFactor),
+		fees:     utils.ComputeNormalizedFees(providers),
+	}
+	if ps.columnar {
+		entry.columns = score.NewColumns(providers, ps.delegationFactor)
+	}
+	if cacheable {
+		ps.aggregates.put(p.version, entry, now)
+	}
+	return entry
+}
@@ -0,0 +1,37 @@
+package system
+
+import (
+	"context"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// screenAnomalies inspects the providers for suspicious entries (see WithAnomalyDetection), logging them, and
+// excludes the quarantined providers, reporting them as diagnostics
+// An external pool is only screened, its entries would poison the inspections of the system's own providers
+func (ps *pairingSystem) screenAnomalies(ctx context.Context, providers []*pairing.Provider, external bool) ([]*pairing.Provider, []Diagnostic) {
+	if !external {
+		if anomalies := ps.anomalies.Inspect(providers); len(anomalies) > 0 {
+			ps.log(ctx).Warn("Found suspicious provider entries", "count", len(anomalies), "anomalies", anomalies)
+		}
+	}
+	var kept []*pairing.Provider
+	var diagnostics []Diagnostic
+	for i, p := range providers {
+		a, quarantined := ps.anomalies.IsQuarantined(p.ID)
+		if !quarantined {
+			if kept != nil {
+				kept = append(kept, p)
+			}
+			continue
+		}
+		if kept == nil {
+			kept = append(make([]*pairing.Provider, 0, len(providers)), providers[:i]...)
+		}
+		diagnostics = append(diagnostics, Diagnostic{ProviderID: p.ID, Address: p.Address, Reason: "quarantined: " + a.Detail})
+	}
+	if kept == nil {
+		return providers, nil
+	}
+	return kept, diagnostics
+}
@@ -0,0 +1,60 @@
+package system
+
+import (
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/policy"
+	"github.com/Yoaz/LavaPairingSystem/pkg/score"
+)
+
+// newScoringPlan lays the transforms and weights out by scorer position, and sizes the arena of a ranking of
+// n providers
+func (ps *pairingSystem) newScoringPlan(n int, transforms map[string]score.Transform, compiled *policy.CompiledPolicy) *scoringPlan {
+	k := len(ps.scorers)
+	plan := &scoringPlan{
+		names:            make([]string, k),
+		transforms:       make([]score.Transform, k),
+		configuredWeight: ps.configuredWeight(compiled.Policy.Weights),
+		arena: &componentArena{
+			scores:  make([]pairing.PairingScore, n),
+			values:  make([]float64, n*k),
+			applied: make([]bool, n*k),
+			size:    k,
+		},
+		weights: compiled.Weights, // NaN where a scorer has no weight, see score.CombineVector
+	}
+	for i, scorer := range ps.scorers {
+		plan.names[i] = scorer.Name()
+		plan.transforms[i] = transforms[scorer.Name()]
+	}
+	return plan
+}
+
+// alloc returns a score with an empty component vector, from the arena until it runs out
+// It is safe for concurrent use
+func (a *componentArena) alloc(names []string) *pairing.PairingScore {
+	i := int(a.next.Add(1) - 1)
+	if i >= len(a.scores) {
+		return &pairing.PairingScore{Vector: pairing.ComponentVector{
+			Names:   names,
+			Values:  make([]float64, a.size),
+			Applied: make([]bool, a.size),
+		}}
+	}
+	start, end := i*a.size, (i+1)*a.size
+	s := &a.scores[i]
+	s.Vector = pairing.ComponentVector{
+		Names:   names,
+		Values:  a.values[start:end:end],
+		Applied: a.applied[start:end:end],
+	}
+	return s
+}
+
+// fillComponents builds the components map of scores handed out from their component vectors
+func fillComponents(scores []*pairing.PairingScore) {
+	for _, s := range scores {
+		if s.Components == nil {
+			s.Components = s.Vector.Map()
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package system
+
+import (
+	"github.com/Yoaz/LavaPairingSystem/pkg/commitment"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// newCommitmentCache returns an empty cache
+func newCommitmentCache() *commitmentCache {
+	return &commitmentCache{byVersion: make(map[string]*commitment.Tree), byRoot: make(map[string]*commitment.Tree)}
+}
+
+// commit returns the Merkle tree of the providers, built once per pool version and kept to serve proofs
+// Unversioned pools are built on every call and not kept. As with the aggregate cache, providers as many as the
+// version's tree are its pool itself, fewer or more (e.g. once one is quarantined) build the tree again
+func (c *commitmentCache) commit(version string, providers []*pairing.Provider) *commitment.Tree {
+	if version == "" {
+		return commitment.Build(providers)
+	}
+	c.mu.Lock()
+	if tree, ok := c.byVersion[version]; ok && tree.Size() == len(providers) {
+		c.mu.Unlock()
+		return tree
+	}
+	c.mu.Unlock()
+
+	tree := commitment.Build(providers) // Outside the lock, calls on other versions don't wait for it
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if previous, ok := c.byVersion[version]; ok {
+		c.forget(previous)
+	} else {
+		c.order = append(c.order, version)
+	}
+	c.byVersion[version] = tree
+	c.byRoot[tree.Root()] = tree
+	for len(c.order) > maxCommitments {
+		oldest := c.order[0]
+		c.order = c.order[1:]
+		c.forget(c.byVersion[oldest])
+		delete(c.byVersion, oldest)
+	}
+	return tree
+}
+
+// forget stops serving proofs from a replaced or evicted tree, unless another version committed to the same root
+func (c *commitmentCache) forget(tree *commitment.Tree) {
+	if root := tree.Root(); c.byRoot[root] == tree {
+		delete(c.byRoot, root)
+	}
+}
+
+// SnapshotTree returns the tree of the snapshot committed to by the hex root, among the last pool versions
+// committed to, see WithSnapshotCommitment
+func (ps *pairingSystem) SnapshotTree(root string) (*commitment.Tree, bool) {
+	if ps.commitments == nil {
+		return nil, false
+	}
+	ps.commitments.mu.Lock()
+	defer ps.commitments.mu.Unlock()
+	tree, ok := ps.commitments.byRoot[root]
+	return tree, ok
+}
@@ -0,0 +1,123 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/internal/utils"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// Compare runs pairing for a baseline and a candidate run and returns how their top-N selections differ
+// Runs may differ in system configuration, provider snapshot, policy, or any combination, which makes it
+// possible to evaluate the impact of a config change before rolling it out
+// NOTE: Compare bypasses quotas and strict mode, it only evaluates filtering, ranking and selection
+func Compare(baseline, candidate PairingRun) (*PairingDiff, error) {
+	baseSelected, err := selectRun(baseline, false)
+	if err != nil {
+		return nil, fmt.Errorf("baseline run: %w", err)
+	}
+	candSelected, err := selectRun(candidate, false)
+	if err != nil {
+		return nil, fmt.Errorf("candidate run: %w", err)
+	}
+	return diffSelections(baseSelected, candSelected), nil
+}
+
+// Changed reports whether the two runs selected different providers or the same ones in a different order
+func (d *PairingDiff) Changed() bool {
+	return len(d.Added) > 0 || len(d.Removed) > 0 || len(d.Reordered) > 0
+}
+
+// diffSelections computes the difference between two sorted top-N selections
+func diffSelections(baseSelected, candSelected []*pairing.PairingScore) *PairingDiff {
+	baseRanks := rankIndex(baseSelected)
+	diff := &PairingDiff{ScoreDeltas: make(map[string]float64)}
+
+	for i, cand := range candSelected {
+		change := ProviderChange{
+			ProviderID:     cand.Provider.ID,
+			CandidateRank:  i + 1,
+			CandidateScore: cand.Score,
+		}
+		baseRank, ok := baseRanks[cand.Provider.ID]
+		if !ok {
+			diff.Added = append(diff.Added, change)
+			continue
+		}
+		change.BaselineRank = baseRank
+		change.BaselineScore = baseSelected[baseRank-1].Score
+		diff.ScoreDeltas[change.ProviderID] = change.CandidateScore - change.BaselineScore
+		if change.BaselineRank == change.CandidateRank {
+			diff.Unchanged = append(diff.Unchanged, change)
+		} else {
+			diff.Reordered = append(diff.Reordered, change)
+		}
+	}
+
+	candRanks := rankIndex(candSelected)
+	for i, base := range baseSelected {
+		if _, ok := candRanks[base.Provider.ID]; !ok {
+			diff.Removed = append(diff.Removed, ProviderChange{
+				ProviderID:    base.Provider.ID,
+				BaselineRank:  i + 1,
+				BaselineScore: base.Score,
+			})
+		}
+	}
+
+	return diff
+}
+
+// selectRun filters, ranks and sorts a run's providers, returning its top-N scored providers
+// Providers are ordered like a live request, from the consumer's previous ranking with WithIncrementalSort,
+// which is only updated when record is set
+func selectRun(run PairingRun, record bool) ([]*pairing.PairingScore, error) {
+	if run.System == nil || run.Policy == nil {
+		return nil, fmt.Errorf("run needs both a system and a policy")
+	}
+	ps, ok := run.System.(*pairingSystem)
+	if !ok {
+		filtered := run.System.FilterProviders(run.Providers, run.Policy)
+		scored := run.System.RankProviders(filtered, run.Policy)
+		sortByScore(scored)
+		topN := run.Policy.TopN
+		if topN <= 0 {
+			topN = topNProviders
+		}
+		return scored[:utils.Min(topN, len(scored))], nil
+	}
+
+	// Resolve settings and weights and break ties exactly as the live request would
+	settings := ps.resolveSettings(run.Policy, PairingOptions{})
+	policy, err := ps.resolveWeights(context.Background(), withSettings(run.Policy, settings))
+	if err != nil {
+		return nil, err
+	}
+	var scored []*pairing.PairingScore
+	if ps.fused {
+		scored, err = ps.fusedFilterAndRank(context.Background(), run.Providers, policy)
+	} else {
+		var filtered []*pairing.Provider
+		if filtered, err = ps.filterProviders(context.Background(), run.Providers, policy); err == nil {
+			scored, err = ps.rankProviders(context.Background(), filtered, policy)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	ps.rankScored(context.Background(), scored, policy, time.Now(), record)
+	selected := scored[:utils.Min(settings.TopN, len(scored))]
+	fillComponents(selected)
+	return selected, nil
+}
+
+// rankIndex maps provider IDs to their 1-based rank in a sorted selection
+func rankIndex(selected []*pairing.PairingScore) map[string]int {
+	ranks := make(map[string]int, len(selected))
+	for i, s := range selected {
+		ranks[s.Provider.ID] = i + 1
+	}
+	return ranks
+}
@@ -0,0 +1,15 @@
+package system
+
+import (
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/policy"
+)
+
+// compile compiles the policy of a call with its weights laid out for the system's scorers
+func (ps *pairingSystem) compile(consumerPolicy *pairing.ConsumerPolicy) *policy.CompiledPolicy {
+	names := make([]string, len(ps.scorers))
+	for i, scorer := range ps.scorers {
+		names[i] = scorer.Name()
+	}
+	return policy.Compile(consumerPolicy, names...)
+}
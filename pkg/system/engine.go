@@ -0,0 +1,82 @@
+package system
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/filter"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/score"
+	"github.com/Yoaz/LavaPairingSystem/pkg/workerpool"
+)
+
+// NewPairingEngine creates a new PairingEngine whose systems share a pool of poolSize workers
+func NewPairingEngine(poolSize int, logger *slog.Logger) *PairingEngine {
+	// Ensure logger is not nil, provide a default discard logger if it is
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	return &PairingEngine{
+		pool:    workerpool.New(poolSize),
+		logger:  logger,
+		systems: make(map[string]PairingSystem),
+	}
+}
+
+// Register creates a system from the given configuration on the engine's shared pool and registers it under
+// name, replacing any system previously registered under it
+func (e *PairingEngine) Register(name string, filters []filter.Filter, scorers []score.Scorer, strictMode bool, opts ...Option) PairingSystem {
+	opts = append(opts, WithWorkerPool(e.pool)) // Last, so the shared pool can't be overridden
+	ps := NewPairingSystem(filters, scorers, e.logger.With("system", name), strictMode, opts...)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.systems[name] = ps
+	e.logger.Info("Registered pairing system", "system", name)
+	return ps
+}
+
+// Unregister removes the system registered under name
+func (e *PairingEngine) Unregister(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.systems, name)
+}
+
+// System returns the system registered under name
+func (e *PairingEngine) System(name string) (PairingSystem, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	ps, ok := e.systems[name]
+	return ps, ok
+}
+
+// Names returns the names of the registered systems, sorted
+func (e *PairingEngine) Names() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	names := make([]string, 0, len(e.systems))
+	for name := range e.systems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetPairingList runs GetPairingList on the system registered under name
+// NOTE: Like PairingSystem.GetPairingList, it returns a *PairingResult rather than the selected providers
+func (e *PairingEngine) GetPairingList(name string, providers []*pairing.Provider, policy *pairing.ConsumerPolicy, opts ...PairingOptions) (*PairingResult, error) {
+	ps, ok := e.System(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownSystem, name)
+	}
+	return ps.GetPairingList(providers, policy, opts...)
+}
+
+// Close stops the shared worker pool once running work is done
+// Systems keep working afterwards, each call spawning dedicated goroutines
+func (e *PairingEngine) Close() {
+	e.pool.Close()
+}
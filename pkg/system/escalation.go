@@ -0,0 +1,123 @@
+package system
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// logBuffer is a slog.Handler holding back the log records of a GetPairingList call until it finishes, see
+// WithLogEscalation
+// Handlers derived with WithAttrs and WithGroup share the buffer of the call
+type logBuffer struct {
+	next  slog.Handler
+	state *bufferState
+}
+
+// bufferState is the buffer of a call shared by its handlers
+type bufferState struct {
+	mu      sync.Mutex
+	records []bufferedRecord
+	reason  string // Why the call's records are escalated, empty for a normal call
+	flushed bool   // Once flushed, records (e.g. of shadow evaluations) pass straight through
+}
+
+// bufferedRecord is a held back record along with the handler it was logged to
+type bufferedRecord struct {
+	handler slog.Handler
+	record  slog.Record
+}
+
+// newLogBuffer returns a handler buffering the records of a call before passing them to next
+func newLogBuffer(next slog.Handler) *logBuffer {
+	return &logBuffer{next: next, state: &bufferState{}}
+}
+
+// Enabled reports true for every level, any record may get escalated above the next handler's level
+func (h *logBuffer) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+// Handle holds back the record until the call finishes, an error escalates the call
+func (h *logBuffer) Handle(ctx context.Context, r slog.Record) error {
+	h.state.mu.Lock()
+	defer h.state.mu.Unlock()
+	if h.state.flushed {
+		if !h.next.Enabled(ctx, r.Level) {
+			return nil
+		}
+		return h.next.Handle(ctx, r)
+	}
+	h.state.records = append(h.state.records, bufferedRecord{handler: h.next, record: r.Clone()})
+	if r.Level >= slog.LevelError && h.state.reason == "" {
+		h.state.reason = r.Message
+	}
+	return nil
+}
+
+// WithAttrs returns a handler with the attributes sharing the buffer
+func (h *logBuffer) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &logBuffer{next: h.next.WithAttrs(attrs), state: h.state}
+}
+
+// WithGroup returns a handler with the group sharing the buffer
+func (h *logBuffer) WithGroup(name string) slog.Handler {
+	return &logBuffer{next: h.next.WithGroup(name), state: h.state}
+}
+
+// escalate flags the call as anomalous, keeping the first reason given
+// It is a no-op on a nil buffer, i.e. when WithLogEscalation is off
+func (h *logBuffer) escalate(reason string) {
+	if h == nil {
+		return
+	}
+	h.state.mu.Lock()
+	defer h.state.mu.Unlock()
+	if h.state.reason == "" {
+		h.state.reason = reason
+	}
+}
+
+// flush passes the call's records on: at Debug for a normal call, and raised to Warn, after a record stating
+// the reason, for an escalated one; warnings and errors keep their level either way
+// It is a no-op on a nil buffer
+func (h *logBuffer) flush() {
+	if h == nil {
+		return
+	}
+	h.state.mu.Lock()
+	defer h.state.mu.Unlock()
+	h.state.flushed = true
+	ctx := context.Background()
+	level := slog.LevelDebug
+	if h.state.reason != "" {
+		level = slog.LevelWarn
+		if h.next.Enabled(ctx, level) {
+			summary := slog.NewRecord(time.Now(), level, "Escalating request logs", 0)
+			summary.AddAttrs(slog.String("reason", h.state.reason), slog.Int("record_count", len(h.state.records)))
+			_ = h.next.Handle(ctx, summary)
+		}
+	}
+	for _, b := range h.state.records {
+		r := b.record
+		if r.Level < slog.LevelWarn && r.Level != level {
+			r = relevel(r, level)
+		}
+		if b.handler.Enabled(ctx, r.Level) {
+			_ = b.handler.Handle(ctx, r)
+		}
+	}
+	h.state.records = nil
+}
+
+// relevel returns a copy of the record at the given level, noting its original level
+func relevel(r slog.Record, level slog.Level) slog.Record {
+	leveled := slog.NewRecord(r.Time, level, r.Message, r.PC)
+	leveled.AddAttrs(slog.String("original_level", r.Level.String()))
+	r.Attrs(func(a slog.Attr) bool {
+		leveled.AddAttrs(a)
+		return true
+	})
+	return leveled
+}
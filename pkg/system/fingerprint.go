@@ -0,0 +1,105 @@
+package system
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// ConfigFingerprint identifies the system's configuration: its filters, scorers, default weights, top-N,
+// selection strategy and strict mode, so results, logs and verification can reference exactly which configuration produced them
+// Results of requests carrying their own weights are stamped with a hash of those (see PairingResult.ConfigHash)
+// With configuration layers (see WithLayers), every layer is part of the configuration
+func (ps *pairingSystem) ConfigFingerprint() string {
+	policy := &pairing.ConsumerPolicy{}
+	settings := ps.resolveSettings(policy, PairingOptions{})
+	weights := settings.Weights
+	if resolved, err := ps.resolveWeights(context.Background(), withSettings(policy, settings)); err == nil {
+		weights = resolved.Weights
+	}
+	return ps.configHash(nil, weights, settings, ps.mode, ps.selection)
+}
+
+// configHash hashes the system's configuration, its configuration layers included, together with the effective
+// settings of a request: its weights, top-N and strict mode and the layer each came from, the per-chain and
+// per-interface weights of the policy that applied, and the filters skipped for the policy, identifying exactly
+// which configuration produced a result
+// Nil weights stand for the default ones, scorers then contribute equally. A nil policy hashes the system's
+// configuration alone
+func (ps *pairingSystem) configHash(policy *pairing.ConsumerPolicy, weights map[string]float64, settings ResolvedSettings, mode PipelineMode, selection SelectionStrategy) string {
+	filters := make([]string, 0, len(ps.filters))
+	var skipped []string
+	for _, f := range ps.filters {
+		filters = append(filters, f.Name())
+		if policy != nil && !ps.strictConstraints && !f.Applicable(policy) {
+			skipped = append(skipped, f.Name())
+		}
+	}
+	var chainWeights, interfaceWeights map[string]float64
+	if policy != nil {
+		chainWeights, interfaceWeights = policy.ChainWeights[policy.ChainID], policy.InterfaceWeights[policy.RequiredAPIInterface]
+	}
+	scorers := make([]string, 0, len(ps.scorers))
+	for _, s := range ps.scorers {
+		scorers = append(scorers, s.Name())
+	}
+	var comparator string
+	if ps.comparator != nil {
+		comparator = ps.comparator.Name
+	}
+	// Order matters for filters (short-circuiting) and is kept as configured; json sorts the weights' keys
+	data, _ := json.Marshal(struct {
+		Filters           []string
+		Scorers           []string
+		Weights           map[string]float64
+		TopN              int
+		StrictMode        bool
+		DelegationFactor  float64
+		Fused             bool
+		Selection         string
+		StrictConstraints bool               `json:",omitempty"` // Omitted by default, keeping the hashes of earlier configurations
+		Mode              PipelineMode       `json:",omitempty"`
+		Comparator        string             `json:",omitempty"`
+		Layers            *Layers            `json:",omitempty"`
+		Sources           map[string]string  `json:",omitempty"` // Layer each setting came from
+		ChainWeights      map[string]float64 `json:",omitempty"` // Policy overrides applied, see ConsumerPolicy.ScopedWeights
+		InterfaceWeights  map[string]float64 `json:",omitempty"`
+		SkippedFilters    []string           `json:",omitempty"` // Filters the policy sets no inputs for
+	}{filters, scorers, weights, settings.TopN, settings.Strict, ps.delegationFactor, ps.fused, selection.Name(), ps.strictConstraints, mode, comparator,
+		ps.layers, settings.Sources, chainWeights, interfaceWeights, skipped})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// scoreSeriesKey hashes the scored policy, so the scores of every consumer with the same policy are recorded
+// together (see WithScoreHistory); json sorts map keys, making the hash canonical
+func scoreSeriesKey(policy *pairing.ConsumerPolicy) string {
+	scored := *policy
+	scored.ConsumerID, scored.ComputeUnits = "", 0
+	data, _ := json.Marshal(&scored)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// admit reports whether the scores of the series are recorded: a new series is only recorded while fewer than
+// maxScoreSeries were recorded within scoreSeriesIdle, so ever new policies can't flood the store
+func (s *scoreSeries) admit(key string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.used[key]; !ok && len(s.used) >= maxScoreSeries {
+		for k, at := range s.used {
+			if now.Sub(at) > scoreSeriesIdle {
+				delete(s.used, k)
+			}
+		}
+		if len(s.used) >= maxScoreSeries {
+			return false
+		}
+	}
+	s.used[key] = now
+	return true
+}
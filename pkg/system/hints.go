@@ -0,0 +1,69 @@
+package system
+
+import (
+	"strings"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// build returns the connection hints of the providers with endpoints, by provider ID
+func (h *hintSource) build(providers []*pairing.Provider, policy *pairing.ConsumerPolicy) map[string]ConnectionHints {
+	hints := make(map[string]ConnectionHints, len(providers))
+	for _, p := range providers {
+		if len(p.Endpoints) == 0 {
+			continue
+		}
+		hint := ConnectionHints{
+			Endpoints:       make(map[string]EndpointHint),
+			RequireTLS:      p.TLSEnabled,
+			CertFingerprint: p.CertFingerprint,
+		}
+		preferred := make(map[string]endpointRank)
+		for i, e := range p.Endpoints {
+			rank := h.rank(e, i, policy)
+			if current, ok := preferred[e.APIInterface]; ok && !rank.before(current) {
+				continue
+			}
+			preferred[e.APIInterface] = rank
+			hint.Endpoints[e.APIInterface] = EndpointHint{URL: e.URL, Geolocation: e.Geolocation, ExpectedLatencyMS: rank.latency}
+		}
+		hints[p.ID] = hint
+	}
+	return hints
+}
+
+// endpointRank orders the endpoints of an API interface for a consumer
+type endpointRank struct {
+	local   bool    // Served from the consumer's region
+	known   bool    // Whether latency is known
+	latency float64 // Expected latency (ms) from the consumer's region
+	index   int     // Registration order
+}
+
+// rank returns how the i-th endpoint of a provider ranks for the policy's consumer
+func (h *hintSource) rank(e pairing.Endpoint, i int, policy *pairing.ConsumerPolicy) endpointRank {
+	rank := endpointRank{index: i}
+	if wanted, served := policy.GeolocationMask(), pairing.GeolocationOf(e.Geolocation); wanted != 0 && served != 0 {
+		rank.local = wanted.Intersects(served)
+	} else {
+		rank.local = policy.RequiredLocation != "" && strings.EqualFold(e.Geolocation, policy.RequiredLocation)
+	}
+	if h.latencies != nil && policy.RequiredLocation != "" {
+		rank.latency, rank.known = h.latencies.Latency(policy.RequiredLocation, e.Geolocation)
+	}
+	return rank
+}
+
+// before reports whether the endpoint should be dialed rather than other
+func (r endpointRank) before(other endpointRank) bool {
+	if r.local != other.local {
+		return r.local
+	}
+	if r.known != other.known {
+		return r.known
+	}
+	if r.known && r.latency != other.latency {
+		return r.latency < other.latency
+	}
+	return r.index < other.index
+}
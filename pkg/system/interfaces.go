@@ -0,0 +1,43 @@
+package system
+
+import (
+	"fmt"
+
+	"github.com/Yoaz/LavaPairingSystem/internal/utils"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// PairInterfaces runs GetPairingList once per API interface listed in the policy's APIInterfaces and returns
+// the results by interface, each only keeping providers with an endpoint serving that interface
+// The pairings are independent, a provider serving several interfaces may be selected for each of them
+// Each pairing counts as a request against the consumer's quota, but the policy's compute units are charged
+// once, with the first interface
+// It works with any PairingSystem including remote ones, and fails as a whole if any of the pairings fails
+// The options are passed to every pairing
+func PairInterfaces(ps PairingSystem, providers []*pairing.Provider, policy *pairing.ConsumerPolicy, opts ...PairingOptions) (map[string]*PairingResult, error) {
+	if policy == nil {
+		return nil, ErrNilPolicy
+	}
+	if len(policy.APIInterfaces) == 0 {
+		return nil, fmt.Errorf("%w: no api_interfaces to pair", ErrInvalidAPIInterfaces)
+	}
+	if err := utils.ValidateAPIInterfaces(policy.APIInterfaces, policy.RequiredAPIInterface); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidAPIInterfaces, err)
+	}
+
+	results := make(map[string]*PairingResult, len(policy.APIInterfaces))
+	for i, apiInterface := range policy.APIInterfaces {
+		interfacePolicy := *policy
+		interfacePolicy.APIInterfaces = nil
+		interfacePolicy.RequiredAPIInterface = apiInterface
+		if i > 0 {
+			interfacePolicy.ComputeUnits = 0
+		}
+		result, err := ps.GetPairingList(providers, &interfacePolicy, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("api interface %s: %w", apiInterface, err)
+		}
+		results[apiInterface] = result
+	}
+	return results, nil
+}
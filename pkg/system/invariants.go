@@ -0,0 +1,45 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/score"
+)
+
+// checkComponent verifies that a scorer's raw score is within its declared range, see WithInvariantChecks
+func (ps *pairingSystem) checkComponent(ctx context.Context, scorer score.Scorer, p *pairing.Provider, value float64) {
+	if ps.invariants == InvariantsOff {
+		return
+	}
+	min, max := score.DeclaredRange(scorer)
+	if math.IsNaN(value) || value < min || value > max {
+		ps.violation(ctx, fmt.Sprintf("scorer %s scored provider %s %v, outside its declared range [%v, %v]", scorer.Name(), p.ID, value, min, max))
+	}
+}
+
+// checkFinite verifies that a score is a finite number, see WithInvariantChecks
+func (ps *pairingSystem) checkFinite(ctx context.Context, p *pairing.Provider, what string, value float64) {
+	if ps.invariants == InvariantsOff {
+		return
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		ps.violation(ctx, fmt.Sprintf("%s of provider %s is %v", what, p.ID, value))
+	}
+}
+
+// violation handles a violated score invariant according to the system's InvariantMode
+func (ps *pairingSystem) violation(ctx context.Context, msg string) {
+	if ps.invariants == InvariantsPanic {
+		panic(invariantViolation(msg))
+	}
+	ps.log(ctx).Error("Score invariant violated", "violation", msg)
+}
+
+// invariantViolation is the panic value of a violated invariant under InvariantsPanic, which the recoveries of
+// the pipeline let through
+type invariantViolation string
+
+func (v invariantViolation) Error() string { return "score invariant violated: " + string(v) }
@@ -0,0 +1,319 @@
+package system
+
+import (
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/anomaly"
+	"github.com/Yoaz/LavaPairingSystem/pkg/cluster"
+	"github.com/Yoaz/LavaPairingSystem/pkg/fairness"
+	"github.com/Yoaz/LavaPairingSystem/pkg/latency"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/quota"
+	"github.com/Yoaz/LavaPairingSystem/pkg/score"
+	"github.com/Yoaz/LavaPairingSystem/pkg/timeseries"
+	"github.com/Yoaz/LavaPairingSystem/pkg/workerpool"
+)
+
+// WithQuota enforces per-consumer request and compute unit quotas on GetPairingList
+// Requests beyond the quota fail with a *quota.ExceededError carrying the time until the quota resets, and
+// policies requesting negative compute units with ErrNegativeComputeUnits
+// Only successful pairings cost quota, the charge of a failing one is refunded
+// NOTE: The quota is keyed by the policy's ConsumerID, which the HTTP API sets to the authenticated principal
+func WithQuota(tracker *quota.Tracker) Option {
+	return func(ps *pairingSystem) {
+		ps.quota = tracker
+	}
+}
+
+// WithDelegationFactor sets the weight of delegated stake relative to self stake used for stake normalization
+// NOTE: Configure filter.StakeFilter with the same factor so filtering and scoring agree
+func WithDelegationFactor(factor float64) Option {
+	return func(ps *pairingSystem) {
+		ps.delegationFactor = factor
+	}
+}
+
+// WithTimeSeries computes the rolling aggregates requested by scorers (see score.AggregateRequester) from the
+// given store, and records into it the fees of the providers of GetPairingList calls over the system's own pool
+// and when each was first seen
+func WithTimeSeries(store *timeseries.Store) Option {
+	return func(ps *pairingSystem) {
+		ps.timeSeries = store
+	}
+}
+
+// WithShadow evaluates the given shadow configurations on every GetPairingList call, in the background,
+// logging and counting how their selections differ from the live one without affecting returned results
+// At most 16 evaluations run at once across shadows; under heavier load, evaluations are skipped and counted as
+// dropped (see ShadowStats) rather than piling up
+func WithShadow(shadows ...*Shadow) Option {
+	return func(ps *pairingSystem) {
+		ps.shadows = append(ps.shadows, shadows...)
+		if ps.shadowSlots == nil {
+			ps.shadowSlots = make(chan struct{}, maxShadowRuns)
+		}
+	}
+}
+
+// WithTieShuffle shuffles providers with equal scores instead of keeping them in input order, so ties are
+// broken fairly across consumers
+// The shuffle is seeded from the consumer ID and the current epoch of the given length, so a consumer
+// sees a stable order for the whole epoch
+func WithTieShuffle(epochLength time.Duration) Option {
+	return func(ps *pairingSystem) {
+		ps.tieShuffleEpoch = epochLength
+	}
+}
+
+// WithUnknownWeights sets how GetPairingList handles policy weights referencing scorers that aren't registered
+// in the system (e.g. a typo or a disabled scorer), see UnknownWeightMode; the default is UnknownWeightsIgnore
+func WithUnknownWeights(mode UnknownWeightMode) Option {
+	return func(ps *pairingSystem) {
+		ps.unknownWeights = mode
+	}
+}
+
+// WithInvariantChecks verifies while scoring that every scorer stays within its declared range (see
+// score.RangeReporter) and that transformed components and final scores are finite, handling violations as
+// mode says
+// Meant to catch buggy custom scorers early, in tests or debug deployments
+func WithInvariantChecks(mode InvariantMode) Option {
+	return func(ps *pairingSystem) {
+		ps.invariants = mode
+	}
+}
+
+// WithTransforms post-processes component scores with the given transforms, by scorer name, before weighting
+// Transforms sent in a policy are applied after these
+func WithTransforms(transforms map[string]score.Transform) Option {
+	return func(ps *pairingSystem) {
+		if ps.transforms == nil {
+			ps.transforms = make(map[string]score.Transform, len(transforms))
+		}
+		for name, t := range transforms {
+			ps.transforms[name] = t
+		}
+	}
+}
+
+// WithWorkers sets the number of filter and rank workers, 10 by default; non-positive counts are ignored
+// With a shared pool (see WithWorkerPool) the pool's size still bounds how many run at once
+func WithWorkers(n int) Option {
+	return func(ps *pairingSystem) {
+		if n > 0 {
+			ps.workers = n
+		}
+	}
+}
+
+// WithWorkerPool runs filter and rank workers on the given shared pool instead of a pool owned by the system,
+// bounding concurrency across every system sharing it (see PairingEngine)
+// The system's Close leaves a shared pool running
+func WithWorkerPool(pool *workerpool.Pool) Option {
+	return func(ps *pairingSystem) {
+		ps.pool = pool
+	}
+}
+
+// WithFusedPipeline filters and scores providers in a single worker pass instead of two separate phases,
+// halving channel traffic and per-provider overhead for latency-sensitive deployments
+// NOTE: Pool-wide scoring inputs such as the max stake are then computed over every provider, not only the
+// ones passing the filters, so scores can differ slightly from the default pipeline
+func WithFusedPipeline() Option {
+	return func(ps *pairingSystem) {
+		ps.fused = true
+	}
+}
+
+// WithSelection sets how GetPairingList picks the returned providers among the ranked ones, e.g.
+// EpsilonGreedySelection to reserve slots for exploration; the default is TopSelection
+func WithSelection(strategy SelectionStrategy) Option {
+	return func(ps *pairingSystem) {
+		ps.selection = strategy
+	}
+}
+
+// WithFairness caps each provider's share of the selections over a rolling window with the given tracker:
+// selected providers over their cap give their slot to the next ranked providers (see fairness.Tracker.Admit)
+func WithFairness(tracker *fairness.Tracker) Option {
+	return func(ps *pairingSystem) {
+		ps.fairness = tracker
+	}
+}
+
+// WithComparator replaces the score-descending final sort by the given ordering, e.g.
+// ComparatorChain(ByScore, ByFee, ByStake) to break score ties by fee and then stake
+// Providers the comparator considers equal are ordered by ID, or shuffled with WithTieShuffle
+// ByStake weighs delegations by the system's delegation factor (see WithDelegationFactor), like the stake scorer
+func WithComparator(comparator Comparator) Option {
+	return func(ps *pairingSystem) {
+		ps.comparator = &comparator
+	}
+}
+
+// WithMode restricts GetPairingList to part of the pipeline, e.g. ModeScoreOnly for integrators filtering
+// providers themselves; ScoreOnly and FilterOnly select a mode per call instead (see StageRunner)
+func WithMode(mode PipelineMode) Option {
+	return func(ps *pairingSystem) {
+		ps.mode = mode
+	}
+}
+
+// WithStrictConstraints applies every filter to every policy, instead of skipping the filters a policy sets no
+// inputs for (see filter.Filter.Applicable), keeping the exact match semantics of empty policy fields: a policy
+// without a required location then only keeps providers without a location
+func WithStrictConstraints() Option {
+	return func(ps *pairingSystem) {
+		ps.strictConstraints = true
+	}
+}
+
+// WithLayers sets global and per-chain defaults for the weights, top-N and strict mode, which policies can
+// override in turn (see ResolvedSettings)
+func WithLayers(layers *Layers) Option {
+	return func(ps *pairingSystem) {
+		ps.layers = layers
+	}
+}
+
+// WithAggregateCache reuses the max stake and normalized fees of a provider pool for ttl across calls passing
+// the same PairingOptions.PoolVersion, instead of recomputing them on every call; a new version computes them
+// again. They are only reused when no provider of the pool was filtered out, as they are computed over the
+// providers left after filtering
+func WithAggregateCache(ttl time.Duration) Option {
+	return func(ps *pairingSystem) {
+		ps.aggregates = newAggregateCache(ttl)
+	}
+}
+
+// WithIncrementalSort re-ranks each consumer's providers starting from the consumer's previous ranking, so a
+// mostly stable pool is sorted in linear time instead of O(n log n): providers keep their previous order and
+// only move past the neighbors they now outscore. A provider having to move more than maxShift ranks falls back
+// to a full sort. Equally ranked providers are ordered by ID, as in a full sort
+// It has no effect with WithTieShuffle, whose shuffles start from a fresh sort
+// NOTE: The latest rankings of the 10,000 most recently paired consumers are kept in memory, others are sorted
+// from scratch
+func WithIncrementalSort(maxShift int) Option {
+	return func(ps *pairingSystem) {
+		ps.rankings = newRankings(maxShift)
+	}
+}
+
+// WithColumnarScoring lays each ranked pool out in columns (see score.Columns), so scorers implementing
+// score.BatchScorer (StakeScore, FeeScore, CommissionScore) score the whole pool in a single loop instead of
+// provider by provider; worth it for very large pools. With WithAggregateCache the columns are built once per
+// pool version
+func WithColumnarScoring() Option {
+	return func(ps *pairingSystem) {
+		ps.columnar = true
+	}
+}
+
+// WithLogEscalation holds back each GetPairingList call's logs until it finishes: a normal call's logs are then
+// passed on at Debug, while those of a call ending without candidates, running out of time, producing non-finite
+// scores or logging an error (e.g. a violated score invariant) are raised to Warn, so operators logging at Warn
+// see every detail of the calls worth looking at, and nothing of the others
+// NOTE: Every record of a call is buffered, including the per-provider Debug records, whatever the logger's level
+func WithLogEscalation() Option {
+	return func(ps *pairingSystem) {
+		ps.escalateLogs = true
+	}
+}
+
+// WithConnectionHints attaches connection hints to GetPairingList results (see PairingResult.Hints): for every
+// selected and backup provider with endpoints, its preferred endpoint per API interface, the expected latency
+// from the consumer's region according to latencies (nil leaves it unknown) and whether it must be dialed over
+// TLS
+// Endpoints in the consumer's region are preferred, then the closest ones, then the first registered
+func WithConnectionHints(latencies *latency.Matrix) Option {
+	return func(ps *pairingSystem) {
+		ps.hints = &hintSource{latencies: latencies}
+	}
+}
+
+// WithScoreHistory records the final score of every provider ranked by GetPairingList in the given store, for
+// trend dashboards and spotting providers whose quality degrades (see timeseries.Store.History and Trend)
+// Scores depend on the policy, so they are recorded per policy under timeseries.ScoreMetric of a hash of the
+// scored policy (its consumer ID and compute units aside), reported in PairingResult.ScoreSeries; consumers
+// with the same policy share a series. At most maxScoreSeries policies are recorded at a time (see
+// scoreSeriesIdle), and calls over an ExternalPool aren't. The store's resolution bounds the samples kept per
+// series
+func WithScoreHistory(store *timeseries.Store) Option {
+	return func(ps *pairingSystem) {
+		ps.scoreHistory = store
+		ps.scoreSeries = &scoreSeries{used: make(map[string]time.Time)}
+	}
+}
+
+// WithAnomalyDetection inspects the valid providers of every GetPairingList call with the given detector,
+// logging suspicious entries (outlier fees, sudden stake changes, emptied feature lists), and excludes the
+// providers it quarantined, reporting them in the result's diagnostics until they are released
+// NOTE: Stake and feature changes are measured between calls, so calls over an ExternalPool aren't inspected,
+// only screened for quarantined providers
+func WithAnomalyDetection(detector *anomaly.Detector) Option {
+	return func(ps *pairingSystem) {
+		ps.anomalies = detector
+	}
+}
+
+// WithOperatorClustering groups the ranked providers into clusters believed to be run by a single operator
+// (see cluster.Clusterer) and selects at most maxPerCluster providers of each, the slots of the lower ranked
+// providers of a full cluster going to the next ranked providers, so a single operator can't take over the
+// selection by registering many providers (maxPerCluster <= 0 disables it)
+func WithOperatorClustering(clusterer *cluster.Clusterer, maxPerCluster int) Option {
+	return func(ps *pairingSystem) {
+		if clusterer == nil || maxPerCluster <= 0 {
+			ps.clusters = nil
+			return
+		}
+		ps.clusters = &clusterLimit{clusterer: clusterer, max: maxPerCluster}
+	}
+}
+
+// WithNetworkLookup resolves the ASN of providers registered without one from their endpoint hosts, for
+// policies requiring the selection to span several ASNs (see pairing.ConsumerPolicy.MinASNs)
+func WithNetworkLookup(lookup cluster.NetworkLookup) Option {
+	return func(ps *pairingSystem) {
+		ps.networks = lookup
+	}
+}
+
+// WithSnapshotCommitment stamps every result with the Merkle root of the providers it considered (see
+// PairingResult.SnapshotRoot), so that a provider's presence or absence can later be proven against it
+// The tree of a versioned pool (see PairingOptions.PoolVersion) is built once per version and the trees of the
+// last versions are kept to serve proofs (see SnapshotProver); caller-supplied pools are hashed on every call and
+// only their root is returned, commitment.Build rebuilds their tree from the same snapshot
+func WithSnapshotCommitment() Option {
+	return func(ps *pairingSystem) {
+		ps.commitments = newCommitmentCache()
+	}
+}
+
+// WithRecorder records the exact inputs and outcome of the calls sample selects (nil samples every call) with
+// recorder, so they can be replayed later to reproduce them (see Recording)
+// Recorded calls are seeded and their clock pinned (see PairingOptions.Seed and Now) when their options don't
+// already, so they replay identically
+// The recorder captures the service state before each sampled call runs, see Recorder; Close closes it if it
+// is an io.Closer
+// NOTE: Recording runs on the request path, recorders should be quick or hand recordings off
+func WithRecorder(recorder Recorder, sample func(policy *pairing.ConsumerPolicy) bool) Option {
+	return func(ps *pairingSystem) {
+		if recorder == nil {
+			ps.recorder = nil
+			return
+		}
+		ps.recorder = &recording{recorder: recorder, sample: sample}
+	}
+}
+
+// WithStageTimeouts bounds the filter and rank stages of GetPairingList (0 leaves a stage unbounded)
+// When a stage runs out of time, the providers processed so far are used and the result is flagged Partial;
+// in strict mode GetPairingList fails with ErrStageTimeout instead
+// NOTE: Workers check deadlines between providers, and the sequential filtering of small pools isn't bounded
+// In fused mode the whole pass is bounded by the sum of both timeouts
+func WithStageTimeouts(filter, rank time.Duration) Option {
+	return func(ps *pairingSystem) {
+		ps.filterTimeout = filter
+		ps.rankTimeout = rank
+	}
+}
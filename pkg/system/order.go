@@ -0,0 +1,69 @@
+package system
+
+import (
+	"cmp"
+	"strings"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// ComparatorChain orders by the first comparator, breaking its ties with the next one, and so on
+func ComparatorChain(comparators ...Comparator) Comparator {
+	names := make([]string, 0, len(comparators))
+	for _, c := range comparators {
+		names = append(names, c.Name)
+	}
+	return Comparator{
+		Name: strings.Join(names, ","),
+		Compare: func(a, b *pairing.PairingScore) int {
+			for _, c := range comparators {
+				if order := c.Compare(a, b); order != 0 {
+					return order
+				}
+			}
+			return 0
+		},
+		configure: func(delegationFactor float64) Comparator {
+			configured := make([]Comparator, 0, len(comparators))
+			for _, c := range comparators {
+				configured = append(configured, c.configured(delegationFactor))
+			}
+			return ComparatorChain(configured...)
+		},
+	}
+}
+
+// configured returns the comparator ordering as the system with the given delegation factor scores
+func (c Comparator) configured(delegationFactor float64) Comparator {
+	if c.configure == nil {
+		return c
+	}
+	return c.configure(delegationFactor)
+}
+
+// compareScore orders higher scores first
+func compareScore(a, b *pairing.PairingScore) int {
+	return cmp.Compare(b.Score, a.Score)
+}
+
+// compareFee orders lower fees first
+func compareFee(a, b *pairing.PairingScore) int {
+	return cmp.Compare(a.Provider.Fee, b.Provider.Fee)
+}
+
+// stakeComparator orders higher effective stakes first, delegations weighed by the given delegation factor like
+// the stake scorer weighs them, 0 meaning pairing.DefaultDelegationFactor
+func stakeComparator(delegationFactor float64) Comparator {
+	return Comparator{
+		Name: "stake",
+		Compare: func(a, b *pairing.PairingScore) int {
+			return cmp.Compare(b.Provider.EffectiveStake(delegationFactor), a.Provider.EffectiveStake(delegationFactor))
+		},
+		configure: stakeComparator,
+	}
+}
+
+// compareID orders provider IDs ascending
+func compareID(a, b *pairing.PairingScore) int {
+	return cmp.Compare(a.Provider.ID, b.Provider.ID)
+}
@@ -0,0 +1,106 @@
+package system
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// sampled reports whether the call of the policy is recorded
+func (r *recording) sampled(policy *pairing.ConsumerPolicy) bool {
+	return r.sample == nil || r.sample(policy)
+}
+
+// prepare fixes what a recorded call would otherwise leave to chance: its request ID, seed and clock
+func (r *recording) prepare(call PairingOptions) PairingOptions {
+	if call.RequestID == "" {
+		call.RequestID = newRequestID()
+	}
+	for call.Seed == 0 {
+		call.Seed = rand.Uint64()
+	}
+	if call.Now.IsZero() {
+		call.Now = time.Now()
+	}
+	return call
+}
+
+// newRecording records the inputs of a call about to run, along with the consumer's previous ranking
+// The providers and policy are shared, not copied: the pipeline doesn't modify them
+func newRecording(ps *pairingSystem, providers []*pairing.Provider, policy *pairing.ConsumerPolicy, mode PipelineMode, call PairingOptions) *Recording {
+	rec := &Recording{
+		RequestID:         call.RequestID,
+		Mode:              mode,
+		ConfigFingerprint: ps.ConfigFingerprint(),
+		Providers:         providers,
+		Policy:            policy,
+		Options: RecordedOptions{
+			TopN:         call.TopN,
+			Strict:       call.Strict,
+			Timeout:      call.Timeout,
+			Backups:      call.Backups,
+			PoolVersion:  call.PoolVersion,
+			ExternalPool: call.ExternalPool,
+			Seed:         call.Seed,
+			Now:          call.Now,
+		},
+	}
+	if call.Selection != nil {
+		rec.Options.Selection = call.Selection.Name()
+	}
+	if ps.rankings != nil {
+		rec.PreviousRanking = ps.rankings.get(rankingKey(policy)) // Replaced, never modified, by later calls
+	}
+	return rec
+}
+
+// PrepareReplay restores the consumer's ranking the recorded call started from, see ReplayPreparer
+func (ps *pairingSystem) PrepareReplay(rec *Recording) {
+	if ps.rankings == nil || rec.Policy == nil {
+		return
+	}
+	ps.rankings.set(rankingKey(rec.Policy), rec.PreviousRanking)
+}
+
+// PairingOptions returns the options to replay the recorded call with, selection replacing the system's
+// strategy like the recorded call's did (nil keeps the system's)
+func (o RecordedOptions) PairingOptions(requestID string, selection SelectionStrategy) PairingOptions {
+	return PairingOptions{
+		TopN:         o.TopN,
+		Strict:       o.Strict,
+		Timeout:      o.Timeout,
+		Selection:    selection,
+		RequestID:    requestID,
+		Backups:      o.Backups,
+		PoolVersion:  o.PoolVersion,
+		ExternalPool: o.ExternalPool,
+		Seed:         o.Seed,
+		Now:          o.Now,
+	}
+}
+
+// NewOutcome returns the outcome of a call from what GetPairingList returned
+func NewOutcome(result *PairingResult, err error) Outcome {
+	if err != nil {
+		return Outcome{Error: err.Error()}
+	}
+	outcome := Outcome{Selected: recordScores(result.Providers, result.Scores), Partial: result.Partial, ConstraintsUnmet: result.ConstraintsUnmet}
+	if len(result.Backups) > 0 {
+		outcome.Backups = recordScores(result.Backups, result.BackupScores)
+	}
+	return outcome
+}
+
+// recordScores returns the recorded scores of providers, scores being empty when the call didn't rank them
+func recordScores(providers []*pairing.Provider, scores []*pairing.PairingScore) []RecordedScore {
+	recorded := make([]RecordedScore, len(providers))
+	for i, p := range providers {
+		recorded[i].ProviderID = p.ID
+		if i < len(scores) {
+			recorded[i].Score = scores[i].Score
+			recorded[i].Components = scores[i].Components
+		}
+	}
+	return recorded
+}
@@ -0,0 +1,110 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/filter"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/policy"
+	"github.com/Yoaz/LavaPairingSystem/pkg/score"
+)
+
+// recoveriesKey is the context key of the recoveries of a GetPairingList call
+type recoveriesKey struct{}
+
+// withRecoveries returns a context collecting the providers skipped because evaluating them panicked
+func withRecoveries(ctx context.Context, r *recoveries) context.Context {
+	return context.WithValue(ctx, recoveriesKey{}, r)
+}
+
+// rethrowViolation panics again with a recovered invariant violation, which must reach the caller under
+// InvariantsPanic rather than be taken for a provider's failure
+func rethrowViolation(v any) {
+	if violation, ok := v.(invariantViolation); ok {
+		panic(violation)
+	}
+}
+
+// recoverProvider, deferred while evaluating a single provider, turns a panic into a skipped provider: the
+// panic is logged and reported in the call's diagnostics (see withRecoveries)
+// It must be deferred directly for recover to stop the panic
+func (ps *pairingSystem) recoverProvider(ctx context.Context, workerID int, stage string, p *pairing.Provider) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	rethrowViolation(v)
+	ps.log(ctx).Error("Recovered from panic evaluating provider, skipping it",
+		"worker_id", workerID,
+		"provider_id", p.ID,
+		"stage", stage,
+		"panic", v,
+		"stack", string(debug.Stack()),
+	)
+	if r, ok := ctx.Value(recoveriesKey{}).(*recoveries); ok {
+		r.mu.Lock()
+		r.diagnostics = append(r.diagnostics, Diagnostic{
+			ProviderID: p.ID,
+			Address:    p.Address,
+			Reason:     fmt.Sprintf("panic in %s: %v", stage, v),
+		})
+		r.mu.Unlock()
+	}
+}
+
+// safePassesFilters is passesFilters, rejecting the provider if a filter panics
+func (ps *pairingSystem) safePassesFilters(ctx context.Context, workerID int, p *pairing.Provider, compiled *policy.CompiledPolicy, filters []filter.Filter) (pass bool, err error) {
+	defer ps.recoverProvider(ctx, workerID, "filter", p)
+	return ps.passesFilters(ctx, workerID, p, compiled, filters)
+}
+
+// safeScoreProvider is scoreProvider, returning a nil score if a scorer panics
+func (ps *pairingSystem) safeScoreProvider(ctx context.Context, workerID int, p *pairing.Provider, policy *pairing.ConsumerPolicy, preScoreCtx *score.PreScoreContext, plan *scoringPlan) (scored *pairing.PairingScore, err error) {
+	defer ps.recoverProvider(ctx, workerID, "rank", p)
+	return ps.scoreProvider(ctx, workerID, p, policy, preScoreCtx, plan)
+}
+
+// safeApplyFilter is applyFilter, falling back to checking the providers one at a time if the filter panics,
+// so only the providers it panics on are rejected
+func (ps *pairingSystem) safeApplyFilter(ctx context.Context, f filter.Filter, providers []*pairing.Provider, compiled *policy.CompiledPolicy) (result []*pairing.Provider, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			rethrowViolation(v)
+			ps.log(ctx).Warn("Filter panicked, checking providers one at a time", "filter_name", f.Name(), "panic", v)
+			result, err = ps.checkEach(ctx, f, providers, compiled)
+		}
+	}()
+	return applyFilter(f, providers, compiled)
+}
+
+// checkEach returns the providers passing a filter, checked one at a time and skipping those it panics on
+func (ps *pairingSystem) checkEach(ctx context.Context, f filter.Filter, providers []*pairing.Provider, compiled *policy.CompiledPolicy) ([]*pairing.Provider, error) {
+	var result []*pairing.Provider
+	for _, p := range providers {
+		pass, err := func() (pass bool, err error) {
+			defer ps.recoverProvider(ctx, 0, "filter", p)
+			return checkFilter(f, p, compiled)
+		}()
+		if err != nil {
+			return nil, err
+		}
+		if pass {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+// safePrepareScoring is prepareScoring, failing with ErrPipeline if computing the pool-wide inputs panics
+func (ps *pairingSystem) safePrepareScoring(ctx context.Context, providers []*pairing.Provider, policy *pairing.ConsumerPolicy) (preScoreCtx *score.PreScoreContext, plan *scoringPlan, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			rethrowViolation(v)
+			ps.log(ctx).Error("Recovered from panic preparing scoring", "panic", v, "stack", string(debug.Stack()))
+			err = fmt.Errorf("%w: panic preparing scoring: %v", ErrPipeline, v)
+		}
+	}()
+	return ps.prepareScoring(ctx, providers, policy)
+}
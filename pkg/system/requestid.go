@@ -0,0 +1,33 @@
+package system
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+)
+
+// loggerKey is the context key of the logger of a GetPairingList call
+type loggerKey struct{}
+
+// newRequestID returns a random ID identifying a GetPairingList call in logs, results and responses
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// withLogger returns a context carrying the logger of a GetPairingList call, tagged with its request ID
+func withLogger(ctx context.Context, log *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, log)
+}
+
+// log returns the logger of the call the context belongs to, the system's logger outside of a call
+func (ps *pairingSystem) log(ctx context.Context) *slog.Logger {
+	if log, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return log
+	}
+	return ps.logger
+}
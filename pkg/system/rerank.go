@@ -0,0 +1,148 @@
+package system
+
+import (
+	"container/list"
+	"context"
+	"slices"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// rankScored orders scored providers like orderScored, starting from the consumer's previous ranking when
+// WithIncrementalSort is set, and records the new ranking for the consumer's next call unless record is false
+func (ps *pairingSystem) rankScored(ctx context.Context, scored []*pairing.PairingScore, policy *pairing.ConsumerPolicy, now time.Time, record bool) {
+	if ps.rankings == nil || ps.tieShuffleEpoch > 0 {
+		// Shuffled ties must start from the same permutation every call, see orderScored
+		ps.orderScored(scored, policy, now)
+		return
+	}
+	key := rankingKey(policy)
+	order := ByScore
+	if ps.comparator != nil {
+		order = *ps.comparator
+	}
+	if previous := ps.rankings.get(key); previous == nil {
+		ps.orderScored(scored, policy, now)
+	} else if !rerank(scored, previous, ComparatorChain(order, ByID).Compare, ps.rankings.maxShift) {
+		ps.log(ctx).Debug("Ranking moved too far from the previous one, sorting from scratch", "consumer_id", policy.ConsumerID)
+		ps.orderScored(scored, policy, now)
+	}
+	if record {
+		ps.rankings.put(key, scored)
+	}
+}
+
+// rerank sorts scored providers starting from their previous ranks, by insertion sort for those ranked before
+// and by a regular sort for the newcomers, which are then merged in: O(n) for a stable ranking
+// It returns false, leaving scored in an unspecified order, as soon as a provider would move more than maxShift
+// ranks from its previous one
+func rerank(scored []*pairing.PairingScore, previous map[string]int, compare func(a, b *pairing.PairingScore) int, maxShift int) bool {
+	// Lay the known providers out in their previous order, without sorting, and set the newcomers apart
+	slots := make([]*pairing.PairingScore, len(previous))
+	var fresh []*pairing.PairingScore
+	for _, s := range scored {
+		if rank, ok := previous[s.Provider.ID]; ok && slots[rank] == nil {
+			slots[rank] = s
+		} else {
+			fresh = append(fresh, s)
+		}
+	}
+	known := slots[:0]
+	for _, s := range slots {
+		if s != nil {
+			known = append(known, s)
+		}
+	}
+
+	// Insertion sort, bounded by the shift allowed to a single provider
+	for i := 1; i < len(known); i++ {
+		current := known[i]
+		j := i
+		for ; j > 0 && compare(known[j-1], current) > 0; j-- {
+			if i-j >= maxShift {
+				return false
+			}
+			known[j] = known[j-1]
+		}
+		known[j] = current
+	}
+	slices.SortStableFunc(fresh, compare)
+
+	// Merge, known providers first among equals
+	i, j := 0, 0
+	for k := range scored {
+		if j >= len(fresh) || (i < len(known) && compare(fresh[j], known[i]) >= 0) {
+			scored[k] = known[i]
+			i++
+		} else {
+			scored[k] = fresh[j]
+			j++
+		}
+	}
+	return true
+}
+
+// rankingKey returns the key the rankings of the policy's consumer are kept under
+func rankingKey(policy *pairing.ConsumerPolicy) string {
+	return policy.ChainID + "/" + string(policy.ConsumerID)
+}
+
+// newRankings returns an empty ranking cache letting providers move up to maxShift ranks
+func newRankings(maxShift int) *rankings {
+	return &rankings{maxShift: maxShift, ranks: make(map[string]*list.Element), recent: list.New()}
+}
+
+// get returns the previous ranks of a consumer's providers by provider ID, nil if there are none
+func (r *rankings) get(key string) map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.ranks[key]
+	if !ok {
+		return nil
+	}
+	r.recent.MoveToFront(e)
+	return e.Value.(*consumerRanking).ranks
+}
+
+// put records the ranking of a consumer's providers
+func (r *rankings) put(key string, scored []*pairing.PairingScore) {
+	ranks := make(map[string]int, len(scored))
+	for i, s := range scored {
+		ranks[s.Provider.ID] = i
+	}
+	r.set(key, ranks)
+}
+
+// set replaces the ranks of a consumer's providers, nil forgetting them, and forgets the least recently paired
+// consumers past maxRankings
+func (r *rankings) set(key string, ranks map[string]int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.ranks[key]
+	switch {
+	case ranks == nil:
+		if ok {
+			r.recent.Remove(e)
+			delete(r.ranks, key)
+		}
+		return
+	case ok:
+		e.Value.(*consumerRanking).ranks = ranks
+		r.recent.MoveToFront(e)
+	default:
+		r.ranks[key] = r.recent.PushFront(&consumerRanking{key: key, ranks: ranks})
+	}
+	for r.recent.Len() > maxRankings {
+		oldest := r.recent.Back()
+		r.recent.Remove(oldest)
+		delete(r.ranks, oldest.Value.(*consumerRanking).key)
+	}
+}
+
+// len returns the number of consumers whose ranking is kept
+func (r *rankings) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.ranks)
+}
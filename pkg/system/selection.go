@@ -0,0 +1,442 @@
+package system
+
+import (
+	"cmp"
+	"context"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/internal/utils"
+	"github.com/Yoaz/LavaPairingSystem/pkg/cluster"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// admitFair settles a selection with the fairness tracker, swapping providers over their share cap for the
+// next ranked ones that fit the cluster slots, as of the call's time now
+func (ps *pairingSystem) admitFair(ctx context.Context, selected, scored []*pairing.PairingScore, slots *clusterSlots, now time.Time) []*pairing.PairingScore {
+	byID := make(map[string]*pairing.PairingScore, len(scored))
+	ranked := make([]string, 0, len(scored))
+	for _, s := range scored {
+		byID[s.Provider.ID] = s
+		ranked = append(ranked, s.Provider.ID)
+	}
+	selectedIDs := make([]string, 0, len(selected))
+	for _, s := range selected {
+		selectedIDs = append(selectedIDs, s.Provider.ID)
+	}
+
+	admittedIDs := ps.fairness.Admit(now, selectedIDs, ranked, slots.swap)
+	admitted := make([]*pairing.PairingScore, 0, len(admittedIDs))
+	kept := make(map[string]bool, len(admittedIDs))
+	for _, id := range admittedIDs {
+		admitted = append(admitted, byID[id])
+		kept[id] = true
+	}
+	for _, id := range selectedIDs {
+		if !kept[id] {
+			ps.log(ctx).Debug("Provider over its selection share cap, slot redistributed", "provider_id", id)
+		}
+	}
+	return admitted
+}
+
+// limit caps the selected providers of each operator cluster, giving the slots of the providers over the cap to
+// the next ranked providers of clusters with room left
+// Selected providers missing from scored, e.g. unproven providers given an exploration slot, are capped too.
+// It returns the cluster slots the later swaps must fit
+func (c *clusterLimit) limit(ctx context.Context, ps *pairingSystem, selected, scored []*pairing.PairingScore) ([]*pairing.PairingScore, *clusterSlots) {
+	providers := make([]*pairing.Provider, 0, len(scored)+len(selected))
+	ranked := make(map[string]bool, len(scored))
+	for _, s := range scored {
+		providers = append(providers, s.Provider)
+		ranked[s.Provider.ID] = true
+	}
+	for _, s := range selected {
+		if !ranked[s.Provider.ID] {
+			providers = append(providers, s.Provider)
+		}
+	}
+	clusters := c.clusterer.Cluster(providers)
+
+	counts := make(map[string]int, len(selected))
+	kept := make([]*pairing.PairingScore, 0, len(selected))
+	chosen := make(map[string]bool, len(selected))
+	for _, s := range selected {
+		id := clusters[s.Provider.ID]
+		if counts[id] >= c.max {
+			ps.log(ctx).Debug("Provider's operator cluster is full, slot redistributed",
+				"provider_id", s.Provider.ID, "cluster", id)
+			continue
+		}
+		counts[id]++
+		kept = append(kept, s)
+		chosen[s.Provider.ID] = true
+	}
+	for _, s := range scored {
+		if len(kept) == len(selected) {
+			break
+		}
+		id := clusters[s.Provider.ID]
+		if chosen[s.Provider.ID] || counts[id] >= c.max {
+			continue
+		}
+		counts[id]++
+		kept = append(kept, s)
+		chosen[s.Provider.ID] = true
+	}
+	return kept, &clusterSlots{clusters: clusters, counts: counts, max: c.max}
+}
+
+// fits reports whether provider may take the slot of the replaced one without overfilling its cluster
+func (c *clusterSlots) fits(provider, replaced string) bool {
+	if c == nil {
+		return true
+	}
+	to := c.clusters[provider]
+	return to == c.clusters[replaced] || c.counts[to] < c.max
+}
+
+// swap gives the slot of the replaced provider to provider if it fits, reporting whether it did
+func (c *clusterSlots) swap(provider, replaced string) bool {
+	if !c.fits(provider, replaced) {
+		return false
+	}
+	if c != nil {
+		c.counts[c.clusters[replaced]]--
+		c.counts[c.clusters[provider]]++
+	}
+	return true
+}
+
+// diversify swaps selected providers for the next ranked providers of ASNs not selected yet until the selection
+// spans k distinct ASNs, replacing the lowest ranked providers whose ASN is unknown or shared with another
+// selected provider and whose slot fits the replacement's cluster; replacements go last. Providers with an
+// unknown ASN never count towards k
+// It reports whether the selection spans k ASNs
+func (ps *pairingSystem) diversify(ctx context.Context, selected, scored []*pairing.PairingScore, k int, slots *clusterSlots) ([]*pairing.PairingScore, bool) {
+	asns := make(map[string]uint32, len(scored))
+	asnOf := func(p *pairing.Provider) uint32 {
+		asn, ok := asns[p.ID]
+		if !ok {
+			asn = cluster.NetworkOf(p, ps.networks).ASN
+			asns[p.ID] = asn
+		}
+		return asn
+	}
+
+	diversified := slices.Clone(selected)
+	counts := make(map[uint32]int, len(selected)) // Selected providers by known ASN
+	chosen := make(map[string]bool, len(selected))
+	for _, s := range diversified {
+		if asn := asnOf(s.Provider); asn != 0 {
+			counts[asn]++
+		}
+		chosen[s.Provider.ID] = true
+	}
+	for _, s := range scored {
+		if len(counts) >= k {
+			break
+		}
+		asn := asnOf(s.Provider)
+		if asn == 0 || counts[asn] > 0 || chosen[s.Provider.ID] {
+			continue
+		}
+		replaced := -1
+		for i, r := range slices.Backward(diversified) {
+			if replacedASN := asnOf(r.Provider); (replacedASN == 0 || counts[replacedASN] > 1) && slots.fits(s.Provider.ID, r.Provider.ID) {
+				replaced = i
+				break
+			}
+		}
+		if replaced < 0 {
+			continue // Every selected provider holds an ASN of its own, or a slot the provider's cluster can't take
+		}
+		slots.swap(s.Provider.ID, diversified[replaced].Provider.ID)
+		ps.log(ctx).Debug("Provider swapped for ASN diversity",
+			"provider_id", diversified[replaced].Provider.ID, "replacement_id", s.Provider.ID, "asn", asn)
+		if replacedASN := asnOf(diversified[replaced].Provider); replacedASN != 0 {
+			counts[replacedASN]--
+		}
+		diversified = append(slices.Delete(diversified, replaced, replaced+1), s)
+		counts[asn]++
+		chosen[s.Provider.ID] = true
+	}
+	if len(counts) < k {
+		ps.log(ctx).Warn("Selection spans fewer ASNs than the policy requires", "asn_count", len(counts), "min_asns", k)
+		return diversified, false
+	}
+	return diversified, true
+}
+
+// backupTier returns up to n scored providers that weren't selected, in rank order
+func backupTier(scored, selected []*pairing.PairingScore, n int) []*pairing.PairingScore {
+	chosen := make(map[string]bool, len(selected))
+	for _, s := range selected {
+		chosen[s.Provider.ID] = true
+	}
+	backups := make([]*pairing.PairingScore, 0, utils.Min(n, len(scored)))
+	for _, s := range scored {
+		if len(backups) == n {
+			break
+		}
+		if !chosen[s.Provider.ID] {
+			backups = append(backups, s)
+		}
+	}
+	return backups
+}
+
+/* ***********************************************************************
+ *                            TOP SELECTION                              *
+ *********************************************************************** */
+
+// Select returns the first n scored providers
+func (TopSelection) Select(scored []*pairing.PairingScore, n int, _ *pairing.ConsumerPolicy) []*pairing.PairingScore {
+	return scored[:utils.Min(n, len(scored))]
+}
+
+func (TopSelection) Name() string { return "top" }
+
+/* ***********************************************************************
+ *                        EPSILON-GREEDY SELECTION                       *
+ *********************************************************************** */
+
+// Select returns the top n-Exploration scored providers followed by Exploration providers sampled uniformly,
+// without replacement, among the remaining ones
+// When fewer providers than n are eligible they are all returned, in score order
+func (s EpsilonGreedySelection) Select(scored []*pairing.PairingScore, n int, policy *pairing.ConsumerPolicy) []*pairing.PairingScore {
+	return s.SelectRand(scored, n, policy, nil)
+}
+
+// SelectRand is Select drawing the explored providers from rng, see RandomSelection
+func (s EpsilonGreedySelection) SelectRand(scored []*pairing.PairingScore, n int, _ *pairing.ConsumerPolicy, rng *rand.Rand) []*pairing.PairingScore {
+	if len(scored) <= n {
+		return scored
+	}
+	explore := utils.Min(s.Exploration, n)
+	if explore < 0 {
+		explore = 0
+	}
+	exploit := n - explore
+	selected := make([]*pairing.PairingScore, 0, n)
+	selected = append(selected, scored[:exploit]...)
+
+	// Partial Fisher-Yates over a copy of the rest, the caller's order is left intact
+	rest := append([]*pairing.PairingScore(nil), scored[exploit:]...)
+	for i := 0; len(selected) < n; i++ {
+		j := i + intN(rng, len(rest)-i)
+		rest[i], rest[j] = rest[j], rest[i]
+		selected = append(selected, rest[i])
+	}
+	return selected
+}
+
+func (s EpsilonGreedySelection) Name() string {
+	return "epsilon-greedy:" + strconv.Itoa(s.Exploration)
+}
+
+/* ***********************************************************************
+ *                        TOP-K SAMPLE SELECTION                         *
+ *********************************************************************** */
+
+// Select returns n providers sampled uniformly, without replacement, among the top K scored providers, in
+// random order
+// A K below n samples among the top n, so only the order is randomized
+func (s TopKSampleSelection) Select(scored []*pairing.PairingScore, n int, policy *pairing.ConsumerPolicy) []*pairing.PairingScore {
+	return s.SelectRand(scored, n, policy, nil)
+}
+
+// SelectRand is Select drawing the sampled providers from rng, see RandomSelection
+func (s TopKSampleSelection) SelectRand(scored []*pairing.PairingScore, n int, _ *pairing.ConsumerPolicy, rng *rand.Rand) []*pairing.PairingScore {
+	if n <= 0 {
+		return scored[:0]
+	}
+	pool := utils.Min(s.K, len(scored))
+	if pool < n {
+		pool = utils.Min(n, len(scored))
+	}
+
+	// Partial Fisher-Yates over a copy of the pool, the caller's order is left intact
+	candidates := append([]*pairing.PairingScore(nil), scored[:pool]...)
+	count := utils.Min(n, pool)
+	for i := 0; i < count; i++ {
+		j := i + intN(rng, pool-i)
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	}
+	return candidates[:count]
+}
+
+func (s TopKSampleSelection) Name() string {
+	return "top-k-sample:" + strconv.Itoa(s.K)
+}
+
+// intN returns a random int in [0, n) from rng, or from the global source when rng is nil
+func intN(rng *rand.Rand, n int) int {
+	if rng == nil {
+		return rand.IntN(n)
+	}
+	return rng.IntN(n)
+}
+
+/* ***********************************************************************
+ *                         CONSTRAINT SELECTION                          *
+ *********************************************************************** */
+
+// constraintCandidate is a ranked provider with what it contributes to the group requirements
+type constraintCandidate struct {
+	score    *pairing.PairingScore
+	fee      float64
+	regions  pairing.Geolocation
+	features pairing.FeatureSet // Positions of the RequiredFeatures it supports
+}
+
+// constraintSearch is the state of a ConstraintSelection search
+type constraintSearch struct {
+	s          ConstraintSelection
+	candidates []constraintCandidate
+	required   int // Number of distinct RequiredFeatures
+	nodes      int // Partial selections left to explore
+	chosen     []int
+}
+
+// Select searches the ranked providers, best first, for the largest selection of at most n providers that
+// satisfies every requirement, see ConstraintSelection
+func (s ConstraintSelection) Select(scored []*pairing.PairingScore, n int, policy *pairing.ConsumerPolicy) []*pairing.PairingScore {
+	selected, _ := s.SelectConstrained(scored, n, policy)
+	return selected
+}
+
+// SelectConstrained is Select also reporting whether the selection satisfies every requirement, false when it
+// fell back to the best-ranked providers fitting the fee budget
+func (s ConstraintSelection) SelectConstrained(scored []*pairing.PairingScore, n int, _ *pairing.ConsumerPolicy) ([]*pairing.PairingScore, bool) {
+	if n <= 0 {
+		return scored[:0], true
+	}
+	search := &constraintSearch{s: s, nodes: s.MaxNodes}
+	if search.nodes <= 0 {
+		search.nodes = DefaultConstraintSearchNodes
+	}
+	required := make([]string, 0, len(s.RequiredFeatures))
+	for _, feature := range s.RequiredFeatures {
+		if feature != "" && !slices.Contains(required, feature) {
+			required = append(required, feature)
+		}
+	}
+	search.required = len(required)
+	search.candidates = make([]constraintCandidate, len(scored))
+	for i, ranked := range scored {
+		c := constraintCandidate{score: ranked, fee: ranked.Provider.Fee, regions: ranked.Provider.GeolocationMask()}
+		for j, feature := range required {
+			if slices.Contains(ranked.Provider.Features, feature) {
+				c.features = c.features.With(j)
+			}
+		}
+		search.candidates[i] = c
+	}
+
+	for size := utils.Min(n, len(scored)); size > 0 && search.nodes > 0; size-- {
+		search.chosen = search.chosen[:0]
+		if search.find(0, size, 0, 0, nil) {
+			return search.selection(), true
+		}
+	}
+	return search.greedy(n), false
+}
+
+// find extends the partial selection with candidates from start on, in rank order, until it holds size
+// providers satisfying every requirement, reporting whether it did
+func (cs *constraintSearch) find(start, size int, fee float64, regions pairing.Geolocation, features pairing.FeatureSet) bool {
+	if len(cs.chosen) == size {
+		return cs.satisfied(regions, features)
+	}
+	for i := start; len(cs.candidates)-i >= size-len(cs.chosen); i++ {
+		if cs.nodes <= 0 {
+			return false
+		}
+		cs.nodes--
+		c := cs.candidates[i]
+		if cs.s.MaxTotalFee > 0 && fee+c.fee > cs.s.MaxTotalFee {
+			continue
+		}
+		covered := append(pairing.FeatureSet(nil), features...).Union(c.features)
+		cs.chosen = append(cs.chosen, i)
+		if cs.find(i+1, size, fee+c.fee, regions|c.regions, covered) {
+			return true
+		}
+		cs.chosen = cs.chosen[:len(cs.chosen)-1]
+	}
+	return false
+}
+
+// satisfied reports whether a selection covering the given regions and required features meets the
+// requirements, the fee budget being checked while selecting
+func (cs *constraintSearch) satisfied(regions pairing.Geolocation, features pairing.FeatureSet) bool {
+	return regions.Count() >= cs.s.MinRegions && features.Len() == cs.required
+}
+
+// selection returns the chosen providers, in rank order
+func (cs *constraintSearch) selection() []*pairing.PairingScore {
+	selected := make([]*pairing.PairingScore, 0, len(cs.chosen))
+	for _, i := range cs.chosen {
+		selected = append(selected, cs.candidates[i].score)
+	}
+	return selected
+}
+
+// greedy returns the best-ranked providers fitting the fee budget, when no selection meets every requirement
+func (cs *constraintSearch) greedy(n int) []*pairing.PairingScore {
+	selected := make([]*pairing.PairingScore, 0, utils.Min(n, len(cs.candidates)))
+	var fee float64
+	for _, c := range cs.candidates {
+		if len(selected) == n {
+			break
+		}
+		if cs.s.MaxTotalFee > 0 && fee+c.fee > cs.s.MaxTotalFee {
+			continue
+		}
+		fee += c.fee
+		selected = append(selected, c.score)
+	}
+	return selected
+}
+
+func (s ConstraintSelection) Name() string {
+	fee := strconv.FormatFloat(s.MaxTotalFee, 'g', -1, 64)
+	return "constraint:fee=" + fee + ",features=" + strings.Join(s.RequiredFeatures, "+") + ",regions=" + strconv.Itoa(s.MinRegions)
+}
+
+/* ***********************************************************************
+ *                           BUDGET SELECTION                            *
+ *********************************************************************** */
+
+// Select returns the n cheapest scored providers reaching the quality floor, ties going to the better
+// ranked, in rank order
+func (s BudgetSelection) Select(scored []*pairing.PairingScore, n int, _ *pairing.ConsumerPolicy) []*pairing.PairingScore {
+	eligible := make([]int, 0, len(scored))
+	for i, ranked := range scored {
+		if ranked.Score >= s.MinScore {
+			eligible = append(eligible, i)
+		}
+	}
+	// The cheapest n minimize the summed fee, the stable sort keeps rank order among equal fees
+	slices.SortStableFunc(eligible, func(a, b int) int {
+		return cmp.Compare(scored[a].Provider.Fee, scored[b].Provider.Fee)
+	})
+	if n < len(eligible) {
+		eligible = eligible[:max(n, 0)]
+	}
+	slices.Sort(eligible)
+
+	selected := make([]*pairing.PairingScore, 0, len(eligible))
+	for _, i := range eligible {
+		selected = append(selected, scored[i])
+	}
+	return selected
+}
+
+func (s BudgetSelection) Name() string {
+	return "budget:min-score=" + strconv.FormatFloat(s.MinScore, 'g', -1, 64)
+}
@@ -0,0 +1,176 @@
+package system
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Yoaz/LavaPairingSystem/internal/utils"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/score"
+)
+
+// LoadLayers loads configuration layers from a JSON file, see ParseLayers
+func LoadLayers(path string, scorers []score.Scorer) (*Layers, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	layers, err := ParseLayers(raw, scorers)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return layers, nil
+}
+
+// ParseLayers parses configuration layers, e.g.
+// {"global": {"top_n": 5}, "chains": {"LAV1": {"weights": {"StakeScore": 0.7, "FeeScore": 0.3}, "strict": true}}}
+// Every layer's settings are validated like a policy's, and their weights may only name the given scorers, those
+// of the system the layers are for
+func ParseLayers(raw []byte, scorers []score.Scorer) (*Layers, error) {
+	var layers Layers
+	if err := json.Unmarshal(raw, &layers); err != nil {
+		return nil, fmt.Errorf("decode layers: %w", err)
+	}
+	names := make([]string, 0, len(scorers))
+	for _, s := range scorers {
+		names = append(names, s.Name())
+	}
+	if err := layers.Global.validate(names); err != nil {
+		return nil, fmt.Errorf("%s layer: %w", LayerGlobal, err)
+	}
+	for _, chainID := range sortedKeys(layers.Chains) {
+		if err := layers.Chains[chainID].validate(names); err != nil {
+			return nil, fmt.Errorf("%s layer: %w", chainLayer(chainID), err)
+		}
+	}
+	return &layers, nil
+}
+
+// validate checks a layer's settings like a policy's, its weights naming the given scorers only
+func (s Settings) validate(scorers []string) error {
+	if s.TopN < 0 {
+		return fmt.Errorf("top_n must not be negative, got %d", s.TopN)
+	}
+	for _, name := range sortedKeys(s.Weights) {
+		if !slices.Contains(scorers, name) {
+			return fmt.Errorf("unknown scorer %q, expected one of %s", name, strings.Join(scorers, ", "))
+		}
+	}
+	return utils.ValidateWeights(s.Weights)
+}
+
+// resolveSettings merges the system's defaults, the configuration layers (see WithLayers), the policy's own
+// settings and the call's options into the settings the policy is paired with
+func (ps *pairingSystem) resolveSettings(policy *pairing.ConsumerPolicy, call PairingOptions) ResolvedSettings {
+	strict := ps.strictMode
+	type layer struct {
+		name     string
+		settings Settings
+	}
+	layers := []layer{{LayerSystem, Settings{TopN: topNProviders, Strict: &strict}}}
+	if ps.layers != nil {
+		layers = append(layers, layer{LayerGlobal, ps.layers.Global})
+		if chain, ok := ps.layers.Chains[policy.ChainID]; ok && policy.ChainID != "" {
+			layers = append(layers, layer{chainLayer(policy.ChainID), chain})
+		}
+	}
+	layers = append(layers,
+		layer{LayerPolicy, Settings{Weights: policy.ScopedWeights(), TopN: policy.TopN, Strict: policy.Strict}},
+		layer{LayerRequest, Settings{TopN: call.TopN, Strict: call.Strict}},
+	)
+
+	resolved := ResolvedSettings{Sources: make(map[string]string, 3)}
+	set := func(setting, name string) {
+		if previous, ok := resolved.Sources[setting]; ok && previous != LayerSystem {
+			resolved.Conflicts = append(resolved.Conflicts, SettingConflict{Setting: setting, Layer: name, Overridden: previous})
+		}
+		resolved.Sources[setting] = name
+	}
+	for _, l := range layers {
+		if len(l.settings.Weights) > 0 {
+			resolved.Weights = l.settings.Weights
+			set("weights", l.name)
+		}
+		if l.settings.TopN > 0 {
+			resolved.TopN = l.settings.TopN
+			set("top_n", l.name)
+		}
+		if l.settings.Strict != nil {
+			resolved.Strict = *l.settings.Strict
+			set("strict", l.name)
+		}
+	}
+	if _, ok := resolved.Sources["weights"]; !ok {
+		resolved.Sources["weights"] = LayerSystem
+	}
+	return resolved
+}
+
+// mergeOptions merges the options of a call, later set fields winning
+func mergeOptions(opts []PairingOptions) PairingOptions {
+	var merged PairingOptions
+	for _, o := range opts {
+		if o.TopN > 0 {
+			merged.TopN = o.TopN
+		}
+		if o.Strict != nil {
+			merged.Strict = o.Strict
+		}
+		if o.Timeout > 0 {
+			merged.Timeout = o.Timeout
+		}
+		if o.Selection != nil {
+			merged.Selection = o.Selection
+		}
+		if o.RequestID != "" {
+			merged.RequestID = o.RequestID
+		}
+		if o.PoolVersion != "" {
+			merged.PoolVersion = o.PoolVersion
+		}
+		if o.Backups > 0 {
+			merged.Backups = o.Backups
+		}
+		if o.Seed != 0 {
+			merged.Seed = o.Seed
+		}
+		if !o.Now.IsZero() {
+			merged.Now = o.Now
+		}
+		if o.ExternalPool {
+			merged.ExternalPool = true
+		}
+	}
+	return merged
+}
+
+// withSettings returns the policy carrying the resolved weights, a copy if they come from a layer below it or
+// from one of its per-chain or per-interface overrides
+func withSettings(policy *pairing.ConsumerPolicy, settings ResolvedSettings) *pairing.ConsumerPolicy {
+	scoped := len(policy.ChainWeights) > 0 || len(policy.InterfaceWeights) > 0
+	if settings.Sources["weights"] == LayerPolicy && !scoped || settings.Weights == nil {
+		return policy
+	}
+	layered := *policy
+	layered.Weights = settings.Weights
+	return &layered
+}
+
+// chainLayer names the configuration layer of a chain
+func chainLayer(chainID string) string {
+	return "chain:" + chainID
+}
+
+// sortedKeys returns the keys of a map, sorted
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
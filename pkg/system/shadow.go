@@ -0,0 +1,71 @@
+package system
+
+import (
+	"context"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// Stats returns a snapshot of the shadow's counters
+func (s *Shadow) Stats() ShadowStats {
+	return ShadowStats{
+		Runs:      s.runs.Load(),
+		Dropped:   s.dropped.Load(),
+		Diverged:  s.diverged.Load(),
+		Added:     s.added.Load(),
+		Removed:   s.removed.Load(),
+		Reordered: s.reordered.Load(),
+	}
+}
+
+// runShadows evaluates every shadow over the same input as the live request in the background
+// and records how each shadow's selection differs from the live one
+// A shadow is skipped, and counted as dropped, when every evaluation slot is taken
+// NOTE: The policy must not be modified by the caller while shadows may still be running
+func (ps *pairingSystem) runShadows(ctx context.Context, providers []*pairing.Provider, policy *pairing.ConsumerPolicy, live []*pairing.PairingScore) {
+	for _, shadow := range ps.shadows {
+		select {
+		case ps.shadowSlots <- struct{}{}:
+		default:
+			shadow.dropped.Add(1)
+			ps.log(ctx).Debug("Skipped shadow evaluation, too many running", "shadow", shadow.Name)
+			continue
+		}
+		go func(shadow *Shadow) {
+			defer func() { <-ps.shadowSlots }()
+			selected, err := selectRun(PairingRun{System: shadow.System, Providers: providers, Policy: policy}, true)
+			if err != nil {
+				ps.log(ctx).Warn("Shadow evaluation failed", "shadow", shadow.Name, "error", err)
+				return
+			}
+			diff := diffSelections(live, selected)
+
+			shadow.runs.Add(1)
+			shadow.added.Add(int64(len(diff.Added)))
+			shadow.removed.Add(int64(len(diff.Removed)))
+			shadow.reordered.Add(int64(len(diff.Reordered)))
+			if !diff.Changed() {
+				ps.log(ctx).Debug("Shadow selection matches live selection", "shadow", shadow.Name)
+				return
+			}
+			shadow.diverged.Add(1)
+
+			ps.log(ctx).Info("Shadow selection differs from live selection",
+				"shadow", shadow.Name,
+				"consumer_id", policy.ConsumerID,
+				"added", changedIDs(diff.Added),
+				"removed", changedIDs(diff.Removed),
+				"reordered", changedIDs(diff.Reordered),
+			)
+		}(shadow)
+	}
+}
+
+// changedIDs returns the provider IDs of a list of changes
+func changedIDs(changes []ProviderChange) []string {
+	ids := make([]string, len(changes))
+	for i, c := range changes {
+		ids[i] = c.ProviderID
+	}
+	return ids
+}
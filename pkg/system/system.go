@@ -0,0 +1,1118 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/internal/errgroup"
+	"github.com/Yoaz/LavaPairingSystem/internal/utils"
+	"github.com/Yoaz/LavaPairingSystem/pkg/filter"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairingerrors"
+	"github.com/Yoaz/LavaPairingSystem/pkg/policy"
+	"github.com/Yoaz/LavaPairingSystem/pkg/score"
+	"github.com/Yoaz/LavaPairingSystem/pkg/timeseries"
+	"github.com/Yoaz/LavaPairingSystem/pkg/workerpool"
+)
+
+// NewPairingSystem creates a new PairingSystem instance with the provided filters, scorers, and logger
+// StrictMode determines if the system should return an error when no providers match the filter criteria
+// Optional behaviour (quotas, ...) is enabled through opts
+func NewPairingSystem(filters []filter.Filter, scorers []score.Scorer, logger *slog.Logger, strictMode bool, opts ...Option) PairingSystem {
+	// Ensure logger is not nil, provide a default discard logger if it is
+	if logger == nil {
+		// If no logger is provided, default to discarding logs
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	ps := &pairingSystem{
+		filters:    filters,
+		scorers:    scorers,
+		logger:     logger,
+		strictMode: strictMode, // NOTE: If true, returns error when no providers match; if false, returns empty list
+		workers:    workerCount,
+		rejected:   make(map[string]*atomic.Int64, len(filters)),
+	}
+	for _, f := range filters {
+		ps.rejected[f.Name()] = new(atomic.Int64)
+	}
+	for _, s := range scorers {
+		if min, max := score.DeclaredRange(s); !(max > min) || math.IsInf(max-min, 0) {
+			logger.Error("Scorer declares an invalid range, its scores will be 0", "scorer_name", s.Name(), "min", min, "max", max)
+		}
+	}
+	for _, opt := range opts {
+		opt(ps)
+	}
+	if ps.selection == nil {
+		ps.selection = TopSelection{}
+	}
+	if ps.comparator != nil {
+		// Options apply in any order, the delegation factor is only known once they all did
+		configured := ps.comparator.configured(ps.delegationFactor)
+		ps.comparator = &configured
+	}
+	// Reuse the same workers across calls instead of spawning fresh ones for every filter and rank phase
+	if ps.pool == nil {
+		ps.pool = workerpool.New(ps.workers)
+		ps.ownsPool = true
+	}
+	return ps
+}
+
+// Close stops the system's own worker pool, shared pools are left to their owner
+func (ps *pairingSystem) Close() {
+	if ps.ownsPool {
+		ps.pool.Close()
+	}
+	if ps.recorder != nil {
+		if closer, ok := ps.recorder.recorder.(io.Closer); ok {
+			closer.Close() // Flushes recordings still being written
+		}
+	}
+}
+
+// RuntimeStats returns the current usage of the system's worker pool and caches
+func (ps *pairingSystem) RuntimeStats() RuntimeStats {
+	stats := RuntimeStats{Workers: ps.pool.Size(), BusyWorkers: ps.pool.Busy(), QueuedTasks: ps.pool.Waiting()}
+	if ps.aggregates != nil {
+		ps.aggregates.mu.Lock()
+		stats.AggregateCache = len(ps.aggregates.entries)
+		ps.aggregates.mu.Unlock()
+	}
+	if ps.rankings != nil {
+		stats.RankingCache = ps.rankings.len()
+	}
+	for _, s := range ps.scorers {
+		if memoized, ok := s.(*score.MemoizedScorer); ok {
+			if stats.ScorerCaches == nil {
+				stats.ScorerCaches = make(map[string]int)
+			}
+			stats.ScorerCaches[memoized.Name()] = memoized.Len()
+		}
+	}
+	return stats
+}
+
+// FilterStats returns the filter rejection counters accumulated since the system was created
+func (ps *pairingSystem) FilterStats() FilterStats {
+	stats := FilterStats{Evaluated: ps.evaluated.Load(), Rejected: make(map[string]int64, len(ps.rejected)), SkippedNil: ps.skippedNil.Load()}
+	for name, count := range ps.rejected {
+		stats.Rejected[name] = count.Load()
+	}
+	return stats
+}
+
+/* ***********************************************************************
+ *                                   CORE                                *
+ *********************************************************************** */
+
+// FilterProviders filters the list of providers based on the consumer policy
+// It applies each filter in the order they were added to the PairingSystem
+// If a filter fails (see filter.FallibleFilter) the error is logged and no provider is returned, GetPairingList
+// surfaces such errors instead
+func (ps *pairingSystem) FilterProviders(providers []*pairing.Provider, policy *pairing.ConsumerPolicy) []*pairing.Provider {
+	filtered, err := ps.filterProviders(context.Background(), providers, policy)
+	if err != nil {
+		// Fail closed, a provider no filter could vouch for isn't known to qualify
+		ps.logger.Error("Provider filtering failed", "error", err)
+		return []*pairing.Provider{}
+	}
+	return filtered
+}
+
+// filterProviders is FilterProviders, returning the errors of failing filters
+// If ctx expires, the providers that passed every filter so far are returned along with ctx's error
+func (ps *pairingSystem) filterProviders(ctx context.Context, providers []*pairing.Provider, policy *pairing.ConsumerPolicy) ([]*pairing.Provider, error) {
+	ps.log(ctx).Debug("Starting provider filtering", "initial_count", len(providers))
+	if policy == nil {
+		return nil, ErrNilPolicy
+	}
+	providers = ps.skipNil(ctx, providers)
+
+	// Check if there are any providers to filter
+	if len(providers) == 0 {
+		return []*pairing.Provider{}, nil
+	}
+
+	ps.evaluated.Add(int64(len(providers)))
+	filters := ps.applicableFilters(ctx, policy)
+	compiled := ps.compile(policy)
+
+	// Sequential filtering for small lists
+	if len(providers) <= parallelFilterThreshold {
+		filtered := providers
+		for _, filter := range filters {
+			countBefore := len(filtered)
+			var err error
+			if filtered, err = ps.safeApplyFilter(ctx, filter, filtered, compiled); err != nil {
+				return nil, err
+			}
+			countAfter := len(filtered)
+			ps.rejected[filter.Name()].Add(int64(countBefore - countAfter))
+			ps.log(ctx).Debug("Filter applied", "filter_name", filter.Name(), "count_before", countBefore, "count_after", countAfter)
+		}
+		ps.log(ctx).Debug("Finished sequential provider filtering", "final_count", len(filtered))
+		return filtered, nil
+	}
+
+	// Parallel filtering for large lists
+	filtered, err := ps.parallelFilterProviders(ctx, providers, compiled, filters)
+	if err != nil {
+		return nil, err
+	}
+	ps.log(ctx).Debug("Finished parallel provider filtering", "final_count", len(filtered))
+	return filtered, nil
+}
+
+// parallelFilterProviders filters providers in parallel using goroutines
+// It creates a worker pool to process the providers concurrently
+// Each worker applies the filters to a provider and sends the result to a results channel
+// The first failing worker stops the others, every worker error is returned
+func (ps *pairingSystem) parallelFilterProviders(ctx context.Context, providers []*pairing.Provider, compiled *policy.CompiledPolicy, filters []filter.Filter) ([]*pairing.Provider, error) {
+	tasks := make(chan *pairing.Provider, len(providers))
+	results := make(chan *pairing.Provider, len(providers))
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetSpawner(ps.spawn)
+
+	// Feed tasks before starting workers, so workers running on a shared pool always drain and exit
+	for _, p := range providers {
+		tasks <- p
+	}
+	close(tasks)
+
+	// Start workers
+	for w := 0; w < utils.Min(ps.workers, len(providers)); w++ {
+		g.Go(func() error { return ps.filterWorker(gctx, w, tasks, results, compiled, filters) })
+	}
+
+	// Block until all workers finish, results is buffered for every provider so workers never block on it
+	err := g.Wait()
+	close(results)
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect results
+	filtered := make([]*pairing.Provider, 0, len(providers))
+	for p := range results {
+		filtered = append(filtered, p)
+	}
+
+	// If ctx expired, workers stopped early and only part of the providers went through the filters
+	return filtered, ctx.Err()
+}
+
+// RankProviders ranks the filtered providers based on the consumer policy
+// It calculates scores using the provided scorers and returns a list of PairingScore
+// Each PairingScore contains the provider, its score, and the individual components of the score
+//
+// NOTE: If weights are provided in the policy, they are used to calculate a weighted score
+// If no weights are provided, the average score is used
+//
+// If a scorer fails (see score.FallibleScorer) the error is logged and no provider is returned, GetPairingList
+// surfaces such errors instead
+func (ps *pairingSystem) RankProviders(providers []*pairing.Provider, policy *pairing.ConsumerPolicy) []*pairing.PairingScore {
+	scores, err := ps.rankProviders(context.Background(), providers, policy)
+	if err != nil {
+		ps.logger.Error("Provider ranking failed", "error", err)
+		return []*pairing.PairingScore{}
+	}
+	fillComponents(scores)
+	return scores
+}
+
+// rankProviders is RankProviders, returning the errors of failing scorers
+// If ctx expires, the providers scored so far are returned along with ctx's error
+// The first failing worker stops the others, every worker error is returned
+func (ps *pairingSystem) rankProviders(ctx context.Context, providers []*pairing.Provider, policy *pairing.ConsumerPolicy) ([]*pairing.PairingScore, error) {
+	ps.log(ctx).Debug("Starting provider ranking", "provider_count", len(providers))
+	if policy == nil {
+		return nil, ErrNilPolicy
+	}
+	providers = ps.skipNil(ctx, providers)
+
+	if len(providers) == 0 {
+		ps.log(ctx).Debug("No providers to rank, returning empty list.")
+		return []*pairing.PairingScore{}, nil
+	}
+
+	preScoreCtx, plan, err := ps.safePrepareScoring(ctx, providers, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make(chan *pairing.Provider, len(providers))
+	results := make(chan *pairing.PairingScore, len(providers))
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetSpawner(ps.spawn)
+
+	// Feed tasks before starting workers, so workers running on a shared pool always drain and exit
+	for _, provider := range providers {
+		tasks <- provider
+	}
+	close(tasks)
+
+	// Start worker goroutines
+	for w := 0; w < utils.Min(ps.workers, len(providers)); w++ {
+		g.Go(func() error { return ps.rankWorker(gctx, w, tasks, results, policy, preScoreCtx, plan) })
+	}
+
+	// Wait for workers to finish and close results channel, results is buffered for every provider so
+	// workers never block on it
+	err = g.Wait()
+	close(results)
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect results
+	scores := make([]*pairing.PairingScore, 0, len(providers))
+	for score := range results {
+		scores = append(scores, score)
+	}
+
+	ps.log(ctx).Debug("Finished calculating all provider scores")
+	// If ctx expired, workers stopped early and only part of the providers were scored
+	return scores, ctx.Err()
+}
+
+// fusedFilterAndRank filters and scores providers in a single pass, each worker scoring the providers it lets
+// through right away
+// NOTE: Pool-wide scoring inputs (max stake, normalized fees, aggregates, attribute ranges) are computed
+// over every provider rather than only the ones passing the filters, so scores can differ slightly from
+// the two-phase pipeline
+func (ps *pairingSystem) fusedFilterAndRank(ctx context.Context, providers []*pairing.Provider, policy *pairing.ConsumerPolicy) ([]*pairing.PairingScore, error) {
+	ps.log(ctx).Debug("Starting fused provider filtering and ranking", "provider_count", len(providers))
+	if policy == nil {
+		return nil, ErrNilPolicy
+	}
+	providers = ps.skipNil(ctx, providers)
+	if len(providers) == 0 {
+		return []*pairing.PairingScore{}, nil
+	}
+
+	ps.evaluated.Add(int64(len(providers)))
+	filters := ps.applicableFilters(ctx, policy)
+	preScoreCtx, plan, err := ps.safePrepareScoring(ctx, providers, policy)
+	if err != nil {
+		return nil, err
+	}
+	compiled := preScoreCtx.Policy
+
+	tasks := make(chan *pairing.Provider, len(providers))
+	results := make(chan *pairing.PairingScore, len(providers))
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetSpawner(ps.spawn)
+
+	// Feed tasks before starting workers, so workers running on a shared pool always drain and exit
+	for _, provider := range providers {
+		tasks <- provider
+	}
+	close(tasks)
+
+	for w := 0; w < utils.Min(ps.workers, len(providers)); w++ {
+		g.Go(func() error { return ps.fusedWorker(gctx, w, tasks, results, compiled, filters, preScoreCtx, plan) })
+	}
+
+	// Block until all workers finish, results is buffered for every provider so workers never block on it
+	err = g.Wait()
+	close(results)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make([]*pairing.PairingScore, 0, len(results))
+	for score := range results {
+		scores = append(scores, score)
+	}
+	ps.log(ctx).Debug("Finished fused provider filtering and ranking", "ranked_count", len(scores))
+	// If ctx expired, workers stopped early and only part of the providers were processed
+	return scores, ctx.Err()
+}
+
+// prepareScoring computes the pool-wide inputs of scoring: the PreScoreContext and the scoring plan
+// It returns ctx's error once ctx is done, between the pool-wide computations, as the rank stage's deadline
+// covers them (see WithStageTimeouts)
+func (ps *pairingSystem) prepareScoring(ctx context.Context, providers []*pairing.Provider, policy *pairing.ConsumerPolicy) (*score.PreScoreContext, *scoringPlan, error) {
+	// Compute max stake and normalized fees for normalization, possibly cached (see WithAggregateCache)
+	// This is done to ensure that the stake and fee scores are relative to the maximum stake and fee in the list
+	aggregates := ps.poolAggregates(ctx, providers)
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	currentMaxStake := aggregates.maxStake
+	if currentMaxStake == 0 {
+		ps.log(ctx).Debug("No providers with stake found, setting max stake to 1")
+		currentMaxStake = 1
+	} else {
+		ps.log(ctx).Debug("Computed max stake for normalization", "max_stake", currentMaxStake)
+	}
+
+	preScoreCtx := &score.PreScoreContext{
+		DelegationFactor: ps.delegationFactor,
+		MaxStake:         currentMaxStake,
+		NormalizedFees:   aggregates.fees,
+		Policy:           ps.compile(policy),
+		Context:          ctx,
+	}
+	if aggregates.columns != nil {
+		preScoreCtx.Columns = aggregates.columns
+		preScoreCtx.Batched = ps.scoreBatches(ctx, policy, preScoreCtx)
+	}
+
+	// Compute the rolling aggregates scorers asked for, once for the whole pool
+	if ps.timeSeries != nil {
+		preScoreCtx.Aggregates = ps.computeAggregates(ctx, providers)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	preScoreCtx.AttributeRanges = ps.computeAttributeRanges(ctx, providers)
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	transforms, err := ps.resolveTransforms(policy)
+	if err != nil {
+		// GetPairingList rejects such policies upfront, direct callers get the system-wide transforms only
+		ps.log(ctx).Warn("Ignoring invalid policy transforms", "consumer_id", policy.ConsumerID, "error", err)
+		transforms, _ = ps.resolveTransforms(&pairing.ConsumerPolicy{})
+	}
+	return preScoreCtx, ps.newScoringPlan(len(providers), transforms, preScoreCtx.Policy), nil
+}
+
+// scoreBatches runs every BatchScorer over the pool's columns, see WithColumnarScoring
+// A batch that panics is dropped, its scorer then scores providers one by one
+// It stops early once ctx is done
+func (ps *pairingSystem) scoreBatches(ctx context.Context, policy *pairing.ConsumerPolicy, preScoreCtx *score.PreScoreContext) map[string][]float64 {
+	batched := make(map[string][]float64)
+	for _, scorer := range ps.scorers {
+		batcher, ok := scorer.(score.BatchScorer)
+		if !ok {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		func() {
+			defer func() {
+				if v := recover(); v != nil {
+					rethrowViolation(v)
+					ps.log(ctx).Error("Recovered from panic in batch scorer, scoring providers one by one", "scorer_name", scorer.Name(), "panic", v)
+				}
+			}()
+			out := make([]float64, preScoreCtx.Columns.Len())
+			batcher.ScoreBatch(preScoreCtx.Columns, policy, preScoreCtx, out)
+			batched[scorer.Name()] = out
+		}()
+	}
+	ps.log(ctx).Debug("Scored pool in batches", "scorer_count", len(batched), "provider_count", preScoreCtx.Columns.Len())
+	return batched
+}
+
+// computeAggregates computes every aggregate requested by the system's scorers for every provider
+// Aggregates requested by several scorers are only computed once
+// It stops early once ctx is done
+func (ps *pairingSystem) computeAggregates(ctx context.Context, providers []*pairing.Provider) map[string]map[string]float64 {
+	aggregates := make(map[string]map[string]float64)
+	for _, scorer := range ps.scorers {
+		requester, ok := scorer.(score.AggregateRequester)
+		if !ok {
+			continue
+		}
+		for _, spec := range requester.RequiredAggregates() {
+			key := spec.Key()
+			if _, done := aggregates[key]; done {
+				continue
+			}
+			if ctx.Err() != nil {
+				return aggregates
+			}
+			values := make(map[string]float64, len(providers))
+			for _, p := range providers {
+				if value, ok := ps.timeSeries.Aggregate(p.ID, spec); ok {
+					values[p.ID] = value
+				}
+			}
+			aggregates[key] = values
+			ps.log(ctx).Debug("Computed rolling aggregate", "aggregate", key, "provider_count", len(values))
+		}
+	}
+	return aggregates
+}
+
+// computeAttributeRanges computes the range across the pool of every attribute (AttributeRequester) and value
+// (ValueRequester) requested by the system's scorers
+// Providers lacking an attribute don't contribute to its range
+func (ps *pairingSystem) computeAttributeRanges(ctx context.Context, providers []*pairing.Provider) map[string]score.AttributeRange {
+	var ranges map[string]score.AttributeRange
+	add := func(key string, value func(*pairing.Provider) (float64, bool)) {
+		if _, done := ranges[key]; done {
+			return
+		}
+		r, found := valueRange(providers, value)
+		if !found {
+			return
+		}
+		if ranges == nil {
+			ranges = make(map[string]score.AttributeRange)
+		}
+		ranges[key] = r
+		ps.log(ctx).Debug("Computed attribute range", "attribute", key, "min", r.Min, "max", r.Max)
+	}
+	for _, scorer := range ps.scorers {
+		if requester, ok := scorer.(score.AttributeRequester); ok {
+			for _, name := range requester.RequiredAttributes() {
+				add(name, func(p *pairing.Provider) (float64, bool) { return p.Attribute(name) })
+			}
+		}
+		if requester, ok := scorer.(score.ValueRequester); ok {
+			for key, value := range requester.RequiredValues() {
+				add(key, value)
+			}
+		}
+	}
+	return ranges
+}
+
+// valueRange returns the range of a value across the providers that have it
+// It returns false if none has
+func valueRange(providers []*pairing.Provider, value func(*pairing.Provider) (float64, bool)) (r score.AttributeRange, found bool) {
+	for _, p := range providers {
+		v, ok := value(p)
+		if !ok {
+			continue
+		}
+		if !found || v < r.Min {
+			r.Min = v
+		}
+		if !found || v > r.Max {
+			r.Max = v
+		}
+		found = true
+	}
+	return r, found
+}
+
+// GetPairingList retrieves a list of top providers based on the consumer policy
+// It filters, ranks, and sorts the providers, returning the top N providers
+// With WithMode, it only runs the stages of the configured mode
+func (ps *pairingSystem) GetPairingList(providers []*pairing.Provider, policy *pairing.ConsumerPolicy, opts ...PairingOptions) (*PairingResult, error) {
+	return ps.pair(providers, policy, ps.mode, mergeOptions(opts))
+}
+
+// ScoreOnly is GetPairingList over providers the caller already filtered: they are validated, ranked and the
+// top N selected, without going through the filters
+func (ps *pairingSystem) ScoreOnly(providers []*pairing.Provider, policy *pairing.ConsumerPolicy, opts ...PairingOptions) (*PairingResult, error) {
+	return ps.pair(providers, policy, ModeScoreOnly, mergeOptions(opts))
+}
+
+// FilterOnly is GetPairingList without scoring: the providers are validated and filtered, and every provider
+// passing the filters is returned in input order, without scores
+func (ps *pairingSystem) FilterOnly(providers []*pairing.Provider, policy *pairing.ConsumerPolicy, opts ...PairingOptions) (*PairingResult, error) {
+	return ps.pair(providers, policy, ModeFilterOnly, mergeOptions(opts))
+}
+
+// pair runs the stages of the pairing pipeline the mode selects with the call's options, see GetPairingList
+// Calls sampled by WithRecorder are seeded and recorded
+func (ps *pairingSystem) pair(providers []*pairing.Provider, policy *pairing.ConsumerPolicy, mode PipelineMode, call PairingOptions) (*PairingResult, error) {
+	if ps.recorder == nil || policy == nil || !ps.recorder.sampled(policy) {
+		return ps.execute(providers, policy, mode, call)
+	}
+	call = ps.recorder.prepare(call)
+	rec := newRecording(ps, providers, policy, mode, call)
+	state := ps.recorder.recorder.Capture() // Before the call updates it
+	result, err := ps.execute(providers, policy, mode, call)
+	rec.Outcome = NewOutcome(result, err)
+	if recordErr := ps.recorder.recorder.Record(rec, state); recordErr != nil {
+		ps.logger.Warn("Failed to record pairing call", "request_id", call.RequestID, "error", recordErr)
+	}
+	return result, err
+}
+
+// execute runs the stages of the pairing pipeline, see pair
+func (ps *pairingSystem) execute(providers []*pairing.Provider, policy *pairing.ConsumerPolicy, mode PipelineMode, call PairingOptions) (_ *PairingResult, err error) {
+	start := time.Now()
+	now := start // The call's clock, see PairingOptions.Now
+	if !call.Now.IsZero() {
+		now = call.Now
+	}
+	requestID := call.RequestID
+	if requestID == "" {
+		requestID = newRequestID()
+	}
+	// Tag every log line of the call with its request ID, the stages log through the call's context
+	log := ps.logger.With("request_id", requestID)
+	var buffer *logBuffer
+	if ps.escalateLogs {
+		buffer = newLogBuffer(log.Handler())
+		log = slog.New(buffer)
+		defer buffer.flush()
+	}
+	callCtx := withLogger(context.Background(), log)
+	log.Info("Starting GetPairingList", "initial_provider_count", len(providers), "mode", mode)
+	if policy == nil {
+		return nil, ErrNilPolicy
+	}
+
+	// Fill in the settings the policy leaves to the configuration layers
+	settings := ps.resolveSettings(policy, call)
+	selection := ps.selection
+	if call.Selection != nil {
+		selection = call.Selection
+	}
+	if len(settings.Conflicts) > 0 {
+		log.Debug("Configuration layers override each other", "chain_id", policy.ChainID, "conflicts", settings.Conflicts)
+	}
+	policy = withSettings(policy, settings)
+
+	// Validate the weights before charging anything, a malformed request shouldn't cost quota
+	resolved, err := ps.resolveWeights(callCtx, policy)
+	if err != nil {
+		log.Warn("Rejected policy weights", "consumer_id", policy.ConsumerID, "error", err)
+		return nil, err
+	}
+	if _, err := ps.resolveTransforms(policy); err != nil {
+		log.Warn("Rejected policy transforms", "consumer_id", policy.ConsumerID, "error", err)
+		return nil, err
+	}
+
+	if policy.ComputeUnits < 0 {
+		log.Warn("Rejected negative compute units", "consumer_id", policy.ConsumerID, "compute_units", policy.ComputeUnits)
+		return nil, ErrNegativeComputeUnits
+	}
+
+	// Step 0: Charge the request against the consumer's quota, rejecting it once the quota is used up
+	if ps.quota != nil {
+		refund, chargeErr := ps.quota.Charge(string(policy.ConsumerID), policy.ComputeUnits)
+		if chargeErr != nil {
+			log.Warn("Consumer quota exceeded", "consumer_id", policy.ConsumerID, "error", chargeErr)
+			return nil, chargeErr
+		}
+		// Only a successful pairing costs quota, a failed one is refunded
+		defer func() {
+			if err != nil {
+				refund()
+			}
+		}()
+	}
+
+	result := &PairingResult{
+		RequestID:  requestID,
+		ConfigHash: ps.configHash(policy, resolved.Weights, settings, mode, selection),
+		Mode:       mode,
+		Timestamp:  now,
+		Counts:     StageCounts{Input: len(providers)},
+		Settings:   settings,
+	}
+	// Keep invalid provider data from corrupting the scores of valid providers
+	providers, result.Diagnostics = ps.validateProviders(callCtx, providers)
+	result.Counts.Invalid = len(result.Diagnostics)
+	if ps.anomalies != nil {
+		var quarantined []Diagnostic
+		providers, quarantined = ps.screenAnomalies(callCtx, providers, call.ExternalPool)
+		result.Diagnostics = append(result.Diagnostics, quarantined...)
+		result.Counts.Quarantined = len(quarantined)
+	}
+	if ps.timeSeries != nil && !call.ExternalPool {
+		ps.observe(providers, now)
+	}
+	if ps.commitments != nil {
+		result.SnapshotRoot = ps.commitments.commit(call.PoolVersion, providers).Root()
+	}
+	recovered := &recoveries{}
+	// The call's timeout bounds every stage together, each stage context derives from it
+	poolCtx := withPool(callCtx, pool{version: call.PoolVersion, size: len(providers)})
+	parent, cancelCall := context.WithCancel(poolCtx)
+	if call.Timeout > 0 {
+		parent, cancelCall = context.WithTimeout(poolCtx, call.Timeout)
+	}
+	defer cancelCall()
+	var scored []*pairing.PairingScore
+	var filtered []*pairing.Provider
+	if ps.fused && mode == ModeFull {
+		// Steps 1 & 2 in a single pass over the providers, see WithFusedPipeline
+		stageStart := time.Now()
+		ctx, cancel := stageContext(parent, ps.filterTimeout, ps.rankTimeout)
+		scored, err = ps.fusedFilterAndRank(withRecoveries(ctx, recovered), providers, resolved)
+		cancel()
+		result.Partial, err = ps.stageOutcome(callCtx, "filter and rank", err, settings.Strict)
+		result.Durations.Rank = time.Since(stageStart)
+		result.Counts.Filtered = len(scored)
+	} else {
+		// Step 1: Filter providers based on policy requirements, unless the caller already did
+		if mode == ModeScoreOnly {
+			filtered = providers
+		} else {
+			stageStart := time.Now()
+			ctx, cancel := stageContext(parent, ps.filterTimeout)
+			filtered, err = ps.filterProviders(withRecoveries(ctx, recovered), providers, policy)
+			cancel()
+			result.Partial, err = ps.stageOutcome(callCtx, "filter", err, settings.Strict)
+			result.Durations.Filter = time.Since(stageStart)
+		}
+		result.Counts.Filtered = len(filtered)
+		if err == nil && mode != ModeFilterOnly {
+			log.Debug("Filtering complete", "filtered_count", len(filtered))
+
+			// Step 2: Rank the filtered providers based on scoring criteria
+			var rankPartial bool
+			stageStart := time.Now()
+			ctx, cancel := stageContext(parent, ps.rankTimeout)
+			scored, err = ps.rankProviders(withRecoveries(ctx, recovered), filtered, resolved)
+			cancel()
+			rankPartial, err = ps.stageOutcome(callCtx, "rank", err, settings.Strict)
+			result.Durations.Rank = time.Since(stageStart)
+			result.Partial = result.Partial || rankPartial
+		}
+	}
+	result.Counts.Ranked = len(scored)
+	if result.Partial || errors.Is(err, ErrStageTimeout) {
+		buffer.escalate("stage timed out")
+	}
+	for _, s := range scored {
+		if math.IsNaN(s.Score) || math.IsInf(s.Score, 0) {
+			buffer.escalate("non-finite score")
+			break
+		}
+	}
+	result.Counts.Panicked = len(recovered.diagnostics)
+	result.Diagnostics = append(result.Diagnostics, recovered.diagnostics...)
+	if err != nil {
+		log.Error("Pairing pipeline failed", "consumer_id", policy.ConsumerID, "error", err)
+		return nil, err
+	}
+	if mode == ModeFilterOnly {
+		// Stand in for the scores, so empty results are handled like those of a full run
+		scored = make([]*pairing.PairingScore, len(filtered))
+		for i, p := range filtered {
+			scored[i] = &pairing.PairingScore{Provider: p}
+		}
+	}
+	if len(scored) == 0 {
+		buffer.escalate("no candidates")
+		log.Warn("No providers matched the filter criteria.")
+
+		if settings.Strict {
+			return nil, fmt.Errorf("strict mode: %w", pairingerrors.ErrNoProviders)
+		}
+		if policy.MinProviders > 0 {
+			return nil, &pairingerrors.InsufficientProvidersError{Required: policy.MinProviders}
+		}
+
+		// Graceful: return empty list, no error
+		result.Providers = []*pairing.Provider{}
+		result.Durations.Total = time.Since(start)
+		return result, nil
+	}
+	if mode == ModeFilterOnly {
+		return ps.filteredResult(callCtx, result, filtered, policy, start)
+	}
+	log.Debug("Ranking complete", "ranked_count", len(scored))
+	var unproven []*pairing.PairingScore
+	if policy.WarmUp != nil && policy.WarmUp.Hours > 0 {
+		scored, unproven = ps.warmUp(callCtx, scored, policy.WarmUp, now)
+	}
+
+	// Step 3: Sort providers by their final score in descending order
+	sortStart := time.Now()
+	ps.rankScored(callCtx, scored, resolved, now, true)
+	log.Debug("Sorting complete")
+
+	if ps.scoreHistory != nil && !call.ExternalPool {
+		if series := scoreSeriesKey(resolved); ps.scoreSeries.admit(series, now) {
+			metric := timeseries.ScoreMetric(series)
+			for _, s := range scored {
+				ps.scoreHistory.Record(s.Provider.ID, metric, s.Score, now)
+			}
+			result.ScoreSeries = series
+		}
+	}
+
+	// Step 4: Select N providers, the top N unless configured otherwise (see WithSelection)
+	var selected []*pairing.PairingScore
+	if random, ok := selection.(RandomSelection); ok && call.Seed != 0 {
+		selected = random.SelectRand(scored, settings.TopN, resolved, rand.New(rand.NewPCG(call.Seed, call.Seed>>1|1)))
+	} else if constrained, ok := selection.(ConstrainedSelection); ok {
+		var met bool
+		if selected, met = constrained.SelectConstrained(scored, settings.TopN, resolved); !met {
+			if settings.Strict {
+				return nil, fmt.Errorf("strict mode: %w", ErrConstraintsUnmet)
+			}
+			log.Warn("No selection meets the selection constraints, falling back to a best-effort selection", "selection", selection.Name())
+			result.ConstraintsUnmet = true
+		}
+	} else {
+		selected = selection.Select(scored, settings.TopN, resolved)
+	}
+	if len(unproven) > 0 && policy.WarmUp.ExplorationSlots > 0 {
+		ps.orderScored(unproven, resolved, now)
+		selected = ps.explore(callCtx, selected, unproven, policy.WarmUp.ExplorationSlots, settings.TopN)
+	}
+	// The swaps made after the cluster limit keep to it
+	var slots *clusterSlots
+	if ps.clusters != nil {
+		selected, slots = ps.clusters.limit(callCtx, ps, selected, scored)
+	}
+	if policy.MinASNs > 0 {
+		var diverse bool
+		selected, diverse = ps.diversify(callCtx, selected, scored, policy.MinASNs, slots)
+		if !diverse && settings.Strict {
+			return nil, fmt.Errorf("strict mode: %w", ErrInsufficientDiversity)
+		}
+	}
+	if ps.fairness != nil {
+		selected = ps.admitFair(callCtx, selected, scored, slots, now)
+	}
+	fillComponents(selected)
+	finalCount := len(selected) // Fewer than topN when fewer providers are eligible
+	topProviders := make([]*pairing.Provider, 0, finalCount)
+	for i, s := range selected {
+		topProviders = append(topProviders, s.Provider)
+		log.Debug("Selected provider",
+			"rank", i+1,
+			"address", s.Provider.Address,
+			"score", s.Score,
+			"components", s.Components,
+		)
+	}
+	result.Providers = topProviders
+	result.Scores = selected
+	result.Counts.Selected = finalCount
+	backups := policy.Backups
+	if call.Backups > 0 {
+		backups = call.Backups
+	}
+	if backups > 0 {
+		result.BackupScores = backupTier(scored, selected, backups)
+		fillComponents(result.BackupScores)
+		for _, s := range result.BackupScores {
+			result.Backups = append(result.Backups, s.Provider)
+		}
+		log.Debug("Selected backup providers", "backup_count", len(result.Backups))
+	}
+	if ps.hints != nil {
+		result.Hints = ps.hints.build(append(topProviders[:len(topProviders):len(topProviders)], result.Backups...), policy)
+	}
+	result.Durations.Sort = time.Since(sortStart)
+	if finalCount < policy.MinProviders {
+		log.Warn("Fewer providers selected than the policy requires", "selected_count", finalCount, "min_providers", policy.MinProviders)
+		return nil, &pairingerrors.InsufficientProvidersError{Required: policy.MinProviders, Available: finalCount}
+	}
+	if ps.fairness != nil {
+		// Only a selection handed to the consumer counts towards the providers' shares
+		selectedIDs := make([]string, 0, finalCount)
+		for _, p := range topProviders {
+			selectedIDs = append(selectedIDs, p.ID)
+		}
+		ps.fairness.Record(now, selectedIDs)
+	}
+
+	// Step 5: Evaluate shadow configurations against the live selection, off the request path
+	if len(ps.shadows) > 0 {
+		ps.runShadows(callCtx, providers, policy, selected)
+	}
+
+	result.Durations.Total = time.Since(start)
+	log.Info("Finished GetPairingList",
+		"selected_count", len(topProviders),
+		"partial", result.Partial,
+		"config_hash", result.ConfigHash,
+		"counts", result.Counts,
+		"elapsed", result.Durations.Total,
+	)
+	return result, nil
+}
+
+// filteredResult completes a filtering-only result with every provider passing the filters
+func (ps *pairingSystem) filteredResult(ctx context.Context, result *PairingResult, filtered []*pairing.Provider, policy *pairing.ConsumerPolicy, start time.Time) (*PairingResult, error) {
+	result.Providers = filtered
+	result.Counts.Selected = len(filtered)
+	if len(filtered) < policy.MinProviders {
+		ps.log(ctx).Warn("Fewer providers passed the filters than the policy requires", "filtered_count", len(filtered), "min_providers", policy.MinProviders)
+		return nil, &pairingerrors.InsufficientProvidersError{Required: policy.MinProviders, Available: len(filtered)}
+	}
+	result.Durations.Total = time.Since(start)
+	ps.log(ctx).Info("Finished GetPairingList", "mode", ModeFilterOnly, "filtered_count", len(filtered), "partial", result.Partial, "elapsed", result.Durations.Total)
+	return result, nil
+}
+
+// stageContext returns the context bounding a pipeline stage by the sum of the given timeouts, only bounded
+// by the parent if they are all 0
+func stageContext(parent context.Context, timeouts ...time.Duration) (context.Context, context.CancelFunc) {
+	var total time.Duration
+	for _, t := range timeouts {
+		total += t
+	}
+	if total <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, total)
+}
+
+// stageOutcome interprets the error of a pipeline stage, telling whether it ran out of time
+// Running out of time isn't an error, the stage's partial output is used, except in strict mode
+func (ps *pairingSystem) stageOutcome(ctx context.Context, stage string, err error, strict bool) (partial bool, _ error) {
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return false, err
+	}
+	if strict {
+		return true, fmt.Errorf("strict mode: %w: %s", ErrStageTimeout, stage)
+	}
+	ps.log(ctx).Warn("Pairing stage timed out, continuing with partial output", "stage", stage)
+	return true, nil
+}
+
+// sortByScore sorts scored providers by their final score in descending order, ties by provider ID
+// Ranking workers return providers in no particular order, ties must not depend on it
+func sortByScore(scored []*pairing.PairingScore) {
+	slices.SortStableFunc(scored, ComparatorChain(ByScore, ByID).Compare)
+}
+
+/* ***********************************************************************
+ *                                   WORKERS                             *
+ *********************************************************************** */
+
+// rankWorker is a goroutine that processes providers and calculates their scores
+// It takes a provider from the tasks channel, scores it using the provided scorers,
+// and sends the result to the results channel
+// It stops early, without error, once another worker of the same call has failed
+// A panic evaluating a provider skips that provider only, see recoverProvider
+func (ps *pairingSystem) rankWorker(ctx context.Context, workerID int, tasks <-chan *pairing.Provider, results chan<- *pairing.PairingScore, policy *pairing.ConsumerPolicy, preScoreCtx *score.PreScoreContext, plan *scoringPlan) error {
+	for p := range tasks {
+		if ctx.Err() != nil {
+			return nil
+		}
+		scored, err := ps.safeScoreProvider(ctx, workerID, p, policy, preScoreCtx, plan)
+		if err != nil {
+			return err
+		}
+		if scored != nil { // Nil if scoring panicked
+			results <- scored
+		}
+	}
+	return nil
+}
+
+// scoreProvider scores a single provider with every applicable scorer and combines the components into its
+// final score
+func (ps *pairingSystem) scoreProvider(ctx context.Context, workerID int, p *pairing.Provider, policy *pairing.ConsumerPolicy, preScoreCtx *score.PreScoreContext, plan *scoringPlan) (*pairing.PairingScore, error) {
+	scored := plan.arena.alloc(plan.names)
+	scored.Provider = p
+	components := scored.Vector
+
+	for i, scorer := range ps.scorers {
+		if reporter, ok := scorer.(score.ApplicabilityReporter); ok && !reporter.Applicable(p, policy, preScoreCtx) {
+			// Leave the component out entirely, the remaining weights are renormalized below
+			ps.log(ctx).Debug("Scorer not applicable to provider", "worker_id", workerID, "provider_id", p.ID, "scorer_name", scorer.Name())
+			continue
+		}
+		s, err := scoreWith(scorer, p, policy, preScoreCtx)
+		if err != nil {
+			return nil, err
+		}
+		ps.checkComponent(ctx, scorer, p, s)
+		if reporter, ok := scorer.(score.RangeReporter); ok {
+			// Bring heterogeneous scorers to the same [0, 1] scale before transforms and weighting
+			min, max := reporter.Range()
+			s = score.Rescale(s, min, max)
+		}
+		if transform := plan.transforms[i]; transform != nil {
+			s = transform(s, p, policy)
+			ps.checkFinite(ctx, p, scorer.Name()+" after transforms", s)
+		}
+		components.Values[i] = s
+		components.Applied[i] = true
+	}
+
+	// Weighted sum of the components if the policy has weights, their average otherwise
+	// Scorers missing from the weights contribute 0, the user intentionally omitted them from the scheme
+	finalScore := score.CombineVector(components.Values, components.Applied, plan.weights, plan.configuredWeight)
+
+	// Apply the consumer's explicit preference for this provider, keeping the score within [0, 1]
+	if adjustment, ok := policy.Adjustments[p.ID]; ok {
+		finalScore = score.Adjust(finalScore, adjustment)
+		ps.log(ctx).Debug("Applied policy score adjustment", "worker_id", workerID, "provider_id", p.ID, "adjustment", adjustment)
+	}
+	ps.checkFinite(ctx, p, "final score", finalScore)
+
+	ps.log(ctx).Debug("Rank-Worker scored provider",
+		"worker_id", workerID,
+		"provider_id", p.ID,
+		"score", finalScore,
+		"components", components,
+	)
+
+	scored.Score = finalScore
+	return scored, nil
+}
+
+// scoreWith scores a provider with a single scorer, going through TryScore for fallible scorers
+// Scores computed in a batch beforehand (see WithColumnarScoring) are used as is
+func scoreWith(scorer score.Scorer, p *pairing.Provider, policy *pairing.ConsumerPolicy, preScoreCtx *score.PreScoreContext) (float64, error) {
+	if s, ok := preScoreCtx.BatchScore(scorer.Name(), p.ID); ok {
+		return s, nil
+	}
+	fallible, ok := scorer.(score.FallibleScorer)
+	if !ok {
+		return scorer.Score(p, policy, preScoreCtx), nil
+	}
+	s, err := fallible.TryScore(p, policy, preScoreCtx)
+	if err != nil {
+		return 0, fmt.Errorf("%w: scorer %s on provider %s: %w", ErrPipeline, scorer.Name(), p.ID, err)
+	}
+	return s, nil
+}
+
+// configuredWeight returns the total weight given to the system's scorers, ignoring weights of unknown scorers
+func (ps *pairingSystem) configuredWeight(weights map[string]float64) float64 {
+	var total float64
+	for _, scorer := range ps.scorers {
+		total += weights[scorer.Name()]
+	}
+	return total
+}
+
+// spawn runs a worker on the system's worker pool, or on a new goroutine once the pool is closed
+func (ps *pairingSystem) spawn(worker func()) {
+	if err := ps.pool.Submit(worker); err == nil {
+		return
+	}
+	ps.logger.Debug("Worker pool closed, falling back to a dedicated goroutine")
+	go worker()
+}
+
+// filterWorker is a goroutine that processes providers and applies filters to them
+// It stops early, without error, once another worker of the same call has failed
+// A panic evaluating a provider skips that provider only, see recoverProvider
+func (ps *pairingSystem) filterWorker(ctx context.Context, workerID int, tasks <-chan *pairing.Provider, results chan<- *pairing.Provider, compiled *policy.CompiledPolicy, filters []filter.Filter) error {
+	for p := range tasks {
+		if ctx.Err() != nil {
+			return nil
+		}
+		pass, err := ps.safePassesFilters(ctx, workerID, p, compiled, filters)
+		if err != nil {
+			return err
+		}
+		// If the provider passes all filters, send it to the results channel
+		if pass {
+			results <- p
+		}
+	}
+	return nil
+}
+
+// fusedWorker is a goroutine that filters providers and immediately scores the ones passing every filter
+// It stops early, without error, once another worker of the same call has failed
+// A panic evaluating a provider skips that provider only, see recoverProvider
+func (ps *pairingSystem) fusedWorker(ctx context.Context, workerID int, tasks <-chan *pairing.Provider, results chan<- *pairing.PairingScore, compiled *policy.CompiledPolicy, filters []filter.Filter, preScoreCtx *score.PreScoreContext, plan *scoringPlan) error {
+	for p := range tasks {
+		if ctx.Err() != nil {
+			return nil
+		}
+		pass, err := ps.safePassesFilters(ctx, workerID, p, compiled, filters)
+		if err != nil {
+			return err
+		}
+		if !pass {
+			continue
+		}
+		scored, err := ps.safeScoreProvider(ctx, workerID, p, compiled.Policy, preScoreCtx, plan)
+		if err != nil {
+			return err
+		}
+		if scored != nil { // Nil if scoring panicked
+			results <- scored
+		}
+	}
+	return nil
+}
+
+// passesFilters checks a single provider against the given filters, stopping at the first rejection
+func (ps *pairingSystem) passesFilters(ctx context.Context, workerID int, p *pairing.Provider, compiled *policy.CompiledPolicy, filters []filter.Filter) (bool, error) {
+	for _, filter := range filters {
+		// Apply the filter to the provider
+		pass, err := checkFilter(filter, p, compiled)
+		if err != nil {
+			return false, err
+		}
+		if !pass {
+			ps.rejected[filter.Name()].Add(1)
+			ps.log(ctx).Debug("Filter-Worker filter rejected provider",
+				"worker_id", workerID,
+				"provider_id", p.ID,
+				"filter_name", filter.Name(),
+			)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// applicableFilters returns the filters applying to the policy, in order, logging the ones skipped because the
+// policy leaves their inputs unset (see filter.Filter.Applicable)
+// Every filter applies with WithStrictConstraints
+func (ps *pairingSystem) applicableFilters(ctx context.Context, policy *pairing.ConsumerPolicy) []filter.Filter {
+	if ps.strictConstraints {
+		return ps.filters
+	}
+	applicable := make([]filter.Filter, 0, len(ps.filters))
+	var skipped []string
+	for _, f := range ps.filters {
+		if f.Applicable(policy) {
+			applicable = append(applicable, f)
+		} else {
+			skipped = append(skipped, f.Name())
+		}
+	}
+	if len(skipped) > 0 {
+		ps.log(ctx).Debug("Skipped filters the policy sets no inputs for", "filters", skipped)
+	}
+	return applicable
+}
+
+// checkFilter checks a single provider against a filter, going through Check for fallible filters
+func checkFilter(f filter.Filter, p *pairing.Provider, compiled *policy.CompiledPolicy) (bool, error) {
+	fallible, ok := f.(filter.FallibleFilter)
+	if !ok {
+		if c, ok := f.(filter.CompiledFilter); ok {
+			return c.ApplyCompiled(p, compiled), nil
+		}
+		return f.ApplySingle(p, compiled.Policy), nil
+	}
+	pass, err := fallible.Check(p, compiled.Policy)
+	if err != nil {
+		return false, fmt.Errorf("%w: filter %s on provider %s: %w", ErrPipeline, f.Name(), p.ID, err)
+	}
+	return pass, nil
+}
+
+// applyFilter applies a filter to a list of providers, checking fallible filters provider by provider
+func applyFilter(f filter.Filter, providers []*pairing.Provider, compiled *policy.CompiledPolicy) ([]*pairing.Provider, error) {
+	_, fallible := f.(filter.FallibleFilter)
+	if _, ok := f.(filter.CompiledFilter); !ok && !fallible {
+		return f.Apply(providers, compiled.Policy), nil
+	}
+	var result []*pairing.Provider
+	for _, p := range providers {
+		pass, err := checkFilter(f, p, compiled)
+		if err != nil {
+			return nil, err
+		}
+		if pass {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
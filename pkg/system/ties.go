@@ -0,0 +1,57 @@
+package system
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/internal/utils"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// orderScored sorts scored providers by score, ties by provider ID or, if enabled, shuffled
+// With a custom comparator (see WithComparator), it sorts by that comparator instead and breaks or shuffles the
+// ties it leaves
+// Ties are shuffled by the consumer's epoch at now, the call's clock (see PairingOptions.Now)
+func (ps *pairingSystem) orderScored(scored []*pairing.PairingScore, policy *pairing.ConsumerPolicy, now time.Time) {
+	order := ByScore
+	if ps.comparator != nil {
+		order = *ps.comparator
+	}
+	// Order ties by ID, ranking workers return providers in no particular order and neither the order nor the
+	// shuffle may depend on it to be reproducible
+	slices.SortStableFunc(scored, ComparatorChain(order, ByID).Compare)
+	if ps.tieShuffleEpoch <= 0 {
+		return
+	}
+	shuffleTies(scored, order.Compare, tieSeed(string(policy.ConsumerID), utils.Epoch(now, ps.tieShuffleEpoch)))
+}
+
+// shuffleTies shuffles every run of equal providers in a sorted slice, leaving the order between runs intact
+func shuffleTies(scored []*pairing.PairingScore, compare func(a, b *pairing.PairingScore) int, seed uint64) {
+	rng := rand.New(rand.NewPCG(seed, seed>>1|1))
+	for start := 0; start < len(scored); {
+		end := start + 1
+		for end < len(scored) && compare(scored[end], scored[start]) == 0 {
+			end++
+		}
+		if end-start > 1 {
+			group := scored[start:end]
+			rng.Shuffle(len(group), func(i, j int) { group[i], group[j] = group[j], group[i] })
+		}
+		start = end
+	}
+}
+
+// tieSeed derives the shuffle seed of a consumer for an epoch
+// The same consumer gets the same order for a whole epoch, while different consumers get different orders
+func tieSeed(consumerID string, epoch uint64) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(consumerID))
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], epoch)
+	h.Write(buf[:])
+	return h.Sum64()
+}
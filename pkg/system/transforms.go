@@ -0,0 +1,41 @@
+package system
+
+import (
+	"fmt"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/score"
+)
+
+// resolveTransforms builds the transform applied to each scorer's component: the system-wide transform
+// (see WithTransforms) followed by the policy's, keyed by scorer name
+// Policy keys are matched to scorers like weight keys (see canonicalWeightKey)
+func (ps *pairingSystem) resolveTransforms(policy *pairing.ConsumerPolicy) (map[string]score.Transform, error) {
+	if len(ps.transforms) == 0 && len(policy.Transforms) == 0 {
+		return nil, nil
+	}
+	registered := make(map[string]string, len(ps.scorers)) // Canonical key -> scorer name
+	for _, scorer := range ps.scorers {
+		registered[canonicalWeightKey(scorer.Name())] = scorer.Name()
+	}
+
+	transforms := make(map[string]score.Transform, len(ps.transforms)+len(policy.Transforms))
+	for name, t := range ps.transforms {
+		transforms[name] = t
+	}
+	for key, specs := range policy.Transforms {
+		name, ok := registered[canonicalWeightKey(key)]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown scorer %q", ErrInvalidTransforms, key)
+		}
+		t, err := score.ParseTransforms(specs)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidTransforms, name, err)
+		}
+		if systemWide, ok := transforms[name]; ok {
+			t = score.Chain(systemWide, t)
+		}
+		transforms[name] = t
+	}
+	return transforms, nil
+}
@@ -0,0 +1,681 @@
+package system
+
+import (
+	"container/list"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/address"
+	"github.com/Yoaz/LavaPairingSystem/pkg/anomaly"
+	"github.com/Yoaz/LavaPairingSystem/pkg/cluster"
+	"github.com/Yoaz/LavaPairingSystem/pkg/commitment"
+	"github.com/Yoaz/LavaPairingSystem/pkg/fairness"
+	"github.com/Yoaz/LavaPairingSystem/pkg/filter"
+	"github.com/Yoaz/LavaPairingSystem/pkg/latency"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairingerrors"
+	"github.com/Yoaz/LavaPairingSystem/pkg/quota"
+	"github.com/Yoaz/LavaPairingSystem/pkg/score"
+	"github.com/Yoaz/LavaPairingSystem/pkg/timeseries"
+	"github.com/Yoaz/LavaPairingSystem/pkg/workerpool"
+)
+
+// topN is the number of top providers to return
+const (
+	topNProviders           = 5
+	parallelFilterThreshold = 50
+	workerCount             = 10
+)
+
+// ErrInvalidWeights is returned by GetPairingList when the policy's weights can't be applied
+// It matches pairingerrors.ErrInvalidPolicy
+var ErrInvalidWeights = pairingerrors.New(pairingerrors.ErrInvalidPolicy, "invalid policy weights")
+
+// ErrInvalidTransforms is returned by GetPairingList when the policy's transforms can't be parsed
+// It matches pairingerrors.ErrInvalidPolicy
+var ErrInvalidTransforms = pairingerrors.New(pairingerrors.ErrInvalidPolicy, "invalid policy transforms")
+
+// ErrInvalidAPIInterfaces is returned by PairInterfaces when the policy's API interfaces can't be paired
+// It matches pairingerrors.ErrInvalidPolicy
+var ErrInvalidAPIInterfaces = pairingerrors.New(pairingerrors.ErrInvalidPolicy, "invalid policy api interfaces")
+
+// ErrNegativeComputeUnits is returned when a policy requests negative compute units, which would refund quota
+// It matches pairingerrors.ErrInvalidPolicy
+var ErrNegativeComputeUnits = pairingerrors.New(pairingerrors.ErrInvalidPolicy, "compute units must not be negative")
+
+// ErrInsufficientDiversity is returned in strict mode when the eligible providers don't span the policy's MinASNs
+// It matches pairingerrors.ErrInsufficientProviders
+var ErrInsufficientDiversity = pairingerrors.New(pairingerrors.ErrInsufficientProviders, "eligible providers span fewer ASNs than the policy requires")
+
+// ErrConstraintsUnmet is returned in strict mode when no selection of the eligible providers meets the
+// requirements of the ConstrainedSelection
+// It matches pairingerrors.ErrInsufficientProviders
+var ErrConstraintsUnmet = pairingerrors.New(pairingerrors.ErrInsufficientProviders, "no selection of eligible providers meets the selection constraints")
+
+// ErrNilPolicy is returned when pairing is requested without a policy
+// It matches pairingerrors.ErrInvalidPolicy
+var ErrNilPolicy = pairingerrors.New(pairingerrors.ErrInvalidPolicy, "nil policy")
+
+// InvariantMode controls how violated score invariants are handled, see WithInvariantChecks
+type InvariantMode int
+
+const (
+	// InvariantsOff skips the checks, the default
+	InvariantsOff InvariantMode = iota
+	// InvariantsLog logs violations as errors and carries on
+	InvariantsLog
+	// InvariantsPanic panics on the first violation, for tests and debug builds
+	InvariantsPanic
+)
+
+// UnknownWeightMode controls how weights referencing scorers that aren't registered in the system are handled
+type UnknownWeightMode int
+
+const (
+	// UnknownWeightsIgnore logs unknown weights and drops them, the request loses their share of the score
+	UnknownWeightsIgnore UnknownWeightMode = iota
+	// UnknownWeightsError fails the request with ErrInvalidWeights
+	UnknownWeightsError
+	// UnknownWeightsRenormalize drops unknown weights and scales the remaining ones up to the original total
+	UnknownWeightsRenormalize
+)
+
+// NewPairingSystem creates a new PairingSystem instance with the provided filters, scorers, and logger
+type PairingSystem interface {
+	// FilterProviders returns a list of providers that match the policy requirements
+	FilterProviders(providers []*pairing.Provider, policy *pairing.ConsumerPolicy) []*pairing.Provider
+	// RankProviders assigns scores to providers based on the policy requirements
+	RankProviders(providers []*pairing.Provider, policy *pairing.ConsumerPolicy) []*pairing.PairingScore
+	// GetPairingList returns the top-5 best provider for the given consumer policy
+	// Options override the system's settings for this call only
+	// NOTE: It returns a *PairingResult rather than the selected []*pairing.Provider since per-stage timeouts
+	// (WithStageTimeouts), callers written against the old signature read the selection from its Providers
+	GetPairingList(providers []*pairing.Provider, policy *pairing.ConsumerPolicy, opts ...PairingOptions) (*PairingResult, error)
+	// ConfigFingerprint returns a stable hash of the system's configuration
+	ConfigFingerprint() string
+	// Close releases the system's long-lived workers, the system keeps working on per-call goroutines afterwards
+	Close()
+}
+
+// pairingSystem is the implementation of the PairingSystem interface
+type pairingSystem struct {
+	filters    []filter.Filter
+	scorers    []score.Scorer
+	logger     *slog.Logger
+	strictMode bool           // If true, returns error when no providers match; if false, returns empty list
+	quota      *quota.Tracker // Optional per-consumer quota, nil disables quota enforcement
+	// Weight of delegated stake when normalizing stake scores, should match the StakeFilter's
+	delegationFactor  float64
+	timeSeries        *timeseries.Store          // Optional, source of rolling aggregates requested by scorers
+	shadows           []*Shadow                  // Candidate configurations evaluated alongside every request
+	shadowSlots       chan struct{}              // Bounds the shadow evaluations running at once, see WithShadow
+	tieShuffleEpoch   time.Duration              // If set, equally scored providers are shuffled per consumer and epoch
+	unknownWeights    UnknownWeightMode          // Handling of weights referencing unregistered scorers
+	invariants        InvariantMode              // Handling of scores violating their invariants
+	transforms        map[string]score.Transform // System-wide component transforms, by scorer name
+	pool              *workerpool.Pool           // Long-lived pool running filter and rank workers
+	workers           int                        // Workers per filter and rank phase, and the size of the system's own pool
+	filterTimeout     time.Duration              // Optional deadline of the filter stage
+	rankTimeout       time.Duration              // Optional deadline of the rank stage
+	fused             bool                       // Filter and rank in a single pass, see WithFusedPipeline
+	selection         SelectionStrategy          // Picks the returned providers among the ranked ones, see WithSelection
+	fairness          *fairness.Tracker          // Optional, caps each provider's share of the selections
+	comparator        *Comparator                // Optional, replaces the score-descending final sort
+	mode              PipelineMode               // Stages GetPairingList runs, see WithMode
+	strictConstraints bool                       // Whether filters apply to policies leaving them unconstrained, see WithStrictConstraints
+	layers            *Layers                    // Optional, global and per-chain settings below the policy's
+	aggregates        *aggregateCache            // Optional, pool-wide aggregates by pool version, see WithAggregateCache
+	rankings          *rankings                  // Optional, previous rankings by consumer, see WithIncrementalSort
+	columnar          bool                       // Lay the pool out in columns for batch scorers, see WithColumnarScoring
+	escalateLogs      bool                       // Buffer each call's logs, escalating anomalous calls, see WithLogEscalation
+	hints             *hintSource                // Optional, attaches connection hints to results, see WithConnectionHints
+	scoreHistory      *timeseries.Store          // Optional, records every ranked provider's final score, see WithScoreHistory
+	scoreSeries       *scoreSeries               // Policies whose scores are recorded, with scoreHistory
+	anomalies         *anomaly.Detector          // Optional, flags and quarantines suspicious provider entries
+	clusters          *clusterLimit              // Optional, caps the selected providers per operator, see WithOperatorClustering
+	networks          cluster.NetworkLookup      // Optional, resolves the ASN of providers without one, see WithNetworkLookup
+	commitments       *commitmentCache           // Optional, Merkle trees of recent provider pools, see WithSnapshotCommitment
+	recorder          *recording                 // Optional, records the inputs of sampled calls, see WithRecorder
+	ownsPool          bool                       // Whether pool was created by the system (vs. shared, see WithWorkerPool)
+	evaluated         atomic.Int64               // Providers that went through the filters
+	rejected          map[string]*atomic.Int64   // Filter name -> providers it rejected, keys fixed at construction
+	skippedNil        atomic.Int64               // Nil providers skipped by FilterProviders and RankProviders
+}
+
+// SelectionStrategy picks the providers GetPairingList returns among the ranked providers
+type SelectionStrategy interface {
+	// Select returns at most n of the scored providers, which are sorted best first, in the order they are
+	// returned
+	Select(scored []*pairing.PairingScore, n int, policy *pairing.ConsumerPolicy) []*pairing.PairingScore
+	// Name identifies the strategy and its parameters in the configuration fingerprint
+	Name() string
+}
+
+// RandomSelection is a SelectionStrategy whose picks are random; calls given a PairingOptions.Seed select
+// through SelectRand with a source seeded from it, so they can be reproduced
+type RandomSelection interface {
+	SelectionStrategy
+	// SelectRand is Select drawing from rng, or from the global source when rng is nil
+	SelectRand(scored []*pairing.PairingScore, n int, policy *pairing.ConsumerPolicy, rng *rand.Rand) []*pairing.PairingScore
+}
+
+// ConstrainedSelection is a SelectionStrategy with requirements it may be unable to meet, falling back to a
+// best-effort selection; such results are flagged with PairingResult.ConstraintsUnmet, and fail in strict mode
+type ConstrainedSelection interface {
+	SelectionStrategy
+	// SelectConstrained is Select also reporting whether the selection meets every requirement
+	SelectConstrained(scored []*pairing.PairingScore, n int, policy *pairing.ConsumerPolicy) ([]*pairing.PairingScore, bool)
+}
+
+// TopSelection selects the n best-scored providers, the default SelectionStrategy
+type TopSelection struct{}
+
+// EpsilonGreedySelection fills Exploration of the n slots with providers sampled uniformly among the eligible
+// providers ranked below the top ones, so new and low-history providers get traffic and fresh QoS data, and
+// the remaining slots with the top-scored providers
+type EpsilonGreedySelection struct {
+	Exploration int // Number of exploration slots, capped to the number of slots
+}
+
+// TopKSampleSelection samples the n providers uniformly among the K best-scored ones and returns them in random
+// order, so observers of publicly visible results can't infer the providers' scores from the selection and
+// its ordering
+type TopKSampleSelection struct {
+	K int // Size of the pool sampled from, at least n
+}
+
+// BudgetSelection selects the n cheapest providers among those whose final score reaches MinScore, minimizing
+// their summed Fee for cost-sensitive consumers, and returns them in rank order
+// Fewer than n providers are returned when fewer reach MinScore
+type BudgetSelection struct {
+	MinScore float64 // Quality floor every selected provider's final score must reach
+}
+
+// ConstraintSelection selects the best-ranked providers whose set satisfies group-level requirements: a budget
+// for their summed fee, features at least one of them must support and a number of regions they must span
+// together; zero fields don't constrain the selection
+// Among the selections satisfying every requirement it picks the largest, up to n, then the one with the
+// best-ranked providers; when none is found within MaxNodes it falls back to the best-ranked providers
+// fitting the fee budget, see ConstrainedSelection
+type ConstraintSelection struct {
+	MaxTotalFee      float64  // Budget for the summed Fee of the selected providers
+	RequiredFeatures []string // Features each supported by at least one selected provider, e.g. "archive"
+	MinRegions       int      // Minimum number of distinct regions served by the selected providers together
+	MaxNodes         int      // Bounds the search, 0 means DefaultConstraintSearchNodes
+}
+
+// DefaultConstraintSearchNodes is the number of partial selections ConstraintSelection explores by default
+const DefaultConstraintSearchNodes = 10000
+
+// Comparator orders scored providers for the final sort (see WithComparator), returning a negative number when
+// a goes first, a positive one when b does and 0 when they tie
+type Comparator struct {
+	Name    string // Identifies the ordering in the configuration fingerprint
+	Compare func(a, b *pairing.PairingScore) int
+	// configure, when set, returns the ordering for the system's delegation factor, see WithComparator
+	configure func(delegationFactor float64) Comparator
+}
+
+// Built-in comparators, combined with ComparatorChain, e.g. ComparatorChain(ByScore, ByFee, ByStake)
+var (
+	ByScore = Comparator{Name: "score", Compare: compareScore} // Higher final score first, the default ordering
+	ByFee   = Comparator{Name: "fee", Compare: compareFee}     // Lower fee first
+	ByStake = stakeComparator(0)                               // Higher effective stake first
+	ByID    = Comparator{Name: "id", Compare: compareID}       // Provider ID, ascending
+)
+
+// Configuration layers settings are resolved from, least specific first, see ResolvedSettings.Sources
+// Chain layers are named "chain:" followed by the chain ID
+const (
+	LayerSystem  = "system" // The system's own defaults: top 5, its strict mode and equal weights
+	LayerGlobal  = "global"
+	LayerPolicy  = "policy"
+	LayerRequest = "request" // PairingOptions given to GetPairingList
+)
+
+// PairingOptions override system-level settings for a single GetPairingList call, so one system can serve
+// consumers with different needs; zero fields keep the system's settings
+// When several are given they are merged in order, later set fields winning
+type PairingOptions struct {
+	TopN      int               // Number of providers to select, overriding the configuration layers and policy
+	Strict    *bool             // Strict mode, overriding the configuration layers and policy
+	Timeout   time.Duration     // Bounds the filter and rank stages together, on top of WithStageTimeouts
+	Selection SelectionStrategy // Replaces the system's selection strategy, see WithSelection
+	RequestID string            // Identifies the call in logs and the result, generated when empty
+	Backups   int               // Number of backup providers to return, overriding the policy's Backups
+	// PoolVersion identifies the providers passed, e.g. by a provider store's version, so their pool-wide
+	// aggregates can be reused by later calls passing the same version (see WithAggregateCache)
+	// Calls passing the same version must pass the same providers
+	PoolVersion string
+	// Seed seeds the call's random choices (see RandomSelection), 0 draws them from the global source
+	Seed uint64
+	// Now pins the call's clock (its timestamp, warm-up and tie shuffle epoch), zero uses the current time
+	// Replays pin it to the recorded call's, see Recording
+	Now time.Time
+	// ExternalPool marks providers supplied by the caller, e.g. in an API request, rather than taken from the
+	// service's own provider source: they are paired like any other, but don't feed what the system learns
+	// across calls (see WithScoreHistory), which they could otherwise poison
+	ExternalPool bool
+}
+
+// Settings are the pairing settings a configuration layer can set, unset (zero) fields inherit from the layer
+// below
+type Settings struct {
+	Weights map[string]float64 `json:"weights,omitempty"`
+	TopN    int                `json:"top_n,omitempty"`
+	Strict  *bool              `json:"strict,omitempty"`
+}
+
+// Layers are the configuration layers between the system's defaults and the policy: global settings, overridden
+// per chain, so multi-chain deployments don't repeat them in every policy (see WithLayers)
+type Layers struct {
+	Global Settings            `json:"global"`
+	Chains map[string]Settings `json:"chains,omitempty"` // By chain ID
+}
+
+// ResolvedSettings are the settings a request is paired with, merged from the system's defaults, the global
+// and chain layers and the policy, the most specific layer setting a field winning
+// Weights are merged as a whole, a layer's weights replace the ones below instead of mixing scorers
+type ResolvedSettings struct {
+	Weights   map[string]float64 `json:"weights,omitempty"` // Nil for equal weights
+	TopN      int                `json:"top_n"`
+	Strict    bool               `json:"strict"`
+	Sources   map[string]string  `json:"sources"` // Setting ("weights", "top_n", "strict") -> layer it came from
+	Conflicts []SettingConflict  `json:"conflicts,omitempty"`
+}
+
+// SettingConflict reports a layer overriding a setting that a less specific configured layer also set
+type SettingConflict struct {
+	Setting    string `json:"setting"`
+	Layer      string `json:"layer"`      // Layer whose value is used
+	Overridden string `json:"overridden"` // Layer whose value is discarded
+}
+
+// ErrPipeline wraps errors returned by fallible filters and scorers (see filter.FallibleFilter and
+// score.FallibleScorer) while pairing
+var ErrPipeline = errors.New("pairing pipeline failed")
+
+// ErrStageTimeout is returned by GetPairingList in strict mode when a stage exceeds its timeout
+// (see WithStageTimeouts), it matches pairingerrors.ErrTimeout
+var ErrStageTimeout = pairingerrors.New(pairingerrors.ErrTimeout, "pairing stage timed out")
+
+// ErrUnknownSystem is returned by PairingEngine when no system is registered under the requested name
+var ErrUnknownSystem = errors.New("unknown pairing system")
+
+// PairingEngine hosts multiple named PairingSystem configurations (e.g. per chain or per tenant) sharing
+// one bounded worker pool
+type PairingEngine struct {
+	pool    *workerpool.Pool
+	logger  *slog.Logger
+	mu      sync.RWMutex
+	systems map[string]PairingSystem
+}
+
+// PairingResult is the outcome of a GetPairingList call, along with its provenance so callers can embed it
+// in downstream logs and responses
+type PairingResult struct {
+	Providers []*pairing.Provider     // Selected providers, best first
+	Scores    []*pairing.PairingScore // Scores of the selected providers, in the same order
+	// Backups are the next eligible providers in rank order after the selected ones, up to the policy's Backups,
+	// for consumers to fail over to when a selected provider goes down
+	Backups      []*pairing.Provider
+	BackupScores []*pairing.PairingScore // Scores of the backup providers, in the same order
+	// Hints tell how to dial the selected and backup providers that have endpoints, by provider ID, see
+	// WithConnectionHints
+	Hints map[string]ConnectionHints
+	// Partial is set when a stage ran out of time (see WithStageTimeouts): Providers is then the best selection
+	// among the providers processed in time
+	Partial bool
+	// ConstraintsUnmet is set when the selection strategy couldn't meet its requirements and fell back to a
+	// best-effort selection, see ConstrainedSelection
+	ConstraintsUnmet bool
+	RequestID        string // Identifies the call, every log line of the call carries it as request_id
+	ConfigHash       string // Hash of the configuration and effective weights that produced the result
+	// SnapshotRoot is the hex Merkle root of the providers the request considered, once invalid and quarantined
+	// providers are excluded, see WithSnapshotCommitment
+	SnapshotRoot string
+	Mode         PipelineMode // Stages that ran, ModeFull unless the system or call selected fewer
+	Timestamp    time.Time    // When the request started
+	Counts       StageCounts
+	Durations    StageDurations
+	// Diagnostics report the providers excluded before filtering because their data is invalid or they are
+	// quarantined, and those skipped because evaluating them panicked
+	Diagnostics []Diagnostic
+	// Settings are the weights, top-N and strict mode the request was paired with, and where they came from
+	Settings ResolvedSettings
+	// ScoreSeries identifies the policy the scores were recorded under with WithScoreHistory (see
+	// timeseries.ScoreMetric), empty when they weren't recorded
+	ScoreSeries string
+}
+
+// ConnectionHints tell a client how to dial a provider right away, without looking its endpoints up
+type ConnectionHints struct {
+	Endpoints       map[string]EndpointHint `json:"endpoints"`   // Preferred endpoint by API interface
+	RequireTLS      bool                    `json:"require_tls"` // Whether the provider must be dialed over TLS
+	CertFingerprint string                  `json:"cert_fingerprint,omitempty"`
+}
+
+// EndpointHint is the endpoint a client should dial for an API interface
+type EndpointHint struct {
+	URL         string `json:"url"`
+	Geolocation string `json:"geolocation"`
+	// ExpectedLatencyMS is the latency between the consumer's and the endpoint's regions, 0 when unknown
+	ExpectedLatencyMS float64 `json:"expected_latency_ms,omitempty"`
+}
+
+// hintSource builds the connection hints of results, see WithConnectionHints
+type hintSource struct {
+	latencies *latency.Matrix // Optional, source of expected latencies
+}
+
+// clusterLimit caps the selected providers believed to be run by a single operator, see WithOperatorClustering
+type clusterLimit struct {
+	clusterer *cluster.Clusterer
+	max       int // Selected providers allowed per cluster
+}
+
+// clusterSlots tracks the selected providers of each operator cluster once the cluster limit applied, so the
+// swaps made after it keep the selection within the limit; nil allows every swap
+type clusterSlots struct {
+	clusters map[string]string // Provider ID -> cluster
+	counts   map[string]int    // Cluster -> selected providers
+	max      int
+}
+
+// Diagnostic reports a provider excluded from pairing because its data is invalid (see pairing.Provider.Validate)
+// or because a filter or scorer panicked on it
+type Diagnostic struct {
+	ProviderID string          `json:"provider_id"`
+	Address    address.Address `json:"address,omitempty"`
+	Reason     string          `json:"reason"`
+}
+
+// scoringPlan is what scoring the providers of a ranking takes besides the PreScoreContext, laid out by scorer
+// position so scoring a provider doesn't go through maps
+type scoringPlan struct {
+	names            []string          // Scorer names, shared by the component vectors
+	transforms       []score.Transform // Nil where a scorer has no transform
+	weights          []float64         // NaN where a scorer has no weight, nil to average the components
+	configuredWeight float64
+	arena            *componentArena
+}
+
+// componentArena allocates the scores of a ranking and their component vectors in a few slabs instead of
+// per provider
+type componentArena struct {
+	scores  []pairing.PairingScore
+	values  []float64
+	applied []bool
+	size    int          // Components per vector
+	next    atomic.Int64 // Scores handed out
+}
+
+// Bounds on the policies whose scores WithScoreHistory records: at most maxScoreSeries at a time, a policy
+// making room for a new one once it wasn't paired with for scoreSeriesIdle
+const (
+	maxScoreSeries  = 64
+	scoreSeriesIdle = 24 * time.Hour
+)
+
+// scoreSeries tracks the policies whose scores are recorded, see WithScoreHistory
+type scoreSeries struct {
+	mu   sync.Mutex
+	used map[string]time.Time // Series key -> last recorded
+}
+
+// rankings keeps the latest ranking of the most recently paired consumers, see WithIncrementalSort
+type rankings struct {
+	mu       sync.Mutex
+	maxShift int                      // Ranks a provider may move before falling back to a full sort
+	ranks    map[string]*list.Element // Chain and consumer ID -> element of recent holding its ranking
+	recent   *list.List               // Of *consumerRanking, most recently used first
+}
+
+// consumerRanking is the latest ranking of a consumer's providers, provider ID -> rank
+type consumerRanking struct {
+	key   string
+	ranks map[string]int
+}
+
+// maxRankings is the number of consumers whose latest ranking is kept, the least recently paired being
+// forgotten first, see WithIncrementalSort
+const maxRankings = 10_000
+
+// aggregateCache keeps the pool-wide scoring aggregates of recent provider pool versions
+type aggregateCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cachedAggregates // By pool version
+}
+
+// commitmentCache keeps the Merkle trees of the latest provider pool versions, see WithSnapshotCommitment
+type commitmentCache struct {
+	mu        sync.Mutex
+	byVersion map[string]*commitment.Tree
+	byRoot    map[string]*commitment.Tree
+	order     []string // Pool versions, oldest first
+}
+
+// maxCommitments is the number of pool versions whose trees are kept to serve proofs
+const maxCommitments = 16
+
+// cachedAggregates are the pool-wide aggregates of a provider pool version
+type cachedAggregates struct {
+	maxStake int64
+	fees     map[string]float64 // Shared by every call reusing them, read-only
+	columns  *score.Columns     // Only with WithColumnarScoring, read-only
+	expires  time.Time
+}
+
+// recoveries collects the providers skipped during a GetPairingList call because evaluating them panicked
+type recoveries struct {
+	mu          sync.Mutex
+	diagnostics []Diagnostic
+}
+
+// StageCounts are the number of providers going into and out of each pipeline stage
+type StageCounts struct {
+	Input       int `json:"input"`                 // Providers given to GetPairingList
+	Invalid     int `json:"invalid,omitempty"`     // Providers excluded for invalid data, see PairingResult.Diagnostics
+	Quarantined int `json:"quarantined,omitempty"` // Providers excluded as quarantined, see WithAnomalyDetection
+	Panicked    int `json:"panicked,omitempty"`    // Providers skipped because a filter or scorer panicked on them
+	Filtered    int `json:"filtered"`              // Providers passing the filters
+	Ranked      int `json:"ranked"`                // Providers scored
+	Selected    int `json:"selected"`              // Providers returned
+}
+
+// StageDurations are the time spent in each pipeline stage
+// In fused mode (see WithFusedPipeline) the single filter and rank pass is accounted as Rank
+type StageDurations struct {
+	Filter time.Duration
+	Rank   time.Duration
+	Sort   time.Duration // Ordering and top-N selection
+	Total  time.Duration
+}
+
+// PipelineMode selects the stages GetPairingList runs, see WithMode
+type PipelineMode string
+
+const (
+	// ModeFull filters, ranks and selects the top N providers
+	ModeFull PipelineMode = ""
+	// ModeScoreOnly ranks and selects among providers the caller already filtered, skipping the filters
+	ModeScoreOnly PipelineMode = "score-only"
+	// ModeFilterOnly returns every provider passing the filters, in input order, without scoring them
+	ModeFilterOnly PipelineMode = "filter-only"
+)
+
+// StageRunner is implemented by systems that can run part of the pipeline per call, such as those created by
+// NewPairingSystem, for integrators who already have the other part
+type StageRunner interface {
+	// ScoreOnly pairs among providers the caller already filtered
+	ScoreOnly(providers []*pairing.Provider, policy *pairing.ConsumerPolicy, opts ...PairingOptions) (*PairingResult, error)
+	// FilterOnly returns every provider passing the filters, without scores
+	FilterOnly(providers []*pairing.Provider, policy *pairing.ConsumerPolicy, opts ...PairingOptions) (*PairingResult, error)
+}
+
+// FilterStatsReporter is implemented by systems counting filter rejections, such as those created by
+// NewPairingSystem
+type FilterStatsReporter interface {
+	FilterStats() FilterStats
+}
+
+// RuntimeStatsReporter is implemented by systems reporting their worker pool and cache usage, such as those
+// created by NewPairingSystem
+type RuntimeStatsReporter interface {
+	RuntimeStats() RuntimeStats
+}
+
+// RuntimeStats are a snapshot of a system's worker pool and cache usage, for live diagnostics
+type RuntimeStats struct {
+	Workers        int            `json:"workers"`
+	BusyWorkers    int            `json:"busy_workers"`
+	QueuedTasks    int            `json:"queued_tasks"`            // Filter and rank tasks waiting for a worker
+	AggregateCache int            `json:"aggregate_cache"`         // Pool versions with cached aggregates, see WithAggregateCache
+	RankingCache   int            `json:"ranking_cache"`           // Consumers with a previous ranking, see WithIncrementalSort
+	ScorerCaches   map[string]int `json:"scorer_caches,omitempty"` // Memoized scorer name -> cached scores, see score.Memoize
+}
+
+// FilterStats are the filter rejection counters accumulated by a system since it was created
+// A provider is rejected by the first filter it fails, so each rejection is counted once
+type FilterStats struct {
+	Evaluated  int64            `json:"evaluated"`             // Providers that went through the filters
+	Rejected   map[string]int64 `json:"rejected"`              // Filter name -> providers it rejected
+	SkippedNil int64            `json:"skipped_nil,omitempty"` // Nil providers skipped by FilterProviders and RankProviders
+}
+
+// Option configures optional PairingSystem behaviour
+type Option func(*pairingSystem)
+
+// PairingRun is one side of a Compare: a system configuration evaluated over a provider snapshot
+type PairingRun struct {
+	System    PairingSystem
+	Providers []*pairing.Provider
+	Policy    *pairing.ConsumerPolicy
+}
+
+// PairingDiff is the structured difference between the selections of two pairing runs
+type PairingDiff struct {
+	Added       []ProviderChange   // Selected by the candidate run only
+	Removed     []ProviderChange   // Selected by the baseline run only
+	Reordered   []ProviderChange   // Selected by both runs, at different ranks
+	Unchanged   []ProviderChange   // Selected by both runs, at the same rank
+	ScoreDeltas map[string]float64 // Provider ID -> candidate score minus baseline score, for providers selected by both
+}
+
+// ProviderChange describes how a single provider's selection differs between two runs
+// Ranks are 1-based; a rank of 0 means the provider wasn't selected by that run
+type ProviderChange struct {
+	ProviderID     string
+	BaselineRank   int
+	CandidateRank  int
+	BaselineScore  float64
+	CandidateScore float64
+}
+
+// Shadow is a candidate configuration evaluated alongside the live one on every GetPairingList call
+// Its selections are only logged and counted, never returned, which makes it safe for experimentation
+type Shadow struct {
+	Name   string
+	System PairingSystem
+
+	runs      atomic.Int64
+	dropped   atomic.Int64
+	diverged  atomic.Int64
+	added     atomic.Int64
+	removed   atomic.Int64
+	reordered atomic.Int64
+}
+
+// maxShadowRuns is the number of shadow evaluations a system runs at once, see WithShadow
+const maxShadowRuns = 16
+
+// ShadowStats are the counters accumulated by a Shadow since it was created
+type ShadowStats struct {
+	Runs      int64 // Requests the shadow was evaluated on
+	Dropped   int64 // Requests the shadow was skipped on, all shadow evaluation slots being taken
+	Diverged  int64 // Requests where the shadow's selection differed from the live one
+	Added     int64 // Providers selected by the shadow but not the live system, summed over all requests
+	Removed   int64 // Providers selected by the live system but not the shadow, summed over all requests
+	Reordered int64 // Providers selected by both at different ranks, summed over all requests
+}
+
+// Recorder persists the recordings of the calls sampled by WithRecorder
+type Recorder interface {
+	// Capture returns what the recorder keeps of the service state a sampled call is paired against, it is
+	// called right before the call runs
+	Capture() any
+	// Record persists the recording of a sampled call along with what Capture returned for it
+	// It runs on the request path, recorders should hand the write off instead of blocking
+	Record(rec *Recording, state any) error
+}
+
+// SnapshotProver is implemented by systems keeping the Merkle trees of the provider pools they commit to, such
+// as those created by NewPairingSystem with WithSnapshotCommitment
+type SnapshotProver interface {
+	// SnapshotTree returns the tree of the snapshot committed to by the hex root, false if it isn't kept
+	SnapshotTree(root string) (*commitment.Tree, bool)
+}
+
+// ReplayPreparer is implemented by systems keeping state of their own across calls, such as those created by
+// NewPairingSystem: PrepareReplay puts it back to what a recorded call ran against, see Recording.PreviousRanking
+type ReplayPreparer interface {
+	PrepareReplay(rec *Recording)
+}
+
+// recording is the recorder of a system and the calls it records, see WithRecorder
+type recording struct {
+	recorder Recorder
+	sample   func(policy *pairing.ConsumerPolicy) bool // Nil records every call
+}
+
+// Recording holds the exact inputs of a GetPairingList call and its outcome, so the call can be replayed later
+// against the same configuration to reproduce it (see WithRecorder)
+type Recording struct {
+	RequestID         string                  `json:"request_id"`
+	Mode              PipelineMode            `json:"mode,omitempty"`
+	ConfigFingerprint string                  `json:"config_fingerprint"` // See PairingSystem.ConfigFingerprint
+	Providers         []*pairing.Provider     `json:"providers"`          // As passed, before validation
+	Policy            *pairing.ConsumerPolicy `json:"policy"`             // As passed, before the configuration layers
+	Options           RecordedOptions         `json:"options"`
+	// PreviousRanking is the consumer's ranking the call started from with WithIncrementalSort, by provider ID
+	PreviousRanking map[string]int `json:"previous_ranking,omitempty"`
+	Outcome         Outcome        `json:"outcome"`
+}
+
+// RecordedOptions are the PairingOptions of a recorded call, with the seed and clock it ran with
+// A call-level Selection strategy can't be recorded, only its name is, replays must pass it again
+type RecordedOptions struct {
+	TopN        int           `json:"top_n,omitempty"`
+	Strict      *bool         `json:"strict,omitempty"`
+	Timeout     time.Duration `json:"timeout,omitempty"`
+	Selection   string        `json:"selection,omitempty"` // Name of the call-level strategy, see SelectionStrategy.Name
+	Backups     int           `json:"backups,omitempty"`
+	PoolVersion string        `json:"pool_version,omitempty"`
+	// ExternalPool is set for calls over caller-supplied providers, whose replays mustn't feed the system's histories
+	ExternalPool bool      `json:"external_pool,omitempty"`
+	Seed         uint64    `json:"seed"`
+	Now          time.Time `json:"now"`
+}
+
+// Outcome is what a call returned, comparable across runs: two runs reproduce each other when their outcomes
+// encode to the same JSON, see NewOutcome
+type Outcome struct {
+	Selected []RecordedScore `json:"selected"`
+	Backups  []RecordedScore `json:"backups,omitempty"`
+	Partial  bool            `json:"partial,omitempty"`
+	// ConstraintsUnmet is set when the selection fell back to a best-effort one, see ConstrainedSelection
+	ConstraintsUnmet bool   `json:"constraints_unmet,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
+
+// RecordedScore is a returned provider, with its score and score components when the call ranked it
+type RecordedScore struct {
+	ProviderID string             `json:"provider_id"`
+	Score      float64            `json:"score,omitempty"`
+	Components map[string]float64 `json:"components,omitempty"`
+}
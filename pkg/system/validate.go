@@ -0,0 +1,63 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/address"
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// validateProviders returns the providers whose data is valid (see pairing.Provider.Validate), along with a
+// diagnostic for each excluded one
+// Providers sharing an address with an earlier provider are excluded too, as the address identifies the
+// provider on chain
+func (ps *pairingSystem) validateProviders(ctx context.Context, providers []*pairing.Provider) ([]*pairing.Provider, []Diagnostic) {
+	var diagnostics []Diagnostic
+	var valid []*pairing.Provider
+	addresses := make(map[address.Address]string, len(providers)) // Address -> ID of the first provider using it
+	for _, p := range providers {
+		if p == nil {
+			diagnostics = append(diagnostics, Diagnostic{Reason: "nil provider"})
+			continue
+		}
+		err := p.Validate()
+		if err == nil && p.Address != "" {
+			if first, dup := addresses[p.Address]; dup {
+				err = fmt.Errorf("duplicate address, already used by provider %s", first)
+			} else {
+				addresses[p.Address] = p.ID
+			}
+		}
+		if err != nil {
+			diagnostics = append(diagnostics, Diagnostic{ProviderID: p.ID, Address: p.Address, Reason: err.Error()})
+			continue
+		}
+		valid = append(valid, p)
+	}
+	if len(diagnostics) > 0 {
+		ps.log(ctx).Warn("Excluded providers with invalid data", "count", len(diagnostics), "diagnostics", diagnostics)
+		return valid, diagnostics
+	}
+	return providers, nil
+}
+
+// skipNil returns the providers without nil entries, counting the skipped ones (see FilterStats)
+// GetPairingList already reports nil providers in its diagnostics, so only direct FilterProviders and
+// RankProviders calls skip any
+func (ps *pairingSystem) skipNil(ctx context.Context, providers []*pairing.Provider) []*pairing.Provider {
+	if !slices.Contains(providers, nil) {
+		return providers
+	}
+	kept := make([]*pairing.Provider, 0, len(providers))
+	for _, p := range providers {
+		if p != nil {
+			kept = append(kept, p)
+		}
+	}
+	skipped := len(providers) - len(kept)
+	ps.skippedNil.Add(int64(skipped))
+	ps.log(ctx).Warn("Skipped nil providers", "count", skipped)
+	return kept
+}
@@ -0,0 +1,30 @@
+package system
+
+import (
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// PairViews runs GetPairingList over caller-defined provider types and returns the selected items, best first,
+// along with the result
+// Items are converted with pairing.FromView and matched back by ID, which works with any PairingSystem
+// including remote ones; IDs must therefore be unique
+func PairViews[T pairing.ProviderView](ps PairingSystem, items []T, policy *pairing.ConsumerPolicy) ([]T, *PairingResult, error) {
+	providers := make([]*pairing.Provider, 0, len(items))
+	byID := make(map[string]T, len(items))
+	for _, item := range items {
+		providers = append(providers, pairing.FromView(item))
+		byID[item.GetID()] = item
+	}
+
+	result, err := ps.GetPairingList(providers, policy)
+	if err != nil {
+		return nil, nil, err
+	}
+	selected := make([]T, 0, len(result.Providers))
+	for _, p := range result.Providers {
+		if item, ok := byID[p.ID]; ok {
+			selected = append(selected, item)
+		}
+	}
+	return selected, result, nil
+}
@@ -0,0 +1,95 @@
+package system
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+	"github.com/Yoaz/LavaPairingSystem/pkg/timeseries"
+)
+
+// observe records the providers of a call over the system's own pool in the time series store, which tracks
+// when each was first seen, along with their fees
+func (ps *pairingSystem) observe(providers []*pairing.Provider, at time.Time) {
+	ids := make([]string, len(providers))
+	for i, p := range providers {
+		ids[i] = p.ID
+		ps.timeSeries.Record(p.ID, timeseries.MetricFee, p.Fee, at)
+	}
+	ps.timeSeries.Observe(ids, at)
+}
+
+// warmUp applies the policy's warm-up to the scored providers: unproven providers, first seen less than
+// WarmUp.Hours ago, get their score capped to WarmUp.ScoreCap when it is set and are left out otherwise
+// It returns the providers left to rank and the unproven ones, left out or not; without a time series store
+// every provider is taken as proven, and so are those of an ExternalPool the store never observed
+func (ps *pairingSystem) warmUp(ctx context.Context, scored []*pairing.PairingScore, warmUp *pairing.WarmUp, at time.Time) (kept, unproven []*pairing.PairingScore) {
+	if ps.timeSeries == nil {
+		ps.log(ctx).Debug("Policy sets a warm-up but providers aren't tracked, see WithTimeSeries")
+		return scored, nil
+	}
+	cutoff := at.Add(-time.Duration(warmUp.Hours * float64(time.Hour)))
+	kept = scored[:0:0]
+	for _, s := range scored {
+		firstSeen, ok := ps.timeSeries.FirstSeen(s.Provider.ID)
+		if !ok || !firstSeen.After(cutoff) {
+			kept = append(kept, s)
+			continue
+		}
+		if warmUp.ScoreCap > 0 {
+			s.Score = min(s.Score, warmUp.ScoreCap)
+			kept = append(kept, s)
+		}
+		unproven = append(unproven, s)
+	}
+	if len(unproven) > 0 {
+		ps.log(ctx).Debug("Found unproven providers", "unproven_count", len(unproven), "warm_up_hours", warmUp.Hours, "score_cap", warmUp.ScoreCap)
+	}
+	return kept, unproven
+}
+
+// explore gives up to slots of a selection of n providers to the unproven providers (see warmUp), which are
+// ranked, counting those already selected, filling free slots first and then replacing the lowest ranked proven
+// providers; replacements go last
+func (ps *pairingSystem) explore(ctx context.Context, selected, unproven []*pairing.PairingScore, slots, n int) []*pairing.PairingScore {
+	isUnproven := make(map[string]bool, len(unproven))
+	for _, s := range unproven {
+		isUnproven[s.Provider.ID] = true
+	}
+	explored := slices.Clone(selected)
+	chosen := make(map[string]bool, len(selected))
+	for _, s := range explored {
+		chosen[s.Provider.ID] = true
+		if isUnproven[s.Provider.ID] {
+			slots--
+		}
+	}
+	for _, s := range unproven {
+		if slots <= 0 {
+			break
+		}
+		if chosen[s.Provider.ID] {
+			continue
+		}
+		if len(explored) >= n {
+			replaced := -1
+			for i, r := range slices.Backward(explored) {
+				if !isUnproven[r.Provider.ID] {
+					replaced = i
+					break
+				}
+			}
+			if replaced < 0 {
+				break
+			}
+			ps.log(ctx).Debug("Provider swapped for an exploration slot",
+				"provider_id", explored[replaced].Provider.ID, "replacement_id", s.Provider.ID)
+			explored = slices.Delete(explored, replaced, replaced+1)
+		}
+		explored = append(explored, s)
+		chosen[s.Provider.ID] = true
+		slots--
+	}
+	return explored
+}
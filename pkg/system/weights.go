@@ -0,0 +1,93 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Yoaz/LavaPairingSystem/pkg/pairing"
+)
+
+// resolveWeights canonicalizes the policy's weight keys to registered scorer names (see canonicalWeightKey)
+// and applies the configured UnknownWeightMode to weights referencing unknown scorers
+// The caller's policy is never modified, a copy is returned when its weights have to change
+func (ps *pairingSystem) resolveWeights(ctx context.Context, policy *pairing.ConsumerPolicy) (*pairing.ConsumerPolicy, error) {
+	if len(policy.Weights) == 0 {
+		return policy, nil
+	}
+	registered := make(map[string]string, len(ps.scorers)) // Canonical key -> scorer name
+	for _, scorer := range ps.scorers {
+		registered[canonicalWeightKey(scorer.Name())] = scorer.Name()
+	}
+
+	weights := make(map[string]float64, len(policy.Weights))
+	aliases := make(map[string]string, len(policy.Weights)) // Scorer name -> the key that set it
+	var unknown []string
+	var total, known float64
+	rewritten := false
+	for _, key := range sortedKeys(policy.Weights) { // Fixed order, so the sums are bit-identical across calls
+		weight := policy.Weights[key]
+		total += weight
+		name, ok := registered[canonicalWeightKey(key)]
+		if !ok {
+			unknown = append(unknown, key)
+			continue
+		}
+		if previous, dup := aliases[name]; dup {
+			return nil, fmt.Errorf("%w: %q and %q both refer to %s", ErrInvalidWeights, previous, key, name)
+		}
+		aliases[name] = key
+		weights[name] = weight
+		known += weight
+		rewritten = rewritten || key != name
+	}
+
+	if len(unknown) > 0 {
+		switch ps.unknownWeights {
+		case UnknownWeightsError:
+			return nil, fmt.Errorf("%w: unknown scorers %s (valid names: %s)", ErrInvalidWeights, strings.Join(unknown, ", "), strings.Join(ps.scorerNames(), ", "))
+		case UnknownWeightsRenormalize:
+			if known == 0 {
+				return nil, fmt.Errorf("%w: no weight left after dropping unknown scorers %s", ErrInvalidWeights, strings.Join(unknown, ", "))
+			}
+			// Spread the lost weight over the known scorers, keeping their relative importance
+			for name, weight := range weights {
+				weights[name] = weight * total / known
+			}
+			ps.log(ctx).Debug("Renormalized weights after dropping unknown scorers", "consumer_id", policy.ConsumerID, "unknown", unknown, "weights", weights)
+		default:
+			ps.log(ctx).Warn("Policy weights reference unknown scorers, their weight is lost", "consumer_id", policy.ConsumerID, "unknown", unknown, "valid_names", ps.scorerNames())
+			for _, key := range unknown {
+				weights[key] = policy.Weights[key] // Keep them, so the weights still sum to what the consumer sent
+			}
+		}
+	} else if !rewritten {
+		return policy, nil
+	}
+
+	resolved := *policy
+	resolved.Weights = weights
+	return &resolved, nil
+}
+
+// canonicalWeightKey reduces a weight key or scorer name to the form used for matching them up, so that
+// "stake", "Stake", "stake_score" and "StakeScore" all refer to StakeScore
+func canonicalWeightKey(key string) string {
+	key = strings.ToLower(strings.TrimSpace(key))
+	key = strings.NewReplacer("_", "", "-", "", " ", "").Replace(key)
+	if trimmed := strings.TrimSuffix(key, "score"); trimmed != "" {
+		key = trimmed
+	}
+	return key
+}
+
+// scorerNames returns the names of the registered scorers, sorted
+func (ps *pairingSystem) scorerNames() []string {
+	names := make([]string, 0, len(ps.scorers))
+	for _, scorer := range ps.scorers {
+		names = append(names, scorer.Name())
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,277 @@
+package timeseries
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// NewStore creates a new Store keeping samples for the given retention at the given resolution
+func NewStore(retention, resolution time.Duration) *Store {
+	return &Store{
+		retention:  retention,
+		resolution: resolution,
+		series:     make(map[seriesKey][]Sample),
+		maxSeries:  DefaultMaxSeries,
+		sightings:  make(map[string]sighting),
+		now:        time.Now,
+	}
+}
+
+// ScoreMetric returns the metric name of the final scores under the policy identified by key, scores under
+// different policies not being comparable
+func ScoreMetric(key string) string {
+	return MetricScore + ":" + key
+}
+
+// Record adds a sample of a provider metric
+// Samples must be recorded in time order per series; a sample within the resolution of the previous one
+// replaces it. A sample starting a new series is dropped when the store already keeps its maximum number of
+// series, once the series whose samples all fell out of the retention period are dropped
+func (s *Store) Record(providerID, metric string, value float64, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := seriesKey{providerID: providerID, metric: metric}
+	cutoff := s.now().Add(-s.retention)
+	samples, ok := s.series[key]
+	if !ok && len(s.series) >= s.maxSeries {
+		s.sweep(cutoff)
+		if len(s.series) >= s.maxSeries {
+			return
+		}
+	}
+	if n := len(samples); n > 0 && at.Sub(samples[n-1].At) < s.resolution {
+		samples[n-1] = Sample{At: at, Value: value}
+		return
+	}
+	samples = append(samples, Sample{At: at, Value: value})
+
+	// Drop samples that fell out of the retention period
+	if cut := sort.Search(len(samples), func(i int) bool { return !samples[i].At.Before(cutoff) }); cut > 0 {
+		samples = append(samples[:0], samples[cut:]...)
+	}
+	s.series[key] = samples
+}
+
+// SetMaxSeries bounds the number of series the store keeps (DefaultMaxSeries by default), so metrics recorded
+// for ever new providers or policies can't grow it without bound
+func (s *Store) SetMaxSeries(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxSeries = n
+}
+
+// sweep drops the series whose samples are all older than cutoff
+// NOTE: Must be called with s.mu held
+func (s *Store) sweep(cutoff time.Time) {
+	for key, samples := range s.series {
+		if len(samples) == 0 || samples[len(samples)-1].At.Before(cutoff) {
+			delete(s.series, key)
+		}
+	}
+}
+
+// History returns a copy of a provider metric's samples recorded at or after since
+func (s *Store) History(providerID, metric string, since time.Time) []Sample {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	samples := s.series[seriesKey{providerID: providerID, metric: metric}]
+	start := sort.Search(len(samples), func(i int) bool { return !samples[i].At.Before(since) })
+	return append([]Sample(nil), samples[start:]...)
+}
+
+// Snapshot returns a copy of every series, ordered by provider and metric
+func (s *Store) Snapshot() Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snapshot := Snapshot{Series: make([]Series, 0, len(s.series))}
+	for key, samples := range s.series {
+		snapshot.Series = append(snapshot.Series, Series{
+			ProviderID: key.providerID,
+			Metric:     key.metric,
+			Samples:    append([]Sample(nil), samples...),
+		})
+	}
+	if len(s.sightings) > 0 {
+		snapshot.FirstSeen = make(map[string]time.Time, len(s.sightings))
+		snapshot.LastSeen = make(map[string]time.Time, len(s.sightings))
+		for id, seen := range s.sightings {
+			snapshot.FirstSeen[id] = seen.first
+			snapshot.LastSeen[id] = seen.last
+		}
+	}
+	sort.Slice(snapshot.Series, func(i, j int) bool {
+		a, b := snapshot.Series[i], snapshot.Series[j]
+		if a.ProviderID != b.ProviderID {
+			return a.ProviderID < b.ProviderID
+		}
+		return a.Metric < b.Metric
+	})
+	return snapshot
+}
+
+// Restore replaces the store's series with a snapshot, so rolling aggregates survive a restart
+// Samples that fell out of the retention period in the meantime are dropped
+func (s *Store) Restore(snapshot Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cutoff := s.now().Add(-s.retention)
+	s.series = make(map[seriesKey][]Sample, len(snapshot.Series))
+	for _, series := range snapshot.Series {
+		samples := append([]Sample(nil), series.Samples...)
+		sort.SliceStable(samples, func(i, j int) bool { return samples[i].At.Before(samples[j].At) })
+		cut := sort.Search(len(samples), func(i int) bool { return !samples[i].At.Before(cutoff) })
+		if cut < len(samples) {
+			s.series[seriesKey{providerID: series.ProviderID, metric: series.Metric}] = samples[cut:]
+		}
+	}
+	s.sightings = make(map[string]sighting, len(snapshot.FirstSeen))
+	for id, first := range snapshot.FirstSeen {
+		last, ok := snapshot.LastSeen[id]
+		if !ok { // Taken before observations were timed, retain it from now on
+			last = s.now()
+		}
+		if last.Before(cutoff) {
+			continue
+		}
+		s.sightings[id] = sighting{first: first, last: last}
+	}
+}
+
+// Observe records the given providers as seen at the given time, keeping the time each was first seen
+// Every provider is new to a store that wasn't restored, including those of its first observation, so a restart
+// without a snapshot can't pass providers registered just before it for established ones. Providers not
+// observed for longer than the retention period are forgotten, and new again once they return
+// NOTE: Only the system's own pool should be observed, providers supplied by callers would fake their history
+func (s *Store) Observe(providerIDs []string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range providerIDs {
+		seen, ok := s.sightings[id]
+		if !ok {
+			seen.first = at
+		}
+		if at.After(seen.last) {
+			seen.last = at
+		}
+		s.sightings[id] = seen
+	}
+	// Forget the providers no longer observed, at most once per resolution
+	if now := s.now(); now.Sub(s.swept) >= s.resolution {
+		s.swept = now
+		cutoff := now.Add(-s.retention)
+		for id, seen := range s.sightings {
+			if seen.last.Before(cutoff) {
+				delete(s.sightings, id)
+			}
+		}
+	}
+}
+
+// FirstSeen returns when the provider was first observed
+// It returns false if the provider was never observed, or not within the retention period
+func (s *Store) FirstSeen(providerID string) (time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	seen, ok := s.sightings[providerID]
+	return seen.first, ok
+}
+
+// SetClock replaces the clock retention and rolling aggregates are measured against, nil restores time.Now
+// Replays pin it to the time of the recorded call
+func (s *Store) SetClock(now func() time.Time) {
+	if now == nil {
+		now = time.Now
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = now
+}
+
+// Aggregate computes a rolling aggregate of a provider metric as of now
+// It returns false if there are no samples to aggregate
+func (s *Store) Aggregate(providerID string, spec AggregateSpec) (float64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	samples := s.series[seriesKey{providerID: providerID, metric: spec.Metric}]
+	now := s.now()
+	switch spec.Kind {
+	case Mean:
+		return mean(samples, now.Add(-spec.Window))
+	case EWMA:
+		return ewma(samples, now, spec.Window)
+	default:
+		return 0, false
+	}
+}
+
+// Trend returns the least-squares slope of a provider metric's samples recorded at or after since, in value
+// per hour, e.g. a negative score trend flags a provider whose quality is degrading
+// It returns false without at least two samples recorded at different times
+func (s *Store) Trend(providerID, metric string, since time.Time) (float64, bool) {
+	samples := s.History(providerID, metric, since)
+	if len(samples) < 2 {
+		return 0, false
+	}
+	// Hours are counted from the first sample, keeping the sums small
+	origin := samples[0].At
+	var sumX, sumY float64
+	for _, sample := range samples {
+		sumX += sample.At.Sub(origin).Hours()
+		sumY += sample.Value
+	}
+	n := float64(len(samples))
+	meanX, meanY := sumX/n, sumY/n
+	var covariance, variance float64
+	for _, sample := range samples {
+		dx := sample.At.Sub(origin).Hours() - meanX
+		covariance += dx * (sample.Value - meanY)
+		variance += dx * dx
+	}
+	if variance == 0 {
+		return 0, false
+	}
+	return covariance / variance, true
+}
+
+// Key returns a stable string identifying the aggregate, e.g. "ewma(latency,5m0s)"
+func (a AggregateSpec) Key() string {
+	return fmt.Sprintf("%s(%s,%s)", a.Kind, a.Metric, a.Window)
+}
+
+// mean averages the samples recorded at or after since
+func mean(samples []Sample, since time.Time) (float64, bool) {
+	start := sort.Search(len(samples), func(i int) bool { return !samples[i].At.Before(since) })
+	if start == len(samples) {
+		return 0, false
+	}
+	var sum float64
+	for _, sample := range samples[start:] {
+		sum += sample.Value
+	}
+	return sum / float64(len(samples)-start), true
+}
+
+// ewma averages all samples, weighting each by 0.5^(age/halfLife)
+// Weighting by age rather than by sample index keeps irregularly spaced samples from skewing the average
+func ewma(samples []Sample, now time.Time, halfLife time.Duration) (float64, bool) {
+	if len(samples) == 0 || halfLife <= 0 {
+		return 0, false
+	}
+	var sum, weights float64
+	for _, sample := range samples {
+		age := now.Sub(sample.At)
+		weight := math.Exp2(-float64(age) / float64(halfLife))
+		sum += weight * sample.Value
+		weights += weight
+	}
+	if weights == 0 {
+		return 0, false
+	}
+	return sum / weights, true
+}
@@ -0,0 +1,79 @@
+package timeseries
+
+import (
+	"sync"
+	"time"
+)
+
+// Well-known metric names recorded per provider
+const (
+	MetricFee     = "fee"
+	MetricLatency = "latency" // Milliseconds
+	MetricScore   = "score"   // Final pairing score, within [0, 1]
+	MetricLoad    = "load"    // Utilization, 0 idle and 1 at capacity (may exceed 1 when overloaded)
+)
+
+// DefaultMaxSeries is the number of series a Store keeps unless configured otherwise, see Store.SetMaxSeries
+const DefaultMaxSeries = 100_000
+
+// AggregateKind is the kind of rolling aggregate computed over a metric's samples
+type AggregateKind string
+
+const (
+	// Mean is the plain average of the samples within Window
+	Mean AggregateKind = "mean"
+	// EWMA is an exponentially weighted moving average where a sample's weight halves every Window
+	EWMA AggregateKind = "ewma"
+)
+
+// AggregateSpec describes a rolling aggregate of a single metric
+type AggregateSpec struct {
+	Metric string
+	Kind   AggregateKind
+	Window time.Duration // Averaging window for Mean, half-life for EWMA
+}
+
+// Sample is a single metric observation
+type Sample struct {
+	At    time.Time `json:"at"`
+	Value float64   `json:"value"`
+}
+
+// Store keeps per-provider metric samples for a bounded retention period
+// It is safe for concurrent use
+type Store struct {
+	mu         sync.RWMutex
+	retention  time.Duration // Samples older than this are dropped
+	resolution time.Duration // Samples closer than this to the previous one replace it, bounding memory under high QPS
+	series     map[seriesKey][]Sample
+	maxSeries  int                 // Series kept at most, samples of new series are dropped beyond it
+	sightings  map[string]sighting // Provider ID -> observations, see Observe
+	swept      time.Time           // Time of the latest sweep of the providers no longer observed
+	now        func() time.Time
+}
+
+// sighting is when a provider was first and last observed
+type sighting struct {
+	first time.Time
+	last  time.Time
+}
+
+// seriesKey identifies a single provider metric
+type seriesKey struct {
+	providerID string
+	metric     string
+}
+
+// Snapshot is the persistable state of a Store, see Store.Snapshot
+type Snapshot struct {
+	Series    []Series             `json:"series,omitempty"`
+	FirstSeen map[string]time.Time `json:"first_seen,omitempty"` // Provider ID -> first observation
+	LastSeen  map[string]time.Time `json:"last_seen,omitempty"`  // Provider ID -> latest observation
+}
+
+// Series is the samples of a single provider metric
+type Series struct {
+	ProviderID string   `json:"provider_id"`
+	Metric     string   `json:"metric"`
+	Samples    []Sample `json:"samples"`
+}
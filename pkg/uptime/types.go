@@ -0,0 +1,46 @@
+package uptime
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrIntervalMismatch is returned by Tracker.Restore when a snapshot was taken with another heartbeat interval
+var ErrIntervalMismatch = errors.New("snapshot heartbeat interval doesn't match the tracker's")
+
+// Rolling windows uptime is commonly reported over
+const (
+	Window1h  = time.Hour
+	Window24h = 24 * time.Hour
+	Window7d  = 7 * 24 * time.Hour
+)
+
+// Tracker ingests provider heartbeats and computes rolling uptime percentages
+// Time is split into slots of the expected heartbeat interval; a slot counts as "up" if at least one
+// heartbeat arrived in it. It is safe for concurrent use
+type Tracker struct {
+	mu        sync.RWMutex
+	interval  time.Duration // Expected heartbeat interval, i.e. the slot length
+	retention time.Duration // How much history is kept, bounds the largest usable window
+	providers map[string]*history
+	now       func() time.Time
+}
+
+// history is the heartbeat record of a single provider
+type history struct {
+	firstSeen time.Time
+	slots     []int64 // Ascending, de-duplicated indices of slots with at least one heartbeat
+}
+
+// Snapshot is the persistable state of a Tracker, see Tracker.Snapshot
+type Snapshot struct {
+	Interval  time.Duration              `json:"interval"` // Slot length the histories were recorded with
+	Providers map[string]HistorySnapshot `json:"providers,omitempty"`
+}
+
+// HistorySnapshot is the heartbeat record of a single provider
+type HistorySnapshot struct {
+	FirstSeen time.Time `json:"first_seen"`
+	Slots     []int64   `json:"slots"` // Ascending indices of slots with at least one heartbeat
+}
@@ -0,0 +1,137 @@
+package uptime
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// NewTracker creates a new Tracker expecting one heartbeat per interval from each provider
+// History is kept for the 7 day window
+func NewTracker(interval time.Duration) *Tracker {
+	return &Tracker{
+		interval:  interval,
+		retention: Window7d,
+		providers: make(map[string]*history),
+		now:       time.Now,
+	}
+}
+
+// SetClock replaces the clock uptime windows end at, nil restores time.Now
+// Replays pin it to the time of the recorded call
+func (t *Tracker) SetClock(now func() time.Time) {
+	if now == nil {
+		now = time.Now
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.now = now
+}
+
+// Heartbeat records a heartbeat from the provider at the given time
+// Providers silent for the whole retention period are forgotten whenever a new one is tracked
+func (t *Tracker) Heartbeat(providerID string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	oldest := t.now().Add(-t.retention).UnixNano() / int64(t.interval)
+	h, ok := t.providers[providerID]
+	if !ok {
+		for id, other := range t.providers {
+			if len(other.slots) == 0 || other.slots[len(other.slots)-1] < oldest {
+				delete(t.providers, id)
+			}
+		}
+		h = &history{firstSeen: at}
+		t.providers[providerID] = h
+	}
+	if at.Before(h.firstSeen) {
+		h.firstSeen = at
+	}
+
+	slot := at.UnixNano() / int64(t.interval)
+	idx := sort.Search(len(h.slots), func(i int) bool { return h.slots[i] >= slot })
+	if idx < len(h.slots) && h.slots[idx] == slot {
+		return // Already up in this slot
+	}
+	h.slots = append(h.slots, 0)
+	copy(h.slots[idx+1:], h.slots[idx:])
+	h.slots[idx] = slot
+
+	// Drop slots that fell out of the retention period
+	if cut := sort.Search(len(h.slots), func(i int) bool { return h.slots[i] >= oldest }); cut > 0 {
+		h.slots = append(h.slots[:0], h.slots[cut:]...)
+	}
+}
+
+// Uptime returns the fraction (0-1) of completed slots within the window ending now in which the provider
+// sent a heartbeat
+// The window is shortened to the provider's first heartbeat, so new providers aren't penalized for time
+// before they existed. It returns false if the provider has never sent a heartbeat
+func (t *Tracker) Uptime(providerID string, window time.Duration) (float64, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	h, ok := t.providers[providerID]
+	if !ok {
+		return 0, false
+	}
+
+	now := t.now()
+	start := now.Add(-window)
+	if h.firstSeen.After(start) {
+		start = h.firstSeen
+	}
+	startSlot := start.UnixNano() / int64(t.interval)
+	currentSlot := now.UnixNano() / int64(t.interval)
+
+	// The current slot is still in progress, only judge it if a heartbeat already arrived
+	up := countSlots(h.slots, startSlot, currentSlot)
+	expected := currentSlot - startSlot
+	if countSlots(h.slots, currentSlot, currentSlot+1) > 0 {
+		up++
+		expected++
+	}
+	if expected == 0 {
+		return 0, false
+	}
+	return float64(up) / float64(expected), true
+}
+
+// Snapshot returns a copy of every provider's heartbeat history
+func (t *Tracker) Snapshot() Snapshot {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	snapshot := Snapshot{Interval: t.interval, Providers: make(map[string]HistorySnapshot, len(t.providers))}
+	for id, h := range t.providers {
+		snapshot.Providers[id] = HistorySnapshot{FirstSeen: h.firstSeen, Slots: append([]int64(nil), h.slots...)}
+	}
+	return snapshot
+}
+
+// Restore replaces the tracker's histories with a snapshot, so uptime isn't reset by a restart
+// Slots are indices of the interval, so the snapshot must have been taken with the tracker's interval
+func (t *Tracker) Restore(snapshot Snapshot) error {
+	if snapshot.Interval != t.interval {
+		return fmt.Errorf("%w: %s vs %s", ErrIntervalMismatch, snapshot.Interval, t.interval)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	oldest := t.now().Add(-t.retention).UnixNano() / int64(t.interval)
+	t.providers = make(map[string]*history, len(snapshot.Providers))
+	for id, h := range snapshot.Providers {
+		slots := append([]int64(nil), h.Slots...)
+		sort.Slice(slots, func(i, j int) bool { return slots[i] < slots[j] })
+		// Drop slots that fell out of the retention period while the service was down
+		cut := sort.Search(len(slots), func(i int) bool { return slots[i] >= oldest })
+		t.providers[id] = &history{firstSeen: h.FirstSeen, slots: slots[cut:]}
+	}
+	return nil
+}
+
+// countSlots counts the slots in [from, to) present in the sorted slot list
+func countSlots(slots []int64, from, to int64) int64 {
+	lo := sort.Search(len(slots), func(i int) bool { return slots[i] >= from })
+	hi := sort.Search(len(slots), func(i int) bool { return slots[i] >= to })
+	return int64(hi - lo)
+}
@@ -0,0 +1,24 @@
+package workerpool
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrClosed is returned when submitting to a closed Pool
+var ErrClosed = errors.New("worker pool closed")
+
+// Pool is a fixed set of long-lived goroutines running submitted tasks, bounding the concurrency of
+// everything sharing it
+type Pool struct {
+	size  int
+	tasks chan func()
+	wg    sync.WaitGroup
+
+	mu     sync.RWMutex // Guards closed against concurrent Submit and Close
+	closed bool
+
+	busy    atomic.Int64 // Workers running a task
+	waiting atomic.Int64 // Submitters blocked until a worker is free
+}
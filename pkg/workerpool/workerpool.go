@@ -0,0 +1,71 @@
+package workerpool
+
+// New creates a new Pool of the given number of workers, at least 1
+func New(size int) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	p := &Pool{
+		size:  size,
+		tasks: make(chan func()),
+	}
+	p.wg.Add(size)
+	for i := 0; i < size; i++ {
+		go p.worker()
+	}
+	return p
+}
+
+// Submit queues a task, blocking until a worker picks it up
+// NOTE: Tasks must not Submit to the pool they run on and wait for the result, that deadlocks once every
+// worker does it
+func (p *Pool) Submit(task func()) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrClosed
+	}
+	p.waiting.Add(1)
+	p.tasks <- task
+	p.waiting.Add(-1)
+	return nil
+}
+
+// Size returns the number of workers
+func (p *Pool) Size() int {
+	return p.size
+}
+
+// Busy returns the number of workers running a task
+func (p *Pool) Busy() int {
+	return int(p.busy.Load())
+}
+
+// Waiting returns the number of tasks submitted but not yet picked up, i.e. the pool's queue depth
+func (p *Pool) Waiting() int {
+	return int(p.waiting.Load())
+}
+
+// Close stops accepting tasks and waits for running ones to finish
+// It is safe to call Close more than once
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.tasks)
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// worker runs tasks until the pool is closed
+func (p *Pool) worker() {
+	defer p.wg.Done()
+	for task := range p.tasks {
+		p.busy.Add(1)
+		task()
+		p.busy.Add(-1)
+	}
+}